@@ -0,0 +1,151 @@
+//go:build cgo
+
+// Package main is a minimal C facade of the manifestr engine, so tooling in other languages can parse playlists and
+// download streams without shelling out to the CLI. Build it as a shared library, which also writes its header:
+//
+//	go build -buildmode=c-shared -o libmanifestr.so ./capi
+//
+// Strings returned by the library, including errors, are owned by the caller and released with ManifestrFree. From
+// Python, for example, the library is loaded with ctypes.CDLL and the progress callback wrapped in ctypes.CFUNCTYPE.
+package main
+
+/*
+#include <stdlib.h>
+
+// manifestr_progress receives how many of total downloads are done, how many of those failed and their size in bytes.
+// It is called from the threads of the downloads, once for every download.
+typedef void (*manifestr_progress)(void *userdata, int done, int failed, int total, long long bytes);
+
+static inline void manifestr_call_progress(manifestr_progress progress, void *userdata, int done, int failed, int total, long long bytes) {
+	progress(userdata, done, failed, total, bytes);
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"unsafe"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/storage"
+	"github.com/alehechka/manifestr/pkg/utils"
+)
+
+// ManifestrParse fetches and parses the playlist at url, returning it as JSON: a media playlist as a models.Manifest,
+// a master playlist as a models.MasterPlaylist. On failure it returns NULL and sets *err.
+//
+//export ManifestrParse
+func ManifestrParse(url *C.char, err **C.char) *C.char {
+	result, parseErr := parse(C.GoString(url))
+	if parseErr != nil {
+		setError(err, parseErr)
+		return nil
+	}
+	return C.CString(string(result))
+}
+
+// ManifestrDownload downloads the stream at url into dir like the hls command without any flags, selecting the variant
+// of a master playlist by variant, the best one when empty, see models.MasterPlaylist.SelectVariant. progress, when not
+// NULL, is called with userdata as downloads finish. It blocks until the download is done, returning 0, or failed,
+// returning -1 and setting *err.
+//
+//export ManifestrDownload
+func ManifestrDownload(url *C.char, dir *C.char, variant *C.char, progress C.manifestr_progress, userdata unsafe.Pointer, err **C.char) C.int {
+	var update utils.ProgressFunc
+	if progress != nil {
+		update = func(done int, failed int, total int, bytes int64) {
+			C.manifestr_call_progress(progress, userdata, C.int(done), C.int(failed), C.int(total), C.longlong(bytes))
+		}
+	}
+	if downloadErr := download(context.Background(), C.GoString(url), C.GoString(dir), C.GoString(variant), update); downloadErr != nil {
+		setError(err, downloadErr)
+		return -1
+	}
+	return 0
+}
+
+// ManifestrFree releases a string returned by the library.
+//
+//export ManifestrFree
+func ManifestrFree(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+func setError(target **C.char, err error) {
+	if target != nil {
+		*target = C.CString(err.Error())
+	}
+}
+
+func parse(manifestUrl string) ([]byte, error) {
+	playlist, err := readPlaylist(&utils.Downloader{}, manifestUrl)
+	if err != nil {
+		return nil, err
+	}
+	if models.IsMasterPlaylist(bytes.NewReader(playlist)) {
+		master, err := models.ReadMasterPlaylist(bytes.NewReader(playlist), manifestUrl)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(master)
+	}
+	manifest, err := models.ReadManifest(bytes.NewReader(playlist), manifestUrl)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(manifest)
+}
+
+func download(ctx context.Context, manifestUrl string, dir string, selector string, update utils.ProgressFunc) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	// every call fetches through a downloader of its own, so concurrent downloads share no settings
+	downloader := &utils.Downloader{}
+	playlist, err := readPlaylist(downloader, manifestUrl)
+	if err != nil {
+		return err
+	}
+	if models.IsMasterPlaylist(bytes.NewReader(playlist)) {
+		master, err := models.ReadMasterPlaylist(bytes.NewReader(playlist), manifestUrl)
+		if err != nil {
+			return err
+		}
+		variant, err := master.SelectVariant(selector)
+		if err != nil {
+			return err
+		}
+		manifestUrl = master.ResolvedUri(variant.Uri)
+		if playlist, err = readPlaylist(downloader, manifestUrl); err != nil {
+			return err
+		}
+	}
+	manifest, err := models.ReadManifest(bytes.NewReader(playlist), manifestUrl)
+	if err != nil {
+		return err
+	}
+
+	options := models.PlanOptions{Dir: dir, Retries: models.DefaultRetries, RetryBackoff: models.DefaultRetryBackoff, Downloader: downloader}
+	if update != nil {
+		options.Progress = utils.NewProgressFunc(update)
+	}
+	if err := models.Execute(ctx, models.Plan(manifest, options)); err != nil {
+		return err
+	}
+	return storage.WriteFile(ctx, manifest.Files(dir), "local.manifest.m3u8", manifest.WriteLocalManifest)
+}
+
+func readPlaylist(downloader *utils.Downloader, manifestUrl string) ([]byte, error) {
+	in, err := downloader.OpenUrl(manifestUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return io.ReadAll(in)
+}
+
+func main() {}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path"
+
+	"github.com/alehechka/manifestr/pkg/report"
+	"github.com/alehechka/manifestr/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ArgSlowest = "slowest"
+)
+
+var analyzeCdnFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:  ArgSlowest,
+		Value: 10,
+		Usage: "Number of slowest fragments to list alongside their cache result.",
+	},
+}
+
+func analyzeCdn(ctx *cli.Context) (err error) {
+	directory := ctx.Args().Get(0)
+	if directory == "" {
+		return errors.New("no directory provided")
+	}
+
+	index, err := utils.LoadArchiveIndex(path.Join(directory, utils.IndexFileName))
+	if err != nil {
+		return err
+	}
+
+	records := index.Records()
+	if len(records) == 0 {
+		return errors.New("no downloads recorded in directory")
+	}
+
+	return report.AnalyzeCdn(records, ctx.Int(ArgSlowest)).Write(os.Stdout)
+}
+
+var AnalyzeCdnCommand = &cli.Command{
+	Name:      "analyze-cdn",
+	Usage:     "Summarize CDN cache hit ratios, edge POPs and slow fragments from a download directory's index",
+	ArgsUsage: "<directory>",
+	Action:    analyzeCdn,
+	Flags:     analyzeCdnFlags,
+}
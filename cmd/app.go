@@ -1,9 +1,259 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/telemetry"
+	"github.com/alehechka/manifestr/pkg/utils"
 	"github.com/urfave/cli/v2"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	ArgTraceHttp       = "trace-http"
+	ArgTraceHttpBodies = "trace-http-bodies"
+	ArgOtel            = "otel"
+	ArgProfile         = "profile"
+	ArgManifestCache   = "manifest-cache-ttl"
+	ArgHeader          = "header"
+	ArgQuery           = "query"
+	ArgCookie          = "cookie"
+	ArgUserAgent       = "user-agent"
+	ArgBearerToken     = "bearer-token"
+	ArgProxy           = "proxy"
+	ArgTimeout         = "timeout"
+	ArgConnectTimeout  = "connect-timeout"
+	ArgMaxRate         = "max-rate"
+	ArgMaxRequests     = "max-requests-per-second"
+	ArgTmpDir          = "tmp-dir"
+	ArgTmpMaxAge       = "tmp-max-age"
+	ArgYes             = "yes"
 )
 
+var appFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:    ArgHeader,
+		Aliases: []string{"H"},
+		Usage:   "Add a header (Name=value or \"Name: value\") to every playlist, fragment, init segment and key request. Repeatable.",
+	},
+	&cli.StringSliceFlag{
+		Name:  ArgQuery,
+		Usage: "Add a query parameter (name=value) to every fragment, init segment and key request, for CDNs that authenticate by query rather than headers. Its value is redacted from logs. Repeatable.",
+	},
+	&cli.StringSliceFlag{
+		Name:  ArgCookie,
+		Usage: "Send a cookie (name=value) with every media request. Repeatable.",
+	},
+	&cli.StringFlag{
+		Name:  ArgUserAgent,
+		Usage: "User-Agent sent with every media request.",
+	},
+	&cli.StringFlag{
+		Name:    ArgBearerToken,
+		Usage:   "Send an \"Authorization: Bearer\" header with every media request.",
+		EnvVars: []string{"MANIFESTR_BEARER_TOKEN"},
+	},
+	&cli.StringFlag{
+		Name:  ArgProxy,
+		Usage: "Send every request through this HTTP(S) or SOCKS5 proxy url instead of the one from HTTP_PROXY/HTTPS_PROXY.",
+	},
+	&cli.DurationFlag{
+		Name:  ArgTimeout,
+		Usage: "Abort a request, including reading its body, after this long. 0 means no limit.",
+	},
+	&cli.DurationFlag{
+		Name:  ArgConnectTimeout,
+		Usage: "Abort establishing a connection after this long.",
+	},
+	&cli.StringFlag{
+		Name:  ArgMaxRate,
+		Usage: "Limit the bandwidth of all downloads together to this many bytes per second, with a K, M or G suffix such as 10M.",
+	},
+	&cli.Float64Flag{
+		Name:  ArgMaxRequests,
+		Usage: "Limit the requests sent to every host to this many per second, e.g. 0.5 for one every 2 seconds. 0 means no limit.",
+	},
+	&cli.StringFlag{
+		Name:    ArgTmpDir,
+		Usage:   "Create the temporary directories of runs without a --directory in this directory instead of manifestr/tmp in the user cache directory.",
+		EnvVars: []string{"MANIFESTR_TMP_DIR"},
+	},
+	&cli.DurationFlag{
+		Name:  ArgTmpMaxAge,
+		Value: utils.DefaultTempMaxAge,
+		Usage: "Remove temporary directories left by earlier runs once nothing in them changed for this long. 0 keeps them.",
+	},
+	&cli.BoolFlag{
+		Name:    ArgYes,
+		Aliases: []string{"y", "no-input"},
+		Usage:   "Never ask for confirmation: download variants over the --warn-size budget or below the --variant bandwidth and overwrite existing outputs, for cron and CI. Without a terminal nothing is asked anyway.",
+		EnvVars: []string{"MANIFESTR_YES"},
+	},
+	&cli.StringFlag{
+		Name:  ArgTraceHttp,
+		Usage: "Dump sanitized HTTP request and response headers to the given trace file.",
+	},
+	&cli.BoolFlag{
+		Name:  ArgTraceHttpBodies,
+		Usage: "Used in conjunction with --" + ArgTraceHttp + " to also dump playlist response bodies.",
+	},
+	&cli.BoolFlag{
+		Name:  ArgOtel,
+		Usage: "Export OpenTelemetry spans of each pipeline stage over OTLP/HTTP, configured through the standard OTEL_EXPORTER_OTLP_* environment variables.",
+	},
+	&cli.DurationFlag{
+		Name:  ArgManifestCache,
+		Usage: "Reuse playlists fetched and parsed by earlier commands from the user cache directory for this long (e.g. 10m) instead of fetching them again. Live commands always poll.",
+	},
+	&cli.StringFlag{
+		Name:  ArgProfile,
+		Usage: "Write pprof CPU and heap profiles and a stage timing breakdown (parse, download, mux...) to the given directory, printing the breakdown when the command completes.",
+	},
+}
+
+// shutdownTelemetry flushes exported spans once the command completes.
+var shutdownTelemetry = func(context.Context) error { return nil }
+
+// stopSignals restores the default handling of interrupts once the command completes.
+var stopSignals = func() {}
+
+// stopProfile writes the profiles and stage timings once the command completes.
+var stopProfile = func() error { return nil }
+
+// closeTrace flushes and closes the --trace-http file once the command completes.
+var closeTrace = func() error { return nil }
+
+// downloader fetches playlists and media for the command, configured from the request flags of the app.
+var downloader = &utils.Downloader{}
+
+// mediaQuery holds the --query parameters added to the url of every playlist and segment the command fetches.
+var mediaQuery url.Values
+
+// stageTimings aggregates the spans of the command by stage, for the timing report of a run and --profile.
+var stageTimings = telemetry.NewStageTimings()
+
+func before(ctx *cli.Context) error {
+	// the first interrupt cancels the context of the command so it can shut down gracefully, a second one exits immediately
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	signalCtx, cancel := context.WithCancel(ctx.Context)
+	go func() {
+		<-signals
+		cancel()
+		// ffmpeg runs in process groups of its own, which the interrupt does not reach
+		received := <-signals
+		ffmpeg.KillAll()
+		if received == syscall.SIGTERM {
+			os.Exit(143)
+		}
+		os.Exit(130)
+	}()
+	stopSignals = func() {
+		signal.Stop(signals)
+		cancel()
+	}
+	ctx.Context = signalCtx
+
+	maxRate, err := utils.ParseByteRate(ctx.String(ArgMaxRate))
+	if err != nil {
+		return err
+	}
+	if mediaQuery, err = utils.ParseQuery(ctx.StringSlice(ArgQuery)); err != nil {
+		return err
+	}
+	// the values of --query are tokens, so they are hidden wherever a url is logged
+	sensitiveQuery := make([]string, 0, len(mediaQuery))
+	for name := range mediaQuery {
+		sensitiveQuery = append(sensitiveQuery, name)
+	}
+	downloader, err = utils.NewDownloader(utils.DownloaderOptions{
+		Headers:              ctx.StringSlice(ArgHeader),
+		Cookies:              ctx.StringSlice(ArgCookie),
+		UserAgent:            ctx.String(ArgUserAgent),
+		BearerToken:          ctx.String(ArgBearerToken),
+		Proxy:                ctx.String(ArgProxy),
+		Timeout:              ctx.Duration(ArgTimeout),
+		ConnectTimeout:       ctx.Duration(ArgConnectTimeout),
+		MaxRate:              maxRate,
+		MaxRequestsPerSecond: ctx.Float64(ArgMaxRequests),
+		SensitiveQuery:       sensitiveQuery,
+	})
+	if err != nil {
+		return err
+	}
+
+	// tracing wraps the configured transport, so it has to come after it
+	if traceFile := ctx.String(ArgTraceHttp); traceFile != "" {
+		out, err := os.Create(traceFile)
+		if err != nil {
+			return err
+		}
+		downloader.Trace(out, ctx.Bool(ArgTraceHttpBodies))
+		closeTrace = func() error {
+			return errors.Join(out.Sync(), out.Close())
+		}
+	}
+
+	processors := []sdktrace.SpanProcessor{stageTimings}
+	if profileDir := ctx.String(ArgProfile); profileDir != "" {
+		stop, err := telemetry.StartProfile(profileDir)
+		if err != nil {
+			return err
+		}
+
+		stopProfile = func() error {
+			if err := stop(); err != nil {
+				return err
+			}
+			timingsFile, err := os.Create(path.Join(profileDir, "stages.txt"))
+			if err != nil {
+				return err
+			}
+			defer timingsFile.Close()
+			return stageTimings.Write(io.MultiWriter(os.Stderr, timingsFile))
+		}
+	}
+
+	shutdown, err := telemetry.Setup(ctx.Context, ctx.App.Version, ctx.Bool(ArgOtel), processors...)
+	if err != nil {
+		return err
+	}
+	shutdownTelemetry = shutdown
+
+	return nil
+}
+
+func after(ctx *cli.Context) error {
+	stopSignals()
+	ffmpeg.KillAll()
+	// spans are only complete once the provider shuts down
+	return errors.Join(shutdownTelemetry(context.Background()), stopProfile(), closeTrace())
+}
+
+// confirm asks question through utils.Confirm unless --yes is set, which answers every confirmation with yes.
+func confirm(ctx *cli.Context, question string) bool {
+	return ctx.Bool(ArgYes) || utils.Confirm(question)
+}
+
+// tempOptions returns where --tmp-dir says temporary directories are created and how long --tmp-max-age keeps them.
+func tempOptions(ctx *cli.Context) utils.TempOptions {
+	return utils.TempOptions{Root: ctx.String(ArgTmpDir), MaxAge: ctx.Duration(ArgTmpMaxAge)}
+}
+
+// manifestCache reads playlists through the downloader of the command, reusing them for --manifest-cache-ttl.
+func manifestCache(ctx *cli.Context) models.ManifestCache {
+	return models.ManifestCache{Ttl: ctx.Duration(ArgManifestCache), Downloader: downloader}
+}
+
 // App represents the CLI application
 func App(version string) *cli.App {
 	app := cli.NewApp()
@@ -11,8 +261,28 @@ func App(version string) *cli.App {
 	app.Version = version
 	app.EnableBashCompletion = true
 	app.Usage = "CLI application to download full HLS manifests and perform different ffmpeg operations."
+	app.Flags = appFlags
+	app.Before = before
+	app.After = after
 	app.Commands = []*cli.Command{
+		GetCommand,
 		HlsCommand,
+		DashCommand,
+		InspectCommand,
+		HealthCommand,
+		WatchCommand,
+		NormalizeCommand,
+		GenerateCommand,
+		AnalyzeCdnCommand,
+		EdlCommand,
+		ComplianceCommand,
+		ValidateCommand,
+		RetryFailedCommand,
+		DurationsCommand,
+		FilelistCommand,
+		MetadataCommand,
+		ServeCommand,
+		SelftestCommand,
 	}
 	return app
 }
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ArgBandwidth   = "bandwidth"
+	ArgStarveRatio = "starve-ratio"
+	ArgSpikeRatio  = "spike-ratio"
+)
+
+// bitrateFlags are shared by the watch and health commands, which both check the bitrate of the newest segment.
+var bitrateFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:  ArgBandwidth,
+		Usage: "Advertised bitrate of the playlist in bits per second, as in the BANDWIDTH of its #EXT-X-STREAM-INF. Defaults to the average measured bitrate of the recent segments.",
+	},
+	&cli.Float64Flag{
+		Name:  ArgStarveRatio,
+		Value: 0.5,
+		Usage: "Alert when the measured bitrate of a segment falls below this fraction of the advertised bitrate, as when the encoder is starving. 0 disables it.",
+	},
+	&cli.Float64Flag{
+		Name:  ArgSpikeRatio,
+		Value: 2,
+		Usage: "Alert when the measured bitrate of a segment exceeds this multiple of the advertised bitrate. 0 disables it.",
+	},
+}
+
+// bitrateMonitor measures the bitrate of every new segment from its size and duration and compares it with the advertised one.
+type bitrateMonitor struct {
+	advertised  int
+	starveRatio float64
+	spikeRatio  float64
+	history     int
+	// measured are the bitrates of the last history segments, which stand in for the advertised bitrate when there is none
+	measured     []float64
+	lastSequence int
+}
+
+func newBitrateMonitor(ctx *cli.Context, history int) *bitrateMonitor {
+	return &bitrateMonitor{
+		advertised:   ctx.Int(ArgBandwidth),
+		starveRatio:  ctx.Float64(ArgStarveRatio),
+		spikeRatio:   ctx.Float64(ArgSpikeRatio),
+		history:      history,
+		lastSequence: -1,
+	}
+}
+
+// bitrateAlert is a segment whose measured bitrate is too far from the advertised one.
+type bitrateAlert struct {
+	Sequence   int
+	Measured   int
+	Advertised int
+	Starving   bool
+}
+
+func (alert bitrateAlert) Error() string {
+	issue := "spiking"
+	if alert.Starving {
+		issue = "starving"
+	}
+	return fmt.Sprintf("encoder appears to be %s: segment %d measured %s against %s advertised", issue, alert.Sequence, formatBitrate(alert.Measured), formatBitrate(alert.Advertised))
+}
+
+// check measures the newest segment of manifest unless it was measured before, returning its bitrate in bits per second,
+// or 0 when there is no new segment. The error is a bitrateAlert when it is out of bounds.
+func (monitor *bitrateMonitor) check(manifest *models.Manifest) (float64, error) {
+	entry := manifest.LastEntry()
+	sequence := manifest.LastSequence()
+	if entry == nil || entry.Duration <= 0 || sequence == monitor.lastSequence {
+		return 0, nil
+	}
+	monitor.lastSequence = sequence
+
+	size := int64(-1)
+	if entry.ByteRange != nil {
+		size = entry.ByteRange.Length
+	} else {
+		var err error
+		if size, err = downloader.ContentLength(manifest.DynamicUrl(*entry).String()); err != nil {
+			return 0, err
+		}
+	}
+	if size < 0 {
+		return 0, fmt.Errorf("segment %d has no reported size to measure its bitrate by", sequence)
+	}
+	measured := float64(size*8) / entry.Duration
+
+	advertised := float64(monitor.advertised)
+	if advertised == 0 {
+		advertised = float64(manifest.Bandwidth)
+	}
+	if advertised == 0 && len(monitor.measured) > 0 {
+		for _, value := range monitor.measured {
+			advertised += value
+		}
+		advertised /= float64(len(monitor.measured))
+	}
+
+	monitor.measured = append(monitor.measured, measured)
+	if len(monitor.measured) > monitor.history {
+		monitor.measured = monitor.measured[len(monitor.measured)-monitor.history:]
+	}
+
+	if advertised == 0 {
+		return measured, nil
+	}
+	alert := bitrateAlert{Sequence: sequence, Measured: int(measured), Advertised: int(advertised)}
+	switch {
+	case monitor.starveRatio > 0 && measured < advertised*monitor.starveRatio:
+		alert.Starving = true
+		return measured, alert
+	case monitor.spikeRatio > 0 && measured > advertised*monitor.spikeRatio:
+		return measured, alert
+	}
+	return measured, nil
+}
+
+// alertBitrate logs alert and posts it to webhook when one is given.
+func alertBitrate(manifestUrl string, webhook string, alert bitrateAlert) {
+	slog.Warn("bitrate out of bounds", slog.String("url", manifestUrl), slog.Int("sequence", alert.Sequence), slog.Int("measured", alert.Measured), slog.Int("advertised", alert.Advertised), slog.Bool("starving", alert.Starving))
+	if webhook == "" {
+		return
+	}
+	payload := healthAlert{Url: manifestUrl, Error: alert.Error(), Timestamp: time.Now(), MeasuredBitrate: alert.Measured, AdvertisedBitrate: alert.Advertised}
+	if err := fireWebhook(webhook, payload); err != nil {
+		slog.Error("failed to fire webhook", slog.String("url", webhook), slog.String("error", err.Error()))
+	}
+}
+
+// formatBitrate formats bits per second with a decimal unit, e.g. 2.50 Mbps.
+func formatBitrate(bitsPerSecond int) string {
+	switch {
+	case bitsPerSecond >= 1_000_000:
+		return fmt.Sprintf("%.2f Mbps", float64(bitsPerSecond)/1_000_000)
+	case bitsPerSecond >= 1_000:
+		return fmt.Sprintf("%.1f kbps", float64(bitsPerSecond)/1_000)
+	}
+	return fmt.Sprintf("%d bps", bitsPerSecond)
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/report"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ArgProbe = "probe"
+)
+
+var complianceFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  ArgProbe,
+		Usage: "Download the first segment of every variant and probe it with ffprobe to check key frame intervals.",
+	},
+}
+
+func compliance(ctx *cli.Context) (err error) {
+	masterUrl := ctx.Args().Get(0)
+	if masterUrl == "" {
+		return errors.New("no master playlist url provided")
+	}
+
+	in, err := downloader.OpenUrl(masterUrl)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	master, err := models.ReadMasterPlaylist(in, masterUrl)
+	if err != nil {
+		return err
+	}
+	if len(master.Variants) == 0 {
+		return errors.New("playlist has no variant streams, compliance checks need a master playlist")
+	}
+
+	probeDir := ""
+	if ctx.Bool(ArgProbe) {
+		if probeDir, err = os.MkdirTemp("", "manifestr-probe-"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(probeDir)
+	}
+
+	return report.CheckCompliance(ctx.Context, master, probeDir, downloader).Write(os.Stdout)
+}
+
+var ComplianceCommand = &cli.Command{
+	Name:      "compliance",
+	Usage:     "Grade a master playlist and its variants against Apple's HLS Authoring Specification",
+	ArgsUsage: "<url>",
+	Action:    compliance,
+	Flags:     complianceFlags,
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/report"
+	"github.com/alehechka/manifestr/pkg/telemetry"
+	"github.com/alehechka/manifestr/pkg/utils"
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var dashFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    ArgDirectory,
+		Aliases: []string{"d", "dir"},
+		Usage:   fmt.Sprintf("Specify a directory to download files to and/or use as an existing location to skip downloading files that exist (see --%s for more details). An s3://bucket/prefix or gs://bucket/prefix URI streams the files and outputs straight to object storage, with credentials taken from the usual AWS_* variables and shared AWS profiles, or GOOGLE_OAUTH_ACCESS_TOKEN and the application default credentials. Requests to buckets honor --proxy, --timeout and --trace-http.", ArgForceDownload),
+	},
+	&cli.BoolFlag{
+		Name:    ArgForceDownload,
+		Aliases: []string{"force"},
+		Usage:   fmt.Sprintf("Used in conjunction with --%s to force download all files of the MPD when they exist in the provided directory.", ArgDirectory),
+	},
+	&cli.BoolFlag{
+		Name:  ArgConcatMp4,
+		Usage: fmt.Sprintf("After downloading all segments will concat the init and media segments of every period into an MP4 file, short for --%s %s.", ArgConcatMode, models.ConcatPerDiscontinuity),
+	},
+	&cli.StringFlag{
+		Name:  ArgConcatMode,
+		Usage: fmt.Sprintf("How the periods map to MP4 outputs: %q merges them into one MP4 with the ffmpeg concat demuxer, re-timestamping each to follow on from the previous one, %q writes an MP4 of every period and %q skips ffmpeg, leaving the local manifests and segments.", models.ConcatSingle, models.ConcatPerDiscontinuity, models.ConcatNone),
+	},
+	&cli.StringFlag{
+		Name:  ArgOutputName,
+		Usage: fmt.Sprintf("Used in conjunction with --%s to name the MP4 of every period, without extension: {index} or {index:04d} is its position. Defaults to %s, or %s for --%s %s.", ArgConcatMp4, models.DefaultOutputName, models.SingleOutputName, ArgConcatMode, models.ConcatSingle),
+	},
+	namingFlag,
+	&cli.StringFlag{
+		Name:  ArgVariant,
+		Value: models.VariantBest,
+		Usage: fmt.Sprintf("Video representation to download from every period: %q or %q by bandwidth, a resolution such as 1280x720 or 720p, or the highest bandwidth in bits per second to accept.", models.VariantBest, models.VariantWorst),
+	},
+	&cli.StringFlag{
+		Name:  ArgAudioLang,
+		Value: models.RenditionsDefault,
+		Usage: fmt.Sprintf("Audio adaptation sets to download into subfolders and mux into the MP4 outputs: %q (the main role), %q, %q or comma separated languages such as en,de.", models.RenditionsDefault, models.RenditionsAll, models.RenditionsNone),
+	},
+	&cli.BoolFlag{
+		Name:  ArgTimedMetadata,
+		Usage: fmt.Sprintf("Extract the emsg events of the downloaded video segments into a %q sidecar, see the metadata command.", report.EventMessagesFileName),
+	},
+	&cli.IntFlag{
+		Name:  ArgConcurrency,
+		Value: models.DefaultConcurrency,
+		Usage: "Maximum number of files downloaded at once.",
+	},
+	&cli.IntFlag{
+		Name:  ArgRetries,
+		Value: models.DefaultRetries,
+		Usage: "Number of times a download is retried after a server error, rate limiting, stall or network timeout, waiting twice as long before each retry.",
+	},
+	&cli.DurationFlag{
+		Name:  ArgRetryBackoff,
+		Value: models.DefaultRetryBackoff,
+		Usage: fmt.Sprintf("Wait before the first of the --%s, doubled for each next one.", ArgRetries),
+	},
+	&cli.BoolFlag{
+		Name:  ArgPrintFfmpeg,
+		Usage: "Print the ffmpeg commands that would be run to stdout instead of executing them.",
+	},
+	&cli.BoolFlag{
+		Name:  ArgProgress,
+		Value: true,
+		Usage: "Report the progress of the downloads with their size, throughput and ETA: as a bar when stderr is a terminal, printing log lines above it, otherwise as a log line every 10s.",
+	},
+}
+
+func dash(ctx *cli.Context) (err error) {
+	started := time.Now()
+	mpdUrl := ctx.Args().First()
+	if mpdUrl == "" {
+		return errors.New("no MPD url provided")
+	}
+
+	runCtx, span := telemetry.Start(ctx.Context, "dash", attribute.String("url", mpdUrl))
+	defer func() { telemetry.End(span, err) }()
+
+	if ctx.Bool(ArgPrintFfmpeg) {
+		runCtx = ffmpeg.WithRunner(runCtx, ffmpeg.Runner{DryRun: os.Stdout})
+	}
+
+	if err := validateOutputName(ctx.String(ArgOutputName)); err != nil {
+		return err
+	}
+	readOptions, err := readOptions(ctx)
+	if err != nil {
+		return err
+	}
+	concat, err := concatMode(ctx)
+	if err != nil {
+		return err
+	}
+
+	forceDownload := ctx.Bool(ArgForceDownload)
+	directory, output, err := openDirectory(ctx)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := loadMpd(runCtx, ctx, directory, mpdUrl, forceDownload)
+	if err != nil {
+		return err
+	}
+	manifest.Output = output
+	applyReadOptions(manifest, readOptions)
+
+	if err := writeLocalManifests(runCtx, directory, manifest); err != nil {
+		return err
+	}
+	manifest.Statuses = utils.NewDownloadStatusCache()
+
+	options := models.PlanOptions{
+		Dir:           directory,
+		ForceDownload: forceDownload,
+		Concurrency:   ctx.Int(ArgConcurrency),
+		Retries:       ctx.Int(ArgRetries),
+		RetryBackoff:  ctx.Duration(ArgRetryBackoff),
+		Container:     models.ContainerMp4,
+		ConcatMode:    concat,
+		OutputName:    outputName(ctx, concat, manifest),
+		AvSync:        models.AvSyncOff,
+		Downloader:    downloader,
+	}
+	if ctx.Bool(ArgProgress) {
+		if options.Progress = utils.NewProgress(os.Stderr); options.Progress.Terminal() {
+			defer withProgressLogging(options.Progress)()
+		}
+	}
+
+	plan := models.Plan(manifest, options)
+	if err := plan.CheckOutput(); err != nil {
+		return err
+	}
+	if err := ffmpeg.Preflight(runCtx, plan.FfmpegRequirements()); err != nil {
+		return err
+	}
+
+	downloadCtx, downloadSpan := telemetry.Start(runCtx, "download fragments")
+	downloadErr := plan.Download(downloadCtx)
+	downloadSpan.End()
+	defer writeRunReport(directory, mpdUrl, started, plan.Results, false)
+
+	if err := writeSizeReport(plan.Results); err != nil {
+		return err
+	}
+
+	if downloadErr != nil {
+		return downloadErr
+	}
+
+	if ctx.Bool(ArgTimedMetadata) {
+		if err := writeTimedMetadataSidecar(manifest, directory); err != nil {
+			return err
+		}
+	}
+
+	return plan.Process(runCtx)
+}
+
+// loadMpd downloads the MPD at mpdUrl into directory and lists the segments of the representations selected by --variant
+// and --audio-lang as a media playlist, with the audio tracks as renditions in subfolders.
+func loadMpd(runCtx context.Context, ctx *cli.Context, directory string, mpdUrl string, forceDownload bool) (*models.Manifest, error) {
+	mpdPath, err := utils.DownloadFile(runCtx, directory, "original.mpd", mpdUrl, utils.DownloadOptions{Force: forceDownload, Downloader: downloader})
+	if err != nil {
+		return nil, err
+	}
+	mpdFile, err := os.Open(mpdPath)
+	if err != nil {
+		return nil, err
+	}
+	defer mpdFile.Close()
+
+	_, parseSpan := telemetry.Start(runCtx, "parse mpd")
+	mpd, err := models.ReadMpd(mpdFile, mpdUrl)
+	telemetry.End(parseSpan, err)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, renditions, err := mpd.Select(ctx.String(ArgVariant), ctx.String(ArgAudioLang))
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("selected representation", slog.Int("bandwidth", manifest.Bandwidth), slog.String("codecs", manifest.Codecs), slog.Int("periods", len(mpd.Periods)))
+
+	for _, rendition := range renditions {
+		slog.Info("selected rendition", slog.String("rendition", rendition.Media.String()))
+		if err := os.MkdirAll(path.Join(directory, rendition.Dir), os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	manifest.Renditions = renditions
+
+	return manifest, nil
+}
+
+var DashCommand = &cli.Command{
+	Name:      "dash",
+	Usage:     "Run the application against a given DASH MPD url",
+	ArgsUsage: "<url>",
+	Action:    dash,
+	Flags:     dashFlags,
+}
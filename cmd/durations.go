@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/report"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ArgTolerance = "tolerance"
+)
+
+var durationsFlags = []cli.Flag{
+	&cli.Float64Flag{
+		Name:  ArgTolerance,
+		Value: 0.05,
+		Usage: "Difference in seconds between the #EXTINF and the media duration above which a segment is flagged.",
+	},
+}
+
+func durations(ctx *cli.Context) (err error) {
+	directory := ctx.Args().Get(0)
+	if directory == "" {
+		return errors.New("no directory provided")
+	}
+
+	manifest, err := models.ReadManifestFromFile(path.Join(directory, "local.manifest.m3u8"), "", models.ReadOptions{})
+	if err != nil {
+		return err
+	}
+
+	return report.CompareDurations(ctx.Context, manifest, directory, ctx.Float64(ArgTolerance)).Write(os.Stdout)
+}
+
+var DurationsCommand = &cli.Command{
+	Name:      "durations",
+	Usage:     "Compare the #EXTINF duration of every downloaded segment with its actual media duration",
+	ArgsUsage: "<directory>",
+	Action:    durations,
+	Flags:     durationsFlags,
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alehechka/manifestr/pkg/report"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ArgFormat = "format"
+	ArgFps    = "fps"
+)
+
+var edlFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  ArgFormat,
+		Value: report.TimelineCsv,
+		Usage: fmt.Sprintf("Export as %q or as a CMX 3600 edit decision list with %q.", report.TimelineCsv, report.TimelineEdl),
+	},
+	&cli.IntFlag{
+		Name:  ArgFps,
+		Value: 30,
+		Usage: fmt.Sprintf("Frame rate of the timecodes written with --%s %s.", ArgFormat, report.TimelineEdl),
+	},
+	&cli.StringFlag{
+		Name:    ArgOutput,
+		Aliases: []string{"o"},
+		Usage:   "File to write the timeline to instead of stdout.",
+	},
+	inferTimeFlag,
+}
+
+func edl(ctx *cli.Context) (err error) {
+	manifestUrl := ctx.Args().Get(0)
+	if manifestUrl == "" {
+		return errors.New("no manifest url provided")
+	}
+
+	format := ctx.String(ArgFormat)
+	if format != report.TimelineCsv && format != report.TimelineEdl {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	manifest, err := manifestCache(ctx).ReadManifest(manifestUrl)
+	if err != nil {
+		return err
+	}
+
+	if ctx.Bool(ArgInferTime) {
+		inferProgramDateTimes(manifest, manifestUrl)
+	}
+
+	var out io.Writer = os.Stdout
+	if output := ctx.String(ArgOutput); output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	events := manifest.Timeline()
+	if format == report.TimelineEdl {
+		return report.WriteTimelineEdl(out, manifestUrl, events, ctx.Int(ArgFps))
+	}
+	return report.WriteTimelineCsv(out, events)
+}
+
+var EdlCommand = &cli.Command{
+	Name:      "edl",
+	Usage:     "Export the timeline of segments, discontinuities and date ranges as CSV or an edit decision list",
+	ArgsUsage: "<url|->",
+	Action:    edl,
+	Flags:     edlFlags,
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ArgAbsolute = "absolute"
+)
+
+var filelistFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    ArgOutput,
+		Aliases: []string{"o"},
+		Usage:   "File to write the list to instead of stdout. The fragments are then listed relative to its directory.",
+	},
+	&cli.BoolFlag{
+		Name:  ArgAbsolute,
+		Usage: "List the fragments by absolute path, so the list works from anywhere. ffmpeg then needs -safe 0.",
+	},
+}
+
+func filelist(ctx *cli.Context) (err error) {
+	directory := ctx.Args().Get(0)
+	if directory == "" {
+		return errors.New("no directory provided")
+	}
+
+	manifest, err := models.ReadManifestFromFile(filepath.Join(directory, "local.manifest.m3u8"), "", models.ReadOptions{})
+	if err != nil {
+		return err
+	}
+	// the demuxer opens every file on its own, so fragments cannot rely on an init segment or a key
+	if manifest.IsFmp4() {
+		return errors.New("fragmented MP4 fragments cannot be read without their init segment, use local.manifest.m3u8 as the ffmpeg input instead")
+	}
+
+	// relative paths are resolved by ffmpeg against the directory of the list, which is the archive directory for stdout
+	base := directory
+	output := ctx.String(ArgOutput)
+	if output != "" {
+		base = filepath.Dir(output)
+	}
+	var entries []ffmpeg.ConcatEntry
+	for _, discontinuity := range manifest.Discontinuities {
+		for _, entry := range discontinuity.Entries {
+			if entry.Key != nil {
+				return fmt.Errorf("fragment %s is encrypted, use local.manifest.m3u8 as the ffmpeg input instead", entry.Url)
+			}
+			file := filepath.Join(directory, manifest.LocalFilename(*entry, false))
+			if _, err := os.Stat(file); err != nil {
+				slog.Warn("leaving out missing fragment", slog.String("file", file), slog.String("error", err.Error()))
+				continue
+			}
+			if file, err = listedPath(file, base, ctx.Bool(ArgAbsolute)); err != nil {
+				return err
+			}
+			entries = append(entries, ffmpeg.ConcatEntry{File: file, Duration: entry.Duration})
+		}
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%s holds no downloaded fragments", directory)
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	return ffmpeg.WriteConcatList(out, entries)
+}
+
+// listedPath is how file is listed: absolute, or relative to base.
+func listedPath(file string, base string, absolute bool) (string, error) {
+	if absolute {
+		return filepath.Abs(file)
+	}
+	absoluteBase, err := filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+	absoluteFile, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(absoluteBase, absoluteFile)
+}
+
+var FilelistCommand = &cli.Command{
+	Name:      "filelist",
+	Usage:     "Write an ffmpeg concat demuxer list of the fragments of a downloaded directory, with their durations, for custom ffmpeg runs",
+	ArgsUsage: "<directory>",
+	Action:    filelist,
+	Flags:     filelistFlags,
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ArgTemplate      = "template"
+	ArgFirstSequence = "first-sequence"
+	ArgCount         = "count"
+	ArgDuration      = "duration"
+	ArgStartTime     = "start-time"
+)
+
+var generateFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     ArgTemplate,
+		Required: true,
+		Usage:    "Segment url template using {seq} / {seq:05d} for the media sequence number and strftime directives (e.g. %Y%m%d-%H%M%S) for the segment start time.",
+	},
+	&cli.IntFlag{
+		Name:  ArgFirstSequence,
+		Usage: "Media sequence number of the first segment.",
+	},
+	&cli.IntFlag{
+		Name:     ArgCount,
+		Required: true,
+		Usage:    "Number of segments to generate.",
+	},
+	&cli.DurationFlag{
+		Name:  ArgDuration,
+		Value: 6 * time.Second,
+		Usage: "Duration of each segment.",
+	},
+	&cli.TimestampFlag{
+		Name:   ArgStartTime,
+		Layout: time.RFC3339,
+		Usage:  "Wall-clock start of the first segment (RFC 3339), used for strftime directives and #EXT-X-PROGRAM-DATE-TIME.",
+	},
+	&cli.StringFlag{
+		Name:    ArgOutput,
+		Aliases: []string{"o"},
+		Usage:   "File to write the generated playlist to instead of stdout.",
+	},
+}
+
+func generate(ctx *cli.Context) (err error) {
+	if ctx.Int(ArgCount) <= 0 {
+		return errors.New("count must be positive")
+	}
+
+	var start time.Time
+	if startTime := ctx.Timestamp(ArgStartTime); startTime != nil {
+		start = startTime.UTC()
+	}
+
+	manifest := models.GenerateManifest(ctx.String(ArgTemplate), ctx.Int(ArgFirstSequence), ctx.Int(ArgCount), ctx.Duration(ArgDuration), start)
+
+	var out io.Writer = os.Stdout
+	if output := ctx.String(ArgOutput); output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	return manifest.WriteManifest(out)
+}
+
+var GenerateCommand = &cli.Command{
+	Name:   "generate",
+	Usage:  "Reconstruct an HLS manifest for an origin with predictable segment naming",
+	Action: generate,
+	Flags:  generateFlags,
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// getFileName names a media file downloaded by get whose url has no last path segment to be named after.
+const getFileName = "download"
+
+// get detects the format of the url it is given last, see models.DetectFormat, and runs hls, dash or a download of the
+// media file with the flags given before it.
+func get(ctx *cli.Context) error {
+	args := ctx.Args().Slice()
+	if len(args) == 0 {
+		return errors.New("no url provided")
+	}
+	getUrl := args[len(args)-1]
+	if getUrl == utils.StdinUrl {
+		return errors.New("get cannot detect the format of stdin, which can only be read once, use hls or dash instead")
+	}
+	if strings.HasPrefix(getUrl, "-") {
+		return fmt.Errorf("no url provided after the flags %s", strings.Join(args, " "))
+	}
+
+	format, err := detectFormat(getUrl)
+	if err != nil {
+		return fmt.Errorf("detecting the format of %s: %w", downloader.RedactUrl(getUrl), err)
+	}
+	slog.Info("detected format", slog.String("url", downloader.RedactUrl(getUrl)), slog.String("format", format))
+
+	command := getFileCommand
+	switch format {
+	case models.FormatHlsMaster, models.FormatHlsMedia:
+		command = HlsCommand
+	case models.FormatDash:
+		command = DashCommand
+	}
+	return command.Run(cli.NewContext(ctx.App, nil, ctx), append([]string{command.Name}, args...)...)
+}
+
+func detectFormat(getUrl string) (string, error) {
+	body, err := downloader.OpenUrl(getUrl)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	return models.DetectFormat(body)
+}
+
+var getFileFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    ArgDirectory,
+		Aliases: []string{"d", "dir"},
+		Value:   ".",
+		Usage:   "Directory to download the media file to, named after the last path segment of its url.",
+	},
+	&cli.BoolFlag{
+		Name:    ArgForceDownload,
+		Aliases: []string{"force"},
+		Usage:   fmt.Sprintf("Download the media file again when it exists in --%s.", ArgDirectory),
+	},
+}
+
+// getFile downloads the media file get detected, which has no playlist to run hls or dash against.
+func getFile(ctx *cli.Context) error {
+	fileUrl := ctx.Args().First()
+	directory := ctx.String(ArgDirectory)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+
+	result, err := utils.DownloadFileWithResult(ctx.Context, directory, mediaFileName(fileUrl), fileUrl, utils.DownloadOptions{Force: ctx.Bool(ArgForceDownload), Downloader: downloader})
+	if err != nil {
+		return err
+	}
+	if result.Skipped {
+		slog.Info(fmt.Sprintf("file exists, use --%s to download it again", ArgForceDownload), slog.String("file", result.Path))
+		return nil
+	}
+	slog.Info("downloaded media file", slog.String("file", result.Path), slog.String("size", utils.FormatBytes(result.Written)))
+	return nil
+}
+
+// mediaFileName names the media file at fileUrl after the last segment of its path, or getFileName when it has none.
+func mediaFileName(fileUrl string) string {
+	parsed, err := url.Parse(fileUrl)
+	if err != nil {
+		return getFileName
+	}
+	name := path.Base(parsed.Path)
+	if name == "." || name == "/" {
+		return getFileName
+	}
+	return name
+}
+
+// getFileCommand downloads the media files detected by get, named like it as it only runs through it.
+var getFileCommand = &cli.Command{
+	Name:      "get",
+	ArgsUsage: "<url>",
+	Action:    getFile,
+	Flags:     getFileFlags,
+}
+
+var GetCommand = &cli.Command{
+	Name:            "get",
+	Usage:           "Detect whether a url is an HLS master or media playlist, a DASH MPD or a media file by its content, and run hls, dash or a download of the file against it with the flags given before the url",
+	ArgsUsage:       "[flags] <url>",
+	Action:          get,
+	SkipFlagParsing: true,
+}
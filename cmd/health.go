@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ArgInterval   = "interval"
+	ArgMaxLatency = "max-latency"
+	ArgWebhook    = "webhook"
+)
+
+var healthFlags = append([]cli.Flag{
+	&cli.DurationFlag{
+		Name:  ArgInterval,
+		Value: 30 * time.Second,
+		Usage: "How often to poll the playlist.",
+	},
+	&cli.DurationFlag{
+		Name:  ArgMaxLatency,
+		Value: time.Minute,
+		Usage: "Maximum allowed gap between now and the end of the newest fragment according to #EXT-X-PROGRAM-DATE-TIME.",
+	},
+	&cli.StringFlag{
+		Name:  ArgWebhook,
+		Usage: "URL to POST a JSON alert to when the stream is found unhealthy or its bitrate is out of bounds.",
+	},
+}, bitrateFlags...)
+
+type healthAlert struct {
+	Url       string    `json:"url"`
+	Error     string    `json:"error"`
+	Timestamp time.Time `json:"timestamp"`
+	// MeasuredBitrate and AdvertisedBitrate are set for an alert about the bitrate of a segment, see bitrateMonitor.
+	MeasuredBitrate   int `json:"measuredBitrate,omitempty"`
+	AdvertisedBitrate int `json:"advertisedBitrate,omitempty"`
+}
+
+// healthBitrateHistory is the number of segments whose average bitrate stands in for the advertised one in health mode.
+const healthBitrateHistory = 10
+
+// pollManifest fetches the current state of a live manifest, requesting a delta update when previous, fetched at
+// fetched, advertised support for one and is recent enough to be updated by it, see models.Manifest.CanRequestDelta.
+func pollManifest(manifestUrl string, previous *models.Manifest, fetched time.Time) (*models.Manifest, error) {
+	if previous == nil || !previous.CanRequestDelta(time.Since(fetched)) {
+		manifest, err := readPolledManifest(manifestUrl, manifestUrl)
+		if err != nil {
+			return previous, err
+		}
+		return manifest, nil
+	}
+
+	manifest, err := readPolledManifest(models.DeltaUrl(manifestUrl), manifestUrl)
+	if err != nil {
+		return previous, err
+	}
+	if err := manifest.ApplyDelta(previous); err != nil {
+		// the origin skipped segments the previous playlist does not hold, so it is fetched whole instead
+		slog.Debug("falling back to the full playlist", slog.String("url", downloader.RedactUrl(manifestUrl)), slog.String("error", err.Error()))
+		if manifest, err = readPolledManifest(manifestUrl, manifestUrl); err != nil {
+			return previous, err
+		}
+	}
+	return manifest, nil
+}
+
+func readPolledManifest(requestUrl string, manifestUrl string) (*models.Manifest, error) {
+	body, err := downloader.OpenUrl(requestUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return models.ReadManifestWithOptions(body, manifestUrl, models.ReadOptions{MediaQuery: mediaQuery})
+}
+
+func checkHealth(manifestUrl string, previous *models.Manifest, fetched time.Time, maxLatency time.Duration) (*models.Manifest, error) {
+	manifest, err := pollManifest(manifestUrl, previous, fetched)
+	if err != nil {
+		return previous, err
+	}
+
+	sequence := manifest.LastSequence()
+	if previous != nil && sequence <= previous.LastSequence() {
+		return manifest, fmt.Errorf("media sequence stalled at %d", sequence)
+	}
+
+	if edge := manifest.LiveEdgeTime(); !edge.IsZero() && time.Since(edge) > maxLatency {
+		return manifest, fmt.Errorf("program date time is stale by %s", time.Since(edge).Round(time.Second))
+	}
+
+	entry := manifest.LastEntry()
+	if entry == nil {
+		return manifest, errors.New("playlist has no fragments")
+	}
+	if err := downloader.CheckUrl(manifest.DynamicUrl(*entry).String()); err != nil {
+		return manifest, err
+	}
+
+	return manifest, nil
+}
+
+func fireWebhook(webhook string, alert healthAlert) error {
+	b, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	resp, err := downloader.Client.Post(webhook, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func health(ctx *cli.Context) (err error) {
+	manifestUrl := ctx.Args().Get(0)
+	if manifestUrl == "" {
+		return errors.New("no manifest url provided")
+	}
+
+	ticker := time.NewTicker(ctx.Duration(ArgInterval))
+	defer ticker.Stop()
+
+	bitrate := newBitrateMonitor(ctx, healthBitrateHistory)
+	var manifest *models.Manifest
+	var fetched time.Time
+	for {
+		polled := time.Now()
+		manifest, err = checkHealth(manifestUrl, manifest, fetched, ctx.Duration(ArgMaxLatency))
+		fetched = polled
+		if err != nil {
+			slog.Error("stream is unhealthy", slog.String("url", manifestUrl), slog.String("error", err.Error()))
+			if webhook := ctx.String(ArgWebhook); webhook != "" {
+				if whErr := fireWebhook(webhook, healthAlert{Url: manifestUrl, Error: err.Error(), Timestamp: time.Now()}); whErr != nil {
+					slog.Error("failed to fire webhook", slog.String("url", webhook), slog.String("error", whErr.Error()))
+				}
+			}
+			return err
+		}
+
+		// an out of bounds bitrate is alerted without ending the check, as the encoder may recover
+		measured, bitrateErr := bitrate.check(manifest)
+		var alert bitrateAlert
+		if errors.As(bitrateErr, &alert) {
+			alertBitrate(manifestUrl, ctx.String(ArgWebhook), alert)
+		} else if bitrateErr != nil {
+			slog.Warn("failed to measure bitrate", slog.String("url", manifestUrl), slog.String("error", bitrateErr.Error()))
+		}
+
+		slog.Info("stream is healthy", slog.String("url", manifestUrl), slog.Int("sequence", manifest.LastSequence()), slog.Int("bitrate", int(measured)))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+var HealthCommand = &cli.Command{
+	Name:   "health",
+	Usage:  "Continuously validate a live HLS manifest url and exit non-zero when it stalls",
+	Action: health,
+	Flags:  healthFlags,
+}
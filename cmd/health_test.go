@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/models"
+)
+
+// livePlaylist serves a sliding window of four segments ending at sequence, and delta updates skipping all but the
+// last of them.
+func livePlaylist(sequence int, delta bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-SERVER-CONTROL:CAN-SKIP-UNTIL=12\n#EXT-X-MEDIA-SEQUENCE:%d\n", sequence-3)
+	first := sequence - 3
+	if delta {
+		fmt.Fprintf(&b, "#EXT-X-SKIP:SKIPPED-SEGMENTS=3\n")
+		first = sequence
+	}
+	for index := first; index <= sequence; index++ {
+		fmt.Fprintf(&b, "#EXTINF:4.0,\ns%d.ts\n", index)
+	}
+	return b.String()
+}
+
+func TestPollManifestDelta(t *testing.T) {
+	var mu sync.Mutex
+	sequence := 10
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		delta := r.URL.Query().Get("_HLS_skip") == "YES"
+		requests = append(requests, fmt.Sprintf("%d delta=%t", sequence, delta))
+		fmt.Fprint(w, livePlaylist(sequence, delta))
+	}))
+	defer server.Close()
+	manifestUrl := server.URL + "/live.m3u8"
+
+	manifest, err := pollManifest(manifestUrl, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := time.Now()
+
+	// one segment later the delta skips segments the previous playlist holds
+	sequence = 11
+	if manifest, err = pollManifest(manifestUrl, manifest, fetched); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.MediaSequence != 8 || manifest.LastSequence() != 11 {
+		t.Errorf("delta update holds %d-%d, want 8-11", manifest.MediaSequence, manifest.LastSequence())
+	}
+
+	// a playlist older than half of CAN-SKIP-UNTIL is reloaded whole
+	sequence = 14
+	if manifest, err = pollManifest(manifestUrl, manifest, fetched.Add(-6*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.MediaSequence != 11 || manifest.LastSequence() != 14 {
+		t.Errorf("full reload holds %d-%d, want 11-14", manifest.MediaSequence, manifest.LastSequence())
+	}
+
+	// a delta skipping segments the previous playlist lacks falls back to the full playlist
+	sequence = 20
+	if manifest, err = pollManifest(manifestUrl, manifest, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.MediaSequence != 17 || manifest.LastSequence() != 20 {
+		t.Errorf("fallback holds %d-%d, want 17-20", manifest.MediaSequence, manifest.LastSequence())
+	}
+
+	want := []string{"10 delta=false", "11 delta=true", "14 delta=false", "20 delta=true", "20 delta=false"}
+	if strings.Join(requests, ", ") != strings.Join(want, ", ") {
+		t.Errorf("requested %v, want %v", requests, want)
+	}
+}
+
+func TestCheckHealthStalePerSegmentProgramDateTime(t *testing.T) {
+	edge := time.Now().Add(-5 * time.Minute).UTC()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".ts") {
+			return
+		}
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:10\n")
+		for index := 3; index > 0; index-- {
+			fmt.Fprintf(w, "#EXT-X-PROGRAM-DATE-TIME:%s\n#EXTINF:10.0,\ns%d.ts\n", edge.Add(-time.Duration(index)*10*time.Second).Format(models.TimeFormat), index)
+		}
+	}))
+	defer server.Close()
+
+	if _, err := checkHealth(server.URL+"/live.m3u8", nil, time.Time{}, time.Minute); err == nil || !strings.Contains(err.Error(), "stale") {
+		t.Errorf("stalled stream checked as %v, want stale", err)
+	}
+}
@@ -1,11 +1,27 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"manifestr/pkg/models"
-	"manifestr/pkg/utils"
+	"io"
+	"io/fs"
+	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/report"
+	"github.com/alehechka/manifestr/pkg/storage"
+	"github.com/alehechka/manifestr/pkg/telemetry"
+	"github.com/alehechka/manifestr/pkg/utils"
 	"github.com/urfave/cli/v2"
 )
 
@@ -13,66 +29,1146 @@ const (
 	ArgDirectory     = "directory"
 	ArgForceDownload = "force-download"
 	ArgConcatMp4     = "concat-mp4"
+	ArgConcatMode    = "concat-mode"
+	ArgAssets        = "assets"
+	ArgRetryPasses   = "retry-passes"
+	ArgConcurrency   = "concurrency"
+	ArgRetries       = "retries"
+	ArgRetryBackoff  = "retry-backoff"
+	ArgAppend        = "append"
+	ArgBaseUrl       = "base-url"
+	ArgFallbackUrl   = "fallback-base-url"
+	ArgFilter        = "filter"
+	ArgStripAds      = "strip-ads"
+	ArgIncludeUrl    = "include-url-pattern"
+	ArgExcludeUrl    = "exclude-url-pattern"
+	ArgWarnSize      = "warn-size"
+	ArgVariant       = "variant"
+	ArgAllVariants   = "all-variants"
+	ArgAudioLang     = "audio-lang"
+	ArgSubs          = "subs"
+	ArgTimedMetadata = "timed-metadata"
+	ArgStrict        = "strict"
+	ArgLocalFileMode = "local-file-mode"
+	ArgLenient       = "lenient"
+	ArgContainer     = "container"
+	ArgPrintFfmpeg   = "print-ffmpeg"
+	ArgIdleTimeout   = "idle-timeout"
+	ArgIdleRetries   = "idle-retries"
+	ArgWriteBuffer   = "write-buffer-kib"
+	ArgFsync         = "fsync"
+	ArgVerify        = "verify-existing"
+	ArgOverwrite     = "overwrite"
+	ArgSkipExisting  = "skip-existing"
+	ArgRenameExist   = "rename-existing"
+	ArgScanCommand   = "scan-command"
+	ArgValidate      = "validate"
+	ArgArchiveDir    = "archive-dir"
+	ArgSharedStore   = "shared-store"
+	ArgStorePolicy   = "store-policy"
+	ArgQuota         = "quota"
+	ArgAvSync        = "av-sync"
+	ArgProgress      = "progress"
+	ArgLive          = "live"
+	ArgLiveJoin      = "live-join"
+	ArgPreloadHint   = "preload-hint"
+	ArgStart         = "start"
+	ArgEnd           = "end"
+	ArgClipReencode  = "clip-reencode"
+	ArgInferTime     = "infer-time"
+	ArgOutputName    = "output-name"
+	ArgSplitOutput   = "split-output"
+	ArgPlay          = "play"
+	ArgPlayAfter     = "play-after"
+	ArgStage         = "stage"
+	ArgRelay         = "relay"
+	ArgRelayWindow   = "relay-window"
+	ArgPush          = "push"
+	ArgUploadTo      = "upload-to"
+	ArgPlayer        = "player"
+	ArgResume        = "resume"
+	ArgNaming        = "naming"
 )
 
 var hlsFlags = []cli.Flag{
 	&cli.StringFlag{
 		Name:    ArgDirectory,
 		Aliases: []string{"d", "dir"},
-		Usage:   fmt.Sprintf("Specify a directory to download files to and/or use as an existing location to skip downloading files that exist (see --%s for more details).", ArgForceDownload),
+		Usage:   fmt.Sprintf("Specify a directory to download files to and/or use as an existing location to skip downloading files that exist (see --%s for more details). An s3://bucket/prefix or gs://bucket/prefix URI streams the files and outputs straight to object storage, with credentials taken from the usual AWS_* variables and shared AWS profiles, or GOOGLE_OAUTH_ACCESS_TOKEN and the application default credentials. Requests to buckets honor --proxy, --timeout and --trace-http.", ArgForceDownload),
 	},
 	&cli.BoolFlag{
-		Name:  ArgForceDownload,
-		Usage: fmt.Sprintf("Used in conjunction with --%s to force download all files in a manifest when they exist in the provided directory.", ArgDirectory),
+		Name:  ArgResume,
+		Usage: fmt.Sprintf("Resume the download recorded in the %s of --%s, with the urls and flags it was started with, e.g. after a reboot or on another machine the directory was moved to. Urls and flags given on the command line win, and --%s %s repairs fragments damaged on the way.", models.SessionFileName, ArgDirectory, ArgVerify, utils.VerifyChecksum),
+	},
+	&cli.BoolFlag{
+		Name:    ArgForceDownload,
+		Aliases: []string{"force"},
+		Usage:   fmt.Sprintf("Used in conjunction with --%s to force download all files in a manifest when they exist in the provided directory.", ArgDirectory),
 	},
 	&cli.BoolFlag{
 		Name:  ArgConcatMp4,
-		Usage: "After downloading all fragments will concat them and transmux if needed into an MP4 file.",
+		Usage: fmt.Sprintf("After downloading all fragments will concat them and transmux if needed into an MP4 file per discontinuity, short for --%s %s.", ArgConcatMode, models.ConcatPerDiscontinuity),
+	},
+	&cli.StringFlag{
+		Name:  ArgConcatMode,
+		Usage: fmt.Sprintf("How the discontinuities map to MP4 outputs: %q merges them into one MP4 with the ffmpeg concat demuxer, re-timestamping each to follow on from the previous one, %q writes an MP4 of every discontinuity, named by its #EXT-X-PROGRAM-DATE-TIME when they all have one, and %q skips ffmpeg, leaving the local manifest and fragments.", models.ConcatSingle, models.ConcatPerDiscontinuity, models.ConcatNone),
+	},
+	&cli.StringFlag{
+		Name:  ArgOutputName,
+		Usage: fmt.Sprintf("Used in conjunction with --%s to name the MP4 of every discontinuity, without extension: {index} or {index:04d} is its position, {title} the #EXTINF title of its first fragment and strftime directives such as %%Y%%m%%d-%%H%%M%%S its #EXT-X-PROGRAM-DATE-TIME in UTC. A name taken by an earlier discontinuity gets -NNNN, its index, appended. Defaults to %s, or %s for --%s %s.", ArgConcatMp4, models.DefaultOutputName, models.SingleOutputName, ArgConcatMode, models.ConcatSingle),
+	},
+	namingFlag,
+	&cli.StringFlag{
+		Name:  ArgSplitOutput,
+		Usage: fmt.Sprintf("Used in conjunction with --%s to split every MP4 output into parts of at most this size (e.g. 4GB for FAT32) or about this long (e.g. 1h), cut at key frames without re-encoding and numbered as output-001.mp4.", ArgConcatMp4),
+	},
+	&cli.BoolFlag{
+		Name:  ArgAssets,
+		Usage: fmt.Sprintf("Also download external assets referenced by the playlist (#EXT-X-SESSION-DATA, #EXT-X-DATERANGE asset uris and image playlists) into the %q subfolder.", models.AssetsDir),
+	},
+	&cli.StringFlag{
+		Name:  ArgLocalFileMode,
+		Value: utils.LocalFileCopy,
+		Usage: fmt.Sprintf("How fragments referenced by absolute local paths are placed in the directory: %q, %q or %q.", utils.LocalFileCopy, utils.LocalFileHardlink, utils.LocalFileSymlink),
+	},
+	&cli.BoolFlag{
+		Name:  ArgAppend,
+		Usage: fmt.Sprintf("Extend the archive in --%s from a previous run with only the newly published segments, for growing EVENT playlists. The manifest is always fetched again.", ArgDirectory),
+	},
+	&cli.IntFlag{
+		Name:  ArgRetryPasses,
+		Usage: "Number of times to re-fetch the manifest (e.g. to pick up refreshed tokens) and retry only the fragments that failed.",
+	},
+	&cli.IntFlag{
+		Name:  ArgConcurrency,
+		Value: models.DefaultConcurrency,
+		Usage: "Maximum number of files downloaded at once.",
+	},
+	&cli.IntFlag{
+		Name:  ArgRetries,
+		Value: models.DefaultRetries,
+		Usage: "Number of times a download is retried after a server error, rate limiting, stall or network timeout, waiting twice as long before each retry.",
+	},
+	&cli.DurationFlag{
+		Name:  ArgRetryBackoff,
+		Value: models.DefaultRetryBackoff,
+		Usage: fmt.Sprintf("Wait before the first of the --%s, doubled for each next one.", ArgRetries),
+	},
+	&cli.StringFlag{
+		Name:  ArgBaseUrl,
+		Usage: "Url to resolve relative fragment uris against instead of the manifest url. Required to resolve relative uris when reading the manifest from stdin with -.",
+	},
+	&cli.StringFlag{
+		Name:  ArgVariant,
+		Value: models.VariantBest,
+		Usage: fmt.Sprintf("Variant stream to download when given a master playlist: %q or %q by bandwidth, a resolution such as 1280x720 or 720p, or the highest bandwidth in bits per second to accept.", models.VariantBest, models.VariantWorst),
+	},
+	&cli.BoolFlag{
+		Name:  ArgAllVariants,
+		Usage: fmt.Sprintf("Download every variant stream of a master playlist instead of one, each into a subfolder such as 720p-2500000, and write a local.master.m3u8 pointing at their local manifests. The variants are downloaded at once, sharing --%s and the rate limits, and each is concatenated by itself as --%s says, keeping the renditions in their own subfolders rather than muxing them. With --%s every variant and rendition is recorded at once, and the recordings are cut to the window they share by #EXT-X-PROGRAM-DATE-TIME or media sequence number so players can switch between them.", ArgConcurrency, ArgConcatMode, ArgLive),
+	},
+	&cli.StringFlag{
+		Name:    ArgAudioLang,
+		Aliases: []string{"audio"},
+		Value:   models.RenditionsDefault,
+		Usage:   fmt.Sprintf("Alternative audio renditions (#EXT-X-MEDIA) of the selected variant to download into subfolders and mux into the MP4 outputs: %q, %q, %q or comma separated languages such as en,de.", models.RenditionsDefault, models.RenditionsAll, models.RenditionsNone),
+	},
+	&cli.BoolFlag{
+		Name:  ArgTimedMetadata,
+		Usage: fmt.Sprintf("Extract the ID3 timed metadata of the downloaded MPEG-TS segments into a %q sidecar, or the emsg events of fMP4 segments into %q, see the metadata command.", report.TimedMetadataFileName, report.EventMessagesFileName),
+	},
+	&cli.StringFlag{
+		Name:  ArgSubs,
+		Value: models.RenditionsNone,
+		Usage: fmt.Sprintf("Subtitle renditions (#EXT-X-MEDIA) of the selected variant to download into subfolders and mux into the MP4 outputs: %q, %q, %q or comma separated languages such as en,de.", models.RenditionsNone, models.RenditionsAll, models.RenditionsDefault),
+	},
+	&cli.StringSliceFlag{
+		Name:  ArgFallbackUrl,
+		Usage: fmt.Sprintf("Backup origin serving byte-identical fragments, tried once the retries of the primary origin are exhausted. Either a base url for the relative fragment uris, or a primary%sbackup pair of url prefixes to rewrite absolute ones. Can be repeated.", models.FallbackSeparator),
+	},
+	&cli.BoolFlag{
+		Name:  ArgStripAds,
+		Usage: "Leave out the fragments of ad breaks signalled by #EXT-X-CUE-OUT/#EXT-X-CUE-IN markers.",
+	},
+	&cli.StringFlag{
+		Name:  ArgWarnSize,
+		Usage: fmt.Sprintf("Warn before downloading when the size of the selected variant, estimated from its bandwidth and the runtime of the playlist, exceeds this budget such as 2G, suggesting the best --%s that fits. Handy on metered connections.", ArgVariant),
+	},
+	&cli.StringSliceFlag{
+		Name:  ArgIncludeUrl,
+		Usage: "Only download segments whose resolved url matches this regular expression, leaving the others out of the local manifest and outputs. Repeatable, a segment matching any of them is kept.",
+	},
+	&cli.StringSliceFlag{
+		Name:  ArgExcludeUrl,
+		Usage: "Leave out the segments whose resolved url matches this regular expression, e.g. 'slate\\.example\\.com' to skip the slates or ads served from a known host. Repeatable, and applied after --" + ArgIncludeUrl + ".",
+	},
+	&cli.StringFlag{
+		Name:  ArgFilter,
+		Usage: fmt.Sprintf("Only download segments matching an expression such as 'duration > 1 && seq >= 100'. Variables: %s.", strings.Join(models.FilterVariables, ", ")),
+	},
+	&cli.BoolFlag{
+		Name:  ArgStrict,
+		Usage: "Fail when the manifest contains unrecognized or malformed tags, listing each with its line number.",
+	},
+	&cli.BoolFlag{
+		Name:  ArgLenient,
+		Usage: "Repair common playlist violations (missing #EXTM3U, uri on the #EXTINF line, blank lines before a uri) and report each fix.",
+	},
+	&cli.StringFlag{
+		Name:  ArgContainer,
+		Value: models.ContainerMp4,
+		Usage: fmt.Sprintf("Output container when concatenating: %q transmuxes as --%s says, %q byte-concatenates all MPEG-TS fragments into one continuous file without ffmpeg unless --%s is %s.", models.ContainerMp4, ArgConcatMode, models.ContainerTs, ArgConcatMode, models.ConcatNone),
+	},
+	&cli.BoolFlag{
+		Name:  ArgPrintFfmpeg,
+		Usage: "Print the ffmpeg commands that would be run to stdout instead of executing them.",
+	},
+	&cli.DurationFlag{
+		Name:  ArgIdleTimeout,
+		Usage: "Abort and retry a fragment transfer when no bytes arrive for this long (e.g. 10s), independently of how long the whole transfer takes.",
+	},
+	&cli.IntFlag{
+		Name:  ArgIdleRetries,
+		Value: utils.DefaultIdleRetries,
+		Usage: fmt.Sprintf("Used in conjunction with --%s to set how many times a stalled transfer is restarted.", ArgIdleTimeout),
+	},
+	&cli.IntFlag{
+		Name:  ArgWriteBuffer,
+		Value: utils.DefaultWriteBufferSize / 1024,
+		Usage: "Size in KiB of the buffer each fragment is written through.",
+	},
+	&cli.StringFlag{
+		Name:  ArgFsync,
+		Value: utils.FsyncNever,
+		Usage: fmt.Sprintf("When to flush fragments to stable storage: %q leaves it to the OS, %q syncs all fragments once downloads finish, %q syncs every fragment as it completes.", utils.FsyncNever, utils.FsyncBatch, utils.FsyncEach),
+	},
+	&cli.StringFlag{
+		Name:    ArgVerify,
+		Aliases: []string{"verify"},
+		Value:   utils.VerifyExists,
+		Usage:   fmt.Sprintf("How fragments that already exist in --%s are validated before being skipped: %q trusts any existing file of the size it was downloaded with, %q compares it with its recorded SHA-256, %q re-checks it against the Content-Length and ETag the origin reports now. Stale files are downloaded again, partial ones left by an interrupted run are resumed.", ArgDirectory, utils.VerifyExists, utils.VerifyChecksum, utils.VerifyRemote),
+	},
+	&cli.BoolFlag{
+		Name:  ArgOverwrite,
+		Usage: "Replace final outputs (MP4s, clips, concatenated TS) that already exist. This is the default, asking first on a terminal unless --" + ArgYes + " is set.",
+	},
+	&cli.BoolFlag{
+		Name:  ArgSkipExisting,
+		Usage: "Keep final outputs that already exist and skip producing them again.",
+	},
+	&cli.BoolFlag{
+		Name:  ArgRenameExist,
+		Usage: "Move final outputs that already exist aside to name.N.ext before producing new ones.",
+	},
+	&cli.BoolFlag{
+		Name:  ArgValidate,
+		Usage: "Check the structure of every fragment (MPEG-TS packets, fMP4 moof/mdat boxes and the init segment moov box), downloading truncated or corrupt ones again (from a failover origin when one is given).",
+	},
+	&cli.StringFlag{
+		Name:  ArgScanCommand,
+		Usage: "Command run on every downloaded fragment with its path appended, e.g. 'clamscan --no-summary'. Fragments it exits non-zero for are deleted and left out of the outputs.",
+	},
+	&cli.StringFlag{
+		Name:  ArgStage,
+		Usage: fmt.Sprintf("Run a single stage, %s, %s or %s, for pipelines running each as its own job on a shared --%s. Each stage leaves a stage.<name>.json handoff for the next, which reads the manifest and options from it, so the %s and %s stages take no url.", models.StageDownload, models.StageMux, models.StageUpload, ArgDirectory, models.StageMux, models.StageUpload),
 	},
+	&cli.StringFlag{
+		Name:  ArgUploadTo,
+		Usage: "Copy the outputs, or the local manifests and fragments when there are none, to this directory or object storage such as s3://bucket/prefix once they are produced. Required by --" + ArgStage + " " + models.StageUpload + ".",
+	},
+	&cli.StringFlag{
+		Name:  ArgArchiveDir,
+		Usage: "Also keep the raw fragment archive and its manifests in this directory, hardlinked or reflinked where supported so it does not double disk usage.",
+	},
+	&cli.BoolFlag{
+		Name:  ArgSharedStore,
+		Usage: "Reuse init segments and keys fetched by earlier runs, such as downloads of other variants of the same presentation, from a content-addressable store in the user cache directory.",
+	},
+	&cli.StringFlag{
+		Name:  ArgStorePolicy,
+		Value: utils.StoreAuto,
+		Usage: fmt.Sprintf("Used in conjunction with --%s to decide what the store reuses and keeps: %q honors an #EXT-X-ALLOW-CACHE:NO in the playlist and a Cache-Control: no-store served with a file, %q only the playlist, and %q neither.", ArgSharedStore, utils.StoreAuto, utils.StorePlaylist, utils.StoreAlways),
+	},
+	&cli.BoolFlag{
+		Name:  ArgLive,
+		Usage: "Record a live or event playlist: reload it every target duration, downloading new segments as they are published, until it ends with #EXT-X-ENDLIST, the --duration limit is reached or the process is interrupted.",
+	},
+	&cli.DurationFlag{
+		Name:  ArgDuration,
+		Usage: fmt.Sprintf("Used in conjunction with --%s to stop recording after the given duration (e.g. 1h).", ArgLive),
+	},
+	&cli.BoolFlag{
+		Name:  ArgPreloadHint,
+		Usage: fmt.Sprintf("Used in conjunction with --%s to also download the LL-HLS partial segments at the live edge into the %q subfolder, blocking on the #EXT-X-PRELOAD-HINT until the origin publishes it. The parts of a segment are removed once it is published and downloaded whole.", ArgLive, models.PartsDir),
+	},
+	&cli.StringFlag{
+		Name:  ArgQuota,
+		Usage: fmt.Sprintf("Stop downloading once the directory holds this much, such as 50G, counting what it held before, and finish the outputs from what was downloaded, marking the %s as quota-truncated. Protects shared storage from runaway captures. When outputs are concatenated, every fragment reserves as much again for them, so the fragments take up about half of the quota; clips and merged outputs are written on top.", report.RunReportFileName),
+	},
+	&cli.StringFlag{
+		Name:  ArgLiveJoin,
+		Value: models.LiveJoinKeep,
+		Usage: fmt.Sprintf("Used in conjunction with --%s to handle the segment the recording joins in the middle of: %q records it, %q leaves it out, %q trims the first MP4 of --%s to start at its first key frame.", ArgLive, models.LiveJoinKeep, models.LiveJoinDrop, models.LiveJoinGop, ArgConcatMp4),
+	},
+	&cli.StringFlag{
+		Name:  ArgAvSync,
+		Value: models.AvSyncOff,
+		Usage: fmt.Sprintf("Used in conjunction with --%s to measure the offset between the first audio and video timestamps of each MP4: %q, %q logs it, %q also shifts the audio back in line with -itsoffset.", ArgConcatMp4, models.AvSyncOff, models.AvSyncReport, models.AvSyncCorrect),
+	},
+	&cli.BoolFlag{
+		Name:  ArgProgress,
+		Value: true,
+		Usage: "Report the progress of the downloads with their size, throughput and ETA: as a bar when stderr is a terminal, printing log lines above it, otherwise as a log line every 10s.",
+	},
+	&cli.BoolFlag{
+		Name:  ArgPlay,
+		Usage: fmt.Sprintf("Preview the download in a player while the remaining segments download: once the first --%s segments are in, the player is launched on %s served from a local port, which lists the segments downloaded so far.", ArgPlayAfter, PreviewPlaylistName),
+	},
+	&cli.IntFlag{
+		Name:  ArgPlayAfter,
+		Value: DefaultPlayAfter,
+		Usage: fmt.Sprintf("Used in conjunction with --%s, the number of leading segments to download before launching the player.", ArgPlay),
+	},
+	&cli.StringFlag{
+		Name:  ArgRelay,
+		Usage: fmt.Sprintf("Used in conjunction with --%s to republish the recording on this address (e.g. :8081) as %s, a live playlist of the last --%s segments downloaded, so players can watch through the buffer of the recorder rather than an unreliable origin.", ArgLive, RelayPlaylistName, ArgRelayWindow),
+	},
+	&cli.IntFlag{
+		Name:  ArgRelayWindow,
+		Value: models.DefaultRelayWindow,
+		Usage: fmt.Sprintf("Used in conjunction with --%s, the number of segments listed by %s.", ArgRelay, RelayPlaylistName),
+	},
+	&cli.StringFlag{
+		Name:  ArgPush,
+		Usage: fmt.Sprintf("Used in conjunction with --%s to also push the recording with ffmpeg to an RTMP (rtmp://host/app/key) or SRT (srt://host:port) ingest point without re-encoding, reading it from the --%s playlist, or a private one when it is not set.", ArgLive, ArgRelay),
+	},
+	&cli.StringFlag{
+		Name:  ArgPlayer,
+		Usage: fmt.Sprintf("Used in conjunction with --%s, the player command the preview url is appended to, e.g. 'vlc --play-and-exit'. Defaults to %s, whichever is installed first.", ArgPlay, strings.Join(previewPlayers, " or ")),
+	},
+	&cli.StringFlag{
+		Name:  ArgStart,
+		Usage: fmt.Sprintf("Only download the fragments from the given offset (e.g. 1h20m) or wall-clock time matched against #EXT-X-PROGRAM-DATE-TIME (e.g. 2024-01-01T10:00:00Z) on. With --%s the output is also clipped to start there, snapped back to the nearest key frame.", ArgConcatMp4),
+	},
+	&cli.StringFlag{
+		Name:  ArgEnd,
+		Usage: fmt.Sprintf("Only download the fragments up to the given offset (e.g. 1h30m) or wall-clock time, which must be after --%s. With --%s the output is also clipped to end there.", ArgStart, ArgConcatMp4),
+	},
+	&cli.BoolFlag{
+		Name:  ArgClipReencode,
+		Usage: fmt.Sprintf("Used in conjunction with --%s and --%s or --%s to clip the output exactly there rather than from the key frame before --%s, re-encoding only the GOPs the bounds fall in. The streams must be H.264 and AAC.", ArgConcatMp4, ArgStart, ArgEnd, ArgStart),
+	},
+	inferTimeFlag,
+}
+
+var namingFlag = &cli.StringFlag{
+	Name:  ArgNaming,
+	Value: models.NamingOriginal,
+	Usage: fmt.Sprintf("How fragments and init segments are named: %q after their uri, %q after their media sequence number, e.g. 00000042, for opaque or tokenized uris, or %q after a hash of their uri without its query string. Give it to every run into the same directory.", models.NamingOriginal, models.NamingSequence, models.NamingHash),
+}
+
+var inferTimeFlag = &cli.BoolFlag{
+	Name:  ArgInferTime,
+	Usage: "Infer the #EXT-X-PROGRAM-DATE-TIME of discontinuities lacking one from the nearest one plus the #EXTINF durations in between, or from the Last-Modified time of a playlist without any, for wall-clock times and timelines.",
+}
+
+func hls(ctx *cli.Context) error {
+	return runHls(ctx, ctx.Args().Slice(), false)
+}
+
+// concatMode returns the --concat-mode, which --concat-mp4 stands for as per-discontinuity, or an empty one when neither is
+// given.
+func concatMode(ctx *cli.Context) (string, error) {
+	mode := ctx.String(ArgConcatMode)
+	switch mode {
+	case "":
+		if ctx.Bool(ArgConcatMp4) {
+			return models.ConcatPerDiscontinuity, nil
+		}
+	case models.ConcatNone:
+		if ctx.Bool(ArgConcatMp4) {
+			return "", fmt.Errorf("--%s cannot be used with --%s %s", ArgConcatMp4, ArgConcatMode, mode)
+		}
+	case models.ConcatSingle, models.ConcatPerDiscontinuity:
+	default:
+		return "", fmt.Errorf("unknown concat mode %q", mode)
+	}
+	return mode, nil
+}
+
+// splitOutput parses --split-output into the size or the duration the MP4 outputs of concat are split by.
+func splitOutput(ctx *cli.Context, concat string) (size int64, duration time.Duration, err error) {
+	if !ctx.IsSet(ArgSplitOutput) {
+		return 0, 0, nil
+	}
+	if ctx.String(ArgContainer) != models.ContainerMp4 || (concat != models.ConcatSingle && concat != models.ConcatPerDiscontinuity) {
+		return 0, 0, fmt.Errorf("--%s splits MP4 outputs, use it with --%s or --%s %s", ArgSplitOutput, ArgConcatMp4, ArgConcatMode, models.ConcatSingle)
+	}
+	if size, duration, err = models.ParseSplitLimit(ctx.String(ArgSplitOutput)); err != nil {
+		return 0, 0, fmt.Errorf("invalid --%s: %w", ArgSplitOutput, err)
+	}
+	return size, duration, nil
+}
+
+// outputName returns the --output-name, defaulting to models.SingleOutputName for the merged MP4 of --concat-mode single
+// and to the date of every discontinuity when --concat-mode per-discontinuity is asked for explicitly and they all have
+// one.
+func outputName(ctx *cli.Context, mode string, manifest *models.Manifest) string {
+	if ctx.IsSet(ArgOutputName) {
+		return ctx.String(ArgOutputName)
+	}
+	switch {
+	case mode == models.ConcatSingle:
+		return models.SingleOutputName
+	case mode == models.ConcatPerDiscontinuity && ctx.IsSet(ArgConcatMode):
+		for _, discontinuity := range manifest.Discontinuities {
+			if discontinuity.ProgramDateTime.IsZero() {
+				return models.DefaultOutputName
+			}
+		}
+		return models.DatedOutputName
+	}
+	return models.DefaultOutputName
 }
 
-func hls(ctx *cli.Context) (err error) {
-	// slog.SetLogLoggerLevel(slog.LevelDebug)
+// inferProgramDateTimes fills in the program date times manifest lacks for --infer-time, anchoring a playlist without
+// any at the Last-Modified time of playlistUrl.
+func inferProgramDateTimes(manifest *models.Manifest, playlistUrl string) {
+	var lastModified time.Time
+	if !manifest.HasProgramDateTime() && playlistUrl != utils.StdinUrl {
+		var err error
+		if lastModified, err = downloader.LastModified(playlistUrl); err != nil {
+			slog.Warn("failed to get the Last-Modified time of the playlist", slog.String("url", playlistUrl), slog.String("error", err.Error()))
+		}
+	}
+	if inferred := manifest.InferProgramDateTimes(lastModified); inferred > 0 {
+		slog.Info("inferred program date times", slog.Int("discontinuities", inferred), slog.Bool("lastModified", !lastModified.IsZero()))
+	}
+}
 
-	manifestUrl := ctx.Args().Get(0)
-	if manifestUrl == "" {
-		return errors.New("no manifest url provided")
+// compileUrlPatterns compiles the regular expressions given as the flag name.
+func compileUrlPatterns(ctx *cli.Context, name string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0)
+	for _, expression := range ctx.StringSlice(name) {
+		pattern, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s: %w", name, err)
+		}
+		patterns = append(patterns, pattern)
 	}
+	return patterns, nil
+}
 
-	forceDownload := ctx.Bool(ArgForceDownload)
-	directory, err := utils.CreateDirectoryOrTemp(ctx.String(ArgDirectory))
+// validateOutputName rejects an --output-name that would place the MP4s outside of the download directory.
+func validateOutputName(outputName string) error {
+	if strings.ContainsAny(outputName, `/\`) {
+		return fmt.Errorf("--%s %q must be a file name, not a path", ArgOutputName, outputName)
+	}
+	return nil
+}
+
+// outputPolicy returns the output policy selected by the mutually exclusive policy flags. Only the default policy asks
+// before replacing an output, one chosen explicitly or by --force-download is what the user confirmed.
+func outputPolicy(ctx *cli.Context) (utils.OutputPolicy, error) {
+	policy := utils.OutputPolicy{Mode: utils.OutputOverwrite}
+	selected := 0
+	for flag, value := range map[string]string{ArgOverwrite: utils.OutputOverwrite, ArgSkipExisting: utils.OutputSkip, ArgRenameExist: utils.OutputRename} {
+		if ctx.Bool(flag) {
+			policy.Mode = value
+			selected++
+		}
+	}
+
+	if selected > 1 {
+		return policy, fmt.Errorf("only one of --%s, --%s and --%s can be used", ArgOverwrite, ArgSkipExisting, ArgRenameExist)
+	}
+	// a forced re-run replaces its outputs in place rather than keeping stale ones or piling up renamed copies
+	if ctx.Bool(ArgForceDownload) && policy.Mode != utils.OutputOverwrite {
+		return policy, fmt.Errorf("--%s always overwrites existing outputs and cannot be used with --%s or --%s", ArgForceDownload, ArgSkipExisting, ArgRenameExist)
+	}
+	if !ctx.IsSet(ArgOverwrite) && !ctx.Bool(ArgForceDownload) {
+		policy.Confirm = func(question string) bool { return confirm(ctx, question) }
+	}
+	return policy, nil
+}
+
+// transferOptions returns how fragments are transferred and written as --local-file-mode, --fsync, --verify,
+// --write-buffer and --idle-timeout say.
+func transferOptions(ctx *cli.Context) (utils.TransferOptions, error) {
+	options := utils.TransferOptions{
+		IdleTimeout:     ctx.Duration(ArgIdleTimeout),
+		IdleRetries:     ctx.Int(ArgIdleRetries),
+		WriteBufferSize: ctx.Int(ArgWriteBuffer) * 1024,
+	}
+
+	switch mode := ctx.String(ArgLocalFileMode); mode {
+	case utils.LocalFileCopy, utils.LocalFileHardlink, utils.LocalFileSymlink:
+		options.LocalFileMode = mode
+	default:
+		return options, fmt.Errorf("unknown local file mode %q", mode)
+	}
+
+	switch policy := ctx.String(ArgFsync); policy {
+	case utils.FsyncNever, utils.FsyncEach:
+		options.Fsync = policy
+	case utils.FsyncBatch:
+		options.Fsync, options.Batch = policy, &utils.SyncBatch{}
+	default:
+		return options, fmt.Errorf("unknown fsync policy %q", policy)
+	}
+
+	switch policy := ctx.String(ArgVerify); policy {
+	case utils.VerifyExists, utils.VerifyChecksum, utils.VerifyRemote:
+		options.Verify = policy
+	default:
+		return options, fmt.Errorf("unknown verify policy %q", policy)
+	}
+	return options, nil
+}
+
+// storePolicy returns the --store-policy the shared store is opened with.
+func storePolicy(ctx *cli.Context) (string, error) {
+	switch policy := ctx.String(ArgStorePolicy); policy {
+	case utils.StoreAuto, utils.StorePlaylist, utils.StoreAlways:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown store policy %q", policy)
+	}
+}
+
+// readOptions returns how the playlists of the run are read, naming their fragments as --naming says and adding --query
+// to the urls of their media.
+func readOptions(ctx *cli.Context) (models.ReadOptions, error) {
+	namer, err := models.NewNamer(ctx.String(ArgNaming))
 	if err != nil {
+		return models.ReadOptions{}, err
+	}
+	return models.ReadOptions{Strict: ctx.Bool(ArgStrict), Lenient: ctx.Bool(ArgLenient), Namer: namer, MediaQuery: mediaQuery}, nil
+}
+
+// applyReadOptions names the fragments of manifest and of its renditions and adds the media query to their urls as
+// options say, for manifests that were not read with them, such as those of an MPD or a stage handoff.
+func applyReadOptions(manifest *models.Manifest, options models.ReadOptions) {
+	manifest.Namer, manifest.MediaQuery = options.Namer, options.MediaQuery
+	for _, rendition := range manifest.Renditions {
+		if rendition.Manifest != nil {
+			rendition.Manifest.Namer, rendition.Manifest.MediaQuery = options.Namer, options.MediaQuery
+		}
+	}
+}
+
+// withProgressLogging routes slog output above progress until the returned restore function is called.
+func withProgressLogging(progress *utils.Progress) (restore func()) {
+	// the default handler writes through the log package, which slog.SetDefault redirects, so it cannot be wrapped
+	previous, output, flags := slog.Default(), log.Writer(), log.Flags()
+	slog.SetDefault(slog.New(utils.NewProgressHandler(slog.NewTextHandler(os.Stderr, nil), progress)))
+
+	return func() {
+		slog.SetDefault(previous)
+		log.SetOutput(output)
+		log.SetFlags(flags)
+	}
+}
+
+// writeLocalManifests writes the local manifests of the variant and its renditions pointing at the downloaded fragments.
+// Captures of CMAF segments also get a local MPD over the same fragments, so they can be served over DASH as well.
+func writeLocalManifests(ctx context.Context, directory string, manifest *models.Manifest) error {
+	if err := writeLocalManifest(ctx, directory, manifest); err != nil {
 		return err
 	}
+	files := manifest.Files(directory)
+	for _, rendition := range manifest.Renditions {
+		if err := storage.WriteFile(ctx, files, path.Join(rendition.Dir, "local.manifest.m3u8"), rendition.Manifest.WriteLocalManifest); err != nil {
+			return err
+		}
+	}
+
+	if !manifest.IsFmp4() {
+		return nil
+	}
+	return storage.WriteFile(ctx, files, models.LocalMpdFileName, manifest.WriteLocalMpd)
+}
+
+// writeLocalManifest writes the local manifest of the variant next to its fragments, in directory or the object storage
+// given as --directory.
+func writeLocalManifest(ctx context.Context, directory string, manifest *models.Manifest) error {
+	return storage.WriteFile(ctx, manifest.Files(directory), "local.manifest.m3u8", manifest.WriteLocalManifest)
+}
+
+// remoteOutputFlags are the flags relying on the fragments being in a local directory, which object storage given as
+// --directory rules out.
+var remoteOutputFlags = []string{ArgAllVariants, ArgAppend, ArgArchiveDir, ArgSharedStore, ArgQuota, ArgValidate, ArgScanCommand, ArgRenameExist, ArgTimedMetadata, ArgPlay, ArgStage, ArgRelay, ArgPush, ArgPreloadHint}
+
+// openDirectory creates the --directory, or a temporary one when it is not set, returning it with the object storage it
+// addresses when it is a URI such as s3://bucket/prefix. The fragments, local manifests and outputs then go straight to
+// the storage, the temporary directory keeping only working files such as the original manifest and the archive index.
+func openDirectory(ctx *cli.Context) (string, storage.Storage, error) {
+	uri := ctx.String(ArgDirectory)
+	if !storage.IsRemote(uri) {
+		directory, err := utils.CreateDirectoryOrTemp(uri, ctx.Args().First(), tempOptions(ctx))
+		return directory, nil, err
+	}
 
-	manifestPath, err := utils.DownloadFile(directory, "original.manifest.m3u8", manifestUrl, forceDownload)
+	for _, flag := range remoteOutputFlags {
+		if ctx.IsSet(flag) {
+			return "", nil, fmt.Errorf("--%s cannot be used with object storage as --%s", flag, ArgDirectory)
+		}
+	}
+	output, err := storage.Open(uri, downloader.Client)
+	if err != nil {
+		return "", nil, err
+	}
+	directory, err := utils.CreateDirectoryOrTemp("", ctx.Args().First(), tempOptions(ctx))
 	if err != nil {
+		return "", nil, err
+	}
+	slog.Info("writing to object storage", slog.String("output", output.Location("")), slog.String("workingDirectory", directory))
+	return directory, output, nil
+}
+
+// extendArchive merges manifest into the archive manifest kept in directory by previous runs and saves the result.
+func extendArchive(directory string, manifest *models.Manifest) error {
+	if manifest.PlaylistType != models.PlaylistTypeEvent {
+		slog.Warn("appending to an archive of a playlist that is not an EVENT", slog.String("type", manifest.PlaylistType))
+	}
+
+	archivePath := path.Join(directory, "archive.manifest.m3u8")
+	previous, err := models.ReadManifestFromFile(archivePath, "", models.ReadOptions{})
+	if err == nil {
+		appended := manifest.Extend(previous)
+		slog.Info("extending archive", slog.Int("appended", appended), slog.Int("archived", previous.LastSequence()-previous.MediaSequence+1))
+	} else if !errors.Is(err, fs.ErrNotExist) {
 		return err
 	}
 
-	manifest, err := models.ReadManifestFromFile(manifestPath, manifestUrl)
+	archiveFile, err := os.Create(archivePath)
 	if err != nil {
 		return err
 	}
+	defer archiveFile.Close()
 
-	if err := manifest.WriteLocalManifestToFile(directory); err != nil {
-		return err
+	return manifest.WriteManifest(archiveFile)
+}
+
+// playlistSelection is the media playlist loadManifest selected from a master playlist, or was given, with the
+// renditions selected alongside it, which reloadManifest fetches again for live reloads and retry passes instead of
+// selecting them anew.
+type playlistSelection struct {
+	// urls are the media playlist followed by its failovers, tried in order.
+	urls []string
+	// baseUrl resolves the segments of a media playlist given with --base-url instead of the url it is fetched from.
+	baseUrl       string
+	options       models.ReadOptions
+	renditions    []models.Rendition
+	renditionUrls []string
+	// fetched is the media playlist fetched last before it was cut, at fetchedAt, which delta updates apply to.
+	fetched   *models.Manifest
+	fetchedAt time.Time
+}
+
+// sourceUrl returns the url the segments of the media playlist fetched from manifestUrl resolve against.
+func (selection *playlistSelection) sourceUrl(manifestUrl string) string {
+	if selection.baseUrl != "" {
+		return selection.baseUrl
+	}
+	return manifestUrl
+}
+
+// loadManifest downloads and parses the manifest with options, registering the remaining manifestUrls as failovers. A
+// master playlist has its variant and renditions selected, which the returned selection keeps for reloadManifest.
+func loadManifest(runCtx context.Context, ctx *cli.Context, directory string, manifestUrls []string, forceDownload bool, options models.ReadOptions) (*models.Manifest, *playlistSelection, error) {
+	requested := time.Now()
+	manifestUrl, manifestPath, err := downloadManifest(runCtx, manifestCache(ctx), directory, manifestUrls, forceDownload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sourceUrl := manifestUrl
+	if baseUrl := ctx.String(ArgBaseUrl); baseUrl != "" {
+		sourceUrl = baseUrl
+	} else if manifestUrl == utils.StdinUrl {
+		sourceUrl = ""
+	}
+
+	failoverUrls := make([]string, 0, len(manifestUrls))
+	for _, failoverUrl := range manifestUrls {
+		if failoverUrl != manifestUrl && failoverUrl != utils.StdinUrl {
+			failoverUrls = append(failoverUrls, failoverUrl)
+		}
+	}
+
+	selection := &playlistSelection{baseUrl: ctx.String(ArgBaseUrl), options: options, fetchedAt: requested}
+	master, err := readMasterPlaylist(manifestPath, sourceUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+	var variant models.Variant
+	if master != nil {
+		if variant, err = master.SelectVariant(ctx.String(ArgVariant)); err != nil {
+			return nil, nil, err
+		}
+		if err := confirmVariantFallback(ctx, ctx.String(ArgVariant), variant); err != nil {
+			return nil, nil, err
+		}
+		slog.Info("selected variant", slog.String("variant", variant.String()), slog.Int("variants", len(master.Variants)))
+
+		if err := os.Rename(manifestPath, path.Join(directory, "master.m3u8")); err != nil {
+			return nil, nil, err
+		}
+		sourceUrl = master.ResolvedUri(variant.Uri)
+		if manifestPath, err = utils.DownloadFile(runCtx, directory, "original.manifest.m3u8", sourceUrl, utils.DownloadOptions{Force: true, Downloader: downloader}); err != nil {
+			return nil, nil, err
+		}
+		selection.options.Imports = master.Variables
+		// the variant is resolved against the master playlist, which --base-url stands in for
+		selection.baseUrl = ""
+
+		medias, err := selectRenditions(ctx, master, variant)
+		if err != nil {
+			return nil, nil, err
+		}
+		selection.renditionUrls = renditionUrls(master, medias)
+		if selection.renditions, err = loadRenditions(runCtx, directory, medias, selection.renditionUrls, selection.options); err != nil {
+			return nil, nil, err
+		}
+
+		failoverUrls = variantFailoverUrls(failoverUrls, variant.Uri)
+		selection.urls = append([]string{sourceUrl}, failoverUrls...)
+	} else {
+		if ctx.IsSet(ArgVariant) {
+			slog.Warn("ignoring --variant for a media playlist", slog.String("url", sourceUrl))
+		}
+		// stdin can only be read once, reloads take the failovers
+		if manifestUrl != utils.StdinUrl {
+			selection.urls = append(selection.urls, manifestUrl)
+		}
+		selection.urls = append(selection.urls, failoverUrls...)
+	}
+
+	manifest, err := parseManifestFile(runCtx, manifestPath, sourceUrl, selection.options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if manifest, err = cutSelectedManifest(ctx, manifest, failoverUrls, selection); err != nil {
+		return nil, nil, err
+	}
+	if master != nil && ctx.IsSet(ArgWarnSize) {
+		if err := warnSizeBudget(ctx, master, variant, manifest); err != nil {
+			return nil, nil, err
+		}
 	}
+	return manifest, selection, nil
+}
+
+// reloadManifest downloads and parses the media playlist and renditions of selection again, from the first of its urls
+// that responds, without selecting them anew from the master playlist. The media playlist is reloaded with a delta
+// update while the one fetched last is recent enough, see models.Manifest.CanRequestDelta, and with --preload-hint held
+// only until the next partial segment is published, see reloadWithDirectives.
+func reloadManifest(runCtx context.Context, ctx *cli.Context, directory string, selection *playlistSelection) (*models.Manifest, error) {
+	if len(selection.urls) == 0 {
+		return nil, errors.New("the playlist was read from stdin, which cannot be reloaded")
+	}
+	requested := time.Now()
+	manifest, manifestUrl, err := reloadWithDirectives(directory, selection, ctx.Bool(ArgPreloadHint))
+	if err != nil {
+		slog.Debug("falling back to the full playlist", slog.String("error", err.Error()))
+	}
+	if manifest == nil {
+		var manifestPath string
+		if manifestUrl, manifestPath, err = downloadManifest(runCtx, manifestCache(ctx), directory, selection.urls, true); err != nil {
+			return nil, err
+		}
+		if manifest, err = parseManifestFile(runCtx, manifestPath, selection.sourceUrl(manifestUrl), selection.options); err != nil {
+			return nil, err
+		}
+	}
+	selection.fetchedAt = requested
+	failoverUrls := slices.DeleteFunc(slices.Clone(selection.urls), func(failoverUrl string) bool { return failoverUrl == manifestUrl })
+
+	if selection.renditions, err = loadRenditions(runCtx, directory, renditionMedias(selection.renditions), selection.renditionUrls, selection.options); err != nil {
+		return nil, err
+	}
+	return cutSelectedManifest(ctx, manifest, failoverUrls, selection)
+}
+
+// reloadWithDirectives reloads the media playlist of selection from the first of its urls that responds with the
+// delivery directives its origin supports: a delta update applied to the playlist fetched last while that is young
+// enough, see Manifest.CanRequestDelta, and a blocking reload held until the segment after the last one fetched is
+// published, or with parts set only until the part after the preload hint fetched last is. The result is saved in
+// place of the playlist fetched last. The manifest is nil when the origin supports neither.
+func reloadWithDirectives(directory string, selection *playlistSelection, parts bool) (*models.Manifest, string, error) {
+	if selection.fetched == nil {
+		return nil, "", nil
+	}
+	delta := selection.fetched.CanRequestDelta(time.Since(selection.fetchedAt))
+	if !delta && !selection.fetched.CanBlockReload {
+		return nil, "", nil
+	}
+
+	// the part after those listed is the one the preload hint announced, which the recording fetched already, so the
+	// reload is held until the origin publishes it and hints at the next
+	part := -1
+	if last := len(selection.fetched.Discontinuities) - 1; parts && last >= 0 {
+		part = len(selection.fetched.Discontinuities[last].Parts)
+	}
+
+	var err error
+	for _, manifestUrl := range selection.urls {
+		requestUrl := manifestUrl
+		if delta {
+			requestUrl = models.DeltaUrl(requestUrl)
+		}
+		if selection.fetched.CanBlockReload {
+			requestUrl = models.BlockingReloadUrl(requestUrl, selection.fetched.LastSequence()+1, part)
+		}
+
+		var manifest *models.Manifest
+		if manifest, err = readReload(requestUrl, selection.sourceUrl(manifestUrl), selection.options); err != nil {
+			slog.Warn("failed to reload playlist", slog.String("url", downloader.RedactUrl(requestUrl)), slog.String("error", err.Error()))
+			continue
+		}
+		if err := manifest.ApplyDelta(selection.fetched); err != nil {
+			return nil, "", err
+		}
+		slog.Debug("reloaded playlist with delivery directives", slog.String("url", downloader.RedactUrl(requestUrl)))
 
-	manifest.DownloadAllFragments(directory, forceDownload)
+		// the playlist is saved whole, as a delta update cannot be read without the one before it
+		original, err := os.Create(path.Join(directory, "original.manifest.m3u8"))
+		if err != nil {
+			return nil, "", err
+		}
+		defer original.Close()
+		return manifest, manifestUrl, manifest.WriteManifest(original)
+	}
+	return nil, "", err
+}
+
+func readReload(requestUrl string, sourceUrl string, options models.ReadOptions) (*models.Manifest, error) {
+	body, err := downloader.OpenUrl(requestUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return models.ReadManifestWithOptions(body, sourceUrl, options)
+}
+
+// parseManifestFile parses the media playlist downloaded to manifestPath from sourceUrl.
+func parseManifestFile(runCtx context.Context, manifestPath string, sourceUrl string, options models.ReadOptions) (*models.Manifest, error) {
+	_, parseSpan := telemetry.Start(runCtx, "parse manifest")
+	manifest, err := models.ReadManifestFromFile(manifestPath, sourceUrl, options)
+	telemetry.End(parseSpan, err)
+	return manifest, err
+}
+
+// cutSelectedManifest cuts manifest, the media playlist of selection as it was fetched, and the renditions of selection
+// the same way, keeping manifest uncut in selection for the next delta update to apply to.
+func cutSelectedManifest(ctx *cli.Context, manifest *models.Manifest, failoverUrls []string, selection *playlistSelection) (*models.Manifest, error) {
+	fetched := *manifest
+	selection.fetched = &fetched
+
+	for _, repair := range manifest.Repairs {
+		slog.Warn("repaired manifest", slog.Int("line", repair.Line), slog.String("tag", repair.Tag), slog.String("repair", repair.Err.Error()))
+	}
+
+	// renditions are cut the same way as the variant so they stay aligned with it
+	manifest.Renditions = selection.renditions
+	cut := []*models.Manifest{manifest}
+	for _, rendition := range selection.renditions {
+		cut = append(cut, rendition.Manifest)
+	}
 
-	if ctx.Bool(ArgConcatMp4) {
-		if _, err := manifest.ConcatToMp4s(directory); err != nil {
+	if err := cutManifests(ctx, cut); err != nil {
+		return nil, err
+	}
+
+	manifest.AddFailoverUrls(failoverUrls...)
+	if err := manifest.AddFallbackRules(ctx.StringSlice(ArgFallbackUrl)...); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// cutManifests removes the segments dropped by --strip-ads, --include-url-pattern, --exclude-url-pattern and --filter
+// from every one of cut.
+func cutManifests(ctx *cli.Context, cut []*models.Manifest) error {
+	if ctx.Bool(ArgStripAds) {
+		for _, cutManifest := range cut {
+			breaks := len(cutManifest.AdBreaks)
+			slog.Info("stripped ads", slog.String("url", cutManifest.BaseUrl.String()), slog.Int("breaks", breaks), slog.Int("fragments", cutManifest.StripAds()))
+		}
+	}
+
+	include, err := compileUrlPatterns(ctx, ArgIncludeUrl)
+	if err != nil {
+		return err
+	}
+	exclude, err := compileUrlPatterns(ctx, ArgExcludeUrl)
+	if err != nil {
+		return err
+	}
+	if len(include) > 0 || len(exclude) > 0 {
+		for _, cutManifest := range cut {
+			slog.Info("filtered segments by url", slog.String("url", cutManifest.BaseUrl.String()), slog.Int("fragments", cutManifest.ApplyUrlPatterns(include, exclude)))
+		}
+	}
+
+	if expression := ctx.String(ArgFilter); expression != "" {
+		filter, err := models.ParseFilter(expression)
+		if err != nil {
 			return err
 		}
+		for _, cutManifest := range cut {
+			if err := cutManifest.ApplyFilter(filter); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// variantFailoverUrls resolves the variant uri against each of the redundant masterUrls, which list the same variant
+// relative to their own location.
+func variantFailoverUrls(masterUrls []string, uri string) []string {
+	variantUrls := make([]string, 0, len(masterUrls))
+	for _, failoverUrl := range masterUrls {
+		if masterUrl, err := url.Parse(failoverUrl); err == nil {
+			if variantUrl, err := models.ResolveUri(masterUrl, uri); err == nil {
+				failoverUrl = variantUrl.String()
+			}
+		}
+		variantUrls = append(variantUrls, failoverUrl)
+	}
+	return variantUrls
+}
+
+// warnSizeBudget warns when the estimated size of variant, over the runtime of manifest as it is cut and filtered,
+// exceeds --warn-size, suggesting the best variant of master that fits, and asks whether to download it anyway, see
+// confirm. The runtime of a playlist that is still being
+// published is unknown, so it is not checked.
+func warnSizeBudget(ctx *cli.Context, master *models.MasterPlaylist, variant models.Variant, manifest *models.Manifest) error {
+	budget, err := utils.ParseSize(ctx.String(ArgWarnSize))
+	if err != nil {
+		return fmt.Errorf("invalid --%s: %w", ArgWarnSize, err)
+	}
+	if !manifest.EndList {
+		slog.Debug("not checking the size budget of a playlist without #EXT-X-ENDLIST")
+		return nil
+	}
+
+	runtime := 0.0
+	for _, discontinuity := range manifest.Discontinuities {
+		runtime += discontinuity.Entries.Runtime()
+	}
+
+	var fitting *models.Variant
+	for index, candidate := range master.Variants {
+		size := candidate.EstimatedSize(runtime)
+		slog.Debug("estimated variant size", slog.String("variant", candidate.String()), slog.String("size", utils.FormatBytes(size)))
+		if size <= budget && (fitting == nil || candidate.Bandwidth > fitting.Bandwidth) {
+			fitting = &master.Variants[index]
+		}
+	}
+
+	size := variant.EstimatedSize(runtime)
+	if size <= budget {
+		return nil
+	}
+	attrs := []any{slog.String("variant", variant.String()), slog.String("size", utils.FormatBytes(size)), slog.String("budget", utils.FormatBytes(budget))}
+	if fitting != nil {
+		attrs = append(attrs, slog.String("suggestion", fmt.Sprintf("--%s %d", ArgVariant, fitting.Bandwidth)), slog.String("suggestedSize", utils.FormatBytes(fitting.EstimatedSize(runtime))))
+	} else {
+		attrs = append(attrs, slog.String("suggestion", "no variant fits"))
+	}
+	slog.Warn("selected variant exceeds the size budget", attrs...)
+	if !confirm(ctx, fmt.Sprintf("Download %s, about %s, anyway?", variant.String(), utils.FormatBytes(size))) {
+		return fmt.Errorf("selected variant exceeds the size budget of %s", utils.FormatBytes(budget))
+	}
+	return nil
+}
+
+// confirmVariantFallback asks whether to download variant when the bandwidth selector fell back to it because every
+// variant exceeds the bandwidth, see models.MasterPlaylist.SelectVariant and confirm.
+func confirmVariantFallback(ctx *cli.Context, selector string, variant models.Variant) error {
+	bandwidth, err := strconv.Atoi(selector)
+	if err != nil || variant.Bandwidth <= bandwidth {
+		return nil
+	}
+	slog.Warn("every variant exceeds the bandwidth, falling back to the worst", slog.Int("bandwidth", bandwidth), slog.String("variant", variant.String()))
+	if !confirm(ctx, fmt.Sprintf("Download %s instead?", variant.String())) {
+		return fmt.Errorf("no variant fits a bandwidth of %d", bandwidth)
 	}
+	return nil
+}
 
-	return
+// selectRenditions lists the alternative renditions of variants selected by --audio-lang and --subs, those of groups
+// shared by several variants only once.
+func selectRenditions(ctx *cli.Context, master *models.MasterPlaylist, variants ...models.Variant) ([]models.Media, error) {
+	var medias []models.Media
+	selected := make(map[int]bool)
+	for _, variant := range variants {
+		audio, err := master.SelectRenditions(models.MediaTypeAudio, variant.Audio, ctx.String(ArgAudioLang))
+		if err != nil {
+			return nil, err
+		}
+		subtitles, err := master.SelectRenditions(models.MediaTypeSubtitles, variant.Subtitles, ctx.String(ArgSubs))
+		if err != nil {
+			return nil, err
+		}
+		for _, media := range append(audio, subtitles...) {
+			if !selected[media.Line] {
+				selected[media.Line] = true
+				medias = append(medias, media)
+			}
+		}
+	}
+	return medias, nil
+}
+
+// loadRenditions downloads and parses the alternative renditions medias from renditionUrls, each into its own
+// subfolder of directory.
+func loadRenditions(runCtx context.Context, directory string, medias []models.Media, renditionUrls []string, options models.ReadOptions) ([]models.Rendition, error) {
+	renditions := make([]models.Rendition, 0, len(medias))
+	for index, media := range medias {
+		dir := path.Join(directory, media.DirName())
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+		playlistPath, err := utils.DownloadFile(runCtx, dir, "original.manifest.m3u8", renditionUrls[index], utils.DownloadOptions{Force: true, Downloader: downloader})
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := models.ReadManifestFromFile(playlistPath, renditionUrls[index], options)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", media, err)
+		}
+
+		renditions = append(renditions, models.Rendition{Media: media, Dir: media.DirName(), Manifest: manifest})
+	}
+
+	return renditions, nil
+}
+
+// renditionUrls resolves the uris of the renditions medias selected from master.
+func renditionUrls(master *models.MasterPlaylist, medias []models.Media) []string {
+	urls := make([]string, 0, len(medias))
+	for _, media := range medias {
+		slog.Info("selected rendition", slog.String("rendition", media.String()))
+		urls = append(urls, master.ResolvedUri(media.Uri))
+	}
+	return urls
+}
+
+// renditionMedias returns the media of every one of renditions.
+func renditionMedias(renditions []models.Rendition) []models.Media {
+	medias := make([]models.Media, 0, len(renditions))
+	for _, rendition := range renditions {
+		medias = append(medias, rendition.Media)
+	}
+	return medias
+}
+
+// readMasterPlaylist parses the playlist at manifestPath as a master playlist, returning nil when it is a media playlist.
+func readMasterPlaylist(manifestPath string, sourceUrl string) (*models.MasterPlaylist, error) {
+	manifestFile, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer manifestFile.Close()
+
+	if !models.IsMasterPlaylist(manifestFile) {
+		return nil, nil
+	}
+	if _, err := manifestFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return models.ReadMasterPlaylist(manifestFile, sourceUrl)
+}
+
+// downloadManifest downloads the manifest from the first of the redundant manifestUrls that responds, returning which url was used.
+func downloadManifest(ctx context.Context, cache models.ManifestCache, directory string, manifestUrls []string, forceDownload bool) (manifestUrl string, manifestPath string, err error) {
+	_, span := telemetry.Start(ctx, "fetch manifest")
+	defer func() { telemetry.End(span, err) }()
+
+	for attempt, manifestUrl := range manifestUrls {
+		// stdin can only be read once, so it always replaces a previously saved manifest
+		manifestPath, err = fetchManifest(ctx, cache, directory, manifestUrl, forceDownload || attempt > 0 || manifestUrl == utils.StdinUrl)
+		if err == nil {
+			return manifestUrl, manifestPath, nil
+		}
+		slog.Warn("failed to download manifest", slog.String("url", manifestUrl), slog.String("error", err.Error()))
+	}
+
+	return "", "", err
+}
+
+// writeSizeReport prints how the bytes written compare with the sizes announced by the origins once anything was transferred,
+// listing the files whose transfers were silently truncated.
+func writeSizeReport(results []models.FragmentResult) error {
+	sizes := report.CompareSizes(results)
+	if sizes.Transferred == 0 {
+		return nil
+	}
+	if len(sizes.Mismatches) > 0 {
+		slog.Warn("downloaded files differ in size from what the origin announced", slog.Int("files", len(sizes.Mismatches)))
+	}
+	return sizes.Write(os.Stderr)
+}
+
+// writeRunReport prints the timing report of the run and saves it into directory, see report.RunReport. A run stopped
+// by --quota is marked truncated.
+func writeRunReport(directory string, manifestUrl string, started time.Time, results []models.FragmentResult, truncated bool) {
+	runReport := report.NewRunReport(downloader.RedactUrl(manifestUrl), started, stageTimings.Stages(), results)
+	runReport.QuotaTruncated = truncated
+	if err := runReport.Write(os.Stderr); err != nil {
+		slog.Warn("failed to print run report", slog.String("error", err.Error()))
+	}
+	if err := runReport.WriteFile(path.Join(directory, report.RunReportFileName)); err != nil {
+		slog.Warn("failed to write run report", slog.String("error", err.Error()))
+	}
+}
+
+// openQuota opens the --quota over directory, returning nil when it is not set.
+func openQuota(ctx *cli.Context, directory string) (*utils.Quota, error) {
+	if !ctx.IsSet(ArgQuota) {
+		return nil, nil
+	}
+	limit, err := utils.ParseSize(ctx.String(ArgQuota))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %w", ArgQuota, err)
+	}
+	return utils.OpenQuota(directory, limit)
+}
+
+// fetchManifest saves the playlist at manifestUrl into directory, taking it from cache when it was not saved yet. Forced
+// downloads always fetch it again, so that refreshed tokens are picked up.
+func fetchManifest(ctx context.Context, cache models.ManifestCache, directory string, manifestUrl string, forceDownload bool) (string, error) {
+	manifestPath := path.Join(directory, "original.manifest.m3u8")
+	// the playlist saved from a master playlist is that of its variant, see loadManifest
+	if _, err := os.Stat(path.Join(directory, "master.m3u8")); err == nil {
+		forceDownload = true
+	}
+	if forceDownload || cache.Ttl <= 0 {
+		return utils.DownloadFile(ctx, directory, path.Base(manifestPath), manifestUrl, utils.DownloadOptions{Force: forceDownload, Downloader: cache.Downloader})
+	}
+	if _, err := os.Stat(manifestPath); err == nil {
+		return manifestPath, nil
+	}
+
+	playlist, err := cache.Playlist(manifestUrl)
+	if err != nil {
+		return "", err
+	}
+	return manifestPath, os.WriteFile(manifestPath, playlist, 0644)
 }
 
 var HlsCommand = &cli.Command{
-	Name:   "hls",
-	Usage:  "Run the application against a given HLS manifest url",
-	Action: hls,
-	Flags:  hlsFlags,
+	Name:      "hls",
+	Usage:     "Run the application against a given HLS manifest url",
+	ArgsUsage: "<url|-> [failover urls...]",
+	Action:    hls,
+	Flags:     hlsFlags,
+	Subcommands: []*cli.Command{
+		HlsRefreshCommand,
+	},
 }
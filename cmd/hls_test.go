@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestHlsRejectsEmptyWindow(t *testing.T) {
+	tests := [][]string{
+		{"--start", "10m", "--end", "5m"},
+		{"--start", "10m", "--end", "10m"},
+		{"--start", "2024-01-01T10:00:00Z", "--end", "2024-01-01T09:00:00Z"},
+	}
+	for _, window := range tests {
+		args := append([]string{"manifestr", "hls", "--concat-mode", "none", "--progress=false", "-d", t.TempDir()}, window...)
+		// the window is rejected before the playlist is requested
+		err := App("test").RunContext(context.Background(), append(args, "http://localhost:0/playlist.m3u8"))
+		if err == nil || !strings.Contains(err.Error(), "window ends") {
+			t.Errorf("%v: got error %v, want the window rejected", window, err)
+		}
+	}
+}
+
+func TestQueryRedactedPerRun(t *testing.T) {
+	// every run redacts the --query parameters of its own, none of those of the runs before it in the process
+	for _, name := range []string{"token", "sig"} {
+		args := []string{"manifestr", "--query", name + "=secret", "hls", "--concat-mode", "none", "--progress=false", "-d", t.TempDir(), "http://localhost:0/playlist.m3u8"}
+		if err := App("test").RunContext(context.Background(), args); err == nil {
+			t.Fatalf("%s: expected the unreachable playlist to fail the run", name)
+		}
+		if got := strings.Join(downloader.SensitiveQuery, ","); got != name {
+			t.Errorf("run with --query %s redacts %q", name, got)
+		}
+		if got := downloader.RedactUrl("http://localhost/seg.ts?" + name + "=secret"); strings.Contains(got, "secret") {
+			t.Errorf("run with --query %s logs %s", name, got)
+		}
+	}
+}
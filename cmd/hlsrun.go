@@ -0,0 +1,496 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/storage"
+	"github.com/alehechka/manifestr/pkg/telemetry"
+	"github.com/alehechka/manifestr/pkg/utils"
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// hlsSettings are the flags of an hls run, checked and parsed before anything is fetched.
+type hlsSettings struct {
+	stage         string
+	concat        string
+	splitSize     int64
+	splitDuration time.Duration
+	windowStart   models.TimeBound
+	windowEnd     models.TimeBound
+	read          models.ReadOptions
+	transfer      utils.TransferOptions
+	storePolicy   string
+	outputPolicy  utils.OutputPolicy
+}
+
+// hlsDownload is what the download stage of an hls run leaves for the stages after it.
+type hlsDownload struct {
+	plan    *models.DownloadPlan
+	results []models.FragmentResult
+	// truncated is set when the quota stopped the downloads, whose fragments so far are still processed
+	truncated bool
+	err       error
+}
+
+// runHls downloads manifestUrls as the flags of ctx say, downloading the files of the variant again when refetchVariant
+// is set, see hlsRefresh. The stages of the run follow each other: loading the manifest, planning and downloading its
+// fragments, writing the local manifests and indexes, then muxing and reporting.
+func runHls(ctx *cli.Context, manifestUrls []string, refetchVariant bool) (err error) {
+	started := time.Now()
+
+	if ctx.Bool(ArgResume) {
+		if manifestUrls, err = resumeSession(ctx, ctx.String(ArgDirectory), manifestUrls); err != nil {
+			return err
+		}
+	}
+
+	stage, err := selectedStage(ctx)
+	if err != nil {
+		return err
+	}
+	// the later stages take the manifest from the handoff of the one before
+	laterStage := stage == models.StageMux || stage == models.StageUpload
+
+	if len(manifestUrls) == 0 && !laterStage {
+		return errors.New("no manifest url provided")
+	}
+
+	runCtx, span := telemetry.Start(ctx.Context, "hls", attribute.String("url", ctx.Args().First()))
+	defer func() { telemetry.End(span, err) }()
+
+	settings, err := parseHlsSettings(ctx)
+	if err != nil {
+		return err
+	}
+	settings.stage = stage
+
+	if ctx.Bool(ArgPrintFfmpeg) {
+		runCtx = ffmpeg.WithRunner(runCtx, ffmpeg.Runner{DryRun: os.Stdout})
+	}
+
+	if laterStage {
+		return runStage(runCtx, ctx, stage, settings.read, settings.outputPolicy)
+	}
+
+	directory, output, err := openDirectory(ctx)
+	if err != nil {
+		return err
+	}
+
+	if output == nil {
+		if err := writeSession(ctx, directory, manifestUrls); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Bool(ArgAllVariants) {
+		return downloadAllVariants(runCtx, ctx, directory, manifestUrls, settings.concat)
+	}
+
+	// runs failing before the downloads have nothing to report
+	var download hlsDownload
+	defer func() {
+		if download.results != nil {
+			writeRunReport(directory, manifestUrls[0], started, download.results, download.truncated)
+		}
+	}()
+
+	manifest, selection, clipStart, clipEnd, err := loadHlsManifest(runCtx, ctx, settings, directory, output, manifestUrls)
+	if err != nil {
+		return err
+	}
+	if err := openArchiveIndexes(ctx, settings, directory, manifest); err != nil {
+		return err
+	}
+
+	options, err := hlsPlanOptions(ctx, settings, directory, manifest, clipStart, clipEnd)
+	if err != nil {
+		return err
+	}
+	options.RefetchVariant = refetchVariant
+	if ctx.Bool(ArgProgress) {
+		if options.Progress = utils.NewProgress(os.Stderr); options.Progress.Terminal() {
+			defer withProgressLogging(options.Progress)()
+		}
+	}
+
+	if err := preflightHls(runCtx, ctx, stage, manifest, options); err != nil {
+		return err
+	}
+
+	if ctx.Bool(ArgPlay) {
+		wait, err := startPreview(runCtx, directory, ctx.String(ArgPlayer), ctx.Int(ArgPlayAfter))
+		if err != nil {
+			return err
+		}
+		defer wait()
+	}
+
+	finishPush, stopRelay, err := startRepublishing(runCtx, ctx, directory)
+	if err != nil {
+		return err
+	}
+	defer stopRelay()
+
+	var vetoed []models.PlannedDownload
+	if ctx.Bool(ArgLive) {
+		if manifest, vetoed, err = recordHls(runCtx, ctx, directory, selection, manifest, options); err != nil {
+			return err
+		}
+		// an interrupt only ends the recording, what was recorded is still completed and processed
+		runCtx = context.WithoutCancel(runCtx)
+	} else if !manifest.EndList {
+		slog.Info(fmt.Sprintf("playlist has no #EXT-X-ENDLIST, use --%s to keep recording it as new segments are published", ArgLive))
+	}
+
+	download = downloadHls(runCtx, ctx, directory, selection, manifest, options, vetoed)
+
+	if err := writeHlsArchive(runCtx, ctx, directory, manifest, download, finishPush); err != nil {
+		return err
+	}
+
+	if download.err != nil {
+		if failed := len(manifest.DeadLetters.List()); failed > 0 {
+			slog.Info(fmt.Sprintf("run retry-failed to download the %d failed files again once the origin recovers", failed), slog.String("list", path.Join(directory, models.DeadLetterFileName)))
+		}
+		return download.err
+	}
+
+	return processHls(runCtx, ctx, stage, directory, download)
+}
+
+// parseHlsSettings checks the flags of an hls run against each other and parses them, failing on the first invalid one.
+func parseHlsSettings(ctx *cli.Context) (settings hlsSettings, err error) {
+	if container := ctx.String(ArgContainer); container != models.ContainerMp4 && container != models.ContainerTs {
+		return settings, fmt.Errorf("unknown container %q", container)
+	}
+	if settings.concat, err = concatMode(ctx); err != nil {
+		return settings, err
+	}
+	if ctx.String(ArgContainer) == models.ContainerTs && settings.concat == models.ConcatPerDiscontinuity {
+		return settings, fmt.Errorf("--%s %s concatenates every discontinuity into a single file, use --%s %s or %s", ArgContainer, models.ContainerTs, ArgConcatMode, models.ConcatSingle, models.ConcatNone)
+	}
+
+	if settings.splitSize, settings.splitDuration, err = splitOutput(ctx, settings.concat); err != nil {
+		return settings, err
+	}
+
+	if avSync := ctx.String(ArgAvSync); avSync != models.AvSyncOff && avSync != models.AvSyncReport && avSync != models.AvSyncCorrect {
+		return settings, fmt.Errorf("unknown av sync mode %q", avSync)
+	}
+
+	if settings.windowStart, err = models.ParseTimeBound(ctx.String(ArgStart)); err != nil {
+		return settings, fmt.Errorf("invalid --%s: %w", ArgStart, err)
+	}
+	if settings.windowEnd, err = models.ParseTimeBound(ctx.String(ArgEnd)); err != nil {
+		return settings, fmt.Errorf("invalid --%s: %w", ArgEnd, err)
+	}
+	if err := models.CheckWindow(settings.windowStart, settings.windowEnd); err != nil {
+		return settings, fmt.Errorf("invalid --%s and --%s: %w", ArgStart, ArgEnd, err)
+	}
+	if ctx.Bool(ArgPreloadHint) && !ctx.Bool(ArgLive) {
+		return settings, fmt.Errorf("--%s fetches the live edge of a recording, use it with --%s", ArgPreloadHint, ArgLive)
+	}
+	for _, flag := range []string{ArgRelay, ArgPush} {
+		if ctx.IsSet(flag) && !ctx.Bool(ArgLive) {
+			return settings, fmt.Errorf("--%s republishes a live recording, use it with --%s", flag, ArgLive)
+		}
+	}
+	if ctx.Bool(ArgLive) && (!settings.windowStart.IsZero() || !settings.windowEnd.IsZero()) {
+		return settings, fmt.Errorf("--%s and --%s cannot be used with --%s, whose playlist start moves with every reload", ArgStart, ArgEnd, ArgLive)
+	}
+
+	if err := validateOutputName(ctx.String(ArgOutputName)); err != nil {
+		return settings, err
+	}
+	if settings.read, err = readOptions(ctx); err != nil {
+		return settings, err
+	}
+
+	if liveJoin := ctx.String(ArgLiveJoin); liveJoin != models.LiveJoinKeep && liveJoin != models.LiveJoinDrop && liveJoin != models.LiveJoinGop {
+		return settings, fmt.Errorf("unknown live join mode %q", liveJoin)
+	}
+
+	if settings.transfer, err = transferOptions(ctx); err != nil {
+		return settings, err
+	}
+	if settings.storePolicy, err = storePolicy(ctx); err != nil {
+		return settings, err
+	}
+	if settings.outputPolicy, err = outputPolicy(ctx); err != nil {
+		return settings, err
+	}
+	return settings, nil
+}
+
+// loadHlsManifest loads the manifest into directory, see loadManifest, and cuts it to the --start and --end window,
+// returning where the outputs are clipped, before writing its local manifests to directory and output.
+func loadHlsManifest(runCtx context.Context, ctx *cli.Context, settings hlsSettings, directory string, output storage.Storage, manifestUrls []string) (manifest *models.Manifest, selection *playlistSelection, clipStart time.Duration, clipEnd time.Duration, err error) {
+	appendArchive := ctx.Bool(ArgAppend)
+	if manifest, selection, err = loadManifest(runCtx, ctx, directory, manifestUrls, ctx.Bool(ArgForceDownload) || appendArchive, settings.read); err != nil {
+		return nil, nil, 0, 0, err
+	}
+	manifest.Output = output
+
+	if ctx.Bool(ArgInferTime) && manifest.BaseUrl != nil {
+		inferProgramDateTimes(manifest, manifest.BaseUrl.String())
+	}
+
+	if !settings.windowStart.IsZero() || !settings.windowEnd.IsZero() {
+		if clipStart, clipEnd, err = manifest.CutWindow(settings.windowStart, settings.windowEnd); errors.Is(err, models.ErrNoProgramDateTime) && !ctx.Bool(ArgInferTime) {
+			return nil, nil, 0, 0, fmt.Errorf("%w, set --%s to infer it", err, ArgInferTime)
+		} else if err != nil {
+			return nil, nil, 0, 0, err
+		}
+		slog.Info("cut manifest to time window", slog.Int("mediaSequence", manifest.MediaSequence), slog.Int("fragments", manifest.LastSequence()-manifest.MediaSequence+1))
+	}
+
+	// an appended recording already started before, so only a fresh one joins in the middle of a segment
+	if ctx.Bool(ArgLive) && !appendArchive && ctx.String(ArgLiveJoin) == models.LiveJoinDrop && manifest.DropFirstSegment() {
+		slog.Info("dropped the first live segment", slog.Int("mediaSequence", manifest.MediaSequence))
+	}
+
+	if appendArchive {
+		if err := extendArchive(directory, manifest); err != nil {
+			return nil, nil, 0, 0, err
+		}
+	}
+
+	if err := writeLocalManifests(runCtx, directory, manifest); err != nil {
+		return nil, nil, 0, 0, err
+	}
+	return manifest, selection, clipStart, clipEnd, nil
+}
+
+// openArchiveIndexes loads the checksums, archive index and dead letters kept in directory by earlier runs into
+// manifest, and opens the shared store when --shared-store is set.
+func openArchiveIndexes(ctx *cli.Context, settings hlsSettings, directory string, manifest *models.Manifest) (err error) {
+	if manifest.Checksums, err = utils.LoadChecksumIndex(path.Join(directory, utils.ChecksumsFileName)); err != nil {
+		return err
+	}
+	if manifest.Index, err = utils.LoadArchiveIndex(path.Join(directory, utils.IndexFileName)); err != nil {
+		return err
+	}
+	if manifest.DeadLetters, err = models.LoadDeadLetters(path.Join(directory, models.DeadLetterFileName)); err != nil {
+		return err
+	}
+	manifest.Statuses = utils.NewDownloadStatusCache()
+	manifest.Validate = ctx.Bool(ArgValidate)
+	if ctx.Bool(ArgSharedStore) {
+		if manifest.Store, err = utils.OpenSharedStore("", settings.storePolicy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hlsPlanOptions returns the options the fragments of manifest are planned, downloaded and processed with into
+// directory, clipping the outputs to clipStart and clipEnd.
+func hlsPlanOptions(ctx *cli.Context, settings hlsSettings, directory string, manifest *models.Manifest, clipStart time.Duration, clipEnd time.Duration) (options models.PlanOptions, err error) {
+	options = models.PlanOptions{
+		Dir:           directory,
+		ForceDownload: ctx.Bool(ArgForceDownload),
+		Concurrency:   ctx.Int(ArgConcurrency),
+		Retries:       ctx.Int(ArgRetries),
+		RetryBackoff:  ctx.Duration(ArgRetryBackoff),
+		PreloadParts:  ctx.Bool(ArgPreloadHint),
+		Assets:        ctx.Bool(ArgAssets),
+		Container:     ctx.String(ArgContainer),
+		ConcatMode:    settings.concat,
+		OutputName:    outputName(ctx, settings.concat, manifest),
+		AvSync:        ctx.String(ArgAvSync),
+		Start:         clipStart,
+		End:           clipEnd,
+		ClipReencode:  ctx.Bool(ArgClipReencode),
+		SplitSize:     settings.splitSize,
+		SplitDuration: settings.splitDuration,
+		Downloader:    downloader,
+		Transfer:      settings.transfer,
+		OutputPolicy:  settings.outputPolicy,
+	}
+	if ctx.Bool(ArgLive) && !ctx.Bool(ArgAppend) {
+		options.LiveJoin = ctx.String(ArgLiveJoin)
+	}
+	if options.Quota, err = openQuota(ctx, directory); err != nil {
+		return options, err
+	}
+	if command := ctx.String(ArgScanCommand); command != "" {
+		options.Scan = models.ScanCommand(command)
+	}
+	return options, nil
+}
+
+// preflightHls fails before anything is downloaded, rather than once the fragments are in, when the outputs cannot be
+// written or ffmpeg cannot produce them or the --push.
+func preflightHls(runCtx context.Context, ctx *cli.Context, stage string, manifest *models.Manifest, options models.PlanOptions) error {
+	preflight := models.Plan(manifest, options)
+	if err := preflight.CheckOutput(); err != nil {
+		return err
+	}
+	requirements := preflight.FfmpegRequirements()
+	if target := ctx.String(ArgPush); target != "" {
+		push, err := ffmpeg.PushRequirements(target)
+		if err != nil {
+			return err
+		}
+		requirements = requirements.Merge(push)
+	}
+	// a download stage leaves ffmpeg to the job of the mux stage
+	if stage == models.StageDownload {
+		return nil
+	}
+	return ffmpeg.Preflight(runCtx, requirements)
+}
+
+// startRepublishing starts the --relay of the recording in directory and the --push reading from it, on a private
+// port unless the relay is published. finishPush lets the push send what the relay still holds before stopping it.
+func startRepublishing(runCtx context.Context, ctx *cli.Context, directory string) (finishPush func(time.Duration), stop func(), err error) {
+	finishPush, stop = func(time.Duration) {}, func() {}
+	relayAddress := ctx.String(ArgRelay)
+	if relayAddress == "" && ctx.IsSet(ArgPush) {
+		relayAddress = "localhost:0"
+	}
+	if relayAddress == "" {
+		return finishPush, stop, nil
+	}
+
+	relayUrl, stopRelay, err := startRelay(directory, relayAddress, ctx.Int(ArgRelayWindow))
+	if err != nil {
+		return nil, nil, err
+	}
+	stop = stopRelay
+	if target := ctx.String(ArgPush); target != "" {
+		finishPush = startPush(runCtx, relayUrl, target)
+		stop = func() {
+			finishPush(0)
+			stopRelay()
+		}
+	}
+	return finishPush, stop, nil
+}
+
+// recordHls records the live stream of selection into manifest until it ends, see recordLive, returning the recording
+// and the downloads vetoed along the way. Renditions are not recorded live.
+func recordHls(runCtx context.Context, ctx *cli.Context, directory string, selection *playlistSelection, manifest *models.Manifest, options models.PlanOptions) (*models.Manifest, []models.PlannedDownload, error) {
+	if len(manifest.Renditions) > 0 {
+		slog.Warn("renditions are not recorded live, only the variant stream is", slog.Int("renditions", len(manifest.Renditions)))
+		manifest.Renditions, selection.renditions, selection.renditionUrls = nil, nil, nil
+	}
+	manifest, vetoed := recordLive(runCtx, ctx, directory, selection, manifest, options)
+	if ctx.Bool(ArgAppend) {
+		if err := extendArchive(directory, manifest); err != nil {
+			return nil, nil, err
+		}
+	}
+	return manifest, vetoed, nil
+}
+
+// downloadHls downloads the fragments of manifest, reloading the playlist of selection for up to --retry-passes more
+// passes over those that failed, as refreshed tokens or another origin may serve them.
+func downloadHls(runCtx context.Context, ctx *cli.Context, directory string, selection *playlistSelection, manifest *models.Manifest, options models.PlanOptions, vetoed []models.PlannedDownload) hlsDownload {
+	plan := models.Plan(manifest, options)
+	plan.Vetoed = vetoed
+
+	downloadCtx, downloadSpan := telemetry.Start(runCtx, "download fragments")
+	defer downloadSpan.End()
+	download := hlsDownload{plan: plan, err: plan.Download(downloadCtx)}
+	download.results = plan.Results
+
+	for pass := 1; pass <= ctx.Int(ArgRetryPasses) && manifest.Statuses.Failed() > 0 && downloadCtx.Err() == nil && !options.Quota.Exceeded(); pass++ {
+		slog.Info("retrying failed fragments", slog.Int("pass", pass), slog.Int("failed", manifest.Statuses.Failed()))
+
+		retried, err := reloadManifest(downloadCtx, ctx, directory, selection)
+		if err != nil {
+			slog.Error("failed to reload manifest", slog.String("error", err.Error()))
+			continue
+		}
+		retried.Checksums, retried.Index, retried.Statuses, retried.Validate, retried.Store, retried.Output = manifest.Checksums, manifest.Index, manifest.Statuses, manifest.Validate, manifest.Store, manifest.Output
+		retried.DeadLetters = manifest.DeadLetters
+		retriedPlan := models.Plan(retried, options)
+		download.err = retriedPlan.Download(downloadCtx)
+		plan.Vetoed = append(plan.Vetoed, retriedPlan.Vetoed...)
+		download.results = append(download.results, retriedPlan.Results...)
+	}
+
+	// what was downloaded before the quota stopped the downloads is completed and processed
+	if errors.Is(download.err, models.ErrQuotaExceeded) {
+		download.truncated, download.err = true, nil
+	}
+	return download
+}
+
+// writeHlsArchive reports the sizes of the download, rewrites the local manifests of manifest without the fragments it
+// left out and persists the checksums, archive index and dead letters in directory, copying them to --archive-dir.
+// finishPush is given the time the push needs to send what the relay window still holds.
+func writeHlsArchive(runCtx context.Context, ctx *cli.Context, directory string, manifest *models.Manifest, download hlsDownload, finishPush func(time.Duration)) error {
+	if err := writeSizeReport(download.results); err != nil {
+		return err
+	}
+
+	if len(download.plan.Vetoed) > 0 || ctx.Bool(ArgLive) || download.truncated {
+		download.plan.ExcludeVetoed()
+		if download.truncated {
+			download.plan.ExcludeMissing()
+		}
+		if err := writeLocalManifests(runCtx, directory, manifest); err != nil {
+			return err
+		}
+	}
+	// the relay ends with the local manifest, so the push gets to send what the window still holds
+	finishPush(time.Duration(float64(ctx.Int(ArgRelayWindow)+1) * manifest.TargetDuration * float64(time.Second)))
+
+	if err := download.plan.Options.Transfer.Batch.Sync(); err != nil {
+		return err
+	}
+
+	if err := manifest.Checksums.Write(path.Join(directory, utils.ChecksumsFileName)); err != nil {
+		return err
+	}
+	if err := manifest.Index.Write(path.Join(directory, utils.IndexFileName)); err != nil {
+		return err
+	}
+	if err := manifest.DeadLetters.Write(path.Join(directory, models.DeadLetterFileName)); err != nil {
+		return err
+	}
+
+	if archiveDir := ctx.String(ArgArchiveDir); archiveDir != "" {
+		return download.plan.ArchiveTo(archiveDir, "original.manifest.m3u8", "local.manifest.m3u8", models.LocalMpdFileName, utils.ChecksumsFileName, utils.IndexFileName, models.DeadLetterFileName, models.SessionFileName)
+	}
+	return nil
+}
+
+// processHls muxes the downloaded fragments into the outputs and uploads them to --upload-to, or hands the download
+// over to the mux stage when only the download stage runs.
+func processHls(runCtx context.Context, ctx *cli.Context, stage string, directory string, download hlsDownload) error {
+	plan := download.plan
+	if download.truncated && !plan.Manifest.HasEntries() {
+		return fmt.Errorf("nothing to process: %w", models.ErrQuotaExceeded)
+	}
+
+	if ctx.Bool(ArgTimedMetadata) {
+		if err := writeTimedMetadataSidecar(plan.Manifest, directory); err != nil {
+			return err
+		}
+	}
+
+	if stage == models.StageDownload {
+		return models.NewStageHandoff(stage, plan.Manifest, plan.Options).Write(directory)
+	}
+	if err := plan.Process(runCtx); err != nil {
+		return err
+	}
+	if uploadTo := ctx.String(ArgUploadTo); uploadTo != "" {
+		_, err := uploadOutputs(runCtx, directory, uploadTo, stageOutputs(plan))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/report"
+	"github.com/urfave/cli/v2"
+)
+
+var inspectFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  ArgFormat,
+		Value: report.InspectTable,
+		Usage: fmt.Sprintf("Print the report as %q, %q or %q.", report.InspectTable, report.InspectJson, report.InspectYaml),
+	},
+	&cli.StringFlag{
+		Name:    ArgOutput,
+		Aliases: []string{"o"},
+		Usage:   "File to write the report to instead of stdout.",
+	},
+}
+
+func inspect(ctx *cli.Context) (err error) {
+	manifestUrl := ctx.Args().Get(0)
+	if manifestUrl == "" {
+		return errors.New("no manifest url provided")
+	}
+
+	format := ctx.String(ArgFormat)
+	if format != report.InspectTable && format != report.InspectJson && format != report.InspectYaml {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	playlist, err := manifestCache(ctx).Playlist(manifestUrl)
+	if err != nil {
+		return err
+	}
+
+	var summary report.ManifestSummary
+	if models.IsMasterPlaylist(bytes.NewReader(playlist)) {
+		master, err := models.ReadMasterPlaylist(bytes.NewReader(playlist), manifestUrl)
+		if err != nil {
+			return err
+		}
+		summary = report.SummarizeMaster(manifestUrl, master)
+	} else {
+		manifest, err := models.ReadManifest(bytes.NewReader(playlist), manifestUrl)
+		if err != nil {
+			return err
+		}
+		summary = report.SummarizeManifest(manifestUrl, manifest)
+	}
+
+	var out io.Writer = os.Stdout
+	if output := ctx.String(ArgOutput); output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	return summary.Write(out, format)
+}
+
+var InspectCommand = &cli.Command{
+	Name:      "inspect",
+	Usage:     "Print the structure of a master or media playlist, such as its variants or its segment count, runtime and encryption, without downloading any segments",
+	ArgsUsage: "<url|->",
+	Action:    inspect,
+	Flags:     inspectFlags,
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/testserver"
+)
+
+func TestHlsMasterEncrypted(t *testing.T) {
+	server := testserver.New()
+	defer server.Close()
+	server.AddStream("low", testserver.Stream{Segments: 3, Encrypted: true, SegmentSize: 1000})
+	server.AddStream("high", testserver.Stream{Segments: 3, Encrypted: true, SegmentSize: 1000})
+	masterUrl := server.AddMaster("show", "low", "high")
+	server.Fail("/high/seg1.ts", testserver.Failure{Status: http.StatusServiceUnavailable, Times: 1})
+
+	directory := t.TempDir()
+	err := App("test").RunContext(context.Background(), []string{"manifestr", "hls", "--container", "ts", "--concat-mode", "single", "--progress=false", "--retry-backoff", "10ms", "-d", directory, masterUrl})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the best variant is downloaded, retrying the segment that failed once, and decrypted into the output
+	var want []byte
+	for sequence := range 3 {
+		want = append(want, testserver.Payload(sequence, 1000, false)...)
+		if requests := server.Requests(fmt.Sprintf("/low/seg%d.ts", sequence)); requests != 0 {
+			t.Errorf("segment %d of the worst variant requested %d times", sequence, requests)
+		}
+	}
+	if requests := server.Requests("/high/seg1.ts"); requests != 2 {
+		t.Errorf("failed segment requested %d times, want twice", requests)
+	}
+	if b, err := os.ReadFile(filepath.Join(directory, "output.ts")); err != nil || !bytes.Equal(b, want) {
+		t.Errorf("output.ts is not the decrypted segments of the best variant: %v", err)
+	}
+}
+
+func TestHlsLivePolling(t *testing.T) {
+	server := testserver.New()
+	defer server.Close()
+	playlistUrl := server.AddStream("live", testserver.Stream{Segments: 3, SegmentDuration: time.Second, Live: true, EndAfter: 6, Fmp4: true})
+	server.Fail("/live/seg4.m4s", testserver.Failure{Status: http.StatusBadGateway, Times: 1})
+
+	directory := t.TempDir()
+	err := App("test").RunContext(context.Background(), []string{"manifestr", "hls", "--live", "--concat-mode", "none", "--progress=false", "--retry-backoff", "10ms", "-d", directory, playlistUrl})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the recording joins at the start of the window and follows it until the stream ends
+	for sequence := 0; sequence < 6; sequence++ {
+		b, err := os.ReadFile(filepath.Join(directory, fmt.Sprintf("seg%d.m4s", sequence)))
+		if err != nil {
+			t.Errorf("segment %d not recorded: %v", sequence, err)
+			continue
+		}
+		if !bytes.Equal(b, testserver.Payload(sequence, 4096, true)) {
+			t.Errorf("segment %d differs from the one served", sequence)
+		}
+	}
+	if requests := server.Requests("/live/seg4.m4s"); requests != 2 {
+		t.Errorf("failed segment requested %d times, want twice", requests)
+	}
+	if requests := server.Requests("/live/init.mp4"); requests != 1 {
+		t.Errorf("init segment requested %d times, want once", requests)
+	}
+	if requests := server.Requests("/live/index.m3u8"); requests < 2 {
+		t.Errorf("playlist requested %d times, want it reloaded", requests)
+	}
+}
+
+func TestHlsLiveAllVariantsAligned(t *testing.T) {
+	server := testserver.New()
+	defer server.Close()
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server.AddStream("low", testserver.Stream{Segments: 3, SegmentDuration: time.Second, Live: true, EndAfter: 6, ProgramDateTime: started})
+	server.AddStream("high", testserver.Stream{Segments: 3, SegmentDuration: time.Second, Live: true, EndAfter: 6, ProgramDateTime: started})
+	// the recorder of the high variant joins two segments later than that of the low one
+	server.Advance("high", 2)
+	masterUrl := server.AddMaster("show", "low", "high")
+
+	directory := t.TempDir()
+	err := App("test").RunContext(context.Background(), []string{"manifestr", "hls", "--all-variants", "--live", "--concat-mode", "none", "--progress=false", "-d", directory, masterUrl})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both recordings are cut to the window they share, starting on the segment the high one joined at
+	for _, dir := range []string{"360p-1000000", "720p-2000000"} {
+		manifest, err := models.ReadManifestFromFile(filepath.Join(directory, dir, "local.manifest.m3u8"), "", models.ReadOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manifest.MediaSequence != 2 || manifest.LastSequence() != 5 {
+			t.Errorf("%s recorded segments %d-%d, want 2-5", dir, manifest.MediaSequence, manifest.LastSequence())
+		}
+		if start, want := manifest.Discontinuities[0].ProgramDateTime, started.Add(2*time.Second); !start.Equal(want) {
+			t.Errorf("%s starts at %s, want %s", dir, start, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(directory, LocalMasterFileName)); err != nil {
+		t.Errorf("local master not written: %v", err)
+	}
+}
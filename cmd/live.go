@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/urfave/cli/v2"
+)
+
+// recordLive keeps reloading the playlist of a live or event stream selected by loadManifest, downloading segments as they are published and
+// appending them to archive, see Manifest.Extend. Segments already fetched are skipped by their media sequence number.
+// It stops when the playlist ends, after the --duration limit or when runCtx is cancelled on interrupt, returning the
+// archive and the downloads vetoed along the way. With --preload-hint the parts at the live edge are downloaded between
+// the reloads, see models.Manifest.DownloadLiveParts, and removed again once their segment is downloaded whole.
+func recordLive(runCtx context.Context, ctx *cli.Context, directory string, selection *playlistSelection, archive *models.Manifest, options models.PlanOptions) (*models.Manifest, []models.PlannedDownload) {
+	stopCtx := runCtx
+	if limit := ctx.Duration(ArgDuration); limit > 0 {
+		var cancel context.CancelFunc
+		stopCtx, cancel = context.WithTimeout(stopCtx, limit)
+		defer cancel()
+	}
+
+	var vetoed []models.PlannedDownload
+	appended := archive.LastSequence() - archive.MediaSequence + 1
+	// parts maps the files of the parts downloaded to the media sequence number of the segment they belong to
+	parts := make(map[string]int)
+	for {
+		archive.Recording = !archive.EndList
+		plan := models.Plan(archive, options)
+		downloadErr := plan.Download(runCtx)
+		if downloadErr != nil && !errors.Is(downloadErr, models.ErrQuotaExceeded) {
+			slog.Error("failed to download live segments", slog.String("error", downloadErr.Error()))
+		}
+		vetoed = append(vetoed, plan.Vetoed...)
+		if err := writeLocalManifest(runCtx, directory, archive); err != nil {
+			slog.Error("failed to write local manifest", slog.String("error", err.Error()))
+		}
+		if downloadErr == nil {
+			removePublishedParts(options.Dir, parts, archive.LastSequence())
+		}
+
+		if archive.EndList {
+			slog.Info("live playlist ended", slog.Int("lastSequence", archive.LastSequence()))
+			break
+		}
+		if errors.Is(downloadErr, models.ErrQuotaExceeded) {
+			slog.Warn("stopped recording at the quota", slog.Int("lastSequence", archive.LastSequence()))
+			break
+		}
+
+		if options.PreloadParts {
+			files, err := archive.DownloadLiveParts(runCtx, options)
+			if err != nil {
+				slog.Warn("failed to download live parts", slog.String("error", err.Error()))
+			}
+			for _, file := range files {
+				if _, ok := parts[file]; !ok {
+					parts[file] = archive.LastSequence() + 1
+				}
+			}
+		}
+
+		// reload after a target duration, or half of one when the last reload brought nothing new, as RFC 8216 6.3.4 suggests,
+		// or right away when the origin holds the reload until the next segment, or part, is published
+		wait := time.Duration(archive.TargetDuration * float64(time.Second))
+		switch {
+		case archive.CanBlockReload && (appended > 0 || options.PreloadParts):
+			wait = 0
+		case appended == 0:
+			wait /= 2
+		}
+		select {
+		case <-stopCtx.Done():
+			slog.Info("stopped recording", slog.String("reason", context.Cause(stopCtx).Error()), slog.Int("lastSequence", archive.LastSequence()))
+			archive.Recording = false
+			return archive, vetoed
+		case <-time.After(wait):
+		}
+
+		latest, err := reloadManifest(runCtx, ctx, directory, selection)
+		if err != nil {
+			slog.Warn("failed to reload live playlist", slog.String("error", err.Error()))
+			appended = 0
+			continue
+		}
+		latest.Checksums, latest.Index, latest.Statuses, latest.Validate, latest.Store, latest.Output = archive.Checksums, archive.Index, archive.Statuses, archive.Validate, archive.Store, archive.Output
+		latest.DeadLetters = archive.DeadLetters
+
+		appended = latest.Extend(archive)
+		archive = latest
+		if appended > 0 {
+			slog.Info("new live segments", slog.Int("appended", appended), slog.Int("lastSequence", archive.LastSequence()))
+		}
+	}
+
+	archive.Recording = false
+	return archive, vetoed
+}
+
+// removePublishedParts removes the parts of the segments up to lastSequence from dir, as their segments were downloaded
+// whole, and forgets them.
+func removePublishedParts(dir string, parts map[string]int, lastSequence int) {
+	for file, sequence := range parts {
+		if sequence > lastSequence {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, file)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("failed to remove part", slog.String("file", file), slog.String("error", err.Error()))
+		}
+		delete(parts, file)
+	}
+}
@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// liveOrigin serves a master playlist whose variant publishes a segment with every reload, ending after the last.
+// With canSkip set it serves delta updates skipping all but the newest segment of a window of three, with canBlock set
+// it advertises blocking reloads and records the media sequence numbers, and part numbers, they ask for. With parts set
+// it publishes a part with every reload instead, two to a segment, hinting at the next.
+type liveOrigin struct {
+	mu        sync.Mutex
+	last      int
+	canSkip   bool
+	canBlock  bool
+	parts     bool
+	reloads   int
+	deltas    int
+	blockedOn []string
+	requests  map[string]int
+}
+
+func (origin *liveOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin.mu.Lock()
+	defer origin.mu.Unlock()
+	origin.requests[r.URL.Path]++
+
+	switch {
+	case r.URL.Path == "/master.m3u8":
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\",LANGUAGE=\"en\",DEFAULT=YES,URI=\"audio.m3u8\"\n#EXT-X-STREAM-INF:BANDWIDTH=800000,AUDIO=\"aac\"\nvideo.m3u8\n")
+	case r.URL.Path == "/audio.m3u8":
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXTINF:0.1,\na0.aac\n")
+	case r.URL.Path == "/video.m3u8":
+		sequence := min(origin.reloads, origin.last)
+		origin.reloads++
+		if msn := r.URL.Query().Get("_HLS_msn"); msn != "" {
+			if part := r.URL.Query().Get("_HLS_part"); part != "" {
+				msn += "." + part
+			}
+			origin.blockedOn = append(origin.blockedOn, msn)
+		}
+		switch {
+		case origin.parts:
+			// the first playlist holds a whole segment, every reload publishes one more part
+			published := origin.reloads + 1
+			completed := min(published/2, origin.last+1)
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-PART-INF:PART-TARGET=0.5\n#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.5\n#EXT-X-MEDIA-SEQUENCE:0\n")
+			for index := 0; index < completed; index++ {
+				fmt.Fprintf(w, "#EXTINF:1.0,\ns%d.ts\n", index)
+			}
+			if completed > origin.last {
+				fmt.Fprint(w, "#EXT-X-ENDLIST\n")
+				return
+			}
+			for part := 0; part < published%2; part++ {
+				fmt.Fprintf(w, "#EXT-X-PART:DURATION=0.5,URI=\"s%d.p%d.ts\"\n", completed, part)
+			}
+			fmt.Fprintf(w, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"s%d.p%d.ts\"\n", completed, published%2)
+			return
+		case origin.canBlock:
+			fmt.Fprintf(w, "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES\n#EXT-X-MEDIA-SEQUENCE:%d\n#EXTINF:1.0,\ns%d.ts\n", sequence, sequence)
+		case !origin.canSkip:
+			fmt.Fprintf(w, "#EXTM3U\n#EXT-X-TARGETDURATION:0.1\n#EXT-X-MEDIA-SEQUENCE:%d\n#EXTINF:0.1,\ns%d.ts\n", sequence, sequence)
+		default:
+			first := max(sequence-2, 0)
+			fmt.Fprintf(w, "#EXTM3U\n#EXT-X-TARGETDURATION:0.1\n#EXT-X-SERVER-CONTROL:CAN-SKIP-UNTIL=6\n#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+			if r.URL.Query().Get("_HLS_skip") == "YES" && sequence > first {
+				origin.deltas++
+				fmt.Fprintf(w, "#EXT-X-SKIP:SKIPPED-SEGMENTS=%d\n", sequence-first)
+				first = sequence
+			}
+			for index := first; index <= sequence; index++ {
+				fmt.Fprintf(w, "#EXTINF:0.1,\ns%d.ts\n", index)
+			}
+		}
+		if sequence == origin.last {
+			fmt.Fprint(w, "#EXT-X-ENDLIST\n")
+		}
+	default:
+		fmt.Fprint(w, strings.TrimPrefix(r.URL.Path, "/"))
+	}
+}
+
+func TestLiveReloadKeepsSelection(t *testing.T) {
+	origin := &liveOrigin{last: 3, requests: make(map[string]int)}
+	server := httptest.NewServer(origin)
+	defer server.Close()
+
+	directory := t.TempDir()
+	err := App("test").RunContext(context.Background(), []string{"manifestr", "hls", "--live", "--concat-mode", "none", "--progress=false", "--variant", "800000", "-d", directory, server.URL + "/master.m3u8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin.mu.Lock()
+	defer origin.mu.Unlock()
+	if requests := origin.requests["/master.m3u8"]; requests != 1 {
+		t.Errorf("master playlist requested %d times, want once", requests)
+	}
+	if requests := origin.requests["/video.m3u8"]; requests != origin.last+1 {
+		t.Errorf("variant playlist requested %d times, want %d", requests, origin.last+1)
+	}
+	for sequence := 0; sequence <= origin.last; sequence++ {
+		if requests := origin.requests[fmt.Sprintf("/s%d.ts", sequence)]; requests != 1 {
+			t.Errorf("segment %d requested %d times, want once", sequence, requests)
+		}
+	}
+}
+
+func TestLiveReloadDelta(t *testing.T) {
+	origin := &liveOrigin{last: 5, canSkip: true, requests: make(map[string]int)}
+	server := httptest.NewServer(origin)
+	defer server.Close()
+
+	directory := t.TempDir()
+	err := App("test").RunContext(context.Background(), []string{"manifestr", "hls", "--live", "--concat-mode", "none", "--progress=false", "-d", directory, server.URL + "/video.m3u8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin.mu.Lock()
+	defer origin.mu.Unlock()
+	if origin.deltas != origin.last {
+		t.Errorf("%d reloads were delta updates, want %d", origin.deltas, origin.last)
+	}
+	for sequence := 0; sequence <= origin.last; sequence++ {
+		if requests := origin.requests[fmt.Sprintf("/s%d.ts", sequence)]; requests != 1 {
+			t.Errorf("segment %d requested %d times, want once", sequence, requests)
+		}
+	}
+
+	local, err := os.ReadFile(filepath.Join(directory, "local.manifest.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segments := strings.Count(string(local), "#EXTINF"); segments != origin.last+1 {
+		t.Errorf("local manifest lists %d segments, want %d:\n%s", segments, origin.last+1, local)
+	}
+}
+
+func TestLiveBlockingReload(t *testing.T) {
+	origin := &liveOrigin{last: 3, canBlock: true, requests: make(map[string]int)}
+	server := httptest.NewServer(origin)
+	defer server.Close()
+
+	err := App("test").RunContext(context.Background(), []string{"manifestr", "hls", "--live", "--concat-mode", "none", "--progress=false", "-d", t.TempDir(), server.URL + "/video.m3u8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin.mu.Lock()
+	defer origin.mu.Unlock()
+	if requests := origin.requests["/video.m3u8"]; requests != origin.last+1 {
+		t.Errorf("variant playlist requested %d times, want %d", requests, origin.last+1)
+	}
+	if want := []string{"1", "2", "3"}; !slices.Equal(origin.blockedOn, want) {
+		t.Errorf("reloads blocked on media sequence numbers %v, want %v", origin.blockedOn, want)
+	}
+}
+
+func TestLivePreloadHint(t *testing.T) {
+	origin := &liveOrigin{last: 2, parts: true, requests: make(map[string]int)}
+	server := httptest.NewServer(origin)
+	defer server.Close()
+
+	directory := t.TempDir()
+	err := App("test").RunContext(context.Background(), []string{"manifestr", "hls", "--live", "--preload-hint", "--concat-mode", "none", "--progress=false", "-d", directory, server.URL + "/video.m3u8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin.mu.Lock()
+	defer origin.mu.Unlock()
+	if want := []string{"1.0", "1.1", "2.0", "2.1"}; !slices.Equal(origin.blockedOn, want) {
+		t.Errorf("reloads blocked on parts %v, want %v", origin.blockedOn, want)
+	}
+	for sequence := 1; sequence <= origin.last; sequence++ {
+		for part := 0; part < 2; part++ {
+			if requests := origin.requests[fmt.Sprintf("/s%d.p%d.ts", sequence, part)]; requests != 1 {
+				t.Errorf("part %d of segment %d requested %d times, want once", part, sequence, requests)
+			}
+		}
+	}
+	for sequence := 0; sequence <= origin.last; sequence++ {
+		if _, err := os.Stat(filepath.Join(directory, fmt.Sprintf("s%d.ts", sequence))); err != nil {
+			t.Errorf("segment %d was not downloaded whole: %v", sequence, err)
+		}
+	}
+
+	// every segment was published, so its parts were replaced by it
+	parts, err := os.ReadDir(filepath.Join(directory, "parts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) > 0 {
+		t.Errorf("parts left after their segments were downloaded: %v", parts)
+	}
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/report"
+	"github.com/urfave/cli/v2"
+)
+
+var metadataFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    ArgOutput,
+		Aliases: []string{"o"},
+		Usage:   "File to write the timed metadata to instead of stdout.",
+	},
+}
+
+func metadata(ctx *cli.Context) (err error) {
+	directory := ctx.Args().Get(0)
+	if directory == "" {
+		return errors.New("no directory provided")
+	}
+
+	manifest, err := models.ReadManifestFromFile(path.Join(directory, "local.manifest.m3u8"), "", models.ReadOptions{})
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if output := ctx.String(ArgOutput); output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if manifest.IsFmp4() {
+		events, extractErr := report.ExtractEventMessages(manifest, directory)
+		if err := report.WriteEventMessages(out, events); err != nil {
+			return err
+		}
+		return extractErr
+	}
+
+	records, extractErr := report.ExtractTimedMetadata(manifest, directory)
+	if err := report.WriteTimedMetadata(out, records); err != nil {
+		return err
+	}
+	return extractErr
+}
+
+// writeTimedMetadataSidecar writes the timed metadata of the segments downloaded to directory next to them: the ID3 tags
+// of MPEG-TS segments to report.TimedMetadataFileName, the emsg events of fMP4 segments to report.EventMessagesFileName.
+// Segments that cannot be read are only logged, so the sidecar lists what could be extracted.
+func writeTimedMetadataSidecar(manifest *models.Manifest, directory string) error {
+	if manifest.IsFmp4() {
+		events, err := report.ExtractEventMessages(manifest, directory)
+		if err != nil {
+			slog.Warn("failed to extract event messages from every segment", slog.String("error", err.Error()))
+		}
+
+		file, err := os.Create(path.Join(directory, report.EventMessagesFileName))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		slog.Info("extracted event messages", slog.Int("events", len(events)), slog.String("file", file.Name()))
+		return report.WriteEventMessages(file, events)
+	}
+
+	records, err := report.ExtractTimedMetadata(manifest, directory)
+	if err != nil {
+		slog.Warn("failed to extract timed metadata from every segment", slog.String("error", err.Error()))
+	}
+
+	file, err := os.Create(path.Join(directory, report.TimedMetadataFileName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	slog.Info("extracted timed metadata", slog.Int("tags", len(records)), slog.String("file", file.Name()))
+	return report.WriteTimedMetadata(file, records)
+}
+
+var MetadataCommand = &cli.Command{
+	Name:      "metadata",
+	Usage:     "Extract the timed metadata of every downloaded segment as timestamped JSON: ID3 tags (ad beacons, lyrics...) of MPEG-TS segments, emsg events (SCTE-35, DASH events...) of fMP4 segments",
+	ArgsUsage: "<directory>",
+	Action:    metadata,
+	Flags:     metadataFlags,
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ArgOutput    = "output"
+	ArgPrecision = "precision"
+	ArgUrlStyle  = "url-style"
+	ArgDropTag   = "drop-tag"
+)
+
+var normalizeFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    ArgOutput,
+		Aliases: []string{"o"},
+		Usage:   "File to write the normalized playlist to instead of stdout.",
+	},
+	&cli.IntFlag{
+		Name:  ArgPrecision,
+		Value: 3,
+		Usage: "Number of decimal places to write for fragment durations.",
+	},
+	&cli.StringFlag{
+		Name:  ArgUrlStyle,
+		Usage: fmt.Sprintf("Rewrite fragment and URI attribute urls to be %q or %q to the manifest url (default keeps them as written).", models.UrlStyleAbsolute, models.UrlStyleRelative),
+	},
+	&cli.StringSliceFlag{
+		Name:  ArgDropTag,
+		Usage: "Tag name to remove from the playlist, e.g. EXT-X-PROGRAM-DATE-TIME. Can be repeated.",
+	},
+}
+
+func normalize(ctx *cli.Context) (err error) {
+	manifestUrl := ctx.Args().Get(0)
+	if manifestUrl == "" {
+		return errors.New("no manifest url provided")
+	}
+
+	urlStyle := ctx.String(ArgUrlStyle)
+	if urlStyle != models.UrlStyleKeep && urlStyle != models.UrlStyleAbsolute && urlStyle != models.UrlStyleRelative {
+		return fmt.Errorf("unknown url style %q", urlStyle)
+	}
+
+	in, err := downloader.OpenUrl(manifestUrl)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var out io.Writer = os.Stdout
+	if output := ctx.String(ArgOutput); output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	return models.Normalize(in, out, manifestUrl, models.NormalizeOptions{
+		Precision: ctx.Int(ArgPrecision),
+		UrlStyle:  urlStyle,
+		DropTags:  ctx.StringSlice(ArgDropTag),
+	})
+}
+
+var NormalizeCommand = &cli.Command{
+	Name:   "normalize",
+	Usage:  "Rewrite an HLS manifest in a canonical form for diffing and testing",
+	Action: normalize,
+	Flags:  normalizeFlags,
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/models"
+)
+
+// PreviewPlaylistName is served by --play next to the download directory, listing the segments downloaded so far, see
+// models.WritePreview.
+const PreviewPlaylistName = "preview.m3u8"
+
+// DefaultPlayAfter is the number of leading segments --play waits for before launching the player.
+const DefaultPlayAfter = 3
+
+// previewPlayers are the players --play looks for, in order, when --player is not set.
+var previewPlayers = []string{"mpv", "ffplay"}
+
+// startPreview serves directory on a local port with PreviewPlaylistName and launches player, or the first of
+// previewPlayers found, on it once after leading segments are downloaded. The returned wait blocks until the player is
+// closed and stops serving, or stops right away when the player was not launched yet.
+func startPreview(ctx context.Context, directory string, player string, after int) (wait func(), err error) {
+	command := strings.Fields(player)
+	if len(command) == 0 {
+		for _, name := range previewPlayers {
+			if _, err := exec.LookPath(name); err == nil {
+				command = []string{name}
+				break
+			}
+		}
+		if len(command) == 0 {
+			return nil, errors.New("no player found for --play, install mpv or ffplay or set --player")
+		}
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: playbackHandler(previewHandler(directory, http.FileServer(http.Dir(directory))))}
+	go server.Serve(listener)
+	previewUrl := "http://" + listener.Addr().String() + "/" + PreviewPlaylistName
+
+	stop := make(chan struct{})
+	// launched receives once the player is launched, and exited is closed once it is closed
+	launched, exited := make(chan struct{}, 1), make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			segments, complete, err := writePreview(directory, io.Discard)
+			if err != nil || (segments < max(after, 1) && !complete) {
+				continue
+			}
+			slog.Info("launching player", slog.String("player", command[0]), slog.String("url", previewUrl), slog.Int("segments", segments))
+			cmd := exec.CommandContext(ctx, command[0], append(command[1:], previewUrl)...)
+			if err := cmd.Start(); err != nil {
+				slog.Error("failed to launch player", slog.String("player", command[0]), slog.String("error", err.Error()))
+				return
+			}
+			launched <- struct{}{}
+			cmd.Wait()
+			close(exited)
+			return
+		}
+	}()
+
+	return func() {
+		close(stop)
+		select {
+		case <-launched:
+			slog.Info("waiting for the player to be closed", slog.String("url", previewUrl))
+			<-exited
+		default:
+		}
+		server.Shutdown(context.Background())
+	}, nil
+}
+
+// previewHandler generates PreviewPlaylistName from the local manifest of directory and serves the other files.
+func previewHandler(directory string, files http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+PreviewPlaylistName {
+			files.ServeHTTP(w, r)
+			return
+		}
+		var preview bytes.Buffer
+		if _, _, err := writePreview(directory, &preview); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(preview.Bytes())
+	})
+}
+
+func writePreview(directory string, w io.Writer) (int, bool, error) {
+	manifestFile, err := os.Open(path.Join(directory, "local.manifest.m3u8"))
+	if err != nil {
+		return 0, false, err
+	}
+	defer manifestFile.Close()
+
+	return models.WritePreview(manifestFile, w, func(file string) bool {
+		_, err := os.Stat(path.Join(directory, file))
+		return err == nil
+	})
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/urfave/cli/v2"
+)
+
+// refreshFlags are those of hls but --directory, which refresh takes as its argument, and --resume, which it always does.
+var refreshFlags = slices.DeleteFunc(slices.Clone(hlsFlags), func(flag cli.Flag) bool {
+	return slices.Contains([]string{ArgDirectory, ArgResume}, flag.Names()[0])
+})
+
+// hlsRefresh upgrades the archive in the directory argument by resuming its session, see resumeSession, with another
+// --variant or additional renditions, the urls following the directory replacing those of the session. The renditions
+// given are added to those the archive holds, and only the files it lacks are downloaded: a variant other than the one
+// archived is downloaded again, the renditions it holds are kept.
+func hlsRefresh(ctx *cli.Context) error {
+	directory := ctx.Args().First()
+	if directory == "" {
+		return errors.New("no directory provided")
+	}
+	// the flags end at the first argument, so any given after the directory would be taken for urls
+	if slices.ContainsFunc(ctx.Args().Tail(), func(arg string) bool { return strings.HasPrefix(arg, "-") }) {
+		return fmt.Errorf("flags given after the directory %s, give them before it", directory)
+	}
+
+	session, err := models.LoadSession(directory)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s holds no %s to refresh: %w", directory, models.SessionFileName, err)
+	}
+	if err != nil {
+		return err
+	}
+	// what the archive was downloaded with, before the session sets the flags not given
+	recorded := func(name string, value string) string {
+		if values := session.Flags[name]; len(values) > 0 {
+			return values[0]
+		}
+		return value
+	}
+	archivedVariant := recorded(ArgVariant, models.VariantBest)
+	archivedAudio, archivedSubs := recorded(ArgAudioLang, models.RenditionsDefault), recorded(ArgSubs, models.RenditionsNone)
+	addedAudio, addedSubs := addedSelector(ctx, ArgAudioLang), addedSelector(ctx, ArgSubs)
+
+	if err := ctx.Set(ArgDirectory, directory); err != nil {
+		return err
+	}
+	manifestUrls, err := resumeSession(ctx, directory, ctx.Args().Tail())
+	if err != nil {
+		return err
+	}
+
+	masterPath := path.Join(directory, "master.m3u8")
+	if _, err := os.Stat(masterPath); errors.Is(err, fs.ErrNotExist) {
+		if ctx.IsSet(ArgVariant) || addedAudio != "" || addedSubs != "" {
+			return fmt.Errorf("%s was not downloaded from a master playlist, it has no variants or renditions to refresh", directory)
+		}
+		return runHls(ctx, manifestUrls, false)
+	}
+	master, err := readMasterPlaylist(masterPath, manifestUrls[0])
+	if err != nil {
+		return err
+	}
+
+	archived, err := master.SelectVariant(archivedVariant)
+	if err != nil {
+		return err
+	}
+	variant, err := master.SelectVariant(ctx.String(ArgVariant))
+	if err != nil {
+		return err
+	}
+	refetchVariant := variant.Uri != archived.Uri
+	if refetchVariant {
+		slog.Info("switching the archive to another variant", slog.String("archived", archived.String()), slog.String("variant", variant.String()))
+	}
+
+	audio, err := addRenditions(master, models.MediaTypeAudio, archived.Audio, archivedAudio, variant.Audio, addedAudio)
+	if err != nil {
+		return err
+	}
+	subs, err := addRenditions(master, models.MediaTypeSubtitles, archived.Subtitles, archivedSubs, variant.Subtitles, addedSubs)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Set(ArgAudioLang, audio); err != nil {
+		return err
+	}
+	if err := ctx.Set(ArgSubs, subs); err != nil {
+		return err
+	}
+
+	return runHls(ctx, manifestUrls, refetchVariant)
+}
+
+// addedSelector returns the renditions selected by the flag name when given, or an empty selector.
+func addedSelector(ctx *cli.Context, name string) string {
+	if !ctx.IsSet(name) {
+		return ""
+	}
+	return ctx.String(name)
+}
+
+// addRenditions returns a selector of the renditions of mediaType archived, those archivedSelector selected from the
+// archivedGroup of master, together with those added selects from group, as comma separated languages. Renditions
+// without a language cannot be selected that way, and are left out with a warning.
+func addRenditions(master *models.MasterPlaylist, mediaType string, archivedGroup string, archivedSelector string, group string, added string) (string, error) {
+	switch {
+	case added == "":
+		return archivedSelector, nil
+	case added == models.RenditionsAll || added == models.RenditionsNone || archivedSelector == models.RenditionsNone:
+		return added, nil
+	case archivedSelector == models.RenditionsAll:
+		return archivedSelector, nil
+	}
+
+	archived, err := master.SelectRenditions(mediaType, archivedGroup, archivedSelector)
+	if err != nil {
+		return "", err
+	}
+	selected, err := master.SelectRenditions(mediaType, group, added)
+	if err != nil {
+		return "", err
+	}
+
+	var languages []string
+	for _, media := range append(archived, selected...) {
+		language := strings.ToLower(media.Language)
+		if language == "" {
+			slog.Warn("leaving out a rendition without a language", slog.String("rendition", media.String()))
+			continue
+		}
+		if !slices.Contains(languages, language) {
+			languages = append(languages, language)
+		}
+	}
+	if len(languages) == 0 {
+		return models.RenditionsNone, nil
+	}
+	return strings.Join(languages, ","), nil
+}
+
+var HlsRefreshCommand = &cli.Command{
+	Name:      "refresh",
+	Usage:     fmt.Sprintf("Upgrade an archive downloaded by hls to another --%s or additional renditions, resuming the session recorded in its %s and downloading only the files it lacks", ArgVariant, models.SessionFileName),
+	ArgsUsage: "<directory> [urls...]",
+	Action:    hlsRefresh,
+	Flags:     refreshFlags,
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+	"github.com/alehechka/manifestr/pkg/models"
+)
+
+// RelayPlaylistName is served by --relay next to the download directory, listing the last segments recorded, see
+// models.WriteRelay.
+const RelayPlaylistName = "relay.m3u8"
+
+// startRelay serves directory on address with RelayPlaylistName, a live playlist of the last window segments of the
+// recording, returning its url. It serves until the returned stop is called.
+func startRelay(directory string, address string, window int) (relayUrl string, stop func(), err error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return "", nil, err
+	}
+	server := &http.Server{Handler: playbackHandler(relayHandler(directory, window, http.FileServer(http.Dir(directory))))}
+	go server.Serve(listener)
+	relayUrl = "http://" + listener.Addr().String() + "/" + RelayPlaylistName
+	slog.Info("relaying recording", slog.String("url", relayUrl), slog.Int("window", window))
+
+	return relayUrl, func() {
+		server.Shutdown(context.Background())
+	}, nil
+}
+
+// startPush pushes the relay at relayUrl to target with ffmpeg, see ffmpeg.Push. The returned finish waits up to grace
+// for ffmpeg to push the rest of the relay once it ended, then stops it. Pushing again is up to the ingest point, a
+// failed push only logs.
+func startPush(ctx context.Context, relayUrl string, target string) (finish func(grace time.Duration)) {
+	pushCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	// the path of an RTMP url holds the stream key
+	ingest := target
+	if parsed, err := url.Parse(target); err == nil {
+		ingest = parsed.Scheme + "://" + parsed.Host
+	}
+	slog.Info("pushing recording", slog.String("ingest", ingest))
+	go func() {
+		defer close(done)
+		if !awaitRelay(pushCtx, relayUrl) {
+			return
+		}
+		if err := ffmpeg.Push(pushCtx, relayUrl, target); err != nil && pushCtx.Err() == nil {
+			slog.Error("failed to push recording", slog.String("ingest", ingest), slog.String("error", err.Error()))
+		}
+	}()
+
+	var once sync.Once
+	return func(grace time.Duration) {
+		once.Do(func() {
+			select {
+			case <-done:
+			case <-time.After(grace):
+				slog.Warn("stopped pushing the rest of the recording", slog.String("ingest", ingest), slog.Duration("grace", grace))
+			}
+			cancel()
+			<-done
+		})
+	}
+}
+
+// relayHandler generates RelayPlaylistName from the local manifest of directory and serves the other files.
+func relayHandler(directory string, window int, files http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+RelayPlaylistName {
+			files.ServeHTTP(w, r)
+			return
+		}
+		manifestFile, err := os.Open(path.Join(directory, "local.manifest.m3u8"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		defer manifestFile.Close()
+
+		var relay bytes.Buffer
+		err = models.WriteRelay(manifestFile, &relay, window, func(file string) bool {
+			_, err := os.Stat(path.Join(directory, file))
+			return err == nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(relay.Bytes())
+	})
+}
+
+// awaitRelay waits until the relay at relayUrl lists a segment, as ffmpeg gives up on an empty playlist, reporting
+// false when ctx is done first.
+func awaitRelay(ctx context.Context, relayUrl string) bool {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		if resp, err := http.Get(relayUrl); err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			for _, line := range strings.Split(string(body), "\n") {
+				if line != "" && !strings.HasPrefix(line, "#") {
+					return true
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+var retryFailedFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:  ArgConcurrency,
+		Value: models.DefaultConcurrency,
+		Usage: "Maximum number of files downloaded at once.",
+	},
+	&cli.IntFlag{
+		Name:  ArgRetries,
+		Value: models.DefaultRetries,
+		Usage: "Number of times a download is retried after a server error, rate limiting, stall or network timeout, waiting twice as long before each retry.",
+	},
+	&cli.DurationFlag{
+		Name:  ArgRetryBackoff,
+		Value: models.DefaultRetryBackoff,
+		Usage: fmt.Sprintf("Wait before the first of the --%s, doubled for each next one.", ArgRetries),
+	},
+}
+
+func retryFailed(ctx *cli.Context) error {
+	directory := ctx.Args().Get(0)
+	if directory == "" {
+		return errors.New("no directory provided")
+	}
+
+	deadLettersPath := path.Join(directory, models.DeadLetterFileName)
+	if _, err := os.Stat(deadLettersPath); err != nil {
+		return fmt.Errorf("no failed downloads to retry in %s: %w", directory, err)
+	}
+	letters, err := models.LoadDeadLetters(deadLettersPath)
+	if err != nil {
+		return err
+	}
+
+	plan := models.PlanDeadLetters(letters, models.PlanOptions{
+		Dir:          directory,
+		Concurrency:  ctx.Int(ArgConcurrency),
+		Retries:      ctx.Int(ArgRetries),
+		RetryBackoff: ctx.Duration(ArgRetryBackoff),
+		Downloader:   downloader,
+	})
+	checksumsPath := path.Join(directory, utils.ChecksumsFileName)
+	if plan.Manifest.Checksums, err = utils.LoadChecksumIndex(checksumsPath); err != nil {
+		return err
+	}
+	indexPath := path.Join(directory, utils.IndexFileName)
+	if plan.Manifest.Index, err = utils.LoadArchiveIndex(indexPath); err != nil {
+		return err
+	}
+
+	slog.Info("retrying failed downloads", slog.Int("files", len(plan.Downloads)))
+	downloadErr := plan.Download(ctx.Context)
+
+	if err := plan.Manifest.Checksums.Write(checksumsPath); err != nil {
+		return err
+	}
+	if err := plan.Manifest.Index.Write(indexPath); err != nil {
+		return err
+	}
+	if err := letters.Write(deadLettersPath); err != nil {
+		return err
+	}
+
+	if downloadErr != nil {
+		return downloadErr
+	}
+	slog.Info(fmt.Sprintf("every failed download succeeded, run hls again with the same --%s to produce the outputs", ArgDirectory))
+	return nil
+}
+
+var RetryFailedCommand = &cli.Command{
+	Name:      "retry-failed",
+	Usage:     fmt.Sprintf("Download only the files an earlier download into a directory failed on again, as listed in its %s, updating its checksums and archive index in place", models.DeadLetterFileName),
+	ArgsUsage: "<directory>",
+	Action:    retryFailed,
+	Flags:     retryFailedFlags,
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/alehechka/manifestr/pkg/report"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ArgVerbose      = "verbose"
+	ArgUpdateGolden = "update-golden"
+)
+
+var selftestFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:    ArgVerbose,
+		Aliases: []string{"v"},
+		Usage:   "List the lines every playlist lost and gained in the round trip.",
+	},
+	&cli.BoolFlag{
+		Name:  ArgUpdateGolden,
+		Usage: fmt.Sprintf("Write the round-trip output of every playlist to its golden file (the playlist name with %s appended) instead of comparing with it.", report.GoldenSuffix),
+	},
+}
+
+func selftest(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return errors.New("no playlist directory provided")
+	}
+
+	var corpus report.RoundTripReport
+	for _, dir := range ctx.Args().Slice() {
+		dirReport, err := report.RoundTripCorpus(dir, ctx.Bool(ArgUpdateGolden))
+		if err != nil {
+			return err
+		}
+		corpus.Results = append(corpus.Results, dirReport.Results...)
+	}
+	if len(corpus.Results) == 0 {
+		return errors.New("no .m3u8 or .m3u playlists found")
+	}
+
+	if err := corpus.Write(os.Stdout, ctx.Bool(ArgVerbose)); err != nil {
+		return err
+	}
+	if failed := corpus.Failed(); failed > 0 {
+		return fmt.Errorf("%d of %d playlists failed to round trip", failed, len(corpus.Results))
+	}
+	return nil
+}
+
+var SelftestCommand = &cli.Command{
+	Name:      "selftest",
+	Usage:     "Round-trip a corpus of media playlists through the parser and writer, reporting the lines lost or gained, playlists written differently once parsed again and differences from golden files",
+	ArgsUsage: "<dir|file>...",
+	Action:    selftest,
+	Flags:     selftestFlags,
+}
@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "write the golden files of testdata/roundtrip from the round-trip output")
+
+// TestRoundTrip runs selftest against the corpus in testdata/roundtrip, failing when a playlist no longer parses, is
+// written differently once parsed again or differs from its golden file. Run it with -update to accept a change of the
+// writer into the golden files.
+func TestRoundTrip(t *testing.T) {
+	args := []string{"manifestr", "selftest", "--verbose"}
+	if *updateGolden {
+		args = append(args, "--"+ArgUpdateGolden)
+	}
+	if err := App("test").RunContext(context.Background(), append(args, "testdata/roundtrip")); err != nil {
+		t.Fatal(err)
+	}
+}
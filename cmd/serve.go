@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ArgListen = "listen"
+)
+
+var serveFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    ArgListen,
+		Aliases: []string{"l"},
+		Value:   "localhost:8080",
+		Usage:   "Address to serve the directory on, e.g. :8080 to accept connections from other machines.",
+	},
+}
+
+// playbackContentTypes are the MIME types players expect for the files of a download directory, which the system MIME
+// database usually lacks or gets wrong, such as text/vnd.trolltech.linguist for .ts.
+var playbackContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".mpd":  "application/dash+xml",
+	".ts":   "video/mp2t",
+	".mp4":  "video/mp4",
+	".m4s":  "video/mp4",
+	".m4v":  "video/mp4",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".vtt":  "text/vtt",
+	".key":  "application/octet-stream",
+}
+
+func serve(ctx *cli.Context) error {
+	directory := ctx.Args().First()
+	if directory == "" {
+		return errors.New("no directory provided")
+	}
+	// --all-variants downloads hold a local master rather than a local manifest
+	playlist := "local.manifest.m3u8"
+	if _, err := os.Stat(path.Join(directory, LocalMasterFileName)); err == nil {
+		playlist = LocalMasterFileName
+	}
+	if _, err := os.Stat(path.Join(directory, playlist)); err != nil {
+		return fmt.Errorf("%s holds no downloaded manifest: %w", directory, err)
+	}
+
+	listener, err := net.Listen("tcp", ctx.String(ArgListen))
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: playbackHandler(http.FileServer(http.Dir(directory)))}
+	context.AfterFunc(ctx.Context, func() { server.Shutdown(context.Background()) })
+
+	base := "http://" + listener.Addr().String()
+	slog.Info("serving downloaded manifest", slog.String("dir", directory), slog.String("url", base+"/"+playlist))
+	if _, err := os.Stat(path.Join(directory, models.LocalMpdFileName)); err == nil {
+		slog.Info("serving local MPD", slog.String("url", base+"/"+models.LocalMpdFileName))
+	}
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// playbackHandler adds the CORS headers a browser player such as hls.js needs to fetch the files from another origin,
+// and the content types of playbackContentTypes, to the responses of files.
+func playbackHandler(files http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("Access-Control-Allow-Origin", "*")
+		header.Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+		header.Set("Access-Control-Allow-Headers", "Range")
+		header.Set("Access-Control-Expose-Headers", "Content-Length, Content-Range")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		extension := strings.ToLower(path.Ext(r.URL.Path))
+		if contentType, ok := playbackContentTypes[extension]; ok {
+			header.Set("Content-Type", contentType)
+		}
+		// playlists of a recording in progress change with every segment
+		if extension == ".m3u8" || extension == ".mpd" {
+			header.Set("Cache-Control", "no-cache")
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+var ServeCommand = &cli.Command{
+	Name:      "serve",
+	Usage:     "Serve a download directory over HTTP with the MIME types and CORS headers players need, to play back the local manifest in hls.js or Safari",
+	ArgsUsage: "<dir>",
+	Action:    serve,
+	Flags:     serveFlags,
+}
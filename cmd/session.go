@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"slices"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// sessionExcludedFlags are the flags of hls a session does not record: the directory, which may have moved, and those
+// only meant for the run they were given to, such as a push whose url carries a stream key.
+var sessionExcludedFlags = []string{ArgDirectory, ArgResume, ArgForceDownload, ArgPrintFfmpeg, ArgStage, ArgRelay, ArgPush, ArgPlay, ArgPlayAfter, ArgPlayer, ArgProgress}
+
+// writeSession records the urls and flags of the run in directory, see models.Session. A session started by an earlier
+// run into directory keeps its start.
+func writeSession(ctx *cli.Context, directory string, manifestUrls []string) error {
+	session, err := models.LoadSession(directory)
+	if errors.Is(err, fs.ErrNotExist) {
+		session = new(models.Session)
+	} else if err != nil {
+		return err
+	}
+
+	session.Urls, session.Redacted = nil, false
+	for _, manifestUrl := range manifestUrls {
+		redacted := downloader.RedactUrl(manifestUrl)
+		session.Urls = append(session.Urls, redacted)
+		session.Redacted = session.Redacted || redacted != manifestUrl
+	}
+	session.Flags = make(map[string][]string)
+	for _, flag := range ctx.Command.Flags {
+		name := flag.Names()[0]
+		if ctx.IsSet(name) && !slices.Contains(sessionExcludedFlags, name) {
+			session.Flags[name] = flagValues(ctx.Value(name))
+		}
+	}
+	return session.Write(directory)
+}
+
+// flagValues returns value as the arguments of a flag, one for every time a repeatable flag was given.
+func flagValues(value any) []string {
+	if values, ok := value.(cli.StringSlice); ok {
+		return values.Value()
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// resumeSession returns the urls of the session recorded in directory, setting the flags it recorded unless given on
+// the command line, which win like manifestUrls do.
+func resumeSession(ctx *cli.Context, directory string, manifestUrls []string) ([]string, error) {
+	if directory == "" {
+		return nil, fmt.Errorf("--%s resumes the download into --%s, which is not set", ArgResume, ArgDirectory)
+	}
+	session, err := models.LoadSession(directory)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s holds no %s to resume: %w", directory, models.SessionFileName, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range session.Flags {
+		if ctx.IsSet(name) || slices.Contains(sessionExcludedFlags, name) {
+			continue
+		}
+		for _, value := range values {
+			if err := ctx.Set(name, value); err != nil {
+				return nil, fmt.Errorf("invalid --%s recorded in %s: %w", name, models.SessionFileName, err)
+			}
+		}
+	}
+
+	if len(manifestUrls) == 0 {
+		if slices.Contains(session.Urls, utils.StdinUrl) {
+			return nil, errors.New("the session was read from stdin, give the playlist again to resume it")
+		}
+		if session.Redacted {
+			return nil, errors.New("the session urls had their credentials hidden, give them again to resume it")
+		}
+		manifestUrls = session.Urls
+	}
+	slog.Info("resuming session", slog.String("url", downloader.RedactUrl(manifestUrls[0])), slog.Time("started", session.Started), slog.Int("flags", len(session.Flags)))
+	return manifestUrls, nil
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/storage"
+	"github.com/alehechka/manifestr/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// selectedStage returns the --stage, or an empty one when the whole run is asked for.
+func selectedStage(ctx *cli.Context) (string, error) {
+	stage := ctx.String(ArgStage)
+	if stage != "" && !slices.Contains(models.Stages, stage) {
+		return "", fmt.Errorf("unknown stage %q", stage)
+	}
+	if (stage != "" || ctx.IsSet(ArgUploadTo)) && ctx.Bool(ArgAllVariants) {
+		return "", fmt.Errorf("--%s and --%s cannot be used with --%s", ArgStage, ArgUploadTo, ArgAllVariants)
+	}
+	return stage, nil
+}
+
+// runStage runs the mux or upload stage on the --directory from the handoff the stage before left in it, leaving its
+// own handoff for the next one. The fragments are named as readOptions say and outputs replaced as outputPolicy says,
+// which the handoff does not keep.
+func runStage(runCtx context.Context, ctx *cli.Context, stage string, readOptions models.ReadOptions, outputPolicy utils.OutputPolicy) error {
+	directory := ctx.String(ArgDirectory)
+	if directory == "" || storage.IsRemote(directory) {
+		return fmt.Errorf("--%s %s needs the local --%s the stages before ran in", ArgStage, stage, ArgDirectory)
+	}
+	handoff, err := models.LoadStageHandoff(directory, stage)
+	if err != nil {
+		return err
+	}
+	applyReadOptions(handoff.Manifest, readOptions)
+	handoff.Options.OutputPolicy = outputPolicy
+	next := models.NewStageHandoff(stage, handoff.Manifest, handoff.Options)
+
+	switch stage {
+	case models.StageMux:
+		plan := models.Plan(handoff.Manifest, handoff.Options)
+		if err := ffmpeg.Preflight(runCtx, plan.FfmpegRequirements()); err != nil {
+			return err
+		}
+		if err := plan.Process(runCtx); err != nil {
+			return err
+		}
+		next.Outputs = stageOutputs(plan)
+	case models.StageUpload:
+		uploadTo := ctx.String(ArgUploadTo)
+		if uploadTo == "" {
+			return fmt.Errorf("--%s %s needs --%s", ArgStage, stage, ArgUploadTo)
+		}
+		next.Outputs = handoff.Outputs
+		if next.Uploaded, err = uploadOutputs(runCtx, directory, uploadTo, handoff.Outputs); err != nil {
+			return err
+		}
+	}
+
+	// a printed run produced nothing for the next stage
+	if ffmpeg.DryRun(runCtx) {
+		return nil
+	}
+	return next.Write(directory)
+}
+
+// stageOutputs names what plan produced relative to its directory: the outputs of Process, or the local manifests and
+// the fragments they list when it produced none.
+func stageOutputs(plan *models.DownloadPlan) []string {
+	var outputs []string
+	for _, output := range plan.Outputs {
+		if relative, err := filepath.Rel(plan.Options.Dir, output); err == nil {
+			outputs = append(outputs, filepath.ToSlash(relative))
+		}
+	}
+	if len(outputs) > 0 {
+		return outputs
+	}
+
+	candidates := []string{"local.manifest.m3u8", models.LocalMpdFileName}
+	for _, rendition := range plan.Manifest.Renditions {
+		candidates = append(candidates, path.Join(rendition.Dir, "local.manifest.m3u8"))
+	}
+	for _, download := range plan.Downloads {
+		candidates = append(candidates, download.File)
+	}
+	// vetoed fragments and the MPD of a manifest that is not fragmented MP4 are not there
+	for _, candidate := range candidates {
+		if _, err := os.Stat(path.Join(plan.Options.Dir, candidate)); err == nil {
+			outputs = append(outputs, candidate)
+		}
+	}
+	return outputs
+}
+
+// uploadOutputs copies outputs, named relative to directory, to the directory or object storage at uri, returning
+// where each went.
+func uploadOutputs(ctx context.Context, directory string, uri string, outputs []string) (map[string]string, error) {
+	if len(outputs) == 0 {
+		return nil, errors.New("no outputs to upload")
+	}
+	destination, err := storage.Open(uri, downloader.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded := make(map[string]string, len(outputs))
+	for _, output := range outputs {
+		err := storage.WriteFile(ctx, destination, output, func(w io.Writer) error {
+			file, err := os.Open(path.Join(directory, output))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = io.Copy(w, file)
+			return err
+		})
+		if err != nil {
+			return uploaded, fmt.Errorf("uploading %s: %w", output, err)
+		}
+		uploaded[output] = destination.Location(output)
+		slog.Info("uploaded output", slog.String("file", output), slog.String("location", destination.Location(output)))
+	}
+	return uploaded, nil
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/report"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ArgReloads = "reloads"
+)
+
+var validateFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  ArgFormat,
+		Value: report.ValidationText,
+		Usage: fmt.Sprintf("Print the issues as %q or as %q for CI.", report.ValidationText, report.ValidationJson),
+	},
+	&cli.BoolFlag{
+		Name:  ArgProbe,
+		Usage: "Send a HEAD request for every segment and init segment to check that they can be fetched.",
+	},
+	&cli.IntFlag{
+		Name:  ArgConcurrency,
+		Value: models.DefaultConcurrency,
+		Usage: fmt.Sprintf("Maximum number of HEAD requests of --%s sent at once.", ArgProbe),
+	},
+	&cli.IntFlag{
+		Name:  ArgReloads,
+		Usage: "Reload live playlists that many times to check that their media sequence continues without going back, gaps or changed segments.",
+	},
+	&cli.DurationFlag{
+		Name:  ArgInterval,
+		Usage: fmt.Sprintf("Wait between the --%s, the target duration of the playlist when unset.", ArgReloads),
+	},
+}
+
+func validate(ctx *cli.Context) error {
+	playlistUrl := ctx.Args().Get(0)
+	if playlistUrl == "" {
+		return errors.New("no manifest url provided")
+	}
+
+	format := ctx.String(ArgFormat)
+	if format != report.ValidationText && format != report.ValidationJson {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	playlist, err := fetchPlaylist(playlistUrl)
+	if err != nil {
+		return err
+	}
+
+	validation := report.ValidationReport{Url: playlistUrl}
+	if models.IsMasterPlaylist(bytes.NewReader(playlist)) {
+		master, err := models.ReadMasterPlaylist(bytes.NewReader(playlist), playlistUrl)
+		if err != nil {
+			return err
+		}
+		for _, mediaUrl := range masterPlaylistUrls(master) {
+			validatePlaylist(ctx, &validation, mediaUrl, nil)
+		}
+	} else {
+		validatePlaylist(ctx, &validation, playlistUrl, playlist)
+	}
+
+	if err := validation.Write(os.Stdout, format); err != nil {
+		return err
+	}
+	if failed := validation.Failed(); failed > 0 {
+		return fmt.Errorf("%d issues violate %s rules", failed, report.SeverityMust)
+	}
+	return nil
+}
+
+// masterPlaylistUrls returns the urls of the variant, I-frame and rendition playlists of master, each once.
+func masterPlaylistUrls(master *models.MasterPlaylist) []string {
+	var uris []string
+	for _, variant := range master.Variants {
+		uris = append(uris, variant.Uri)
+	}
+	for _, variant := range master.IFrameVariants {
+		uris = append(uris, variant.Uri)
+	}
+	for _, media := range master.Media {
+		uris = append(uris, media.Uri)
+	}
+
+	var urls []string
+	seen := make(map[string]bool)
+	for _, uri := range uris {
+		if uri == "" {
+			continue
+		}
+		if resolved := master.ResolvedUri(uri); !seen[resolved] {
+			seen[resolved] = true
+			urls = append(urls, resolved)
+		}
+	}
+	return urls
+}
+
+// validatePlaylist validates the media playlist at playlistUrl, already fetched when playlist is not nil, and its reloads.
+func validatePlaylist(ctx *cli.Context, validation *report.ValidationReport, playlistUrl string, playlist []byte) {
+	manifest, parseErr, err := readValidatedManifest(playlistUrl, playlist)
+	if err != nil {
+		validation.Playlists++
+		validation.Issues = append(validation.Issues, report.ValidationIssue{Rule: "playlist", Severity: report.SeverityMust, Url: playlistUrl, Message: err.Error()})
+		return
+	}
+	validation.ValidateManifest(playlistUrl, manifest, parseErr)
+	if ctx.Bool(ArgProbe) {
+		validation.ProbeSegments(playlistUrl, manifest, ctx.Int(ArgConcurrency), downloader)
+	}
+
+	for reload := 0; reload < ctx.Int(ArgReloads) && !manifest.EndList; reload++ {
+		interval := ctx.Duration(ArgInterval)
+		if interval <= 0 {
+			interval = time.Duration(max(manifest.TargetDuration, 1) * float64(time.Second))
+		}
+		select {
+		case <-ctx.Context.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		reloaded, _, err := readValidatedManifest(playlistUrl, nil)
+		if err != nil {
+			slog.Warn("failed to reload playlist", slog.String("url", playlistUrl), slog.String("error", err.Error()))
+			continue
+		}
+		validation.ValidateReload(playlistUrl, manifest, reloaded)
+		manifest = reloaded
+	}
+}
+
+// readValidatedManifest strictly parses the media playlist at playlistUrl, fetching it when playlist is nil. parseErr
+// lists the malformed tags of a playlist that was still read, err is set when it could not be read at all.
+func readValidatedManifest(playlistUrl string, playlist []byte) (manifest *models.Manifest, parseErr error, err error) {
+	if playlist == nil {
+		if playlist, err = fetchPlaylist(playlistUrl); err != nil {
+			return nil, nil, err
+		}
+	}
+	manifest, parseErr = models.ReadManifestWithOptions(bytes.NewReader(playlist), playlistUrl, models.ReadOptions{Strict: true, MediaQuery: mediaQuery})
+	var parseError *models.ParseError
+	if parseErr != nil && !errors.As(parseErr, &parseError) {
+		return nil, nil, parseErr
+	}
+	return manifest, parseErr, nil
+}
+
+func fetchPlaylist(playlistUrl string) ([]byte, error) {
+	in, err := downloader.OpenUrl(playlistUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return io.ReadAll(in)
+}
+
+var ValidateCommand = &cli.Command{
+	Name:      "validate",
+	Usage:     "Check a media playlist, or the variant playlists of a master playlist, against RFC 8216 and playability rules, exiting non-zero on violations",
+	ArgsUsage: "<url>",
+	Action:    validate,
+	Flags:     validateFlags,
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/alehechka/manifestr/pkg/report"
+)
+
+func TestValidateProgramDateTimes(t *testing.T) {
+	tests := []struct {
+		name   string
+		date   string
+		failed int
+	}{
+		{name: "utc", date: "2024-01-01T10:00:00.000Z"},
+		{name: "offset", date: "2024-01-01T12:00:00.000+02:00"},
+		{name: "zero offset", date: "2024-01-01T10:00:00.000+00:00"},
+		{name: "basic offset", date: "2024-01-01T10:00:00.000+0000"},
+		{name: "unparsable", date: "2024-01-01 10:00:00", failed: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-PROGRAM-DATE-TIME:" + test.date + "\n#EXTINF:6.0,\ns0.ts\n#EXT-X-ENDLIST\n"
+			manifest, parseErr, err := readValidatedManifest("http://localhost/playlist.m3u8", []byte(playlist))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			validation := &report.ValidationReport{}
+			validation.ValidateManifest("http://localhost/playlist.m3u8", manifest, parseErr)
+			if failed := validation.Failed(); failed != test.failed {
+				t.Errorf("%d issues failed validation, want %d: %+v", failed, test.failed, validation.Issues)
+			}
+		})
+	}
+}
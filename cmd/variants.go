@@ -0,0 +1,377 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/telemetry"
+	"github.com/alehechka/manifestr/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// LocalMasterFileName is the master playlist written by --all-variants, pointing at the local manifests of the variants
+// and renditions.
+const LocalMasterFileName = "local.master.m3u8"
+
+// allVariantsExcludedFlags are the flags working on the single variant stream --all-variants replaces.
+var allVariantsExcludedFlags = []string{ArgVariant, ArgWarnSize, ArgAppend, ArgStart, ArgEnd, ArgClipReencode, ArgInferTime, ArgRetryPasses, ArgArchiveDir, ArgTimedMetadata, ArgPlay, ArgRelay, ArgPush}
+
+// archivedPlaylist is a variant or rendition playlist downloaded by --all-variants into dir, a subfolder of the download
+// directory, which --live reloads through selection.
+type archivedPlaylist struct {
+	dir       string
+	manifest  *models.Manifest
+	selection *playlistSelection
+	rendition bool
+}
+
+// downloadAllVariants downloads every variant stream of the master playlist at manifestUrls, each into its own
+// subfolder of directory, and the renditions of their groups selected by --audio-lang and --subs into theirs, then writes
+// LocalMasterFileName pointing at their local manifests. The playlists are downloaded at once, sharing --concurrency, and
+// every variant is concatenated by itself as concat says. With --live every playlist is recorded at once before, see
+// recordAllVariants.
+func downloadAllVariants(runCtx context.Context, ctx *cli.Context, directory string, manifestUrls []string, concat string) error {
+	for _, flag := range allVariantsExcludedFlags {
+		if ctx.IsSet(flag) {
+			return fmt.Errorf("--%s cannot be used with --%s", flag, ArgAllVariants)
+		}
+	}
+
+	splitSize, splitDuration, err := splitOutput(ctx, concat)
+	if err != nil {
+		return err
+	}
+
+	forceDownload := ctx.Bool(ArgForceDownload)
+	manifestUrl, manifestPath, err := downloadManifest(runCtx, manifestCache(ctx), directory, manifestUrls, forceDownload)
+	if err != nil {
+		return err
+	}
+	sourceUrl := manifestUrl
+	if baseUrl := ctx.String(ArgBaseUrl); baseUrl != "" {
+		sourceUrl = baseUrl
+	} else if manifestUrl == utils.StdinUrl {
+		sourceUrl = ""
+	}
+	failoverUrls := make([]string, 0, len(manifestUrls))
+	for _, failoverUrl := range manifestUrls {
+		if failoverUrl != manifestUrl && failoverUrl != utils.StdinUrl {
+			failoverUrls = append(failoverUrls, failoverUrl)
+		}
+	}
+
+	master, err := readMasterPlaylist(manifestPath, sourceUrl)
+	if err != nil {
+		return err
+	}
+	if master == nil {
+		return fmt.Errorf("--%s needs a master playlist, %s is a media playlist", ArgAllVariants, manifestUrl)
+	}
+	masterPath := path.Join(directory, "master.m3u8")
+	if err := os.Rename(manifestPath, masterPath); err != nil {
+		return err
+	}
+	slog.Info("downloading all variants", slog.Int("variants", len(master.Variants)))
+
+	options, err := readOptions(ctx)
+	if err != nil {
+		return err
+	}
+	options.Imports = master.Variables
+	medias, err := selectRenditions(ctx, master, master.Variants...)
+	if err != nil {
+		return err
+	}
+	mediaUrls := renditionUrls(master, medias)
+	renditionsRequested := time.Now()
+	renditions, err := loadRenditions(runCtx, directory, medias, mediaUrls, options)
+	if err != nil {
+		return err
+	}
+
+	var playlists []archivedPlaylist
+	local := make(map[int]string)
+	taken := make(map[string]bool)
+	for index, variant := range master.Variants {
+		dir := variant.DirName()
+		if taken[dir] {
+			dir = fmt.Sprintf("%s-%d", dir, index)
+		}
+		taken[dir] = true
+		if err := os.MkdirAll(path.Join(directory, dir), os.ModePerm); err != nil {
+			return err
+		}
+
+		variantUrl := master.ResolvedUri(variant.Uri)
+		requested := time.Now()
+		playlistPath, err := utils.DownloadFile(runCtx, path.Join(directory, dir), "original.manifest.m3u8", variantUrl, utils.DownloadOptions{Force: true, Downloader: downloader})
+		if err != nil {
+			return err
+		}
+		manifest, err := models.ReadManifestFromFile(playlistPath, variantUrl, options)
+		if err != nil {
+			return fmt.Errorf("%s: %w", variant, err)
+		}
+		for _, repair := range manifest.Repairs {
+			slog.Warn("repaired manifest", slog.String("dir", dir), slog.Int("line", repair.Line), slog.String("tag", repair.Tag), slog.String("repair", repair.Err.Error()))
+		}
+		variantFailovers := variantFailoverUrls(failoverUrls, variant.Uri)
+		selection := &playlistSelection{urls: append([]string{variantUrl}, variantFailovers...), options: options, fetched: fetchedCopy(manifest), fetchedAt: requested}
+		manifest.AddFailoverUrls(variantFailovers...)
+		if err := manifest.AddFallbackRules(ctx.StringSlice(ArgFallbackUrl)...); err != nil {
+			return err
+		}
+
+		slog.Info("selected variant", slog.String("variant", variant.String()), slog.String("dir", dir))
+		local[variant.Line] = path.Join(dir, "local.manifest.m3u8")
+		playlists = append(playlists, archivedPlaylist{dir: dir, manifest: manifest, selection: selection})
+	}
+	for index, rendition := range renditions {
+		selection := &playlistSelection{urls: []string{mediaUrls[index]}, options: options, fetched: fetchedCopy(rendition.Manifest), fetchedAt: renditionsRequested}
+		local[rendition.Line] = path.Join(rendition.Dir, "local.manifest.m3u8")
+		playlists = append(playlists, archivedPlaylist{dir: rendition.Dir, manifest: rendition.Manifest, selection: selection, rendition: true})
+	}
+
+	cut := make([]*models.Manifest, 0, len(playlists))
+	for _, playlist := range playlists {
+		cut = append(cut, playlist.manifest)
+	}
+	if err := cutManifests(ctx, cut); err != nil {
+		return err
+	}
+	live := ctx.Bool(ArgLive)
+	if live && ctx.String(ArgLiveJoin) == models.LiveJoinDrop {
+		for _, manifest := range cut {
+			manifest.DropFirstSegment()
+		}
+	}
+
+	transfer, err := transferOptions(ctx)
+	if err != nil {
+		return err
+	}
+	outputPolicy, err := outputPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	var store *utils.SharedStore
+	if ctx.Bool(ArgSharedStore) {
+		policy, err := storePolicy(ctx)
+		if err != nil {
+			return err
+		}
+		if store, err = utils.OpenSharedStore("", policy); err != nil {
+			return err
+		}
+	}
+	quota, err := openQuota(ctx, directory)
+	if err != nil {
+		return err
+	}
+	// the recorders of --live download at once, each with a bar of its own, so they only report to the log
+	var progress *utils.Progress
+	if ctx.Bool(ArgProgress) && !live {
+		if progress = utils.NewProgress(os.Stderr); progress.Terminal() {
+			defer withProgressLogging(progress)()
+		}
+	}
+
+	plans := make([]*models.DownloadPlan, 0, len(playlists))
+	var requirements ffmpeg.Requirements
+	for _, playlist := range playlists {
+		dir := path.Join(directory, playlist.dir)
+		manifest := playlist.manifest
+		if err := writeLocalManifest(runCtx, dir, manifest); err != nil {
+			return err
+		}
+		if manifest.Checksums, err = utils.LoadChecksumIndex(path.Join(dir, utils.ChecksumsFileName)); err != nil {
+			return err
+		}
+		if manifest.Index, err = utils.LoadArchiveIndex(path.Join(dir, utils.IndexFileName)); err != nil {
+			return err
+		}
+		if manifest.DeadLetters, err = models.LoadDeadLetters(path.Join(dir, models.DeadLetterFileName)); err != nil {
+			return err
+		}
+		manifest.Statuses = utils.NewDownloadStatusCache()
+		manifest.Validate = ctx.Bool(ArgValidate)
+		manifest.Store = store
+
+		planOptions := models.PlanOptions{
+			Dir:           dir,
+			ForceDownload: forceDownload,
+			Concurrency:   ctx.Int(ArgConcurrency),
+			Retries:       ctx.Int(ArgRetries),
+			RetryBackoff:  ctx.Duration(ArgRetryBackoff),
+			PreloadParts:  ctx.Bool(ArgPreloadHint),
+			Assets:        ctx.Bool(ArgAssets),
+			Container:     ctx.String(ArgContainer),
+			ConcatMode:    concat,
+			OutputName:    outputName(ctx, concat, manifest),
+			AvSync:        ctx.String(ArgAvSync),
+			SplitSize:     splitSize,
+			SplitDuration: splitDuration,
+			Quota:         quota,
+			Progress:      progress,
+			Downloader:    downloader,
+			Transfer:      transfer,
+			OutputPolicy:  outputPolicy,
+		}
+		if live {
+			planOptions.LiveJoin = ctx.String(ArgLiveJoin)
+		}
+		// the renditions are kept as they are for players of the local master
+		if playlist.rendition {
+			planOptions.Container, planOptions.ConcatMode, planOptions.AvSync = models.ContainerMp4, models.ConcatNone, models.AvSyncOff
+		}
+		if command := ctx.String(ArgScanCommand); command != "" {
+			planOptions.Scan = models.ScanCommand(command)
+		}
+
+		plan := models.Plan(manifest, planOptions)
+		requirements = requirements.Merge(plan.FfmpegRequirements())
+		plans = append(plans, plan)
+	}
+	if err := ffmpeg.Preflight(runCtx, requirements); err != nil {
+		return err
+	}
+
+	if live {
+		selections := make([]*playlistSelection, 0, len(playlists))
+		for _, playlist := range playlists {
+			selections = append(selections, playlist.selection)
+		}
+		if plans, err = recordAllVariants(runCtx, ctx, selections, plans); err != nil {
+			return err
+		}
+		// an interrupt only ends the recording, what was recorded is still completed and processed
+		runCtx = context.WithoutCancel(runCtx)
+	}
+	if err := writeLocalMaster(directory, masterPath, sourceUrl, local); err != nil {
+		return err
+	}
+
+	downloadCtx, downloadSpan := telemetry.Start(runCtx, "download fragments")
+	downloadErr := models.DownloadAll(downloadCtx, plans)
+	downloadSpan.End()
+	truncated := errors.Is(downloadErr, models.ErrQuotaExceeded)
+	if truncated {
+		downloadErr = nil
+	}
+
+	var results []models.FragmentResult
+	for _, plan := range plans {
+		results = append(results, plan.Results...)
+	}
+	if err := writeSizeReport(results); err != nil {
+		return err
+	}
+
+	for _, plan := range plans {
+		if len(plan.Vetoed) > 0 || live || truncated {
+			plan.ExcludeVetoed()
+			if truncated {
+				plan.ExcludeMissing()
+			}
+			if err := writeLocalManifest(runCtx, plan.Options.Dir, plan.Manifest); err != nil {
+				return err
+			}
+		}
+		if err := plan.Manifest.Checksums.Write(path.Join(plan.Options.Dir, utils.ChecksumsFileName)); err != nil {
+			return err
+		}
+		if err := plan.Manifest.Index.Write(path.Join(plan.Options.Dir, utils.IndexFileName)); err != nil {
+			return err
+		}
+		if err := plan.Manifest.DeadLetters.Write(path.Join(plan.Options.Dir, models.DeadLetterFileName)); err != nil {
+			return err
+		}
+	}
+	if err := transfer.Batch.Sync(); err != nil {
+		return err
+	}
+	if downloadErr != nil {
+		return downloadErr
+	}
+
+	var errs []error
+	for _, plan := range plans {
+		// the quota may have stopped the downloads before any fragment of a variant
+		if truncated && !plan.Manifest.HasEntries() {
+			slog.Warn("nothing of the variant was downloaded before the quota", slog.String("dir", plan.Options.Dir))
+			continue
+		}
+		if err := plan.Process(runCtx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", plan.Options.Dir, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recordAllVariants records the playlists of plans live at once, see recordLive, each reloaded through the selection at
+// its index, then aligns the recordings by #EXT-X-PROGRAM-DATE-TIME or media sequence number, see
+// models.AlignManifests, so the variants and renditions of the local master start and end on the same segment for
+// players switching between them. It returns the plans of the aligned recordings, whose segments were downloaded.
+func recordAllVariants(runCtx context.Context, ctx *cli.Context, selections []*playlistSelection, plans []*models.DownloadPlan) ([]*models.DownloadPlan, error) {
+	archives := make([]*models.Manifest, len(plans))
+	vetoed := make([][]models.PlannedDownload, len(plans))
+	var wg sync.WaitGroup
+	for index, plan := range plans {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			archives[index], vetoed[index] = recordLive(runCtx, ctx, plan.Options.Dir, selections[index], plan.Manifest, plan.Options)
+		}()
+	}
+	wg.Wait()
+
+	models.AlignManifests(archives...)
+	slog.Info("aligned the live recordings", slog.Int("mediaSequence", archives[0].MediaSequence), slog.Time("programDateTime", archives[0].Discontinuities[0].ProgramDateTime))
+
+	recorded := make([]*models.DownloadPlan, 0, len(plans))
+	for index, archive := range archives {
+		if err := writeLocalManifest(runCtx, plans[index].Options.Dir, archive); err != nil {
+			return nil, err
+		}
+		plan := models.Plan(archive, plans[index].Options)
+		plan.Vetoed = vetoed[index]
+		recorded = append(recorded, plan)
+	}
+	return recorded, nil
+}
+
+// fetchedCopy returns a copy of the media playlist manifest as it was fetched, before it is cut, which the delta
+// updates and blocking reloads of a recording start from, see reloadWithDirectives.
+func fetchedCopy(manifest *models.Manifest) *models.Manifest {
+	fetched := *manifest
+	return &fetched
+}
+
+// writeLocalMaster writes LocalMasterFileName into directory from the master playlist at masterPath, see
+// models.WriteLocalMaster.
+func writeLocalMaster(directory string, masterPath string, sourceUrl string, local map[int]string) error {
+	masterFile, err := os.Open(masterPath)
+	if err != nil {
+		return err
+	}
+	defer masterFile.Close()
+
+	localFile, err := os.Create(path.Join(directory, LocalMasterFileName))
+	if err != nil {
+		return err
+	}
+	defer localFile.Close()
+
+	if err := models.WriteLocalMaster(masterFile, localFile, sourceUrl, local); err != nil {
+		return err
+	}
+	return localFile.Close()
+}
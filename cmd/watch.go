@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	ArgHistory = "history"
+)
+
+var watchFlags = append([]cli.Flag{
+	&cli.DurationFlag{
+		Name:  ArgInterval,
+		Value: 2 * time.Second,
+		Usage: "How often to poll the playlist.",
+	},
+	&cli.IntFlag{
+		Name:  ArgHistory,
+		Value: 60,
+		Usage: "Number of polls to keep in each sparkline.",
+	},
+	&cli.StringFlag{
+		Name:  ArgWebhook,
+		Usage: "URL to POST a JSON alert to when the bitrate of a segment is out of bounds.",
+	},
+}, bitrateFlags...)
+
+// watchSeries is a bounded history of one statistic sampled on every poll.
+type watchSeries struct {
+	label  string
+	unit   string
+	values []float64
+}
+
+func (series *watchSeries) add(value float64, history int) {
+	series.values = append(series.values, value)
+	if len(series.values) > history {
+		series.values = series.values[len(series.values)-history:]
+	}
+}
+
+func (series watchSeries) String() string {
+	if len(series.values) == 0 {
+		return fmt.Sprintf("%-18s", series.label)
+	}
+	return fmt.Sprintf("%-18s %8.2f%-4s %s", series.label, series.values[len(series.values)-1], series.unit, utils.Sparkline(series.values))
+}
+
+func watch(ctx *cli.Context) (err error) {
+	manifestUrl := ctx.Args().Get(0)
+	if manifestUrl == "" {
+		return errors.New("no manifest url provided")
+	}
+
+	history := ctx.Int(ArgHistory)
+	duration := &watchSeries{label: "segment duration", unit: "s"}
+	latency := &watchSeries{label: "playlist latency", unit: "s"}
+	window := &watchSeries{label: "window size", unit: " seg"}
+	bitrateSeries := &watchSeries{label: "bitrate", unit: "Mbps"}
+	bitrate := newBitrateMonitor(ctx, history)
+	var lastAlert error
+
+	ticker := time.NewTicker(ctx.Duration(ArgInterval))
+	defer ticker.Stop()
+
+	var manifest *models.Manifest
+	var fetched time.Time
+	var pollErr error
+	for {
+		polled := time.Now()
+		if manifest, pollErr = pollManifest(manifestUrl, manifest, fetched); pollErr == nil {
+			fetched = polled
+		}
+		if manifest != nil {
+			if entry := manifest.LastEntry(); entry != nil {
+				duration.add(entry.Duration, history)
+			}
+			if edge := manifest.LiveEdgeTime(); !edge.IsZero() {
+				latency.add(time.Since(edge).Seconds(), history)
+			}
+			window.add(float64(manifest.LastSequence()-manifest.MediaSequence+1), history)
+
+			measured, bitrateErr := bitrate.check(manifest)
+			if measured > 0 {
+				bitrateSeries.add(measured/1_000_000, history)
+			}
+			var alert bitrateAlert
+			if errors.As(bitrateErr, &alert) {
+				alertBitrate(manifestUrl, ctx.String(ArgWebhook), alert)
+				lastAlert = alert
+			}
+		}
+
+		// redraw in place from the top left of a cleared screen
+		fmt.Fprint(os.Stdout, "\033[H\033[2J")
+		fmt.Fprintf(os.Stdout, "%s  %s\n\n", manifestUrl, time.Now().Format(time.TimeOnly))
+		if manifest != nil {
+			fmt.Fprintf(os.Stdout, "%-18s %8d\n", "media sequence", manifest.LastSequence())
+		}
+		fmt.Fprintln(os.Stdout, duration)
+		fmt.Fprintln(os.Stdout, latency)
+		fmt.Fprintln(os.Stdout, window)
+		fmt.Fprintln(os.Stdout, bitrateSeries)
+		if lastAlert != nil {
+			fmt.Fprintf(os.Stdout, "\nlast alert: %s\n", lastAlert)
+		}
+		if pollErr != nil {
+			fmt.Fprintf(os.Stdout, "\nlast poll failed: %s\n", pollErr)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+var WatchCommand = &cli.Command{
+	Name:   "watch",
+	Usage:  "Render a live view of segment duration, playlist latency, window size and bitrate for a live HLS manifest url, alerting when the bitrate is out of bounds",
+	Action: watch,
+	Flags:  watchFlags,
+}
@@ -2,14 +2,19 @@ package main
 
 import (
 	"log"
-	"manifestr/cmd"
 	"os"
+
+	"github.com/alehechka/manifestr/cmd"
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
 )
 
 // Version of application
 var Version = "dev"
 
 func main() {
+	// a panic would otherwise leave ffmpeg running in its own process group
+	defer ffmpeg.KillAll()
+
 	if err := cmd.App(Version).Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
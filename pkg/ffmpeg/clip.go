@@ -0,0 +1,111 @@
+package ffmpeg
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ClipMp4 copies the window between start and end seconds of input into output without re-encoding. An end of 0 keeps everything after start.
+func ClipMp4(ctx context.Context, input string, output string, start float64, end float64) error {
+	if err := checkInput(ctx, input); err != nil {
+		return err
+	}
+
+	args := []string{"-ss", formatSeconds(start)}
+	if end > 0 {
+		args = append(args, "-to", formatSeconds(end))
+	}
+	args = append(args, "-i", input, "-c", "copy", "-avoid_negative_ts", "make_zero", output)
+
+	return Ffmpeg(ctx, args...)
+}
+
+// ClipMp4Reencoded clips the window between start and end seconds of input into output exactly, rather than from the
+// key frame before start: only the partial GOPs at either end of the window are re-encoded with libx264 and aac, the
+// GOPs between them are copied, and the parts are joined with ConcatMp4s. keyframes are the key frames of input, see
+// Keyframes. An end of 0 keeps everything after start. The copied GOPs must be H.264 and AAC to join the re-encoded ones.
+func ClipMp4Reencoded(ctx context.Context, input string, output string, start float64, end float64, keyframes []float64) error {
+	if err := checkInput(ctx, input); err != nil {
+		return err
+	}
+
+	// the copied GOPs run from the first key frame at or after start to the last one at or before end
+	copyStart, found := nextKeyframe(keyframes, start)
+	copyEnd := end
+	if end > 0 {
+		copyEnd = SnapToKeyframe(keyframes, end)
+	}
+	if !found || (end > 0 && copyStart >= copyEnd) {
+		// the window lies within a single GOP, so there is nothing to copy
+		return Ffmpeg(ctx, reencodeArgs(input, output, start, end)...)
+	}
+
+	base := strings.TrimSuffix(output, ".mp4")
+	commands := make([][]string, 0, 3)
+	parts := make([]string, 0, 3)
+	if copyStart > start {
+		head := base + ".head.mp4"
+		commands = append(commands, reencodeArgs(input, head, start, copyStart))
+		parts = append(parts, head)
+	}
+	middle := base + ".middle.mp4"
+	args := []string{"-ss", formatSeconds(copyStart)}
+	if end > 0 {
+		args = append(args, "-to", formatSeconds(copyEnd))
+	}
+	commands = append(commands, append(args, "-i", input, "-c", "copy", "-avoid_negative_ts", "make_zero", middle))
+	parts = append(parts, middle)
+	if end > copyEnd {
+		tail := base + ".tail.mp4"
+		commands = append(commands, reencodeArgs(input, tail, copyEnd, end))
+		parts = append(parts, tail)
+	}
+	// printed commands still need the parts they join
+	if !DryRun(ctx) {
+		defer func() {
+			for _, part := range parts {
+				os.Remove(part)
+			}
+		}()
+	}
+
+	if err := Sequence(ctx, commands...); err != nil {
+		return err
+	}
+	return ConcatMp4s(ctx, parts, output)
+}
+
+// reencodeArgs are the args of Ffmpeg re-encoding the window between start and end seconds of input into output.
+func reencodeArgs(input string, output string, start float64, end float64) []string {
+	args := []string{"-ss", formatSeconds(start)}
+	if end > 0 {
+		args = append(args, "-to", formatSeconds(end))
+	}
+	return append(args, "-i", input, "-c:v", "libx264", "-c:a", "aac", "-avoid_negative_ts", "make_zero", output)
+}
+
+// nextKeyframe returns the earliest key frame at or after t, reporting whether there is one.
+func nextKeyframe(keyframes []float64, t float64) (float64, bool) {
+	for _, keyframe := range keyframes {
+		if keyframe >= t {
+			return keyframe, true
+		}
+	}
+	return 0, false
+}
+
+// TrimMp4 copies input to output without re-encoding, leaving out everything before start seconds. Unlike ClipMp4 it
+// keeps every stream, such as muxed renditions.
+func TrimMp4(ctx context.Context, input string, output string, start float64) error {
+	if err := checkInput(ctx, input); err != nil {
+		return err
+	}
+
+	return Ffmpeg(ctx, "-ss", formatSeconds(start), "-i", input, "-map", "0", "-c", "copy", "-avoid_negative_ts", "make_zero", output)
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
@@ -0,0 +1,61 @@
+package ffmpeg
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClipMp4Reencoded(t *testing.T) {
+	keyframes := []float64{0, 4, 8, 12}
+	tests := []struct {
+		name     string
+		start    float64
+		end      float64
+		commands []string
+	}{
+		{
+			name:     "both bounds mid-GOP",
+			start:    2,
+			end:      10,
+			commands: []string{"-ss 2.000 -to 4.000 -i in.mp4 -c:v libx264", "-ss 4.000 -to 8.000 -i in.mp4 -c copy", "-ss 8.000 -to 10.000 -i in.mp4 -c:v libx264", "-f concat"},
+		},
+		{
+			name:     "start on a key frame",
+			start:    4,
+			end:      10,
+			commands: []string{"-ss 4.000 -to 8.000 -i in.mp4 -c copy", "-ss 8.000 -to 10.000 -i in.mp4 -c:v libx264", "-f concat"},
+		},
+		{
+			name:     "open end",
+			start:    2,
+			commands: []string{"-ss 2.000 -to 4.000 -i in.mp4 -c:v libx264", "-ss 4.000 -i in.mp4 -c copy", "-f concat"},
+		},
+		{
+			name:     "within a single GOP",
+			start:    5,
+			end:      7,
+			commands: []string{"-ss 5.000 -to 7.000 -i in.mp4 -c:v libx264"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var printed strings.Builder
+			ctx := WithRunner(context.Background(), Runner{DryRun: &printed})
+
+			if err := ClipMp4Reencoded(ctx, "in.mp4", filepath.Join(t.TempDir(), "out.clip.mp4"), test.start, test.end, keyframes); err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(printed.String()), "\n")
+			if len(lines) != len(test.commands) {
+				t.Fatalf("ran %d commands, want %d:\n%s", len(lines), len(test.commands), printed.String())
+			}
+			for index, want := range test.commands {
+				if !strings.Contains(lines[index], want) {
+					t.Errorf("command %d is %q, want it to contain %q", index, lines[index], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ConcatMp4s joins inputs into output with the concat demuxer, which shifts the timestamps of every input to follow on
+// from the end of the previous one, so discontinuities with restarting timestamps play back as one timeline. The streams
+// are copied, so the inputs must share their codecs.
+func ConcatMp4s(ctx context.Context, inputs []string, output string) error {
+	var list strings.Builder
+	for _, input := range inputs {
+		if err := checkInput(ctx, input); err != nil {
+			return err
+		}
+		// relative paths would be resolved against the directory of the list
+		absolute, err := filepath.Abs(input)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&list, "file %s\n", concatQuote(absolute))
+	}
+
+	listPath := strings.TrimSuffix(output, ".mp4") + ".concat.txt"
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return err
+	}
+	// a printed command still needs its list
+	if !DryRun(ctx) {
+		defer os.Remove(listPath)
+	}
+
+	return Ffmpeg(ctx, "-f", "concat", "-safe", "0", "-i", listPath, "-map", "0", "-c", "copy", "-avoid_negative_ts", "make_zero", output)
+}
+
+// ConcatEntry is a file of a concat demuxer list, see WriteConcatList.
+type ConcatEntry struct {
+	// File is resolved by ffmpeg against the directory of the list when it is relative.
+	File string
+	// Duration in seconds is written as the duration of the file, so the demuxer knows where every file starts without
+	// probing it. Zero leaves it out.
+	Duration float64
+}
+
+// WriteConcatList writes entries as an ffconcat list for the concat demuxer, to be read with "ffmpeg -f concat -i".
+// Absolute or parent relative paths additionally need "-safe 0".
+func WriteConcatList(w io.Writer, entries []ConcatEntry) error {
+	if _, err := fmt.Fprintln(w, "ffconcat version 1.0"); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := fmt.Fprintf(w, "file %s\n", concatQuote(entry.File)); err != nil {
+			return err
+		}
+		if entry.Duration > 0 {
+			if _, err := fmt.Fprintf(w, "duration %s\n", strconv.FormatFloat(entry.Duration, 'f', -1, 64)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// concatQuote quotes path for a concat demuxer list, which only knows single quotes ended and escaped to embed one.
+func concatQuote(path string) string {
+	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
@@ -0,0 +1,73 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var (
+	ErrMissingCodec     = errors.New("codec not available")
+	ErrCorruptInput     = errors.New("corrupt input")
+	ErrInputNotFound    = errors.New("input not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrUnknown          = errors.New("ffmpeg failed")
+)
+
+// failurePatterns maps fragments of ffmpeg's error output to the kind of failure they indicate, checked in order.
+var failurePatterns = []struct {
+	pattern string
+	kind    error
+}{
+	{"Unknown encoder", ErrMissingCodec},
+	{"Encoder not found", ErrMissingCodec},
+	{"Decoder not found", ErrMissingCodec},
+	{"Unsupported codec", ErrMissingCodec},
+	{"codec not currently supported", ErrMissingCodec},
+	{"No such file or directory", ErrInputNotFound},
+	{"Permission denied", ErrPermissionDenied},
+	{"Invalid data found when processing input", ErrCorruptInput},
+	{"moov atom not found", ErrCorruptInput},
+	{"non-existing PPS", ErrCorruptInput},
+	{"error while decoding", ErrCorruptInput},
+	{"corrupt", ErrCorruptInput},
+}
+
+// Error is returned when an ffmpeg invocation fails. Kind classifies the failure and can be matched with errors.Is.
+type Error struct {
+	Args     []string
+	ExitCode int
+	Stderr   string
+	Kind     error
+}
+
+func newError(args []string, runErr error, stderr string) *Error {
+	ffmpegError := &Error{Args: args, ExitCode: -1, Stderr: strings.TrimSpace(stderr), Kind: ErrUnknown}
+
+	var exitErr *exec.ExitError
+	if errors.As(runErr, &exitErr) {
+		ffmpegError.ExitCode = exitErr.ExitCode()
+	}
+
+	for _, failure := range failurePatterns {
+		if strings.Contains(stderr, failure.pattern) {
+			ffmpegError.Kind = failure.kind
+			break
+		}
+	}
+
+	return ffmpegError
+}
+
+func (ffmpegError *Error) Error() string {
+	lastLine := ffmpegError.Stderr
+	if index := strings.LastIndex(lastLine, "\n"); index >= 0 {
+		lastLine = lastLine[index+1:]
+	}
+	return fmt.Sprintf("%s (exit code %d): %s", ffmpegError.Kind, ffmpegError.ExitCode, lastLine)
+}
+
+func (ffmpegError *Error) Unwrap() error {
+	return ffmpegError.Kind
+}
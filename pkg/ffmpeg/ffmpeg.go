@@ -1,31 +1,207 @@
 package ffmpeg
 
 import (
+	"bufio"
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
-	"syscall"
+	"sync"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func Ffmpeg(args ...string) error {
+// Runner decides how the ffmpeg commands of a run are executed. It travels with the context of the run, see WithRunner,
+// so runs sharing a process each have their own. The zero value runs every command, logging its errors only.
+type Runner struct {
+	// DryRun, when set, makes Ffmpeg print each command it would run to the writer instead of executing it.
+	DryRun io.Writer
+	// LogLevel is passed to ffmpeg as -loglevel, "error" when empty; it must keep error messages so failures can be
+	// classified.
+	LogLevel string
+	// OnProgress, when set, is called with every progress report of a running ffmpeg command, along with its args.
+	OnProgress func(args []string, progress Progress)
+}
+
+type runnerKey struct{}
+
+// WithRunner returns a copy of ctx whose ffmpeg commands are executed as runner says.
+func WithRunner(ctx context.Context, runner Runner) context.Context {
+	return context.WithValue(ctx, runnerKey{}, runner)
+}
+
+// RunnerFrom returns the Runner of ctx, the zero value when it has none.
+func RunnerFrom(ctx context.Context) Runner {
+	runner, _ := ctx.Value(runnerKey{}).(Runner)
+	return runner
+}
+
+// DryRun reports whether the ffmpeg commands of ctx are only printed, in which case their outputs never exist.
+func DryRun(ctx context.Context) bool {
+	return RunnerFrom(ctx).DryRun != nil
+}
+
+func (runner Runner) logLevel() string {
+	if runner.LogLevel == "" {
+		return "error"
+	}
+	return runner.LogLevel
+}
+
+// Ffmpeg runs ffmpeg with args as a child process that is interrupted when ctx is done, see startGroup, logging its
+// output through slog as it is written and reporting its progress to the OnProgress of the Runner of ctx. A failure is
+// returned as an *Error classifying it.
+func Ffmpeg(ctx context.Context, args ...string) (err error) {
 	if len(args) == 0 {
 		return errors.New("no args provided")
 	}
 
+	// ffmpeg must never wait on a prompt: stdin is detached and outputs are resolved against the utils.OutputPolicy of the run beforehand
+	if args[0] == "ffmpeg" {
+		args = args[1:]
+	}
+	runner := RunnerFrom(ctx)
+	args = append([]string{"ffmpeg", "-nostdin", "-hide_banner", "-loglevel", runner.logLevel(), "-y"}, args...)
+
+	if runner.DryRun != nil {
+		_, err := fmt.Fprintln(runner.DryRun, shellJoin(args))
+		return err
+	}
+
 	ffmpeg, err := exec.LookPath("ffmpeg")
 	if err != nil {
 		return err
 	}
 
-	if args[0] != "ffmpeg" {
-		args = append([]string{"ffmpeg"}, args...)
+	slog.Debug("running ffmpeg command", slog.String("args", strings.Join(args, " ")))
+
+	ctx, span := telemetry.Start(ctx, "ffmpeg", attribute.String("ffmpeg.args", strings.Join(args, " ")))
+	defer func() { telemetry.End(span, err) }()
+
+	// progress reports go to stdout, leaving stderr to the log messages
+	cmd := exec.Command(ffmpeg, append([]string{"-progress", "pipe:1", "-nostats"}, args[1:]...)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	done, err := startGroup(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	var stderr strings.Builder
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		readProgress(stdout, args, runner.OnProgress)
+	}()
+	go func() {
+		defer wg.Done()
+		scanner := bufio.NewScanner(stderrPipe)
+		for scanner.Scan() {
+			slog.Debug("ffmpeg output", slog.String("stderr", scanner.Text()))
+			stderr.WriteString(scanner.Text() + "\n")
+		}
+	}()
+	// the pipes must be drained before Wait closes them
+	wg.Wait()
+
+	if runErr := cmd.Wait(); runErr != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("ffmpeg interrupted: %w", context.Cause(ctx))
+		}
+		return newError(args, runErr, stderr.String())
 	}
 
-	env := os.Environ()
+	return nil
+}
 
-	slog.Debug("running ffmpeg command", slog.String("args", strings.Join(args, " ")))
+// Sequence runs the ffmpeg commands one after another, each given as the args of Ffmpeg, stopping at the first that
+// fails. Later commands can rely on the outputs of earlier ones.
+func Sequence(ctx context.Context, commands ...[]string) error {
+	for index, args := range commands {
+		if err := Ffmpeg(ctx, args...); err != nil {
+			return fmt.Errorf("step %d of %d: %w", index+1, len(commands), err)
+		}
+	}
+	return nil
+}
+
+// Progress is a progress report ffmpeg writes with -progress while running a command.
+type Progress struct {
+	Frame int64
+	// OutTime is how far into the output ffmpeg got.
+	OutTime   time.Duration
+	TotalSize int64
+	// Speed is how many times faster than real time ffmpeg processes the input.
+	Speed float64
+	// Done is set on the last report, once ffmpeg finished.
+	Done bool
+}
 
-	return syscall.Exec(ffmpeg, args, env)
+// readProgress parses the blocks of key=value lines ffmpeg writes with -progress, each ended by a progress=continue or
+// progress=end line, reporting every block to onProgress when set.
+func readProgress(r io.Reader, args []string, onProgress func(args []string, progress Progress)) {
+	var progress Progress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "frame":
+			progress.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "out_time_us":
+			if microseconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+				progress.OutTime = time.Duration(microseconds) * time.Microsecond
+			}
+		case "total_size":
+			progress.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+		case "speed":
+			progress.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64)
+		case "progress":
+			progress.Done = value == "end"
+			slog.Debug("ffmpeg progress", slog.Duration("time", progress.OutTime), slog.Int64("frame", progress.Frame), slog.Float64("speed", progress.Speed), slog.Bool("done", progress.Done))
+			if onProgress != nil {
+				onProgress(args, progress)
+			}
+		}
+	}
+}
+
+// checkInput verifies an input file exists, unless this is a dry run where it may be the output of a command that was only printed.
+func checkInput(ctx context.Context, input string) error {
+	if DryRun(ctx) {
+		return nil
+	}
+	_, err := os.Stat(input)
+	return err
+}
+
+// shellJoin quotes args so the printed command can be pasted into a POSIX shell.
+func shellJoin(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@%+,") == "" {
+			quoted = append(quoted, arg)
+			continue
+		}
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+	return strings.Join(quoted, " ")
 }
@@ -0,0 +1,49 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+)
+
+// Track is an extra input muxed into an output by MuxTracks, such as an alternative audio rendition or subtitles.
+type Track struct {
+	// Input is usually a local playlist, read through the hls demuxer.
+	Input string
+	// Subtitles tracks are converted to mov_text, the subtitle format MP4 supports, the audio of other tracks is copied.
+	Subtitles bool
+	// Language is the BCP 47 tag of the track, e.g. en-US.
+	Language string
+}
+
+// MuxTracks copies input to output without re-encoding, adding the window of duration seconds from start of every track.
+// The video of input comes first, followed by the tracks in order and then any audio and subtitles input already had.
+func MuxTracks(ctx context.Context, input string, output string, start float64, duration float64, tracks []Track) error {
+	if err := checkInput(ctx, input); err != nil {
+		return err
+	}
+
+	args := []string{"-i", input}
+	for _, track := range tracks {
+		// segments are saved under names the hls demuxer does not allow by default, such as .vtt
+		args = append(args, "-allowed_extensions", "ALL", "-ss", formatSeconds(start), "-t", formatSeconds(duration), "-i", track.Input)
+	}
+
+	args = append(args, "-map", "0:v?")
+	audio, subtitles := 0, 0
+	for index, track := range tracks {
+		stream := fmt.Sprintf("a:%d", audio)
+		if track.Subtitles {
+			stream = fmt.Sprintf("s:%d", subtitles)
+			subtitles++
+		} else {
+			audio++
+		}
+		args = append(args, "-map", fmt.Sprintf("%d:%s", index+1, stream[:1]))
+		if track.Language != "" {
+			args = append(args, fmt.Sprintf("-metadata:s:%s", stream), "language="+track.Language)
+		}
+	}
+	args = append(args, "-map", "0:a?", "-map", "0:s?", "-c", "copy", "-c:s", "mov_text", output)
+
+	return Ffmpeg(ctx, args...)
+}
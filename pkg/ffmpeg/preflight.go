@@ -0,0 +1,173 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// ErrMissingFeature is returned by Preflight when the installed ffmpeg lacks something a flow needs.
+var ErrMissingFeature = errors.New("ffmpeg feature not available")
+
+// Requirements lists what a flow needs from the installed ffmpeg, so it can fail before downloading rather than after.
+type Requirements struct {
+	Encoders []string
+	Muxers   []string
+	// Ffprobe is set when the flow also probes its outputs.
+	Ffprobe bool
+}
+
+// TransmuxRequirements is what TransmuxMpegTsBlob needs: it copies the audio but lets the mp4 muxer encode the video
+// with its default encoder.
+var TransmuxRequirements = Requirements{Encoders: []string{"libx264", "aac"}, Muxers: []string{"mp4"}}
+
+// Merge returns the union of both requirements.
+func (requirements Requirements) Merge(other Requirements) Requirements {
+	merged := Requirements{Ffprobe: requirements.Ffprobe || other.Ffprobe}
+	merged.Encoders = union(requirements.Encoders, other.Encoders)
+	merged.Muxers = union(requirements.Muxers, other.Muxers)
+	return merged
+}
+
+// IsZero reports whether nothing is required from ffmpeg at all.
+func (requirements Requirements) IsZero() bool {
+	return len(requirements.Encoders) == 0 && len(requirements.Muxers) == 0 && !requirements.Ffprobe
+}
+
+func union(a []string, b []string) []string {
+	merged := slices.Clone(a)
+	for _, value := range b {
+		if !slices.Contains(merged, value) {
+			merged = append(merged, value)
+		}
+	}
+	return merged
+}
+
+// Capabilities is what the installed ffmpeg reports it supports.
+type Capabilities struct {
+	Version  string
+	Encoders map[string]bool
+	Muxers   map[string]bool
+	Ffprobe  bool
+}
+
+// Probe queries the installed ffmpeg for its version, encoders and muxers.
+func Probe(ctx context.Context) (*Capabilities, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities := &Capabilities{}
+	_, err = exec.LookPath("ffprobe")
+	capabilities.Ffprobe = err == nil
+
+	out, err := exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-version").Output()
+	if err != nil {
+		return nil, err
+	}
+	capabilities.Version, _, _ = strings.Cut(string(out), "\n")
+
+	if out, err = exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-encoders").Output(); err != nil {
+		return nil, err
+	}
+	capabilities.Encoders = parseListing(string(out))
+
+	if out, err = exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-muxers").Output(); err != nil {
+		return nil, err
+	}
+	capabilities.Muxers = parseListing(string(out))
+
+	return capabilities, nil
+}
+
+// parseListing collects the names listed by ffmpeg -encoders or -muxers, which follow a legend ending in a line of dashes
+// as the second column after the capability flags, e.g. " V....D libx264  libx264 H.264 / AVC".
+func parseListing(out string) map[string]bool {
+	names := make(map[string]bool)
+	listing := false
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if !listing {
+			listing = len(fields) == 1 && strings.Trim(fields[0], "-") == ""
+			continue
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		for _, name := range strings.Split(fields[1], ",") {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// Missing returns the requirements the capabilities do not satisfy.
+func (capabilities Capabilities) Missing(requirements Requirements) Requirements {
+	var missing Requirements
+	for _, encoder := range requirements.Encoders {
+		if !capabilities.Encoders[encoder] {
+			missing.Encoders = append(missing.Encoders, encoder)
+		}
+	}
+	for _, muxer := range requirements.Muxers {
+		if !capabilities.Muxers[muxer] {
+			missing.Muxers = append(missing.Muxers, muxer)
+		}
+	}
+	missing.Ffprobe = requirements.Ffprobe && !capabilities.Ffprobe
+	return missing
+}
+
+// PreflightError lists what the installed ffmpeg lacks, along with guidance on fixing it.
+type PreflightError struct {
+	// Missing is nil when ffmpeg itself is not installed.
+	Missing *Requirements
+	Err     error
+}
+
+func (preflightError *PreflightError) Error() string {
+	var problems []string
+	if preflightError.Missing == nil {
+		problems = append(problems, fmt.Sprintf("ffmpeg is not installed or not on the PATH (%s)", preflightError.Err))
+	} else {
+		if len(preflightError.Missing.Encoders) > 0 {
+			problems = append(problems, "missing encoders: "+strings.Join(preflightError.Missing.Encoders, ", "))
+		}
+		if len(preflightError.Missing.Muxers) > 0 {
+			problems = append(problems, "missing muxers: "+strings.Join(preflightError.Missing.Muxers, ", "))
+		}
+		if preflightError.Missing.Ffprobe {
+			problems = append(problems, "ffprobe is not installed or not on the PATH")
+		}
+	}
+	return fmt.Sprintf("%s: %s\ninstall a full ffmpeg build, e.g. `apt install ffmpeg`, `brew install ffmpeg` or a static build from https://ffmpeg.org/download.html", ErrMissingFeature, strings.Join(problems, "; "))
+}
+
+func (preflightError *PreflightError) Unwrap() error {
+	return ErrMissingFeature
+}
+
+// Preflight verifies the installed ffmpeg satisfies requirements, returning a *PreflightError describing what is missing.
+// Nothing is checked when nothing is required or under DryRun, where no command runs.
+func Preflight(ctx context.Context, requirements Requirements) error {
+	if requirements.IsZero() || DryRun(ctx) {
+		return nil
+	}
+
+	capabilities, err := Probe(ctx)
+	if err != nil {
+		return &PreflightError{Err: err}
+	}
+	slog.Debug("ffmpeg capabilities", slog.String("version", capabilities.Version), slog.Int("encoders", len(capabilities.Encoders)), slog.Int("muxers", len(capabilities.Muxers)), slog.Bool("ffprobe", capabilities.Ffprobe))
+
+	if missing := capabilities.Missing(requirements); !missing.IsZero() {
+		return &PreflightError{Missing: &missing}
+	}
+	return nil
+}
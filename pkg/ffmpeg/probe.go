@@ -0,0 +1,133 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func Ffprobe(ctx context.Context, args ...string) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no args provided")
+	}
+
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Debug("running ffprobe command", slog.String("args", strings.Join(args, " ")))
+
+	return exec.CommandContext(ctx, ffprobe, args...).Output()
+}
+
+// Keyframes returns the presentation timestamps, in seconds, of every key frame in the first video stream of input.
+func Keyframes(ctx context.Context, input string) ([]float64, error) {
+	if DryRun(ctx) {
+		if _, err := os.Stat(input); err != nil {
+			// the input is the output of a printed command, so there is nothing to probe
+			return nil, nil
+		}
+	}
+
+	out, err := Ffprobe(ctx, "-v", "error", "-select_streams", "v:0", "-skip_frame", "nokey", "-show_entries", "frame=pts_time", "-of", "csv=p=0", input)
+	if err != nil {
+		return nil, err
+	}
+
+	keyframes := make([]float64, 0)
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSuffix(strings.TrimSpace(line), ",")
+		if line == "" {
+			continue
+		}
+		pts, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			continue
+		}
+		keyframes = append(keyframes, pts)
+	}
+	sort.Float64s(keyframes)
+
+	return keyframes, nil
+}
+
+// Packet is a packet of input as listed by Packets.
+type Packet struct {
+	Video    bool
+	Time     float64
+	Size     int64
+	Keyframe bool
+}
+
+// Packets lists the packets of every stream of input in the order they are stored.
+func Packets(ctx context.Context, input string) ([]Packet, error) {
+	if DryRun(ctx) {
+		if _, err := os.Stat(input); err != nil {
+			// the input is the output of a printed command, so there is nothing to probe
+			return nil, nil
+		}
+	}
+
+	// the fields are printed in the order ffprobe defines them, not the order they are asked for
+	out, err := Ffprobe(ctx, "-v", "error", "-show_entries", "packet=codec_type,pts_time,size,flags", "-of", "csv=p=0", input)
+	if err != nil {
+		return nil, err
+	}
+
+	packets := make([]Packet, 0)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) < 4 {
+			continue
+		}
+		pts, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		packets = append(packets, Packet{Video: fields[0] == "video", Time: pts, Size: size, Keyframe: strings.HasPrefix(fields[3], "K")})
+	}
+
+	return packets, nil
+}
+
+// SnapToKeyframe returns the latest key frame at or before t, or t itself when there is none.
+func SnapToKeyframe(keyframes []float64, t float64) float64 {
+	snapped := t
+	for _, keyframe := range keyframes {
+		if keyframe > t {
+			break
+		}
+		snapped = keyframe
+	}
+	return snapped
+}
+
+// Duration returns the duration in seconds of input as reported by its container.
+func Duration(ctx context.Context, input string) (float64, error) {
+	out, err := Ffprobe(ctx, "-v", "error", "-show_entries", "format=duration", "-of", "csv=p=0", input)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+}
+
+// StartTime returns the time in seconds input starts at as reported by its container, which key frame timestamps are relative to.
+func StartTime(ctx context.Context, input string) (float64, error) {
+	out, err := Ffprobe(ctx, "-v", "error", "-show_entries", "format=start_time", "-of", "csv=p=0", input)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+}
@@ -0,0 +1,77 @@
+package ffmpeg
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// KillGrace is how long ffmpeg gets to finish the outputs it is writing once its command is cancelled, before it is
+// killed with its process group.
+var KillGrace = 5 * time.Second
+
+// running is the process groups of the ffmpeg commands still running, see KillAll.
+var running = struct {
+	mu        sync.Mutex
+	processes map[*os.Process]bool
+}{processes: make(map[*os.Process]bool)}
+
+// startGroup starts cmd in a process group of its own, so an interrupt of the terminal reaches manifestr alone, which
+// passes it on as it cancels ctx: ffmpeg is interrupted, letting it finish its outputs, and killed with its group if it
+// is still running after KillGrace. The returned function must be called once cmd was waited for.
+func startGroup(ctx context.Context, cmd *exec.Cmd) (done func(), err error) {
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	process := cmd.Process
+
+	running.mu.Lock()
+	running.processes[process] = true
+	running.mu.Unlock()
+
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-exited:
+			return
+		case <-ctx.Done():
+		}
+		slog.Debug("interrupting ffmpeg", slog.Int("pid", process.Pid))
+		if err := interruptGroup(process); err != nil {
+			slog.Debug("failed to interrupt ffmpeg", slog.Int("pid", process.Pid), slog.String("error", err.Error()))
+		}
+		select {
+		case <-exited:
+		case <-time.After(KillGrace):
+			// killing the whole group also closes the pipes that processes started by ffmpeg may hold open
+			slog.Warn("killing ffmpeg, which did not exit after being interrupted", slog.Int("pid", process.Pid), slog.Duration("grace", KillGrace))
+			_ = killGroup(process)
+		}
+	}()
+
+	return func() {
+		close(exited)
+		running.mu.Lock()
+		delete(running.processes, process)
+		running.mu.Unlock()
+		// processes ffmpeg started and left behind when it was cancelled are reaped with its group
+		if ctx.Err() != nil {
+			_ = killGroup(process)
+		}
+	}, nil
+}
+
+// KillAll kills the ffmpeg commands still running with their process groups, which would otherwise keep encoding in the
+// background once manifestr exits without cancelling them, such as on a panic or a second interrupt.
+func KillAll() {
+	running.mu.Lock()
+	defer running.mu.Unlock()
+	for process := range running.processes {
+		_ = killGroup(process)
+		delete(running.processes, process)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package ffmpeg
+
+import (
+	"os"
+	"os/exec"
+)
+
+// process groups are only managed on Unix, elsewhere ffmpeg is killed on its own.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func interruptGroup(process *os.Process) error {
+	return process.Kill()
+}
+
+func killGroup(process *os.Process) error {
+	return process.Kill()
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package ffmpeg
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// interruptGroup sends SIGINT to the process group led by process, on which ffmpeg finishes its outputs and exits.
+func interruptGroup(process *os.Process) error {
+	return syscall.Kill(-process.Pid, syscall.SIGINT)
+}
+
+func killGroup(process *os.Process) error {
+	return syscall.Kill(-process.Pid, syscall.SIGKILL)
+}
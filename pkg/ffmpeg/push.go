@@ -0,0 +1,46 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// pushFormats are the muxers a stream is pushed to an ingest point in, by the scheme of its url.
+var pushFormats = map[string]string{
+	"rtmp":  "flv",
+	"rtmps": "flv",
+	"srt":   "mpegts",
+}
+
+// PushRequirements returns what Push needs to send a stream to target.
+func PushRequirements(target string) (Requirements, error) {
+	format, err := pushFormat(target)
+	if err != nil {
+		return Requirements{}, err
+	}
+	return Requirements{Muxers: []string{format}}, nil
+}
+
+// Push copies the video and audio of the HLS stream at input to target, an RTMP or SRT ingest point such as
+// rtmp://host/app/key or srt://host:port, without re-encoding, until input ends or ctx is done.
+func Push(ctx context.Context, input string, target string) error {
+	format, err := pushFormat(target)
+	if err != nil {
+		return err
+	}
+	// timed metadata and subtitles have no place in FLV
+	return Ffmpeg(ctx, "-re", "-i", input, "-map", "0:v?", "-map", "0:a?", "-c", "copy", "-f", format, target)
+}
+
+func pushFormat(target string) (string, error) {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	format, ok := pushFormats[parsed.Scheme]
+	if !ok {
+		return "", fmt.Errorf("cannot push to %s://, only to rtmp://, rtmps:// or srt://", parsed.Scheme)
+	}
+	return format, nil
+}
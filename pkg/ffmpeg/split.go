@@ -0,0 +1,35 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SplitMp4 copies input without re-encoding into consecutive MP4s named by the printf pattern outputPattern, numbered
+// from 1. A part starts at the first key frame after every duration seconds when duration is non-zero, otherwise at the
+// first key frame at or after each of times. Every part starts at timestamp 0.
+func SplitMp4(ctx context.Context, input string, outputPattern string, duration float64, times []float64) error {
+	if err := checkInput(ctx, input); err != nil {
+		return err
+	}
+
+	if duration <= 0 && len(times) == 0 {
+		// the segment muxer would cut every 2 seconds
+		return Ffmpeg(ctx, "-i", input, "-map", "0", "-c", "copy", fmt.Sprintf(outputPattern, 1))
+	}
+
+	args := []string{"-i", input, "-map", "0", "-c", "copy", "-f", "segment", "-segment_format", "mp4", "-segment_start_number", "1", "-reset_timestamps", "1"}
+	if duration > 0 {
+		args = append(args, "-segment_time", formatSeconds(duration))
+	} else {
+		// key frame timestamps are matched exactly, rounding could move a cut to the next one
+		list := make([]string, 0, len(times))
+		for _, t := range times {
+			list = append(list, strconv.FormatFloat(t, 'f', -1, 64))
+		}
+		args = append(args, "-segment_times", strings.Join(list, ","))
+	}
+	return Ffmpeg(ctx, append(args, outputPattern)...)
+}
@@ -0,0 +1,55 @@
+package ffmpeg
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// AvOffset returns how many seconds the first audio sample of input starts after its first video frame, negative when audio leads.
+// ok is false when input lacks an audio or video stream, or when under DryRun the input was never produced.
+func AvOffset(ctx context.Context, input string) (offset float64, ok bool, err error) {
+	if DryRun(ctx) {
+		if _, err := os.Stat(input); err != nil {
+			return 0, false, nil
+		}
+	}
+
+	out, err := Ffprobe(ctx, "-v", "error", "-show_entries", "stream=codec_type,start_time", "-of", "csv=p=0", input)
+	if err != nil {
+		return 0, false, err
+	}
+
+	starts := make(map[string]float64)
+	for _, line := range strings.Split(string(out), "\n") {
+		codecType, startTime, found := strings.Cut(strings.TrimSpace(line), ",")
+		if !found {
+			continue
+		}
+		start, err := strconv.ParseFloat(strings.TrimSuffix(startTime, ","), 64)
+		if err != nil {
+			continue
+		}
+		// keep the first stream of each type, which is what players sync against by default
+		if _, seen := starts[codecType]; !seen {
+			starts[codecType] = start
+		}
+	}
+
+	video, hasVideo := starts["video"]
+	audio, hasAudio := starts["audio"]
+	if !hasVideo || !hasAudio {
+		return 0, false, nil
+	}
+	return audio - video, true, nil
+}
+
+// ShiftAudio copies input to output without re-encoding, moving its audio earlier by offset seconds (later when negative) with -itsoffset.
+func ShiftAudio(ctx context.Context, input string, output string, offset float64) error {
+	if err := checkInput(ctx, input); err != nil {
+		return err
+	}
+
+	return Ffmpeg(ctx, "-i", input, "-itsoffset", formatSeconds(-offset), "-i", input, "-map", "0:v", "-map", "1:a", "-c", "copy", output)
+}
@@ -1,11 +1,13 @@
 package ffmpeg
 
-import "os"
+import (
+	"context"
+)
 
-func TransmuxMpegTsBlob(input string, output string) error {
-	if _, err := os.Stat(input); err != nil {
+func TransmuxMpegTsBlob(ctx context.Context, input string, output string) error {
+	if err := checkInput(ctx, input); err != nil {
 		return err
 	}
 
-	return Ffmpeg("-i", input, "-acodec", "copy", output)
+	return Ffmpeg(ctx, "-i", input, "-acodec", "copy", output)
 }
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"time"
+)
+
+// forEachEntry calls fn for every fragment with its media sequence number and the program date time at which it starts, its own
+// #EXT-X-PROGRAM-DATE-TIME or carried forward from the last fragment tagged with one.
+// The start time is zero when the manifest does not report program date times.
+func (manifest Manifest) forEachEntry(fn func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry)) {
+	sequence := manifest.MediaSequence
+	var clock time.Time
+	for index, discontinuity := range manifest.Discontinuities {
+		if !discontinuity.ProgramDateTime.IsZero() {
+			clock = discontinuity.ProgramDateTime
+		}
+
+		for _, entry := range discontinuity.Entries {
+			if !entry.ProgramDateTime.IsZero() {
+				clock = entry.ProgramDateTime
+			}
+			fn(index, sequence, clock, entry)
+
+			sequence++
+			if !clock.IsZero() {
+				clock = clock.Add(time.Duration(entry.Duration * float64(time.Second)))
+			}
+		}
+	}
+}
+
+// filterEntries keeps only the fragments for which keep returns true, updating the media sequence to the first fragment kept.
+// Discontinuities left without fragments are dropped, and the program date time of a discontinuity is moved forward to its first remaining fragment.
+func (manifest *Manifest) filterEntries(keep func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) bool) {
+	filtered := make([]Discontinuity, len(manifest.Discontinuities))
+	firstSequence := -1
+
+	manifest.forEachEntry(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) {
+		if !keep(discontinuityIndex, sequence, start, entry) {
+			return
+		}
+
+		kept := &filtered[discontinuityIndex]
+		if len(kept.Entries) == 0 {
+			*kept = manifest.Discontinuities[discontinuityIndex]
+			kept.Entries = nil
+			if !start.IsZero() {
+				kept.ProgramDateTime = start
+			}
+		}
+		kept.Entries = append(kept.Entries, entry)
+
+		if firstSequence < 0 {
+			firstSequence = sequence
+		}
+	})
+
+	nonEmpty := make([]Discontinuity, 0, len(filtered))
+	for _, discontinuity := range filtered {
+		if len(discontinuity.Entries) > 0 {
+			nonEmpty = append(nonEmpty, discontinuity)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		nonEmpty = append(nonEmpty, Discontinuity{})
+	}
+
+	if firstSequence >= 0 {
+		manifest.MediaSequence = firstSequence
+	}
+	manifest.Discontinuities = nonEmpty
+}
+
+// timeRange returns the program date time of the first and last fragment, or zero times when the manifest does not report them.
+func (manifest Manifest) timeRange() (first time.Time, last time.Time) {
+	manifest.forEachEntry(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) {
+		if first.IsZero() {
+			first = start
+		}
+		last = start
+	})
+	return
+}
+
+// AlignManifests trims the renditions of one stream to the window they all cover so that recordings of every variant start and end on the same fragment.
+// Renditions are aligned on #EXT-X-PROGRAM-DATE-TIME when all of them report it and on media sequence numbers otherwise.
+func AlignManifests(manifests ...*Manifest) {
+	if len(manifests) < 2 {
+		return
+	}
+
+	usePdt := true
+	var windowStart, windowEnd time.Time
+	tolerance := time.Duration(0)
+	for index, manifest := range manifests {
+		first, last := manifest.timeRange()
+		if first.IsZero() {
+			usePdt = false
+			break
+		}
+		if index == 0 || first.After(windowStart) {
+			windowStart = first
+		}
+		if index == 0 || last.Before(windowEnd) {
+			windowEnd = last
+		}
+
+		// fragments of different renditions rarely share exact timestamps, so allow half a target duration of drift
+		halfTarget := time.Duration(manifest.TargetDuration * float64(time.Second) / 2)
+		if index == 0 || halfTarget < tolerance {
+			tolerance = halfTarget
+		}
+	}
+
+	if usePdt {
+		for _, manifest := range manifests {
+			manifest.filterEntries(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) bool {
+				return !start.Before(windowStart.Add(-tolerance)) && !start.After(windowEnd.Add(tolerance))
+			})
+		}
+		return
+	}
+
+	firstSequence, lastSequence := manifests[0].MediaSequence, manifests[0].LastSequence()
+	for _, manifest := range manifests[1:] {
+		firstSequence = max(firstSequence, manifest.MediaSequence)
+		lastSequence = min(lastSequence, manifest.LastSequence())
+	}
+
+	for _, manifest := range manifests {
+		manifest.filterEntries(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) bool {
+			return sequence >= firstSequence && sequence <= lastSequence
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"slices"
+	"testing"
+)
+
+func entryUrls(manifest *Manifest) []string {
+	var urls []string
+	for _, discontinuity := range manifest.Discontinuities {
+		for _, entry := range discontinuity.Entries {
+			urls = append(urls, entry.Url)
+		}
+	}
+	return urls
+}
+
+func TestAlignManifestsPerSegmentProgramDateTime(t *testing.T) {
+	// the variants are tagged on every segment and overlap from 10:00:10 to 10:00:30
+	video := readTestManifest(t, datedPlaylist)
+	audio := readTestManifest(t, `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-MEDIA-SEQUENCE:7
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T10:00:10.000Z
+#EXTINF:10.0,
+a1.aac
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T10:00:20.000Z
+#EXTINF:10.0,
+a2.aac
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T10:00:30.000Z
+#EXTINF:10.0,
+a3.aac
+#EXT-X-ENDLIST
+`)
+
+	AlignManifests(video, audio)
+
+	if got, want := entryUrls(video), []string{"s1.ts", "s2.ts"}; !slices.Equal(got, want) {
+		t.Errorf("video kept %v, want %v", got, want)
+	}
+	if got, want := entryUrls(audio), []string{"a1.aac", "a2.aac"}; !slices.Equal(got, want) {
+		t.Errorf("audio kept %v, want %v", got, want)
+	}
+	if video.MediaSequence != 1 || audio.MediaSequence != 7 {
+		t.Errorf("media sequences %d and %d, want 1 and 7", video.MediaSequence, audio.MediaSequence)
+	}
+	if !video.Discontinuities[0].ProgramDateTime.Equal(audio.Discontinuities[0].ProgramDateTime) {
+		t.Errorf("video starts at %s, audio at %s", video.Discontinuities[0].ProgramDateTime, audio.Discontinuities[0].ProgramDateTime)
+	}
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// AssetsDir is the subfolder of the download directory external assets are saved to.
+const AssetsDir = "assets"
+
+const (
+	TagSessionData    string = "#EXT-X-SESSION-DATA:"
+	TagDateRange      string = "#EXT-X-DATERANGE:"
+	TagImageStreamInf string = "#EXT-X-IMAGE-STREAM-INF:"
+)
+
+// Asset is an auxiliary resource referenced by the playlist rather than a media fragment,
+// such as the JSON of an #EXT-X-SESSION-DATA, an asset uri of an #EXT-X-DATERANGE or an image (thumbnail) playlist.
+type Asset struct {
+	// Tag is the name of the tag referencing the asset, e.g. EXT-X-SESSION-DATA.
+	Tag string
+	// Attribute is the attribute of the tag holding Uri, e.g. URI or X-ASSET-URI.
+	Attribute string
+	Uri       string
+	Line      int
+}
+
+// FileName is the name the asset is saved to inside AssetsDir.
+func (asset Asset) FileName() string {
+	return path.Base(uriPath(asset.Uri))
+}
+
+// parseAssets returns the uri-bearing attributes of a tag that can reference external assets, or nil for any other line.
+func parseAssets(line string, lineNumber int) (assets []Asset) {
+	var attributes map[string]string
+	switch {
+	case strings.HasPrefix(line, TagSessionData):
+		attributes = ParseAttributes(strings.TrimPrefix(line, TagSessionData))
+	case strings.HasPrefix(line, TagDateRange):
+		attributes = ParseAttributes(strings.TrimPrefix(line, TagDateRange))
+	case strings.HasPrefix(line, TagImageStreamInf):
+		attributes = ParseAttributes(strings.TrimPrefix(line, TagImageStreamInf))
+	default:
+		return nil
+	}
+
+	// DATERANGE carries asset uris in client defined attributes such as X-ASSET-URI and X-ASSET-LIST
+	names := make([]string, 0, len(attributes))
+	for name, value := range attributes {
+		if value == "" {
+			continue
+		}
+		if name == "URI" || (strings.HasPrefix(name, "X-") && (strings.HasSuffix(name, "-URI") || strings.HasSuffix(name, "-LIST"))) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		assets = append(assets, Asset{Tag: tagName(line), Attribute: name, Uri: attributes[name], Line: lineNumber})
+	}
+	return assets
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Attribute is a single key/value pair of an HLS attribute-list.
+type Attribute struct {
+	Key    string
+	Value  string
+	Quoted bool
+}
+
+func (attribute Attribute) String() string {
+	if attribute.Quoted {
+		return attribute.Key + `="` + attribute.Value + `"`
+	}
+	return attribute.Key + "=" + attribute.Value
+}
+
+// Attributes is an ordered HLS attribute-list.
+type Attributes []Attribute
+
+func (attributes Attributes) String() string {
+	values := make([]string, 0, len(attributes))
+	for _, attribute := range attributes {
+		values = append(values, attribute.String())
+	}
+	return strings.Join(values, ",")
+}
+
+// Sorted returns a copy of the attribute-list in canonical (alphabetical) key order.
+func (attributes Attributes) Sorted() Attributes {
+	sorted := append(Attributes{}, attributes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
+
+// ParseAttributeList splits an HLS attribute-list (e.g. `TYPE=PART,URI="part.mp4"`) into its ordered attributes.
+func ParseAttributeList(list string) Attributes {
+	attributes := make(Attributes, 0)
+
+	for len(list) > 0 {
+		key, rest, found := strings.Cut(list, "=")
+		if !found {
+			break
+		}
+
+		attribute := Attribute{Key: strings.TrimSpace(key)}
+		if strings.HasPrefix(rest, `"`) {
+			attribute.Quoted = true
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				attribute.Value, rest = rest[1:], ""
+			} else {
+				attribute.Value, rest = rest[1:end+1], rest[end+2:]
+			}
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			attribute.Value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		attributes = append(attributes, attribute)
+		list = rest
+	}
+
+	return attributes
+}
+
+// MaxAttributes is the largest attribute-list of a tag the parsers accept; real-world tags carry a few dozen at most.
+const MaxAttributes = 1024
+
+// checkAttributeCount returns ErrTooManyAttributes when the attribute-list of the tag on line exceeds MaxAttributes.
+func checkAttributeCount(line string) error {
+	// every attribute has an equal sign, so lines with fewer are not parsed at all
+	if strings.Count(line, "=") <= MaxAttributes {
+		return nil
+	}
+	_, list, _ := strings.Cut(line, ":")
+	if len(ParseAttributeList(list)) > MaxAttributes {
+		return ErrTooManyAttributes
+	}
+	return nil
+}
+
+// optionalFloat parses the decimal value of the optional attribute name, which is 0 when it is absent.
+func optionalFloat(name string, value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, errors.Unwrap(err))
+	}
+	return number, nil
+}
+
+// optionalInt parses the integer value of the optional attribute name, which is 0 when it is absent.
+func optionalInt(name string, value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, errors.Unwrap(err))
+	}
+	return number, nil
+}
+
+// ParseAttributes splits an HLS attribute-list (e.g. `TYPE=PART,URI="part.mp4"`) into its keys and unquoted values.
+func ParseAttributes(list string) map[string]string {
+	values := make(map[string]string)
+	for _, attribute := range ParseAttributeList(list) {
+		values[attribute.Key] = attribute.Value
+	}
+	return values
+}
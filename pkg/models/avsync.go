@@ -0,0 +1,57 @@
+package models
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+)
+
+const (
+	AvSyncOff     = "off"
+	AvSyncReport  = "report"
+	AvSyncCorrect = "correct"
+)
+
+// DefaultAvSyncTolerance is the audio/video start offset, in seconds, below which outputs are considered in sync.
+const DefaultAvSyncTolerance = 0.010
+
+// CheckAvSync measures the offset between the first audio and video timestamps of every file, as stitched content
+// commonly picks up a constant offset at discontinuities, considering files within tolerance seconds in sync. With
+// correct set, files out of sync are rewritten in place with the audio shifted back into line.
+func CheckAvSync(ctx context.Context, files []string, correct bool, tolerance float64) error {
+	for _, file := range files {
+		offset, ok, err := ffmpeg.AvOffset(ctx, file)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if math.Abs(offset) < tolerance {
+			slog.Info("audio and video in sync", slog.String("file", file), slog.Float64("offset", offset))
+			continue
+		}
+
+		slog.Warn("audio and video out of sync", slog.String("file", file), slog.Float64("offset", offset))
+		if !correct {
+			continue
+		}
+
+		synced := strings.TrimSuffix(file, ".mp4") + ".sync.mp4"
+		if err := ffmpeg.ShiftAudio(ctx, file, synced, offset); err != nil {
+			return err
+		}
+		if !ffmpeg.DryRun(ctx) {
+			if err := os.Rename(synced, file); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const TagByteRange string = "#EXT-X-BYTERANGE:"
+
+var ErrInvalidByteRange = errors.New("invalid byte range")
+
+// ByteRange is the sub-range of a resource a segment or init file occupies, as given by #EXT-X-BYTERANGE or the
+// BYTERANGE attribute of #EXT-X-MAP.
+type ByteRange struct {
+	Length int64
+	Offset int64
+}
+
+// parseByteRange parses a byte range of the form <length>[@<offset>]. Without an offset the range starts where previous,
+// the range of the preceding segment, ended, which must be a sub-range of the same resource.
+func parseByteRange(value string, previous *ByteRange) (*ByteRange, error) {
+	lengthValue, offsetValue, hasOffset := strings.Cut(strings.TrimSpace(value), "@")
+
+	byteRange := new(ByteRange)
+	var err error
+	if byteRange.Length, err = strconv.ParseInt(lengthValue, 10, 64); err != nil || byteRange.Length <= 0 {
+		return nil, fmt.Errorf("%w: length %q", ErrInvalidByteRange, lengthValue)
+	}
+
+	switch {
+	case hasOffset:
+		if byteRange.Offset, err = strconv.ParseInt(offsetValue, 10, 64); err != nil || byteRange.Offset < 0 {
+			return nil, fmt.Errorf("%w: offset %q", ErrInvalidByteRange, offsetValue)
+		}
+		if byteRange.Offset > math.MaxInt64-byteRange.Length {
+			return nil, fmt.Errorf("%w: %q ends past the largest offset", ErrInvalidByteRange, value)
+		}
+	case previous != nil:
+		byteRange.Offset = previous.End()
+		if byteRange.Offset > math.MaxInt64-byteRange.Length {
+			return nil, fmt.Errorf("%w: %q ends past the largest offset", ErrInvalidByteRange, value)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q has no offset and does not follow a sub-range of the same resource", ErrInvalidByteRange, value)
+	}
+
+	return byteRange, nil
+}
+
+// End is the offset of the first byte after the range.
+func (byteRange ByteRange) End() int64 {
+	return byteRange.Offset + byteRange.Length
+}
+
+// String formats the range as <length>@<offset>, always with the explicit offset so it survives fragments being filtered out.
+func (byteRange ByteRange) String() string {
+	return fmt.Sprintf("%d@%d", byteRange.Length, byteRange.Offset)
+}
+
+// localName is the name a range of the resource at uri is saved under, distinct for every range of it, see localName.
+func (byteRange *ByteRange) localName(uri string) string {
+	if byteRange == nil {
+		return localName(uri)
+	}
+	return fmt.Sprintf("%s_%d-%d", localName(uri), byteRange.Offset, byteRange.End()-1)
+}
+
+// cacheKey identifies the range of the resource at url in the status cache and shared store, which would otherwise
+// mistake every range of it for the same file.
+func (byteRange *ByteRange) cacheKey(url string) string {
+	if byteRange == nil {
+		return url
+	}
+	return fmt.Sprintf("%s#bytes=%d-%d", url, byteRange.Offset, byteRange.End()-1)
+}
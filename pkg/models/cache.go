@@ -0,0 +1,168 @@
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/utils"
+)
+
+// ManifestCache reuses the playlists fetched and parsed by earlier commands from the user cache directory for Ttl
+// before fetching them again. A zero Ttl disables it.
+type ManifestCache struct {
+	Ttl time.Duration
+	// Downloader fetches the playlists, a zero utils.Downloader when nil.
+	Downloader *utils.Downloader
+}
+
+func (cache ManifestCache) downloader() *utils.Downloader {
+	if cache.Downloader == nil {
+		return &utils.Downloader{}
+	}
+	return cache.Downloader
+}
+
+// cachedManifest is a fetched playlist and the manifest parsed from it, stored on disk under a hash of its url.
+type cachedManifest struct {
+	Url      string
+	Sha256   string
+	Fetched  time.Time
+	Playlist []byte
+	Manifest *Manifest
+}
+
+// manifestCachePath is where the cache entry of manifestUrl is stored, inside the user cache directory.
+func manifestCachePath(manifestUrl string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(manifestUrl))
+	return path.Join(cacheDir, "manifestr", "manifests", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// loadCachedManifest returns the cache entry of manifestUrl, or nil when there is none.
+func loadCachedManifest(manifestUrl string) *cachedManifest {
+	cachePath, err := manifestCachePath(manifestUrl)
+	if err != nil {
+		return nil
+	}
+	b, err := os.ReadFile(cachePath)
+	if err != nil {
+		return nil
+	}
+
+	entry := new(cachedManifest)
+	if err := json.Unmarshal(b, entry); err != nil || entry.Url != manifestUrl || entry.Manifest == nil {
+		slog.Debug("ignoring invalid manifest cache entry", slog.String("url", manifestUrl))
+		return nil
+	}
+	return entry
+}
+
+func (entry *cachedManifest) store() error {
+	cachePath, err := manifestCachePath(entry.Url)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(cachePath), os.ModePerm); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cachePath, b, 0644)
+}
+
+// fetch returns the cache entry of manifestUrl, fetching the playlist again once the entry is older than Ttl. The
+// stored manifest is only parsed again when the SHA-256 of the playlist changed.
+func (cache ManifestCache) fetch(manifestUrl string) (*cachedManifest, error) {
+	entry := loadCachedManifest(manifestUrl)
+	if entry != nil && time.Since(entry.Fetched) < cache.Ttl {
+		slog.Debug("reusing cached manifest", slog.String("url", manifestUrl), slog.Time("fetched", entry.Fetched))
+		return entry, nil
+	}
+
+	in, err := cache.downloader().OpenUrl(manifestUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	playlist, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(playlist)
+	checksum := hex.EncodeToString(sum[:])
+
+	if entry == nil || entry.Sha256 != checksum {
+		manifest, err := ReadManifest(bytes.NewReader(playlist), manifestUrl)
+		if err != nil {
+			return nil, err
+		}
+		entry = &cachedManifest{Url: manifestUrl, Sha256: checksum, Playlist: playlist, Manifest: manifest}
+	}
+	entry.Fetched = time.Now()
+
+	if err := entry.store(); err != nil {
+		slog.Warn("failed to cache manifest", slog.String("url", manifestUrl), slog.String("error", err.Error()))
+	}
+	return entry, nil
+}
+
+// ReadManifest fetches and parses the manifest at manifestUrl like the ReadManifest function, reusing the manifest
+// parsed by an earlier command from the on-disk cache while it is younger than Ttl. Stdin and a zero Ttl always bypass
+// the cache.
+func (cache ManifestCache) ReadManifest(manifestUrl string) (*Manifest, error) {
+	if cache.Ttl <= 0 || manifestUrl == utils.StdinUrl {
+		in, err := cache.downloader().OpenUrl(manifestUrl)
+		if err != nil {
+			return nil, err
+		}
+		defer in.Close()
+		return ReadManifest(in, manifestUrl)
+	}
+
+	entry, err := cache.fetch(manifestUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := entry.Manifest
+	if manifest.BaseUrl, err = url.Parse(manifestUrl); err != nil {
+		return nil, fmt.Errorf("invalid manifest url: %w", err)
+	}
+	manifest.BaseUrl.Path = strings.TrimSuffix(manifest.BaseUrl.Path, path.Base(manifest.BaseUrl.Path))
+	return manifest, nil
+}
+
+// Playlist returns the raw playlist at manifestUrl from the on-disk cache, fetching it when the cache is disabled, missing or stale.
+func (cache ManifestCache) Playlist(manifestUrl string) ([]byte, error) {
+	if cache.Ttl <= 0 || manifestUrl == utils.StdinUrl {
+		in, err := cache.downloader().OpenUrl(manifestUrl)
+		if err != nil {
+			return nil, err
+		}
+		defer in.Close()
+		return io.ReadAll(in)
+	}
+
+	entry, err := cache.fetch(manifestUrl)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Playlist, nil
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path"
+)
+
+const (
+	MpdNamespace = "urn:mpeg:dash:schema:mpd:2011"
+	// MpdProfileFull is the profile of the local MPD, the only one allowing the SegmentList it lists the segments in.
+	MpdProfileFull = "urn:mpeg:dash:profile:full:2011"
+	// LocalMpdFileName is the DASH counterpart of local.manifest.m3u8, see WriteLocalMpd.
+	LocalMpdFileName = "local.mpd"
+	// mpdTimescale is the timescale of the segment timelines of the local MPD, in which segment durations are rounded to
+	// the millisecond.
+	mpdTimescale = 1000
+)
+
+// WriteLocalMpdToFile writes the local MPD into dir next to the local manifest, see WriteLocalMpd.
+func (manifest *Manifest) WriteLocalMpdToFile(dir string) error {
+	mpdFile, err := os.Create(path.Join(dir, LocalMpdFileName))
+	if err != nil {
+		return err
+	}
+	defer mpdFile.Close()
+
+	return manifest.WriteLocalMpd(mpdFile)
+}
+
+// WriteLocalMpd writes a DASH MPD pointing at the same downloaded init and media segments as the local manifest, so a
+// capture of CMAF segments can be served over both HLS and DASH. Every discontinuity becomes a period, holding the
+// variant and the fMP4 renditions, which are read from their subfolders. The manifest must be fragmented MP4.
+func (manifest *Manifest) WriteLocalMpd(w io.Writer) error {
+	if !manifest.IsFmp4() {
+		return errors.New("a DASH MPD can only be written for fMP4 segments")
+	}
+
+	mpd := Mpd{
+		XMLName:       xml.Name{Space: MpdNamespace, Local: "MPD"},
+		Profiles:      MpdProfileFull,
+		MinBufferTime: isoDuration(manifest.TargetDuration),
+		Type:          MpdTypeStatic,
+	}
+
+	total := 0.0
+	for index, discontinuity := range manifest.Discontinuities {
+		duration := discontinuity.Entries.Runtime()
+		total += duration
+
+		period := MpdPeriod{Id: fmt.Sprintf("d%04d", index), Duration: isoDuration(duration)}
+		period.AdaptationSets = append(period.AdaptationSets, localAdaptationSet("video", "", Media{}, manifest, discontinuity))
+		for _, rendition := range manifest.Renditions {
+			if !rendition.Manifest.IsFmp4() || index >= len(rendition.Manifest.Discontinuities) {
+				continue
+			}
+			contentType := "audio"
+			if rendition.Type == MediaTypeSubtitles {
+				contentType = "text"
+			}
+			period.AdaptationSets = append(period.AdaptationSets, localAdaptationSet(contentType, rendition.Dir, rendition.Media, rendition.Manifest, rendition.Manifest.Discontinuities[index]))
+		}
+		mpd.Periods = append(mpd.Periods, period)
+	}
+	mpd.MediaPresentationDuration = isoDuration(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(mpd); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// localAdaptationSet lists the downloaded segments of discontinuity, found in the subfolder dir, as an adaptation set with
+// a single representation.
+func localAdaptationSet(contentType string, dir string, media Media, manifest *Manifest, discontinuity Discontinuity) MpdAdaptationSet {
+	local := func(name string) string {
+		return path.Join(url.PathEscape(dir), url.PathEscape(name))
+	}
+
+	timescale := int64(mpdTimescale)
+	list := &MpdSegmentList{
+		Timescale:      &timescale,
+		Initialization: &MpdUrl{SourceUrl: local(manifest.InitFileName(discontinuity))},
+		Timeline:       &MpdSegmentTimeline{},
+	}
+	segments := &list.Timeline.Segments
+	for _, entry := range discontinuity.Entries {
+		list.SegmentUrls = append(list.SegmentUrls, MpdSegmentUrl{Media: local(manifest.LocalFilename(*entry, true))})
+
+		duration := int64(math.Round(entry.Duration * mpdTimescale))
+		if last := len(*segments) - 1; last >= 0 && (*segments)[last].D == duration {
+			(*segments)[last].R++
+		} else {
+			*segments = append(*segments, MpdTimelineSegment{D: duration})
+		}
+	}
+
+	id := contentType
+	if dir != "" {
+		id = dir
+	}
+	adaptationSet := MpdAdaptationSet{
+		ContentType: contentType,
+		MimeType:    contentType + "/mp4",
+		Lang:        media.Language,
+		Label:       media.Name,
+		Representations: []MpdRepresentation{{
+			Id:          id,
+			Bandwidth:   manifest.Bandwidth,
+			Width:       manifest.ResolutionWidth,
+			Height:      manifest.ResolutionHeight,
+			Codecs:      manifest.Codecs,
+			SegmentList: list,
+		}},
+	}
+	if contentType == "text" {
+		adaptationSet.MimeType = "application/mp4"
+	}
+	if media.Default {
+		adaptationSet.Roles = []MpdDescriptor{{SchemeIdUri: "urn:mpeg:dash:role:2011", Value: "main"}}
+	}
+	if media.Channels != "" {
+		adaptationSet.AudioChannels = []MpdDescriptor{{SchemeIdUri: "urn:mpeg:dash:23003:3:audio_channel_configuration:2011", Value: media.Channels}}
+	}
+	return adaptationSet
+}
+
+// isoDuration formats seconds as an xs:duration such as PT6.006S.
+func isoDuration(seconds float64) string {
+	return fmt.Sprintf("PT%.3fS", seconds)
+}
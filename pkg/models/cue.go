@@ -0,0 +1,108 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ad markers emitted by many server-side ad insertion vendors. They are not part of RFC 8216, which signals ad breaks
+// with #EXT-X-DATERANGE instead.
+const (
+	TagCueOut     string = "#EXT-X-CUE-OUT"
+	TagCueOutCont string = "#EXT-X-CUE-OUT-CONT"
+	TagCueIn      string = "#EXT-X-CUE-IN"
+)
+
+const EventAdBreak = "adbreak"
+
+// AdBreak is an ad break signalled by #EXT-X-CUE-OUT, spanning the fragments up to the next #EXT-X-CUE-IN.
+type AdBreak struct {
+	// Duration is the planned duration of the break announced by its markers in seconds, or 0 when they do not say.
+	Duration float64
+	// Elapsed is how far into the break the playlist starts, in seconds, when it opens with #EXT-X-CUE-OUT-CONT
+	// because the cue out already slid out of a live window.
+	Elapsed float64
+	// FirstSequence is the media sequence number of the first fragment of the break.
+	FirstSequence int
+	// LastSequence is the media sequence number of the last fragment of the break, or -1 while the break is still open at the end of the playlist.
+	LastSequence int
+	// Attributes holds the attributes of the cue out, such as SCTE35 or vendor specific ones.
+	Attributes map[string]string
+	Line       int
+}
+
+// Contains reports whether the fragment with the given media sequence number belongs to the break.
+func (adBreak AdBreak) Contains(sequence int) bool {
+	return sequence >= adBreak.FirstSequence && (adBreak.LastSequence < 0 || sequence <= adBreak.LastSequence)
+}
+
+// parseCueOut parses the value of #EXT-X-CUE-OUT, written either as a bare duration (#EXT-X-CUE-OUT:30) or as an
+// attribute list (#EXT-X-CUE-OUT:DURATION=30,...). A malformed duration is returned as an error along with the break.
+func parseCueOut(value string, firstSequence int, line int) (AdBreak, error) {
+	adBreak := AdBreak{FirstSequence: firstSequence, LastSequence: -1, Line: line}
+	if duration, err := strconv.ParseFloat(value, 64); err == nil {
+		adBreak.Duration = duration
+		return adBreak, nil
+	}
+
+	var err error
+	adBreak.Attributes = ParseAttributes(value)
+	adBreak.Duration, err = optionalFloat("DURATION", attributeFold(adBreak.Attributes, "DURATION"))
+	return adBreak, err
+}
+
+// parseCueOutCont parses the value of #EXT-X-CUE-OUT-CONT, written either as elapsed/duration (#EXT-X-CUE-OUT-CONT:10/30)
+// or as an attribute list (#EXT-X-CUE-OUT-CONT:ElapsedTime=10,Duration=30,...). Malformed times are returned as an
+// error along with the break.
+func parseCueOutCont(value string, firstSequence int, line int) (AdBreak, error) {
+	adBreak := AdBreak{FirstSequence: firstSequence, LastSequence: -1, Line: line}
+	elapsed, duration, found := strings.Cut(value, "/")
+	if !found {
+		adBreak.Attributes = ParseAttributes(value)
+		elapsed, duration = attributeFold(adBreak.Attributes, "ELAPSEDTIME"), attributeFold(adBreak.Attributes, "DURATION")
+	}
+
+	var elapsedErr, durationErr error
+	adBreak.Elapsed, elapsedErr = optionalFloat("elapsed time", elapsed)
+	adBreak.Duration, durationErr = optionalFloat("duration", duration)
+	return adBreak, errors.Join(elapsedErr, durationErr)
+}
+
+// attributeFold looks an attribute up ignoring case, as vendors disagree on the casing of these non-standard ones.
+func attributeFold(attributes map[string]string, name string) string {
+	for key, value := range attributes {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// InAdBreak reports whether the fragment with the given media sequence number belongs to one of the AdBreaks.
+func (manifest Manifest) InAdBreak(sequence int) bool {
+	for _, adBreak := range manifest.AdBreaks {
+		if adBreak.Contains(sequence) {
+			return true
+		}
+	}
+	return false
+}
+
+// StripAds removes every fragment inside the AdBreaks, returning how many were removed.
+func (manifest *Manifest) StripAds() (removed int) {
+	if len(manifest.AdBreaks) == 0 {
+		return 0
+	}
+
+	manifest.filterEntries(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) bool {
+		if manifest.InAdBreak(sequence) {
+			removed++
+			return false
+		}
+		return true
+	})
+	manifest.AdBreaks = nil
+	return removed
+}
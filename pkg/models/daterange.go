@@ -0,0 +1,110 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateRange represents an #EXT-X-DATERANGE, e.g. an ad break or program boundary signalled by the origin.
+type DateRange struct {
+	ID        string
+	Class     string
+	StartDate time.Time
+	// EndDate is zero when the range is open or only reports a duration.
+	EndDate time.Time
+	// Duration is DURATION, or PLANNED-DURATION when the actual duration is not yet known. Zero when neither is reported.
+	Duration float64
+	// Scte35Cmd, Scte35Out and Scte35In are the hex encoded SCTE-35 splice_info_section of SCTE35-CMD, SCTE35-OUT and
+	// SCTE35-IN, empty when the tag has none. A range carrying SCTE35-OUT starts an ad break that SCTE35-IN ends.
+	Scte35Cmd string
+	Scte35Out string
+	Scte35In  string
+	// Attributes holds every attribute of the tag, including client defined X- attributes such as X-ASSET-URI.
+	Attributes map[string]string
+	// AttributeList is the attribute list as written, which Tag reproduces.
+	AttributeList Attributes
+	Line          int
+}
+
+// Tag returns the #EXT-X-DATERANGE of the range, with its attributes as they were parsed.
+func (dateRange DateRange) Tag() string {
+	if len(dateRange.AttributeList) > 0 {
+		return TagDateRange + dateRange.AttributeList.String()
+	}
+
+	attributes := Attributes{{Key: "ID", Value: dateRange.ID, Quoted: true}}
+	if dateRange.Class != "" {
+		attributes = append(attributes, Attribute{Key: "CLASS", Value: dateRange.Class, Quoted: true})
+	}
+	attributes = append(attributes, Attribute{Key: "START-DATE", Value: dateRange.StartDate.Format(TimeFormat), Quoted: true})
+	if !dateRange.EndDate.IsZero() {
+		attributes = append(attributes, Attribute{Key: "END-DATE", Value: dateRange.EndDate.Format(TimeFormat), Quoted: true})
+	}
+	if dateRange.Duration > 0 {
+		attributes = append(attributes, Attribute{Key: "DURATION", Value: strconv.FormatFloat(dateRange.Duration, 'f', -1, 64)})
+	}
+	for _, attribute := range []Attribute{{Key: "SCTE35-CMD", Value: dateRange.Scte35Cmd}, {Key: "SCTE35-OUT", Value: dateRange.Scte35Out}, {Key: "SCTE35-IN", Value: dateRange.Scte35In}} {
+		if attribute.Value != "" {
+			attributes = append(attributes, attribute)
+		}
+	}
+	return TagDateRange + attributes.String()
+}
+
+// parseDateRange parses the attribute list of an #EXT-X-DATERANGE.
+func parseDateRange(attributeList string, lineNumber int) (dateRange DateRange, err error) {
+	attributes := ParseAttributes(attributeList)
+	dateRange = DateRange{
+		ID:            attributes["ID"],
+		Class:         attributes["CLASS"],
+		Scte35Cmd:     attributes["SCTE35-CMD"],
+		Scte35Out:     attributes["SCTE35-OUT"],
+		Scte35In:      attributes["SCTE35-IN"],
+		Attributes:    attributes,
+		AttributeList: ParseAttributeList(attributeList),
+		Line:          lineNumber,
+	}
+
+	if dateRange.StartDate, err = ParseTime(attributes["START-DATE"]); err != nil {
+		return dateRange, err
+	}
+
+	if endDate, ok := attributes["END-DATE"]; ok {
+		if dateRange.EndDate, err = ParseTime(endDate); err != nil {
+			return dateRange, err
+		}
+	}
+
+	for _, name := range []string{"DURATION", "PLANNED-DURATION"} {
+		if duration, ok := attributes[name]; ok {
+			dateRange.Duration, err = strconv.ParseFloat(strings.TrimSpace(duration), 64)
+			return dateRange, err
+		}
+	}
+
+	if !dateRange.EndDate.IsZero() {
+		dateRange.Duration = dateRange.EndDate.Sub(dateRange.StartDate).Seconds()
+	}
+
+	return dateRange, nil
+}
+
+// mergeDateRanges adds the ranges of current to those of previous. A range of current with the ID of an earlier one
+// replaces it, as a live playlist repeats a range with the attributes it learns later, such as END-DATE or SCTE35-IN.
+func mergeDateRanges(previous []DateRange, current []DateRange) []DateRange {
+	merged := append([]DateRange(nil), previous...)
+	indices := make(map[string]int, len(merged))
+	for index, dateRange := range merged {
+		indices[dateRange.ID] = index
+	}
+	for _, dateRange := range current {
+		if index, ok := indices[dateRange.ID]; ok {
+			merged[index] = dateRange
+			continue
+		}
+		indices[dateRange.ID] = len(merged)
+		merged = append(merged, dateRange)
+	}
+	return merged
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeadLetterFileName is the list of the downloads that still failed after every retry, kept in the download directory
+// until they are downloaded, see DeadLetters.
+const DeadLetterFileName = "failed.json"
+
+// DeadLetters is a concurrency safe list of the downloads that exhausted their retries, so that they can be attempted
+// again later, once the origin recovers, without downloading everything else again, see PlanDeadLetters. A nil list
+// records nothing.
+type DeadLetters struct {
+	mu      sync.Mutex
+	letters map[string]DeadLetter
+}
+
+// DeadLetter is a download that exhausted its retries.
+type DeadLetter struct {
+	File string `json:"file"`
+	// Url is the absolute url of the file, resolved against the playlist it was listed in.
+	Url       string     `json:"url"`
+	Line      int        `json:"line,omitempty"`
+	ByteRange *ByteRange `json:"byteRange,omitempty"`
+	Shared    bool       `json:"shared,omitempty"`
+	Encrypted bool       `json:"encrypted,omitempty"`
+	Error     string     `json:"error"`
+	// Attempts counts the attempts of the last download, including its retries.
+	Attempts int       `json:"attempts"`
+	Failed   time.Time `json:"failed"`
+}
+
+// LoadDeadLetters reads the list at filePath, returning an empty list if it does not exist yet.
+func LoadDeadLetters(filePath string) (*DeadLetters, error) {
+	letters := &DeadLetters{letters: make(map[string]DeadLetter)}
+
+	b, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return letters, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []DeadLetter
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("invalid list of failed downloads %s: %w", filePath, err)
+	}
+	for _, letter := range list {
+		letters.letters[letter.File] = letter
+	}
+	return letters, nil
+}
+
+// record adds the download of result to the list when it failed, resolving its url against baseUrl, and removes it once
+// it succeeds. Downloads never attempted or cancelled are left as they are.
+func (letters *DeadLetters) record(baseUrl *url.URL, result FragmentResult) {
+	if letters == nil || result.Attempts == 0 || errors.Is(result.Err, context.Canceled) {
+		return
+	}
+	letters.mu.Lock()
+	defer letters.mu.Unlock()
+
+	if result.Err == nil {
+		delete(letters.letters, result.File)
+		return
+	}
+	resolvedUrl := result.Url
+	if baseUrl != nil {
+		if resolved, err := ResolveUri(baseUrl, result.Url); err == nil {
+			resolvedUrl = resolved.String()
+		}
+	}
+	letters.letters[result.File] = DeadLetter{
+		File:      result.File,
+		Url:       resolvedUrl,
+		Line:      result.Line,
+		ByteRange: result.ByteRange,
+		Shared:    result.Shared,
+		Encrypted: result.Encrypted,
+		Error:     result.Err.Error(),
+		Attempts:  result.Attempts,
+		Failed:    time.Now().UTC(),
+	}
+}
+
+// contains reports whether the download of file failed before.
+func (letters *DeadLetters) contains(file string) bool {
+	if letters == nil {
+		return false
+	}
+	letters.mu.Lock()
+	defer letters.mu.Unlock()
+	_, ok := letters.letters[file]
+	return ok
+}
+
+// List returns the dead letters sorted by file name.
+func (letters *DeadLetters) List() []DeadLetter {
+	if letters == nil {
+		return nil
+	}
+	letters.mu.Lock()
+	defer letters.mu.Unlock()
+
+	list := make([]DeadLetter, 0, len(letters.letters))
+	for _, letter := range letters.letters {
+		list = append(list, letter)
+	}
+	sort.Slice(list, func(i int, j int) bool {
+		return list[i].File < list[j].File
+	})
+	return list
+}
+
+// Write persists the list to filePath, removing the file once the list is empty.
+func (letters *DeadLetters) Write(filePath string) error {
+	if letters == nil {
+		return nil
+	}
+	list := letters.List()
+	if len(list) == 0 {
+		if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, b, 0644)
+}
+
+// PlanDeadLetters plans downloading the dead letters again, and nothing else, into options.Dir. Their urls are already
+// resolved, so the plan needs no playlist; its Manifest only carries letters, which the downloads are recorded in.
+func PlanDeadLetters(letters *DeadLetters, options PlanOptions) *DownloadPlan {
+	options.ForceDownload = true
+	plan := &DownloadPlan{Manifest: &Manifest{BaseUrl: &url.URL{}, DeadLetters: letters}, Options: options}
+	for _, letter := range letters.List() {
+		plan.Downloads = append(plan.Downloads, PlannedDownload{
+			File:      letter.File,
+			Url:       letter.Url,
+			Line:      letter.Line,
+			Shared:    letter.Shared,
+			Encrypted: letter.Encrypted,
+			ByteRange: letter.ByteRange,
+		})
+	}
+	return plan
+}
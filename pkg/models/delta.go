@@ -0,0 +1,190 @@
+package models
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DeltaUrl returns manifestUrl with the _HLS_skip directive set, asking the origin for a delta update that omits segments the client already has.
+func DeltaUrl(manifestUrl string) string {
+	u, err := url.Parse(manifestUrl)
+	if err != nil || u.Scheme == "" {
+		return manifestUrl
+	}
+
+	query := u.Query()
+	query.Set("_HLS_skip", "YES")
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// BlockingReloadUrl returns manifestUrl with the _HLS_msn directive set, asking an origin that can block reloads to hold
+// the request until the playlist holds media sequence number msn. With part not negative _HLS_part is set as well, to
+// wait for that partial segment of msn only, otherwise it waits for the whole segment.
+func BlockingReloadUrl(manifestUrl string, msn int, part int) string {
+	u, err := url.Parse(manifestUrl)
+	if err != nil || u.Scheme == "" {
+		return manifestUrl
+	}
+
+	query := u.Query()
+	query.Set("_HLS_msn", strconv.Itoa(msn))
+	if part >= 0 {
+		query.Set("_HLS_part", strconv.Itoa(part))
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// CanRequestDelta reports whether a delta update may be requested to reload manifest, fetched age ago: RFC 8216bis only
+// allows it while the playlist is younger than half of its CAN-SKIP-UNTIL, as the origin may skip more segments than it
+// holds past that.
+func (manifest Manifest) CanRequestDelta(age time.Duration) bool {
+	return manifest.CanSkipUntil > 0 && age < time.Duration(manifest.CanSkipUntil*float64(time.Second)/2)
+}
+
+// ApplyDelta restores the segments an #EXT-X-SKIP replaced in a delta update using those from the previously fetched full playlist.
+func (manifest *Manifest) ApplyDelta(previous *Manifest) error {
+	if manifest.SkippedSegments == 0 {
+		return nil
+	}
+
+	firstSkipped := manifest.MediaSequence
+	lastSkipped := manifest.MediaSequence + manifest.SkippedSegments - 1
+	if previous == nil || firstSkipped < previous.MediaSequence || lastSkipped > previous.LastSequence() {
+		return fmt.Errorf("previous playlist does not hold skipped segments %d-%d", firstSkipped, lastSkipped)
+	}
+
+	skipped := make([]Discontinuity, 0)
+	sequence := previous.MediaSequence
+	for _, discontinuity := range previous.Discontinuities {
+		kept := discontinuity
+		kept.Entries = nil
+		kept.Parts = nil
+		for _, entry := range discontinuity.Entries {
+			if sequence >= firstSkipped && sequence <= lastSkipped {
+				kept.Entries = append(kept.Entries, entry)
+			}
+			sequence++
+		}
+		if len(kept.Entries) > 0 {
+			skipped = append(skipped, kept)
+		}
+	}
+
+	// the first delta discontinuity continues the last skipped one unless the delta opened with #EXT-X-DISCONTINUITY
+	if len(skipped) > 0 && len(manifest.Discontinuities) > 0 {
+		last := &skipped[len(skipped)-1]
+		first := manifest.Discontinuities[0]
+		last.Entries = append(last.Entries, first.Entries...)
+		last.Parts = first.Parts
+		manifest.Discontinuities = append(skipped, manifest.Discontinuities[1:]...)
+	}
+
+	manifest.SkippedSegments = 0
+	return nil
+}
+
+// Extend prepends the segments archived by a previous run of the same playlist, keeping only the segments of manifest
+// published after previous. It returns the number of segments appended to the archive.
+// Segments missing between the two, e.g. when runs were too far apart for a sliding window, are logged and left out.
+// When the origin restarted in between, publishing the playlist anew with its media sequence going backwards, the
+// segments of manifest are numbered on from the archive in a discontinuity of their own instead of being taken for
+// segments already archived, see isReset.
+func (manifest *Manifest) Extend(previous *Manifest) (appended int) {
+	lastSequence := previous.LastSequence()
+	reset := manifest.isReset(previous)
+	manifest.Resets, manifest.sequenceOffset = previous.Resets, previous.sequenceOffset
+	if reset {
+		manifest.Resets++
+		manifest.sequenceOffset = lastSequence + 1 - manifest.MediaSequence
+		slog.Warn("playlist was reset by the origin, continuing the recording after a discontinuity",
+			slog.Int("mediaSequence", manifest.SourceSequence), slog.Int("previousMediaSequence", previous.SourceSequence),
+			slog.Int("discontinuitySequence", manifest.DiscontinuitySequence), slog.Int("previousDiscontinuitySequence", previous.DiscontinuitySequence),
+			slog.Int("resets", manifest.Resets))
+	}
+	if first := manifest.MediaSequence + manifest.sequenceOffset; first > lastSequence+1 {
+		slog.Warn("segments were removed from the playlist before they could be archived", slog.Int("from", lastSequence+1), slog.Int("to", first-1))
+	}
+
+	merged := make([]Discontinuity, 0, len(previous.Discontinuities))
+	for _, discontinuity := range previous.Discontinuities {
+		discontinuity.Parts = nil
+		merged = append(merged, discontinuity)
+	}
+
+	manifest.forEachEntry(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) {
+		if sequence+manifest.sequenceOffset <= lastSequence {
+			return
+		}
+
+		// a new segment continues the last archived discontinuity unless it opens a discontinuity of its own, as the first
+		// segment after a reset always does
+		discontinuity := manifest.Discontinuities[discontinuityIndex]
+		if len(merged) == 0 || (reset && appended == 0) || (discontinuityIndex > 0 && entry == discontinuity.Entries[0]) {
+			discontinuity.Entries = nil
+			discontinuity.Parts = nil
+			discontinuity.Resets = manifest.Resets
+			if !start.IsZero() {
+				discontinuity.ProgramDateTime = start
+			}
+			merged = append(merged, discontinuity)
+		}
+
+		entry.Resets, entry.Sequence = manifest.Resets, sequence+manifest.sequenceOffset
+		last := &merged[len(merged)-1]
+		last.Entries = append(last.Entries, entry)
+		appended++
+	})
+
+	// the parts at the live edge follow the newest segment, wherever it was merged
+	if last := len(manifest.Discontinuities) - 1; last >= 0 && len(merged) > 0 {
+		merged[len(merged)-1].Parts = manifest.Discontinuities[last].Parts
+	}
+
+	manifest.MediaSequence = previous.MediaSequence
+	manifest.Discontinuities = merged
+	manifest.DateRanges = mergeDateRanges(previous.DateRanges, manifest.DateRanges)
+	return appended
+}
+
+// isReset reports whether the origin restarted since previous was published, which shows as the media sequence going
+// backwards, as the discontinuity sequence going backwards, or as the discontinuity sequence jumping while the media
+// sequence stands still. A media sequence going backwards to segments previous already archived under the same uris is
+// a stale copy of the playlist, e.g. from another edge of a CDN, rather than a reset.
+func (manifest Manifest) isReset(previous *Manifest) bool {
+	first := manifest.firstEntry()
+	if first == nil {
+		return false
+	}
+	if manifest.SourceSequence < previous.SourceSequence {
+		archived := previous.entryAt(manifest.SourceSequence + previous.sequenceOffset)
+		return archived == nil || archived.Url != first.Url
+	}
+	return manifest.DiscontinuitySequence < previous.DiscontinuitySequence ||
+		(manifest.DiscontinuitySequence > previous.DiscontinuitySequence && manifest.SourceSequence == previous.SourceSequence)
+}
+
+func (manifest Manifest) firstEntry() *ManifestEntry {
+	for _, discontinuity := range manifest.Discontinuities {
+		if len(discontinuity.Entries) > 0 {
+			return discontinuity.Entries[0]
+		}
+	}
+	return nil
+}
+
+// entryAt returns the fragment numbered sequence, or nil when the manifest does not hold it.
+func (manifest Manifest) entryAt(sequence int) (found *ManifestEntry) {
+	manifest.forEachEntry(func(discontinuityIndex int, entrySequence int, start time.Time, entry *ManifestEntry) {
+		if entrySequence == sequence {
+			found = entry
+		}
+	})
+	return found
+}
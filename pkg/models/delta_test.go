@@ -0,0 +1,88 @@
+package models
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCanRequestDelta(t *testing.T) {
+	manifest := Manifest{CanSkipUntil: 36}
+	tests := []struct {
+		age  time.Duration
+		want bool
+	}{
+		{age: 0, want: true},
+		{age: 17 * time.Second, want: true},
+		{age: 18 * time.Second, want: false},
+		{age: time.Minute, want: false},
+	}
+	for _, test := range tests {
+		if got := manifest.CanRequestDelta(test.age); got != test.want {
+			t.Errorf("delta after %s: %t, want %t", test.age, got, test.want)
+		}
+	}
+	if (Manifest{}).CanRequestDelta(0) {
+		t.Error("delta requested of an origin without CAN-SKIP-UNTIL")
+	}
+}
+
+func TestApplyDeltaImplicitIv(t *testing.T) {
+	previous := readTestManifest(t, `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-SERVER-CONTROL:CAN-SKIP-UNTIL=24
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin"
+#EXTINF:4.0,
+s10.ts
+#EXTINF:4.0,
+s11.ts
+#EXTINF:4.0,
+s12.ts
+`)
+	delta := readTestManifest(t, `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-SERVER-CONTROL:CAN-SKIP-UNTIL=24
+#EXT-X-MEDIA-SEQUENCE:11
+#EXT-X-SKIP:SKIPPED-SEGMENTS=2
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin"
+#EXTINF:4.0,
+s13.ts
+`)
+	if err := delta.ApplyDelta(previous); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := entryUrls(delta), []string{"s11.ts", "s12.ts", "s13.ts"}; !slices.Equal(got, want) {
+		t.Fatalf("delta holds %v, want %v", got, want)
+	}
+	for index, entry := range delta.Discontinuities[0].Entries {
+		sequence := 11 + index
+		if entry.Sequence != sequence {
+			t.Errorf("%s has sequence %d, want %d", entry.Url, entry.Sequence, sequence)
+		}
+		if iv := binary.BigEndian.Uint64(entry.IV[8:]); iv != uint64(sequence) {
+			t.Errorf("%s has the implicit IV of sequence %d, want %d", entry.Url, iv, sequence)
+		}
+	}
+}
+
+func TestBlockingReloadUrl(t *testing.T) {
+	tests := []struct {
+		manifestUrl string
+		part        int
+		want        string
+	}{
+		{manifestUrl: "http://localhost/live.m3u8", part: -1, want: "http://localhost/live.m3u8?_HLS_msn=42"},
+		{manifestUrl: "http://localhost/live.m3u8?token=a", part: -1, want: "http://localhost/live.m3u8?_HLS_msn=42&token=a"},
+		{manifestUrl: DeltaUrl("http://localhost/live.m3u8"), part: -1, want: "http://localhost/live.m3u8?_HLS_msn=42&_HLS_skip=YES"},
+		{manifestUrl: "live.m3u8", part: -1, want: "live.m3u8"},
+		{manifestUrl: "http://localhost/live.m3u8", part: 3, want: "http://localhost/live.m3u8?_HLS_msn=42&_HLS_part=3"},
+	}
+	for _, test := range tests {
+		if got := BlockingReloadUrl(test.manifestUrl, 42, test.part); got != test.want {
+			t.Errorf("BlockingReloadUrl(%q, 42, %d) = %q, want %q", test.manifestUrl, test.part, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+const (
+	FormatHlsMaster = "hls-master"
+	FormatHlsMedia  = "hls-media"
+	FormatDash      = "dash"
+	FormatMedia     = "media"
+)
+
+// formatSniffLength is how much of a resource DetectFormat looks at to tell an MPD from other XML or HTML.
+const formatSniffLength = 4096
+
+// ErrHtmlPage is returned by DetectFormat for a web page, such as the player embedding a stream or an error page served
+// with a success status, which holds neither a playlist nor media.
+var ErrHtmlPage = errors.New("url is an HTML page rather than a playlist, MPD or media file")
+
+// DetectFormat tells what the resource in r is by its content rather than its url or Content-Type, which origins often
+// get wrong: an HLS master playlist, an HLS media playlist, a DASH MPD or, failing those, a media file. Only the start
+// of r is read, but for HLS playlists, which are read until their first variant stream or segment.
+func DetectFormat(r io.Reader) (string, error) {
+	buffered := bufio.NewReaderSize(r, formatSniffLength)
+	head, err := buffered.Peek(formatSniffLength)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	// a media file is not decoded, which would read all of it, unless it starts like a UTF-16 playlist or MPD
+	if isUtf16Text(head) {
+		decoded, err := decodeEncoding(buffered)
+		if err != nil {
+			return "", err
+		}
+		buffered = bufio.NewReaderSize(decoded, formatSniffLength)
+		if head, err = buffered.Peek(formatSniffLength); err != nil && err != io.EOF {
+			return "", err
+		}
+	}
+	text := bytes.TrimSpace(bytes.TrimPrefix(head, []byte{0xEF, 0xBB, 0xBF}))
+	if len(text) == 0 {
+		return "", errors.New("url is empty")
+	}
+
+	switch {
+	case bytes.HasPrefix(text, []byte("#EXTM3U")):
+		if IsMasterPlaylist(buffered) {
+			return FormatHlsMaster, nil
+		}
+		return FormatHlsMedia, nil
+	case isBinary(text):
+		return FormatMedia, nil
+	case bytes.HasPrefix(text, []byte("<")) && (bytes.Contains(text, []byte("<MPD")) || bytes.Contains(text, []byte(":MPD"))):
+		return FormatDash, nil
+	case bytes.HasPrefix(text, []byte("<")) && bytes.Contains(bytes.ToLower(text), []byte("<html")):
+		return "", ErrHtmlPage
+	}
+	return FormatMedia, nil
+}
+
+// isUtf16Text reports whether head starts with a UTF-16 byte order mark, or with the # of a playlist or the < of an MPD
+// encoded as UTF-16 without one.
+func isUtf16Text(head []byte) bool {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}), bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return true
+	case len(head) < 2:
+		return false
+	}
+	return (head[1] == 0 && (head[0] == '#' || head[0] == '<')) || (head[0] == 0 && (head[1] == '#' || head[1] == '<'))
+}
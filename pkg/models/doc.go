@@ -0,0 +1,23 @@
+// Package models parses HLS playlists and DASH MPDs, plans the downloads of their fragments and post-processes them
+// with ffmpeg. It backs the manifestr commands and can be embedded in other programs:
+//
+//	manifest, err := models.ReadManifestWithOptions(playlist, playlistUrl, models.ReadOptions{Strict: true})
+//	if err != nil {
+//		return err // a *ParseError lists every malformed tag as a TagError with its line
+//	}
+//	plan := models.Plan(manifest, models.PlanOptions{
+//		Dir:           dir,
+//		Concurrency:   4,
+//		ForceDownload: true,
+//		Client:        client,
+//		Logger:        logger,
+//	})
+//	if err := plan.Download(ctx); err != nil {
+//		return err // a *DownloadError lists every download that failed for good
+//	}
+//	return plan.Process(ctx)
+//
+// Master playlists are read with ReadMasterPlaylist and their variant picked with MasterPlaylist.SelectVariant.
+// Nothing is configured through package variables: naming and query parameters come with the ReadOptions, headers,
+// rate limits and tracing with the utils.Downloader in PlanOptions, so runs with different settings can share a process.
+package models
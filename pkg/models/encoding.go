@@ -0,0 +1,107 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// MaxLineLength is the longest line of a playlist the parsers accept, well above the longest real-world tags such as
+// #EXT-X-DATERANGE carrying SCTE-35 payloads.
+const MaxLineLength = 1 << 20
+
+// binarySniffLength is how much of a playlist is looked at to tell it apart from binary data, such as a segment or an
+// HTML error page compressed without a Content-Encoding.
+const binarySniffLength = 512
+
+// decodePlaylist wraps r so that it always yields UTF-8 without a byte order mark.
+// Some Windows-based packagers emit a UTF-8 BOM or encode the whole playlist as UTF-16, with or without a BOM.
+// Binary data, such as a segment served in place of its playlist, is rejected with ErrBinaryPlaylist.
+func decodePlaylist(r io.Reader) (io.Reader, error) {
+	decoded, err := decodeEncoding(r)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(decoded)
+	head, err := buffered.Peek(binarySniffLength)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if isBinary(head) {
+		return nil, ErrBinaryPlaylist
+	}
+	return buffered, nil
+}
+
+func decodeEncoding(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+
+	head, err := buffered.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		_, err := buffered.Discard(3)
+		return buffered, err
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return decodeUtf16(buffered, binary.LittleEndian, 2)
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return decodeUtf16(buffered, binary.BigEndian, 2)
+	case len(head) >= 2 && head[0] != 0 && head[1] == 0:
+		return decodeUtf16(buffered, binary.LittleEndian, 0)
+	case len(head) >= 2 && head[0] == 0 && head[1] != 0:
+		return decodeUtf16(buffered, binary.BigEndian, 0)
+	}
+
+	return buffered, nil
+}
+
+func decodeUtf16(r io.Reader, order binary.ByteOrder, skip int) (io.Reader, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	raw = raw[skip:]
+
+	units := make([]uint16, 0, len(raw)/2)
+	for index := 0; index+1 < len(raw); index += 2 {
+		units = append(units, order.Uint16(raw[index:]))
+	}
+
+	return bytes.NewReader([]byte(string(utf16.Decode(units)))), nil
+}
+
+// isBinary reports whether head holds control characters no text playlist contains, such as the NUL bytes of MPEG-TS
+// and MP4 segments.
+func isBinary(head []byte) bool {
+	for _, b := range head {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\v' && b != '\f' && b != '\r' {
+			return true
+		}
+	}
+	return false
+}
+
+// newPlaylistScanner scans the lines of a playlist decoded by decodePlaylist, up to MaxLineLength long.
+func newPlaylistScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, MaxLineLength)
+	return scanner
+}
+
+// scanError returns the error the scanner of a playlist stopped with at lineNumber, reporting overlong lines as
+// ErrLineTooLong.
+func scanError(scanner *bufio.Scanner, lineNumber int) error {
+	err := scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("line %d: %w", lineNumber, ErrLineTooLong)
+	}
+	return err
+}
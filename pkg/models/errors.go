@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnrecognizedTag       = errors.New("unrecognized tag")
+	ErrMissingUri            = errors.New("missing fragment uri")
+	ErrMissingHeader         = errors.New("playlist does not start with #EXTM3U")
+	ErrInlineUri             = errors.New("fragment uri on the same line as #EXTINF")
+	ErrBlankLine             = errors.New("blank line between #EXTINF and its uri")
+	ErrInvalidDefine         = errors.New("invalid variable definition")
+	ErrUndefinedVariable     = errors.New("undefined variable")
+	ErrInvalidKey            = errors.New("invalid key")
+	ErrUnsupportedEncryption = errors.New("unsupported encryption")
+	ErrMasterPlaylist        = errors.New("playlist is a master playlist listing variant streams rather than media segments")
+	ErrBinaryPlaylist        = errors.New("playlist is binary data rather than text")
+	ErrLineTooLong           = errors.New("line is longer than MaxLineLength")
+	ErrTooManyAttributes     = errors.New("attribute list has more than MaxAttributes attributes")
+	ErrInvalidDuration       = errors.New("invalid duration")
+)
+
+// knownTags lists every tag name defined by RFC 8216 and the LL-HLS extensions, whether or not the parser models it.
+var knownTags = map[string]bool{
+	"EXTM3U": true, "EXTINF": true, "EXT-X-VERSION": true, "EXT-X-BYTERANGE": true, "EXT-X-DISCONTINUITY": true,
+	"EXT-X-KEY": true, "EXT-X-MAP": true, "EXT-X-PROGRAM-DATE-TIME": true, "EXT-X-DATERANGE": true, "EXT-X-GAP": true,
+	"EXT-X-BITRATE": true, "EXT-X-TARGETDURATION": true, "EXT-X-MEDIA-SEQUENCE": true, "EXT-X-DISCONTINUITY-SEQUENCE": true,
+	"EXT-X-ENDLIST": true, "EXT-X-PLAYLIST-TYPE": true, "EXT-X-I-FRAMES-ONLY": true, "EXT-X-MEDIA": true,
+	"EXT-X-STREAM-INF": true, "EXT-X-I-FRAME-STREAM-INF": true, "EXT-X-SESSION-DATA": true, "EXT-X-SESSION-KEY": true,
+	"EXT-X-INDEPENDENT-SEGMENTS": true, "EXT-X-START": true, "EXT-X-DEFINE": true, "EXT-X-ALLOW-CACHE": true,
+	"EXT-X-PART": true, "EXT-X-PART-INF": true, "EXT-X-PRELOAD-HINT": true, "EXT-X-SERVER-CONTROL": true,
+	"EXT-X-SKIP": true, "EXT-X-RENDITION-REPORT": true, "EXT-X-CONTENT-STEERING": true,
+	"EXT-X-IMAGE-STREAM-INF": true, "EXT-X-IMAGES-ONLY": true, "EXT-X-TILES": true,
+}
+
+// FailedDownload is a planned download that failed permanently and why.
+type FailedDownload struct {
+	PlannedDownload
+	Err error
+}
+
+// DownloadError is returned by DownloadPlan.Download and lists every download that failed permanently.
+type DownloadError struct {
+	Failed []FailedDownload
+}
+
+func (downloadError *DownloadError) Error() string {
+	lines := make([]string, 0, len(downloadError.Failed))
+	for _, failed := range downloadError.Failed {
+		lines = append(lines, fmt.Sprintf("%s (line %d): %s", failed.File, failed.Line, failed.Err))
+	}
+	return fmt.Sprintf("%d downloads failed:\n%s", len(downloadError.Failed), strings.Join(lines, "\n"))
+}
+
+func (downloadError *DownloadError) Unwrap() []error {
+	errs := make([]error, 0, len(downloadError.Failed))
+	for _, failed := range downloadError.Failed {
+		errs = append(errs, failed.Err)
+	}
+	return errs
+}
+
+// TagError describes an unrecognized or malformed tag and where it was found.
+type TagError struct {
+	Line int
+	Tag  string
+	Err  error
+}
+
+func (tagError TagError) Error() string {
+	return fmt.Sprintf("line %d: %s: %s", tagError.Line, tagError.Tag, tagError.Err)
+}
+
+func (tagError TagError) Unwrap() error {
+	return tagError.Err
+}
+
+// ParseError is returned by strict parsing and lists every TagError found in the playlist.
+type ParseError struct {
+	Errors []TagError
+}
+
+func (parseError *ParseError) Error() string {
+	lines := make([]string, 0, len(parseError.Errors))
+	for _, tagError := range parseError.Errors {
+		lines = append(lines, tagError.Error())
+	}
+	return fmt.Sprintf("%d invalid tags:\n%s", len(parseError.Errors), strings.Join(lines, "\n"))
+}
+
+func (parseError *ParseError) Unwrap() []error {
+	errs := make([]error, 0, len(parseError.Errors))
+	for _, tagError := range parseError.Errors {
+		errs = append(errs, tagError)
+	}
+	return errs
+}
+
+// tagName returns the name of the tag on line without its leading # or value, e.g. EXT-X-VERSION.
+func tagName(line string) string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(line, "#"), ":")
+	return name
+}
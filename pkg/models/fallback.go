@@ -0,0 +1,72 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// FallbackSeparator separates the primary and backup prefixes of a fallback rule. It cannot appear in a valid url.
+const FallbackSeparator = "=>"
+
+// FallbackRule maps the urls of fragments starting with Prefix to a backup origin serving byte-identical content, with
+// Prefix replaced by Replacement. Fallbacks are only tried once every retry of the primary and failover origins failed.
+type FallbackRule struct {
+	Prefix      string
+	Replacement string
+}
+
+// Rewrite returns the backup url of rawUrl, reporting false when the rule does not apply to it.
+func (rule FallbackRule) Rewrite(rawUrl string) (string, bool) {
+	if !strings.HasPrefix(rawUrl, rule.Prefix) {
+		return "", false
+	}
+	return rule.Replacement + strings.TrimPrefix(rawUrl, rule.Prefix), true
+}
+
+// AddFallbackRules registers rules written as primary=>backup url prefixes. A rule that is just a url is the backup of
+// the manifest base url, so every relative fragment uri falls back to it.
+func (manifest *Manifest) AddFallbackRules(rules ...string) error {
+	for _, value := range rules {
+		prefix, replacement, found := strings.Cut(value, FallbackSeparator)
+		if !found {
+			prefix, replacement = manifest.BaseUrl.String(), value
+		}
+		if _, err := url.Parse(replacement); err != nil || replacement == "" {
+			return fmt.Errorf("invalid fallback rule %q", value)
+		}
+		manifest.FallbackRules = append(manifest.FallbackRules, FallbackRule{Prefix: prefix, Replacement: replacement})
+	}
+	return nil
+}
+
+// candidateUrls lists the urls the fragment at the relative url is downloaded from in turn: the primary and failover
+// origins, or the backup urls of the fallback rules applying to the primary url when fallback is set.
+func (manifest Manifest) candidateUrls(relativeUrl string, primaryUrl string, fallback bool) ([]string, error) {
+	candidates := make([]string, 0, 1+len(manifest.FailoverBaseUrls))
+	if fallback {
+		for _, rule := range manifest.FallbackRules {
+			if backupUrl, ok := rule.Rewrite(primaryUrl); ok {
+				candidates = append(candidates, manifest.mediaUrl(backupUrl))
+			}
+		}
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("no fallback rule applies to %s", primaryUrl)
+		}
+		return candidates, nil
+	}
+
+	var err error
+	for _, baseUrl := range append([]*url.URL{manifest.BaseUrl}, manifest.FailoverBaseUrls...) {
+		resolved, parseErr := manifest.resolveMediaUri(baseUrl, relativeUrl)
+		if parseErr != nil {
+			err = parseErr
+			continue
+		}
+		candidates = append(candidates, resolved.String())
+	}
+	if len(candidates) == 0 {
+		return nil, err
+	}
+	return candidates, nil
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxFilenameLength is the longest name, in bytes, a local file is given before its extension. It leaves room within the
+// 255 byte limit of common filesystems for extensions and suffixes such as ".clip.mp4".
+const MaxFilenameLength = 200
+
+// localName derives the name, without extension, a resource is saved under from the decoded last path segment of its uri.
+// Characters that are illegal in filenames on common platforms are replaced, and names that are too long are truncated
+// with a hash of the full uri appended to keep them unique. The index records the uri of every file for the reverse mapping.
+func localName(uri string) string {
+	name := path.Base(uriPath(uri))
+	name = sanitizeFilename(strings.TrimSuffix(name, path.Ext(name)))
+
+	if len(name) > MaxFilenameLength {
+		sum := sha256.Sum256([]byte(uri))
+		suffix := "-" + hex.EncodeToString(sum[:8])
+		name = truncateUtf8(name, MaxFilenameLength-len(suffix)) + suffix
+	}
+
+	return name
+}
+
+// resetName suffixes the local name of a resource published after resets restarts of the origin, which often reuses the
+// names of the resources published before, so they do not overwrite those.
+func resetName(name string, resets int) string {
+	if resets == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s_reset%d", name, resets)
+}
+
+// resetKey tells the resource at url published after resets restarts of the origin apart from the one published before
+// under the same url in the status cache and shared store, see resetName.
+func resetKey(url string, resets int) string {
+	if resets == 0 {
+		return url
+	}
+	return fmt.Sprintf("%s#reset=%d", url, resets)
+}
+
+// sanitizeFilename replaces the characters of name that are illegal in filenames on common platforms.
+func sanitizeFilename(name string) string {
+	// percent-decoding may produce bytes that are not valid UTF-8 in any filesystem encoding
+	name = strings.ToValidUTF8(name, "_")
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	// Windows drops trailing dots and spaces
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		name = "_"
+	}
+	return name
+}
+
+// DefaultOutputName names the MP4 of every discontinuity after its position, e.g. d0001.
+const DefaultOutputName = "d{index:04d}"
+
+// DatedOutputName names the MP4 of every discontinuity after its #EXT-X-PROGRAM-DATE-TIME, e.g. 20240101T100000Z.
+const DatedOutputName = "%Y%m%dT%H%M%SZ"
+
+// SingleOutputName names the MP4 that ConcatSingle merges every discontinuity into.
+const SingleOutputName = "output"
+
+var indexPlaceholder = regexp.MustCompile(`\{index(?::(0?)(\d+)d)?\}`)
+
+// OutputNames expands template into the name, without extension, of the output of every discontinuity. {index} or
+// {index:04d} is the position of the discontinuity, {title} the #EXTINF title of its first segment and strftime
+// directives such as %Y%m%d-%H%M%S its #EXT-X-PROGRAM-DATE-TIME, unless its Namer names them otherwise. Names are deterministic so that a re-run overwrites the
+// same outputs: one that collides with a fragment or an earlier output gets the index of its discontinuity appended.
+func (manifest Manifest) OutputNames(template string) []string {
+	if template == "" {
+		template = DefaultOutputName
+	}
+
+	// the outputs share the directory with the fragments, whose names are taken as well
+	isFmp4 := manifest.IsFmp4()
+	taken := make(map[string]bool)
+	for _, discontinuity := range manifest.Discontinuities {
+		for _, entry := range discontinuity.Entries {
+			name := manifest.LocalFilename(*entry, isFmp4)
+			taken[strings.ToLower(strings.TrimSuffix(name, path.Ext(name)))] = true
+		}
+	}
+
+	names := make([]string, 0, len(manifest.Discontinuities))
+	for index, discontinuity := range manifest.Discontinuities {
+		name := sanitizeFilename(manifest.namer().Output(template, index, discontinuity))
+		name = truncateUtf8(name, MaxFilenameLength-5)
+
+		if taken[strings.ToLower(name)] {
+			name = fmt.Sprintf("%s-%04d", name, index)
+		}
+		taken[strings.ToLower(name)] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// expandOutputName expands template into the name of the output of the discontinuity at index, see OutputNames.
+func expandOutputName(template string, index int, discontinuity Discontinuity) string {
+	title := ""
+	if len(discontinuity.Entries) > 0 {
+		title = discontinuity.Entries[0].Title
+	}
+
+	name := indexPlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		match := indexPlaceholder.FindStringSubmatch(placeholder)
+		if match[2] == "" {
+			return strconv.Itoa(index)
+		}
+		return fmt.Sprintf("%"+match[1]+match[2]+"d", index)
+	})
+	// the title is substituted last so a % in it is not taken for a directive
+	name = strftime(name, discontinuity.ProgramDateTime.UTC())
+	return strings.ReplaceAll(name, "{title}", title)
+}
+
+// partOutputName is the template of the MP4s of the discontinuities that ConcatSingle merges, named after the merged MP4.
+func partOutputName(template string) string {
+	if template == "" {
+		template = DefaultOutputName
+	}
+	return template + ".part{index:04d}"
+}
+
+// truncateUtf8 cuts s to at most n bytes without splitting a multi-byte character.
+func truncateUtf8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !isRuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,388 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// FilterVariables lists the segment properties a filter expression can refer to.
+var FilterVariables = []string{"seq", "duration", "discontinuity", "offset", "url"}
+
+// Filter is a parsed segment filter expression such as `duration > 1 && seq >= 100`.
+// Expressions compare the FilterVariables against numbers or double quoted strings with ==, !=, <, <=, > and >=,
+// and combine comparisons with &&, ||, ! and parentheses.
+type Filter struct {
+	expression string
+	root       filterNode
+}
+
+type filterNode interface {
+	eval(vars map[string]any) (any, error)
+}
+
+// ParseFilter compiles expression, reporting syntax errors and unknown variables up front.
+func ParseFilter(expression string) (*Filter, error) {
+	tokens, err := tokenizeFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &filterParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.position < len(parser.tokens) {
+		return nil, fmt.Errorf("unexpected %q in filter", parser.tokens[parser.position])
+	}
+
+	return &Filter{expression: expression, root: root}, nil
+}
+
+func (filter *Filter) String() string {
+	return filter.expression
+}
+
+// Match evaluates the filter for a single segment.
+func (filter *Filter) Match(vars map[string]any) (bool, error) {
+	value, err := filter.root.eval(vars)
+	if err != nil {
+		return false, err
+	}
+
+	matched, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("filter %q does not evaluate to a boolean", filter.expression)
+	}
+	return matched, nil
+}
+
+// ApplyFilter drops every segment that does not match filter. Segments for which the filter cannot be evaluated are dropped as well.
+func (manifest *Manifest) ApplyFilter(filter *Filter) error {
+	offsets := make(map[*ManifestEntry]float64)
+	offset := 0.0
+	manifest.forEachEntry(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) {
+		offsets[entry] = offset
+		offset += entry.Duration
+	})
+
+	var filterErr error
+	manifest.filterEntries(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) bool {
+		matched, err := filter.Match(map[string]any{
+			"seq":           float64(sequence),
+			"duration":      entry.Duration,
+			"discontinuity": float64(discontinuityIndex),
+			"offset":        offsets[entry],
+			"url":           entry.Url,
+		})
+		if err != nil && filterErr == nil {
+			filterErr = err
+		}
+		return matched
+	})
+
+	return filterErr
+}
+
+// ApplyUrlPatterns drops every segment whose resolved url matches one of exclude, or none of include when there are
+// any, returning how many were dropped. Patterns are matched anywhere in the url, so a bare hostname such as
+// `slate\.example\.com` matches every segment served from it.
+func (manifest *Manifest) ApplyUrlPatterns(include []*regexp.Regexp, exclude []*regexp.Regexp) int {
+	matchesAny := func(patterns []*regexp.Regexp, url string) bool {
+		for _, pattern := range patterns {
+			if pattern.MatchString(url) {
+				return true
+			}
+		}
+		return false
+	}
+
+	dropped := 0
+	manifest.filterEntries(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) bool {
+		url := entry.Url
+		if resolved, err := ResolveUri(manifest.BaseUrl, entry.Url); err == nil {
+			url = resolved.String()
+		}
+		if matchesAny(exclude, url) || (len(include) > 0 && !matchesAny(include, url)) {
+			dropped++
+			return false
+		}
+		return true
+	})
+	return dropped
+}
+
+func tokenizeFilter(expression string) ([]string, error) {
+	tokens := make([]string, 0)
+
+	for index := 0; index < len(expression); {
+		char := rune(expression[index])
+		switch {
+		case unicode.IsSpace(char):
+			index++
+		case strings.ContainsRune("()", char):
+			tokens = append(tokens, string(char))
+			index++
+		case strings.HasPrefix(expression[index:], "&&"), strings.HasPrefix(expression[index:], "||"),
+			strings.HasPrefix(expression[index:], "=="), strings.HasPrefix(expression[index:], "!="),
+			strings.HasPrefix(expression[index:], "<="), strings.HasPrefix(expression[index:], ">="):
+			tokens = append(tokens, expression[index:index+2])
+			index += 2
+		case strings.ContainsRune("<>!", char):
+			tokens = append(tokens, string(char))
+			index++
+		case char == '"':
+			end := strings.IndexByte(expression[index+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string in filter at %d", index)
+			}
+			tokens = append(tokens, expression[index:index+end+2])
+			index += end + 2
+		case unicode.IsDigit(char) || char == '.' || unicode.IsLetter(char) || char == '_':
+			start := index
+			for index < len(expression) && (unicode.IsDigit(rune(expression[index])) || unicode.IsLetter(rune(expression[index])) || strings.ContainsRune("._", rune(expression[index]))) {
+				index++
+			}
+			tokens = append(tokens, expression[start:index])
+		default:
+			return nil, fmt.Errorf("unexpected %q in filter at %d", char, index)
+		}
+	}
+
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens   []string
+	position int
+}
+
+func (parser *filterParser) peek() string {
+	if parser.position < len(parser.tokens) {
+		return parser.tokens[parser.position]
+	}
+	return ""
+}
+
+func (parser *filterParser) next() string {
+	token := parser.peek()
+	parser.position++
+	return token
+}
+
+func (parser *filterParser) parseOr() (filterNode, error) {
+	left, err := parser.parseAnd()
+	for err == nil && parser.peek() == "||" {
+		parser.next()
+		var right filterNode
+		right, err = parser.parseAnd()
+		left = logicalNode{operator: "||", left: left, right: right}
+	}
+	return left, err
+}
+
+func (parser *filterParser) parseAnd() (filterNode, error) {
+	left, err := parser.parseUnary()
+	for err == nil && parser.peek() == "&&" {
+		parser.next()
+		var right filterNode
+		right, err = parser.parseUnary()
+		left = logicalNode{operator: "&&", left: left, right: right}
+	}
+	return left, err
+}
+
+func (parser *filterParser) parseUnary() (filterNode, error) {
+	if parser.peek() == "!" {
+		parser.next()
+		operand, err := parser.parseUnary()
+		return notNode{operand: operand}, err
+	}
+	return parser.parseComparison()
+}
+
+func (parser *filterParser) parseComparison() (filterNode, error) {
+	left, err := parser.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	switch operator := parser.peek(); operator {
+	case "==", "!=", "<", "<=", ">", ">=":
+		parser.next()
+		right, err := parser.parsePrimary()
+		return comparisonNode{operator: operator, left: left, right: right}, err
+	}
+
+	return left, nil
+}
+
+func (parser *filterParser) parsePrimary() (filterNode, error) {
+	token := parser.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of filter")
+	case token == "(":
+		inner, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if parser.next() != ")" {
+			return nil, fmt.Errorf("missing ) in filter")
+		}
+		return inner, nil
+	case strings.HasPrefix(token, `"`):
+		return literalNode{value: strings.Trim(token, `"`)}, nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		number, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in filter", token)
+		}
+		return literalNode{value: number}, nil
+	case token == "true" || token == "false":
+		return literalNode{value: token == "true"}, nil
+	}
+
+	for _, variable := range FilterVariables {
+		if token == variable {
+			return variableNode{name: token}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown variable %q in filter, expected one of %s", token, strings.Join(FilterVariables, ", "))
+}
+
+type literalNode struct {
+	value any
+}
+
+func (node literalNode) eval(vars map[string]any) (any, error) {
+	return node.value, nil
+}
+
+type variableNode struct {
+	name string
+}
+
+func (node variableNode) eval(vars map[string]any) (any, error) {
+	value, ok := vars[node.name]
+	if !ok {
+		return nil, fmt.Errorf("variable %q is not set", node.name)
+	}
+	return value, nil
+}
+
+type notNode struct {
+	operand filterNode
+}
+
+func (node notNode) eval(vars map[string]any) (any, error) {
+	value, err := node.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	boolean, ok := value.(bool)
+	if !ok {
+		return nil, fmt.Errorf("! expects a boolean")
+	}
+	return !boolean, nil
+}
+
+type logicalNode struct {
+	operator    string
+	left, right filterNode
+}
+
+func (node logicalNode) eval(vars map[string]any) (any, error) {
+	left, err := node.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	leftBool, ok := left.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%s expects booleans", node.operator)
+	}
+
+	// short circuit like Go does
+	if (node.operator == "&&" && !leftBool) || (node.operator == "||" && leftBool) {
+		return leftBool, nil
+	}
+
+	right, err := node.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	rightBool, ok := right.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%s expects booleans", node.operator)
+	}
+	return rightBool, nil
+}
+
+type comparisonNode struct {
+	operator    string
+	left, right filterNode
+}
+
+func (node comparisonNode) eval(vars map[string]any) (any, error) {
+	left, err := node.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := node.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	var compared int
+	switch leftValue := left.(type) {
+	case float64:
+		rightValue, ok := right.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare number with %v", right)
+		}
+		compared = compareOrdered(leftValue, rightValue)
+	case string:
+		rightValue, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare string with %v", right)
+		}
+		compared = strings.Compare(leftValue, rightValue)
+	case bool:
+		rightValue, ok := right.(bool)
+		if !ok || (node.operator != "==" && node.operator != "!=") {
+			return nil, fmt.Errorf("booleans can only be compared with == or !=")
+		}
+		if leftValue != rightValue {
+			compared = 1
+		}
+	}
+
+	switch node.operator {
+	case "==":
+		return compared == 0, nil
+	case "!=":
+		return compared != 0, nil
+	case "<":
+		return compared < 0, nil
+	case "<=":
+		return compared <= 0, nil
+	case ">":
+		return compared > 0, nil
+	default:
+		return compared >= 0, nil
+	}
+}
+
+func compareOrdered(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fuzzSource is the url the fuzzed playlists are read from.
+const fuzzSource = "http://localhost/stream/playlist.m3u8"
+
+var fuzzMediaPlaylists = []string{
+	datedPlaylist,
+	"#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.0,\ns0.ts\n#EXT-X-ENDLIST\n",
+	"#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:10\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:4.0,\ns10.m4s\n#EXT-X-DISCONTINUITY\n#EXT-X-MAP:URI=\"init2.mp4\",BYTERANGE=\"720@0\"\n#EXTINF:4.0,\n#EXT-X-BYTERANGE:1000@720\ns11.m4s\n",
+	"#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\",IV=0x00000000000000000000000000000001\n#EXTINF:6.0,\ns0.ts\n#EXT-X-KEY:METHOD=NONE\n#EXTINF:6.0,title\ns1.ts\n",
+	"#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=12\n#EXT-X-PART-INF:PART-TARGET=0.5\n#EXT-X-MEDIA-SEQUENCE:4\n#EXT-X-SKIP:SKIPPED-SEGMENTS=2\n#EXTINF:2.0,\ns6.ts\n#EXT-X-PART:DURATION=0.5,URI=\"s7.0.ts\",INDEPENDENT=YES\n#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"s7.1.ts\"\n",
+	"#EXTM3U\n#EXT-X-DEFINE:NAME=\"token\",VALUE=\"abc\"\n#EXT-X-TARGETDURATION:6\n#EXT-X-DATERANGE:ID=\"ad\",START-DATE=\"2024-01-01T10:00:00Z\",DURATION=30,CLASS=\"com.apple.hls.interstitial\",X-ASSET-URI=\"ad.m3u8\"\n#EXT-X-CUE-OUT:30\n#EXTINF:6.0,\ns0.ts?token={$token}\n#EXT-X-CUE-IN\n#EXT-X-GAP\n#EXTINF:6.0,\ns1.ts\n",
+	"\xef\xbb\xbf#EXTM3U\r\n#EXT-X-TARGETDURATION:6\r\n#EXTINF:6,\r\n\r\ns0.ts\r\n",
+	"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\nvideo.m3u8\n",
+}
+
+var fuzzMasterPlaylists = []string{
+	"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS=\"avc1.4d401e,mp4a.40.2\"\nlow.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=2400000,RESOLUTION=1280x720,FRAME-RATE=29.970\nhigh.m3u8\n",
+	"#EXTM3U\n#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\",LANGUAGE=\"en\",DEFAULT=YES,URI=\"audio/en.m3u8\"\n#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"Deutsch\",LANGUAGE=\"de\",URI=\"subs/de.m3u8\"\n#EXT-X-STREAM-INF:BANDWIDTH=800000,AUDIO=\"aac\",SUBTITLES=\"subs\"\nvideo.m3u8\n#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,URI=\"iframes.m3u8\"\n",
+	"#EXTM3U\n#EXT-X-DEFINE:NAME=\"cdn\",VALUE=\"https://cdn.example.com\"\n#EXT-X-SESSION-DATA:DATA-ID=\"com.example.title\",VALUE=\"Title\"\n#EXT-X-CONTENT-STEERING:SERVER-URI=\"steering.json\"\n#EXT-X-STREAM-INF:BANDWIDTH=800000,PATHWAY-ID=\"cdn-a\"\n{$cdn}/video.m3u8\n",
+	"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\n",
+}
+
+// isTypedError reports whether err is one the parsers document, rather than one leaking from their internals.
+func isTypedError(err error) bool {
+	var parseError *ParseError
+	var tagError TagError
+	var masterError *MasterPlaylistError
+	return errors.As(err, &parseError) || errors.As(err, &tagError) || errors.As(err, &masterError) ||
+		errors.Is(err, ErrBinaryPlaylist) || errors.Is(err, ErrLineTooLong)
+}
+
+func FuzzReadManifest(f *testing.F) {
+	for _, playlist := range fuzzMediaPlaylists {
+		f.Add(playlist, false)
+		f.Add(playlist, true)
+	}
+	f.Fuzz(func(t *testing.T, playlist string, strict bool) {
+		manifest, err := ReadManifestWithOptions(strings.NewReader(playlist), fuzzSource, ReadOptions{Strict: strict, Lenient: !strict})
+		if err != nil {
+			if !isTypedError(err) {
+				t.Fatalf("untyped error %T: %v", err, err)
+			}
+			return
+		}
+
+		// every segment is numbered one after the other from the media sequence on
+		sequence := manifest.MediaSequence
+		manifest.forEachEntry(func(discontinuityIndex int, entrySequence int, start time.Time, entry *ManifestEntry) {
+			if entrySequence != sequence {
+				t.Fatalf("segment %q numbered %d, want %d", entry.Url, entrySequence, sequence)
+			}
+			sequence++
+		})
+
+		// what was read is written back as a playlist reading the same segments
+		var written strings.Builder
+		if err := manifest.WriteManifest(&written); err != nil {
+			t.Fatalf("writing manifest: %v", err)
+		}
+		reread, err := ReadManifestWithOptions(strings.NewReader(written.String()), fuzzSource, ReadOptions{})
+		if err != nil {
+			t.Fatalf("reading written manifest: %v\n%s", err, written.String())
+		}
+		if got, want := entryUrls(reread), entryUrls(manifest); strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("written manifest lists %q, read %q\n%s", got, want, written.String())
+		}
+	})
+}
+
+func FuzzReadMasterPlaylist(f *testing.F) {
+	for _, playlist := range fuzzMasterPlaylists {
+		f.Add(playlist)
+	}
+	f.Fuzz(func(t *testing.T, playlist string) {
+		master, err := ReadMasterPlaylist(strings.NewReader(playlist), fuzzSource)
+		if err != nil {
+			if !isTypedError(err) {
+				t.Fatalf("untyped error %T: %v", err, err)
+			}
+			return
+		}
+		for _, variant := range master.Variants {
+			if variant.Uri == "" {
+				t.Fatalf("variant on line %d has no uri", variant.Line)
+			}
+		}
+	})
+}
+
+func FuzzParseAttributeList(f *testing.F) {
+	for _, list := range []string{
+		`TYPE=PART,URI="part.mp4"`,
+		`BANDWIDTH=800000,CODECS="avc1.4d401e,mp4a.40.2",RESOLUTION=640x360`,
+		`METHOD=AES-128,URI="key.bin",IV=0x00000000000000000000000000000001`,
+		`URI="unterminated`,
+		`=,==,"=",`,
+		``,
+	} {
+		f.Add(list)
+	}
+	f.Fuzz(func(t *testing.T, list string) {
+		attributes := ParseAttributeList(list)
+		if len(attributes) > strings.Count(list, "=") {
+			t.Fatalf("%d attributes parsed from %d equal signs", len(attributes), strings.Count(list, "="))
+		}
+
+		// attributes that can be written unambiguously are parsed back as they were
+		for _, attribute := range attributes {
+			if attribute.Key == "" || strings.ContainsAny(attribute.Key, `=,"`) || attribute.Key != strings.TrimSpace(attribute.Key) ||
+				strings.Contains(attribute.Value, `"`) || (!attribute.Quoted && strings.Contains(attribute.Value, ",")) ||
+				(!attribute.Quoted && strings.HasPrefix(attribute.Value, `"`)) {
+				return
+			}
+		}
+		reparsed := ParseAttributeList(attributes.String())
+		if reparsed.String() != attributes.String() || len(reparsed) != len(attributes) {
+			t.Fatalf("%q parsed as %q, written and parsed again as %q", list, attributes, reparsed)
+		}
+	})
+}
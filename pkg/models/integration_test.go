@@ -0,0 +1,134 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/testserver"
+)
+
+// readServedManifest reads the media playlist at playlistUrl from the test server.
+func readServedManifest(t *testing.T, playlistUrl string) *Manifest {
+	t.Helper()
+	manifest, err := ManifestCache{}.ReadManifest(playlistUrl)
+	if err != nil {
+		t.Fatalf("reading %s: %v", playlistUrl, err)
+	}
+	return manifest
+}
+
+func TestPlanDownload(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream testserver.Stream
+		files  []string
+	}{
+		{name: "ts", stream: testserver.Stream{Segments: 4}, files: []string{"seg0.ts", "seg1.ts", "seg2.ts", "seg3.ts"}},
+		{name: "fmp4", stream: testserver.Stream{Segments: 3, Fmp4: true}, files: []string{"init.mp4", "seg0.m4s", "seg1.m4s", "seg2.m4s"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := testserver.New()
+			defer server.Close()
+			manifest := readServedManifest(t, server.AddStream("vod", test.stream))
+
+			directory := t.TempDir()
+			plan := Plan(manifest, PlanOptions{Dir: directory})
+			if err := plan.Download(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			for sequence, file := range test.files[len(test.files)-test.stream.Segments:] {
+				b, err := os.ReadFile(filepath.Join(directory, file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(b, testserver.Payload(sequence, 4096, test.stream.Fmp4)) {
+					t.Errorf("%s differs from the segment served", file)
+				}
+			}
+			if test.stream.Fmp4 {
+				if _, err := os.Stat(filepath.Join(directory, "init.mp4")); err != nil {
+					t.Errorf("init segment not downloaded: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanDownloadEncrypted(t *testing.T) {
+	server := testserver.New()
+	defer server.Close()
+	manifest := readServedManifest(t, server.AddStream("vod", testserver.Stream{Segments: 3, Encrypted: true, SegmentSize: 1000}))
+
+	directory := t.TempDir()
+	plan := Plan(manifest, PlanOptions{Dir: directory, Container: ContainerTs, ConcatMode: ConcatSingle})
+	if err := plan.Download(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Process(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the segments are kept as served, the concatenated output is decrypted with the key fetched alongside them
+	if b, err := os.ReadFile(filepath.Join(directory, "seg1.ts")); err != nil || !bytes.Equal(b, testserver.Encrypt(testserver.Payload(1, 1000, false), 1)) {
+		t.Errorf("seg1.ts is not the encrypted segment served: %v", err)
+	}
+	var want []byte
+	for sequence := range 3 {
+		want = append(want, testserver.Payload(sequence, 1000, false)...)
+	}
+	if b, err := os.ReadFile(filepath.Join(directory, "output.ts")); err != nil || !bytes.Equal(b, want) {
+		t.Errorf("output.ts is not the decrypted segments: %v", err)
+	}
+	if requests := server.Requests("/vod/key.bin"); requests != 1 {
+		t.Errorf("key requested %d times, want once", requests)
+	}
+}
+
+func TestPlanDownloadFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		failure  testserver.Failure
+		requests int
+		failed   bool
+	}{
+		{name: "transient", failure: testserver.Failure{Status: http.StatusServiceUnavailable, Times: 2}, requests: 3},
+		{name: "retry after", failure: testserver.Failure{Status: http.StatusTooManyRequests, Times: 1, RetryAfter: time.Second}, requests: 2},
+		{name: "retries exhausted", failure: testserver.Failure{Status: http.StatusBadGateway}, requests: 3, failed: true},
+		{name: "permanent", failure: testserver.Failure{Status: http.StatusNotFound}, requests: 1, failed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := testserver.New()
+			defer server.Close()
+			manifest := readServedManifest(t, server.AddStream("vod", testserver.Stream{Segments: 3}))
+			server.Fail("/vod/seg1.ts", test.failure)
+
+			plan := Plan(manifest, PlanOptions{Dir: t.TempDir(), Retries: 2, RetryBackoff: 10 * time.Millisecond})
+			err := plan.Download(context.Background())
+
+			var downloadError *DownloadError
+			switch {
+			case !test.failed && err != nil:
+				t.Fatal(err)
+			case test.failed && !errors.As(err, &downloadError):
+				t.Fatalf("got %v, want a DownloadError", err)
+			case test.failed && (len(downloadError.Failed) != 1 || downloadError.Failed[0].File != "seg1.ts"):
+				t.Errorf("failed downloads %+v, want seg1.ts", downloadError.Failed)
+			}
+			if requests := server.Requests("/vod/seg1.ts"); requests != test.requests {
+				t.Errorf("seg1.ts requested %d times, want %d", requests, test.requests)
+			}
+			if requests := server.Requests("/vod/seg2.ts"); requests != 1 {
+				t.Errorf("seg2.ts requested %d times, want once", requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+)
+
+const (
+	// LiveJoinKeep records the live playlist from the first segment it lists.
+	LiveJoinKeep = "keep"
+	// LiveJoinDrop leaves out the first segment, which a player joining mid-segment would only see part of.
+	LiveJoinDrop = "drop"
+	// LiveJoinGop trims the first output to start at its first key frame, so it does not begin with broken frames.
+	LiveJoinGop = "gop"
+)
+
+// DefaultKeyframeTolerance is how far in seconds the first key frame may be from the start of an output for it to be
+// left as is.
+const DefaultKeyframeTolerance = 0.001
+
+// DropFirstSegment leaves out the first segment of the manifest, reporting whether there was one to drop. The media
+// sequence moves past it even when it was the only one, so a reload does not add it back, see Extend.
+func (manifest *Manifest) DropFirstSegment() bool {
+	first := -1
+	manifest.filterEntries(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) bool {
+		if first < 0 {
+			first = sequence
+			return false
+		}
+		return true
+	})
+	if first < 0 {
+		return false
+	}
+	manifest.MediaSequence = max(manifest.MediaSequence, first+1)
+	return true
+}
+
+// TrimToFirstKeyframe rewrites the first of files, the MP4 of the first discontinuity, in place to start at its first key
+// frame, leaving out the frames before it that cannot be decoded without the part of the GOP recorded before the join.
+// A first key frame within tolerance seconds of the start leaves the file as it is.
+func TrimToFirstKeyframe(ctx context.Context, files []string, tolerance float64) error {
+	if len(files) == 0 {
+		return nil
+	}
+	file := files[0]
+
+	keyframes, err := ffmpeg.Keyframes(ctx, file)
+	if err != nil || len(keyframes) == 0 {
+		return err
+	}
+	start, err := ffmpeg.StartTime(ctx, file)
+	if err != nil {
+		return err
+	}
+
+	offset := keyframes[0] - start
+	if offset < tolerance {
+		slog.Info("recording starts on a key frame", slog.String("file", file))
+		return nil
+	}
+
+	slog.Info("trimming recording to its first key frame", slog.String("file", file), slog.Float64("offset", offset))
+	trimmed := strings.TrimSuffix(file, ".mp4") + ".trim.mp4"
+	if err := ffmpeg.TrimMp4(ctx, file, trimmed, offset); err != nil {
+		return err
+	}
+	if !ffmpeg.DryRun(ctx) {
+		return os.Rename(trimmed, file)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/alehechka/manifestr/pkg/storage"
+)
+
+const TagKey string = "#EXT-X-KEY:"
+
+const (
+	KeyMethodNone      = "NONE"
+	KeyMethodAes128    = "AES-128"
+	KeyMethodSampleAes = "SAMPLE-AES"
+)
+
+// KeyFormatIdentity is the key format of keys served as the raw 16 bytes, the default when KEYFORMAT is omitted.
+const KeyFormatIdentity = "identity"
+
+// Key is an #EXT-X-KEY describing how the fragments that follow it are encrypted.
+type Key struct {
+	Method string
+	Uri    string
+	// IV is the explicit initialization vector, or nil when it is the media sequence number of each fragment.
+	IV        []byte
+	KeyFormat string
+	Line      int
+}
+
+// parseKey parses the attribute list of an #EXT-X-KEY, returning nil for METHOD=NONE.
+func parseKey(attributeList string, line int) (*Key, error) {
+	attributes := ParseAttributes(attributeList)
+	key := &Key{Method: attributes["METHOD"], Uri: attributes["URI"], KeyFormat: attributes["KEYFORMAT"], Line: line}
+
+	switch key.Method {
+	case KeyMethodNone:
+		return nil, nil
+	case KeyMethodAes128, KeyMethodSampleAes:
+	default:
+		return nil, fmt.Errorf("%w: unknown METHOD %q", ErrInvalidKey, key.Method)
+	}
+	if key.Uri == "" {
+		return nil, fmt.Errorf("%w: missing URI", ErrInvalidKey)
+	}
+
+	if iv, ok := attributes["IV"]; ok {
+		hexIv := strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X")
+		decoded, err := hex.DecodeString(fmt.Sprintf("%032s", hexIv))
+		if err != nil || len(decoded) != aes.BlockSize {
+			return nil, fmt.Errorf("%w: IV %q is not a 128-bit hexadecimal integer", ErrInvalidKey, iv)
+		}
+		key.IV = decoded
+	}
+
+	return key, nil
+}
+
+// IsIdentity reports whether the key itself is served by its uri, as opposed to being negotiated with a DRM system.
+func (key Key) IsIdentity() bool {
+	return key.KeyFormat == "" || key.KeyFormat == KeyFormatIdentity
+}
+
+// FileName is the name the key is saved to alongside the fragments.
+func (key Key) FileName() string {
+	return localName(key.Uri) + ".key"
+}
+
+// fragmentIv returns the initialization vector of the fragment with the given media sequence number.
+func (key Key) fragmentIv(sequence int) []byte {
+	if key.IV != nil {
+		return key.IV
+	}
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	return iv
+}
+
+// equal reports whether other is the same key, either of which may be nil.
+func (key *Key) equal(other *Key) bool {
+	if key == nil || other == nil {
+		return key == other
+	}
+	return key.Method == other.Method && key.Uri == other.Uri && key.KeyFormat == other.KeyFormat && bytes.Equal(key.IV, other.IV)
+}
+
+// tag writes the key as an #EXT-X-KEY with an explicit IV, referencing uri in place of the original uri.
+func (key Key) tag(uri string, iv []byte) string {
+	tag := fmt.Sprintf("%sMETHOD=%s,URI=%q,IV=0x%X", TagKey, key.Method, uri, iv)
+	if key.KeyFormat != "" {
+		tag += fmt.Sprintf(",KEYFORMAT=%q", key.KeyFormat)
+	}
+	return tag
+}
+
+// decryptFragment reads the fragment kept in fragments as fileName, decrypting it with the key kept alongside it when the
+// entry is encrypted. Only AES-128 with identity keys can be decrypted; SAMPLE-AES encrypts individual samples and needs a
+// player to decode.
+func decryptFragment(ctx context.Context, fragments storage.Storage, entry ManifestEntry, fileName string) ([]byte, error) {
+	data, err := storage.ReadFile(ctx, fragments, fileName)
+	if err != nil || entry.Key == nil {
+		return data, err
+	}
+	if entry.Key.Method != KeyMethodAes128 || !entry.Key.IsIdentity() {
+		return nil, fmt.Errorf("%w: %s fragments with key format %q cannot be decrypted", ErrUnsupportedEncryption, entry.Key.Method, entry.Key.KeyFormat)
+	}
+
+	secret, err := storage.ReadFile(ctx, fragments, entry.Key.FileName())
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidKey, err)
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%s: encrypted size %d is not a multiple of the AES block size", path.Base(fileName), len(data))
+	}
+
+	cipher.NewCBCDecrypter(block, entry.IV).CryptBlocks(data, data)
+
+	// strip the PKCS#7 padding
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(data[len(data)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, fmt.Errorf("%s: invalid padding, the key or IV is wrong", path.Base(fileName))
+	}
+	return data[:len(data)-padding], nil
+}
@@ -1,50 +1,270 @@
 package models
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
-	"manifestr/pkg/ffmpeg"
-	"manifestr/pkg/utils"
+	"math"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+	"github.com/alehechka/manifestr/pkg/storage"
+	"github.com/alehechka/manifestr/pkg/telemetry"
+	"github.com/alehechka/manifestr/pkg/utils"
+	"github.com/alehechka/manifestr/pkg/validate"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-const TimeFormat = "2006-01-02T15:04:05.999Z"
+// TimeFormat writes the dates of playlists as ISO 8601 with millisecond precision, keeping the offset they were read
+// with and writing Z for UTC.
+const TimeFormat = "2006-01-02T15:04:05.999Z07:00"
+
+// ParseTime parses the date of an #EXT-X-PROGRAM-DATE-TIME, which RFC 8216 allows with any ISO 8601 offset: Z, +02:00
+// or the basic +0200 form.
+func ParseTime(value string) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		// fractional seconds are accepted after the seconds even though the layout has none
+		if basic, basicErr := time.Parse("2006-01-02T15:04:05Z0700", value); basicErr == nil {
+			return basic, nil
+		}
+	}
+	return parsed, err
+}
+
+const (
+	PlaylistTypeEvent = "EVENT"
+	PlaylistTypeVod   = "VOD"
+)
 
 const (
-	TagOpener           string = "#EXTM3U"
-	TagBandwidth        string = "##X-BANDWIDTH:"
-	TagCodecs           string = "##X-CODECS:"
-	TagResolution       string = "##X-RESOLUTION:"
-	TagVersion          string = "#EXT-X-VERSION:"
-	TagMediaSequence    string = "#EXT-X-MEDIA-SEQUENCE:"
-	TagAllowCache       string = "#EXT-X-ALLOW-CACHE:"
-	TagTargetDuration   string = "#EXT-X-TARGETDURATION:"
-	TagDiscontinuity    string = "#EXT-X-DISCONTINUITY"
-	TagProgramDateTime  string = "#EXT-X-PROGRAM-DATE-TIME:"
-	TagInitFile         string = "#EXT-X-MAP:URI="
-	TagFragmentDuration string = "#EXTINF:"
-	TagEndList          string = "#EXT-X-ENDLIST"
+	TagOpener          string = "#EXTM3U"
+	TagBandwidth       string = "##X-BANDWIDTH:"
+	TagCodecs          string = "##X-CODECS:"
+	TagResolution      string = "##X-RESOLUTION:"
+	TagVersion         string = "#EXT-X-VERSION:"
+	TagIndependentSegs string = "#EXT-X-INDEPENDENT-SEGMENTS"
+	TagMediaSequence   string = "#EXT-X-MEDIA-SEQUENCE:"
+	// TagDiscontinuitySequence numbers the first discontinuity of a sliding window live playlist.
+	TagDiscontinuitySequence string = "#EXT-X-DISCONTINUITY-SEQUENCE:"
+	TagAllowCache            string = "#EXT-X-ALLOW-CACHE:"
+	TagTargetDuration        string = "#EXT-X-TARGETDURATION:"
+	TagDiscontinuity         string = "#EXT-X-DISCONTINUITY"
+	TagProgramDateTime       string = "#EXT-X-PROGRAM-DATE-TIME:"
+	TagInitFile              string = "#EXT-X-MAP:URI="
+	TagMap                   string = "#EXT-X-MAP:"
+	TagFragmentDuration      string = "#EXTINF:"
+	TagEndList               string = "#EXT-X-ENDLIST"
+	TagPart                  string = "#EXT-X-PART:"
+	TagPreloadHint           string = "#EXT-X-PRELOAD-HINT:"
+	TagServerControl         string = "#EXT-X-SERVER-CONTROL:"
+	TagSkip                  string = "#EXT-X-SKIP:"
+	TagPlaylistType          string = "#EXT-X-PLAYLIST-TYPE:"
 )
 
 type Manifest struct {
-	Version          int
-	MediaSequence    int
-	AllowCache       bool
-	TargetDuration   float64
-	Bandwidth        int
-	Codecs           string
-	ResolutionHeight int
-	ResolutionWidth  int
-	Discontinuities  []Discontinuity
-	BaseUrl          *url.URL
+	Version             int
+	MediaSequence       int
+	AllowCache          bool
+	HasAllowCache       bool
+	IndependentSegments bool
+	PlaylistType        string
+	EndList             bool
+	TargetDuration      float64
+	Bandwidth           int
+	Codecs              string
+	ResolutionHeight    int
+	ResolutionWidth     int
+	Discontinuities     []Discontinuity
+	PreloadHint         *PreloadHint
+	// CanSkipUntil is the CAN-SKIP-UNTIL server control in seconds; when non-zero the origin accepts delta update requests.
+	CanSkipUntil float64
+	// CanBlockReload is the CAN-BLOCK-RELOAD server control; when set the origin holds reloads asking for a segment it
+	// has yet to publish until it does, see BlockingReloadUrl.
+	CanBlockReload bool
+	// SkippedSegments is the number of segments an #EXT-X-SKIP replaced in a delta update, see ApplyDelta.
+	SkippedSegments int
+	// DiscontinuitySequence is the number of discontinuities a sliding window dropped before the first segment.
+	DiscontinuitySequence int
+	// SourceSequence is the media sequence number the playlist was published with, which stays as it was when Extend
+	// numbers the segments as the archive does.
+	SourceSequence int
+	// Resets counts the restarts of the origin Extend found while recording the playlist live.
+	Resets int
+	// sequenceOffset is what the media sequence numbers published since the last reset are shifted by to number the
+	// segments of the archive.
+	sequenceOffset int
+	// BaseUrl and the other fields excluded from JSON are restored or set up per run rather than cached, see
+	// ManifestCache.ReadManifest.
+	BaseUrl *url.URL `json:"-"`
+	// TagLines maps the name of each playlist-level tag (e.g. EXT-X-TARGETDURATION) to the line it was last found on, for diagnostics.
+	TagLines map[string]int
+	// Repairs lists the violations fixed while parsing in lenient mode.
+	Repairs []TagError `json:"-"`
+	// Checksums, when set, records the SHA-256 of every fragment as it is downloaded.
+	Checksums *utils.ChecksumIndex `json:"-"`
+	// Index, when set, records the checksum, CDN response headers and timing of every fragment downloaded.
+	Index *utils.ArchiveIndex `json:"-"`
+	// Statuses, when set, skips fragments already confirmed complete earlier in the session and forces failed ones to be downloaded again.
+	Statuses *utils.DownloadStatusCache `json:"-"`
+	// DeadLetters, when set, records the fragments that still fail after every retry, see DeadLetters.
+	DeadLetters *DeadLetters `json:"-"`
+	// DateRanges lists every #EXT-X-DATERANGE in the order they appear.
+	DateRanges []DateRange
+	// AdBreaks lists the ad breaks signalled by #EXT-X-CUE-OUT and #EXT-X-CUE-IN in the order they appear.
+	AdBreaks []AdBreak
+	// Assets are the external resources referenced by the playlist, see Asset.
+	Assets []Asset
+	// Variables are the #EXT-X-DEFINE variables substituted into the playlist while parsing.
+	Variables map[string]string
+	// Validate checks the structure of every fragment downloaded or kept, downloading invalid ones again, see validate.File.
+	Validate bool `json:"-"`
+	// Store, when set, shares init segments and keys with other runs, see utils.SharedStore.
+	Store *utils.SharedStore `json:"-"`
+	// Output, when set, receives the downloaded files and the concatenated outputs in place of the download directory,
+	// see storage.Open.
+	Output storage.Storage `json:"-"`
+	// MuxJournal, when set, skips the discontinuities already converted by a run of Process that failed, see MuxJournal.
+	MuxJournal *MuxJournal `json:"-"`
+	// Recording marks an archive live mode is still appending to, which is written as an EVENT playlist without #EXT-X-ENDLIST.
+	Recording bool `json:"-"`
+	// Renditions are the alternative audio and subtitle renditions downloaded into subfolders alongside the variant.
+	Renditions []Rendition `json:"-"`
+	// FailoverBaseUrls are equivalent origins tried in order whenever a download from BaseUrl fails.
+	FailoverBaseUrls []*url.URL `json:"-"`
+	// FallbackRules map fragment urls to backup origins tried once the primary and failover origins are exhausted, see DownloadPlan.Download.
+	FallbackRules []FallbackRule `json:"-"`
+	// Namer names the downloaded files, OriginalNamer when nil.
+	Namer Namer `json:"-"`
+	// MediaQuery is added to the query of every segment, init segment and key url, for CDNs that authenticate requests
+	// by query parameters rather than headers, see MediaUrl.
+	MediaQuery url.Values `json:"-"`
+}
+
+// AddFailoverUrls registers redundant manifest urls whose origins serve the same fragments as the primary.
+func (manifest *Manifest) AddFailoverUrls(manifestUrls ...string) {
+	for _, manifestUrl := range manifestUrls {
+		baseUrl, err := url.Parse(manifestUrl)
+		if err != nil {
+			slog.Error("failed to parse failover url", slog.String("url", manifestUrl), slog.String("error", err.Error()))
+			continue
+		}
+		baseUrl.Path = strings.TrimSuffix(baseUrl.Path, path.Base(baseUrl.Path))
+		manifest.FailoverBaseUrls = append(manifest.FailoverBaseUrls, baseUrl)
+	}
+}
+
+// downloadWithFailover downloads the resource at the relative url into options.Dir from the primary origin, falling back to each failover
+// origin in turn, or from the backup origins of FallbackRules when fallback is set, which always downloads it again. Encrypted fragments
+// are kept as served, so they cannot be validated. Shared files are taken from Store when it has them.
+func (manifest Manifest) downloadWithFailover(ctx context.Context, options PlanOptions, download PlannedDownload, fallback bool) (result utils.DownloadResult, err error) {
+	dir, forceDownload, logger := options.Dir, options.ForceDownload || download.Force || fallback, options.logger()
+	fileName, relativeUrl := download.File, download.Url
+	_, span := telemetry.Start(ctx, "download fragment", attribute.String("file", fileName), attribute.String("url", relativeUrl))
+	defer func() { telemetry.End(span, err) }()
+
+	statusUrl := relativeUrl
+	if resolved, err := ResolveUri(manifest.BaseUrl, relativeUrl); err == nil {
+		statusUrl = resolved.String()
+	}
+	cacheKey := download.ByteRange.cacheKey(resetKey(statusUrl, download.Resets))
+	if manifest.Statuses != nil {
+		switch manifest.Statuses.Get(cacheKey) {
+		case utils.DownloadComplete:
+			return utils.DownloadResult{Path: path.Join(dir, fileName), Skipped: true, Expected: -1}, nil
+		case utils.DownloadFailed:
+			forceDownload = true
+		}
+	}
+	defer func() {
+		if manifest.Statuses == nil {
+			return
+		}
+		if err != nil {
+			manifest.Statuses.Set(cacheKey, utils.DownloadFailed)
+		} else {
+			manifest.Statuses.Set(cacheKey, utils.DownloadComplete)
+		}
+	}()
+
+	if !forceDownload && manifest.Output == nil {
+		forceDownload = manifest.isStale(dir, fileName, statusUrl, download.ByteRange, options.downloadOptions(false))
+	}
+
+	useStore := manifest.Store != nil && download.Shared && manifest.CacheAllowed()
+	if useStore && !forceDownload {
+		if _, err := os.Stat(path.Join(dir, fileName)); errors.Is(err, fs.ErrNotExist) && manifest.Store.Get(cacheKey, path.Join(dir, fileName)) {
+			logger.Debug("reusing file from the shared store", slog.String("file", fileName), slog.String("url", cacheKey))
+		}
+	}
+
+	validateFile := manifest.Validate && !download.Encrypted
+	if !forceDownload && validateFile {
+		if err := validate.File(path.Join(dir, fileName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Warn("existing fragment is invalid, downloading again", slog.String("error", err.Error()))
+			forceDownload = true
+		}
+	}
+
+	candidates, err := manifest.candidateUrls(relativeUrl, statusUrl, fallback)
+	if err != nil {
+		return result, err
+	}
+
+	for attempt, candidate := range candidates {
+		// an earlier attempt may have left an invalid file behind, so later attempts must overwrite it
+		var offset, length int64
+		if download.ByteRange != nil {
+			offset, length = download.ByteRange.Offset, download.ByteRange.Length
+		}
+		downloadOptions := options.downloadOptions(forceDownload || attempt > 0)
+		downloadOptions.Offset, downloadOptions.Length = offset, length
+		if manifest.Output != nil {
+			result, err = utils.DownloadToStorage(ctx, manifest.Output, fileName, candidate, downloadOptions)
+		} else {
+			result, err = utils.DownloadFileWithResult(ctx, dir, fileName, candidate, downloadOptions)
+		}
+		if err == nil && validateFile {
+			err = validate.File(result.Path)
+		}
+		if err == nil {
+			if manifest.Checksums != nil && result.Checksum != "" {
+				manifest.Checksums.Set(fileName, result.Checksum)
+			}
+			if manifest.Index != nil && !result.Skipped {
+				manifest.Index.Record(fileName, downloadOptions.Downloader.RedactUrl(candidate), result)
+			}
+			if useStore && result.Checksum != "" && manifest.Store.Allows(result.Headers) {
+				if err := manifest.Store.Put(cacheKey, result.Path, result.Checksum); err != nil {
+					logger.Warn("failed to add file to the shared store", slog.String("file", fileName), slog.String("error", err.Error()))
+				}
+			}
+			return result, nil
+		}
+
+		if attempt < len(candidates)-1 {
+			logger.Warn("failing over to backup origin", slog.String("url", downloadOptions.Downloader.RedactUrl(candidate)), slog.String("error", err.Error()))
+		}
+	}
+
+	return result, err
+}
+
+// CacheAllowed reports whether the files of the playlist may be reused from and added to the shared store, which an
+// #EXT-X-ALLOW-CACHE:NO forbids unless the Policy of Store ignores it. HasAllowCache is unset without the tag, which
+// allows caching.
+func (manifest Manifest) CacheAllowed() bool {
+	return (manifest.Store != nil && manifest.Store.Policy == utils.StoreAlways) || !manifest.HasAllowCache || manifest.AllowCache
 }
 
 func (manifest Manifest) AllowCacheString() string {
@@ -54,6 +274,108 @@ func (manifest Manifest) AllowCacheString() string {
 	return "NO"
 }
 
+// MinimumVersion is the lowest EXT-X-VERSION compatible with the features the written manifest uses:
+// 3 for the decimal #EXTINF durations, 4 for #EXT-X-BYTERANGE, 5 for KEYFORMAT and 6 for #EXT-X-MAP outside of an I-frame playlist.
+func (manifest Manifest) MinimumVersion() int {
+	if manifest.IsFmp4() {
+		return 6
+	}
+	version := 3
+	for _, discontinuity := range manifest.Discontinuities {
+		for _, entry := range discontinuity.Entries {
+			if entry.Key != nil && entry.Key.KeyFormat != "" {
+				return 5
+			}
+			if entry.ByteRange != nil {
+				version = 4
+			}
+		}
+	}
+	return version
+}
+
+// IntegerTargetDuration is the #EXT-X-TARGETDURATION as the decimal integer the spec requires, raised where needed so that
+// every fragment duration rounded to the nearest integer fits within it.
+func (manifest Manifest) IntegerTargetDuration() int {
+	targetDuration := int(math.Ceil(manifest.TargetDuration))
+	for _, discontinuity := range manifest.Discontinuities {
+		for _, entry := range discontinuity.Entries {
+			targetDuration = max(targetDuration, int(math.Round(entry.Duration)))
+		}
+	}
+	return targetDuration
+}
+
+// isStale reports whether an existing download of fileName no longer matches what was recorded for it, see utils.IsStale.
+// Verification failures are logged and keep the existing file. A byteRange marks a file holding part of the resource at fileUrl.
+func (manifest Manifest) isStale(dir string, fileName string, fileUrl string, byteRange *ByteRange, options utils.DownloadOptions) bool {
+	recorded := utils.RecordedFile{Partial: byteRange != nil}
+	if byteRange != nil {
+		recorded.Size = byteRange.Length
+	}
+	if manifest.Checksums != nil {
+		recorded.Checksum = manifest.Checksums.Get(fileName)
+	}
+	if manifest.Index != nil {
+		if record, ok := manifest.Index.Get(fileName); ok {
+			recorded.ETag = record.Headers[http.CanonicalHeaderKey("ETag")]
+			if byteRange == nil {
+				recorded.Size, _ = strconv.ParseInt(record.Headers[http.CanonicalHeaderKey("Content-Length")], 10, 64)
+			}
+			if recorded.Checksum == "" {
+				recorded.Checksum = record.Sha256
+			}
+		}
+	}
+
+	stale, err := utils.IsStale(path.Join(dir, fileName), manifest.mediaUrl(fileUrl), recorded, options)
+	if err != nil {
+		slog.Warn("failed to verify existing file", slog.String("file", fileName), slog.String("url", fileUrl), slog.String("error", err.Error()))
+		return false
+	}
+	if stale {
+		slog.Info("existing file is stale, downloading again", slog.String("file", fileName), slog.String("policy", options.Verify))
+	}
+	return stale
+}
+
+// CanClipWithoutKeyframeScan reports whether every segment is guaranteed to start with a key frame, making it safe to cut at any segment boundary without probing the media.
+func (manifest Manifest) CanClipWithoutKeyframeScan() bool {
+	return manifest.IndependentSegments
+}
+
+// LastSequence returns the media sequence number of the newest fragment in the manifest.
+func (manifest Manifest) LastSequence() int {
+	sequence := manifest.MediaSequence - 1
+	for _, discontinuity := range manifest.Discontinuities {
+		sequence += len(discontinuity.Entries)
+	}
+	return sequence
+}
+
+// LastEntry returns the newest fragment in the manifest, or nil if it has none.
+func (manifest Manifest) LastEntry() *ManifestEntry {
+	for index := len(manifest.Discontinuities) - 1; index >= 0; index-- {
+		entries := manifest.Discontinuities[index].Entries
+		if len(entries) > 0 {
+			return entries[len(entries)-1]
+		}
+	}
+	return nil
+}
+
+// LiveEdgeTime returns the wall-clock time at which the newest fragment ends based on #EXT-X-PROGRAM-DATE-TIME, or the zero time when it is not reported.
+func (manifest Manifest) LiveEdgeTime() time.Time {
+	var edge time.Time
+	manifest.forEachEntry(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) {
+		edge = time.Time{}
+		if !start.IsZero() {
+			edge = start.Add(time.Duration(entry.Duration * float64(time.Second)))
+		}
+	})
+	return edge
+}
+
 func (manifest Manifest) IsFmp4() bool {
 	for _, discontinuity := range manifest.Discontinuities {
 		if discontinuity.InitFile != "" {
@@ -64,123 +386,382 @@ func (manifest Manifest) IsFmp4() bool {
 	return false
 }
 
-func (manifest Manifest) ConcatToMp4s(dir string) ([]string, error) {
+// ConcatToMp4s writes an MP4 of every discontinuity into dir, or Output when it is set, named by expanding outputName, see
+// OutputNames. Existing MP4s are resolved against policy. MPEG-TS fragments are transmuxed by ffmpeg, which needs them on disk.
+func (manifest Manifest) ConcatToMp4s(ctx context.Context, dir string, outputName string, policy utils.OutputPolicy) ([]string, error) {
 	files := make([]string, 0)
+	isFmp4 := manifest.IsFmp4()
+	if !isFmp4 && manifest.Output != nil {
+		return files, errors.New("MPEG-TS fragments can only be transmuxed into MP4 files in a local directory")
+	}
+	names := manifest.OutputNames(outputName)
+	fragments := manifest.Files(dir)
 
 	for index, discontinuity := range manifest.Discontinuities {
-		outFileName := fmt.Sprintf("d%04d", index)
-		if manifest.IsFmp4() {
-			outFileName += ".mp4"
-		} else {
-			outFileName += ".ts"
+		outputMp4 := names[index] + ".mp4"
+		source := ""
+		if manifest.MuxJournal != nil {
+			source = manifest.muxSource(dir, discontinuity)
+			if manifest.MuxJournal.converted(dir, outputMp4, source) {
+				slog.Info("resuming after converted output", slog.String("file", fragments.Location(outputMp4)))
+				files = append(files, fragments.Location(outputMp4))
+				continue
+			}
 		}
 
-		outFilePath := path.Join(dir, outFileName)
-		out, err := os.Create(outFilePath)
+		skip, err := manifest.resolveOutput(ctx, dir, outputMp4, policy)
 		if err != nil {
 			return files, err
 		}
-		defer out.Close()
+		if skip {
+			slog.Info("skipping existing output", slog.String("file", fragments.Location(outputMp4)))
+			files = append(files, fragments.Location(outputMp4))
+			continue
+		}
 
-		if discontinuity.InitFile != "" {
-			initFile, err := os.Open(path.Join(dir, discontinuity.InitFileName()))
-			if err != nil {
-				return files, err
-			}
-			if _, err := io.Copy(out, initFile); err != nil {
-				return files, err
-			}
+		outFile := outputMp4
+		if !isFmp4 {
+			outFile = names[index] + ".ts"
 		}
 
-		for _, entry := range discontinuity.Entries {
-			filename := entry.MpegTsFilename()
-			if manifest.IsFmp4() {
-				filename = entry.Fmp4Filename()
-			}
-			fragment, err := os.Open(path.Join(dir, filename))
-			if err != nil {
-				return files, err
-			}
-			if _, err := io.Copy(out, fragment); err != nil {
-				return files, err
-			}
+		err = storage.WriteFile(ctx, fragments, outFile, func(w io.Writer) error {
+			return manifest.concatDiscontinuity(ctx, fragments, discontinuity, w)
+		})
+		if err != nil {
+			return files, err
 		}
 
-		if manifest.IsFmp4() {
-			files = append(files, out.Name())
-		} else {
-			outputMp4 := fmt.Sprintf("%s.mp4", strings.TrimSuffix(outFileName, path.Ext(outFileName)))
-			if err := ffmpeg.TransmuxMpegTsBlob(outFilePath, outputMp4); err != nil {
+		if !isFmp4 {
+			if err := ffmpeg.TransmuxMpegTsBlob(ctx, path.Join(dir, outFile), path.Join(dir, outputMp4)); err != nil {
 				return files, err
 			}
-			files = append(files, outputMp4)
 		}
+		if err := manifest.MuxJournal.record(dir, outputMp4, source); err != nil {
+			return files, err
+		}
+		files = append(files, fragments.Location(outputMp4))
 	}
 
 	return files, nil
 }
 
-func (manifest Manifest) DownloadAllFragments(dir string, forceDownload bool) {
-	var wg sync.WaitGroup
+// Files is where the downloaded fragments are kept: Output when it is set, otherwise dir.
+func (manifest Manifest) Files(dir string) storage.Storage {
+	if manifest.Output != nil {
+		return manifest.Output
+	}
+	return storage.Local{Dir: dir}
+}
+
+// resolveOutput applies policy to the output name, see utils.OutputPolicy.Resolve. Objects in Output cannot be renamed,
+// so an existing one is replaced unless the policy skips it.
+func (manifest Manifest) resolveOutput(ctx context.Context, dir string, name string, policy utils.OutputPolicy) (skip bool, err error) {
+	if manifest.Output == nil {
+		return policy.Resolve(path.Join(dir, name))
+	}
+	if !policy.Skips() {
+		return false, nil
+	}
+	if _, err := manifest.Output.Size(ctx, name); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// concatDiscontinuity byte-concatenates the init file and fragments of a discontinuity, kept in fragments, into out.
+func (manifest Manifest) concatDiscontinuity(ctx context.Context, fragments storage.Storage, discontinuity Discontinuity, out io.Writer) error {
+	if discontinuity.InitFile != "" {
+		if err := appendFile(ctx, out, fragments, manifest.InitFileName(discontinuity)); err != nil {
+			return err
+		}
+	}
+
 	isFmp4 := manifest.IsFmp4()
+	for _, entry := range discontinuity.Entries {
+		if err := appendFragment(ctx, out, fragments, *entry, manifest.LocalFilename(*entry, isFmp4)); err != nil {
+			return err
+		}
+	}
 
-	for _, discontinuity := range manifest.Discontinuities {
-		if isFmp4 {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				initFileName := discontinuity.InitFileName()
-				initFileUrl := discontinuity.DynamicInitFile(manifest.BaseUrl).String()
-				if _, err := utils.DownloadFile(dir, initFileName, initFileUrl, forceDownload); err != nil {
-					slog.Error("failed to download fragment", slog.String("url", initFileUrl), slog.String("file", initFileName))
+	return nil
+}
+
+// ConcatToTs byte-concatenates every MPEG-TS fragment, across all discontinuities, into a single continuous .ts file in
+// dir, or Output when it is set, without invoking ffmpeg. An existing file is resolved against policy.
+func (manifest Manifest) ConcatToTs(ctx context.Context, dir string, policy utils.OutputPolicy) (string, error) {
+	if manifest.IsFmp4() {
+		return "", errors.New("fragmented MP4 manifests cannot be concatenated into a MPEG-TS file")
+	}
+
+	fragments := manifest.Files(dir)
+	outFilePath := fragments.Location("output.ts")
+	if skip, err := manifest.resolveOutput(ctx, dir, "output.ts", policy); err != nil || skip {
+		return outFilePath, err
+	}
+
+	err := storage.WriteFile(ctx, fragments, "output.ts", func(w io.Writer) error {
+		for _, discontinuity := range manifest.Discontinuities {
+			for _, entry := range discontinuity.Entries {
+				if err := appendFragment(ctx, w, fragments, *entry, manifest.MpegTsFilename(*entry)); err != nil {
+					return err
 				}
-			}()
+			}
 		}
+		return nil
+	})
+	return outFilePath, err
+}
 
-		for _, entry := range discontinuity.Entries {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				fileName := entry.MpegTsFilename()
-				if isFmp4 {
-					fileName = entry.Fmp4Filename()
-				}
+// appendFragment appends the fragment kept in fragments as fileName, decrypted when it is encrypted, see decryptFragment.
+func appendFragment(ctx context.Context, w io.Writer, fragments storage.Storage, entry ManifestEntry, fileName string) error {
+	if entry.Key == nil {
+		return appendFile(ctx, w, fragments, fileName)
+	}
+	data, err := decryptFragment(ctx, fragments, entry, fileName)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func appendFile(ctx context.Context, w io.Writer, fragments storage.Storage, fileName string) error {
+	file, err := fragments.Open(ctx, fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// ClipMp4s trims the files produced by ConcatToMp4s down to the window between start and end, measured from the start of the manifest. An end of 0 keeps everything after start.
+// Cut points are snapped back to a key frame so clips never begin mid-GOP: segment boundaries are used directly when the manifest declares independent segments, otherwise the media is probed.
+// With reencode the clips are cut exactly at start and end instead, re-encoding the partial GOPs at either end, see ffmpeg.ClipMp4Reencoded.
+// Existing clips are resolved against policy.
+func (manifest Manifest) ClipMp4s(ctx context.Context, files []string, start time.Duration, end time.Duration, reencode bool, policy utils.OutputPolicy) ([]string, error) {
+	clips := make([]string, 0)
+
+	offset := 0.0
+	for index, discontinuity := range manifest.Discontinuities {
+		if index >= len(files) {
+			break
+		}
+
+		runtime := discontinuity.Entries.Runtime()
+		localStart := start.Seconds() - offset
+		localEnd := runtime
+		if end > 0 {
+			localEnd = end.Seconds() - offset
+		}
+		offset += runtime
+
+		if localEnd <= 0 || localStart >= runtime {
+			continue
+		}
+		if localStart < 0 {
+			localStart = 0
+		}
+		if localEnd >= runtime {
+			localEnd = 0
+		}
 
-				fragmentUrl := entry.DynamicUrl(manifest.BaseUrl).String()
-				if _, err := utils.DownloadFile(dir, fileName, fragmentUrl, forceDownload); err != nil {
-					slog.Error("failed to download fragment", slog.String("url", fragmentUrl), slog.String("file", fileName))
+		var keyframes []float64
+		if localStart > 0 || (reencode && localEnd > 0) {
+			if manifest.CanClipWithoutKeyframeScan() {
+				keyframes = discontinuity.Entries.Boundaries()
+			} else {
+				var err error
+				if keyframes, err = ffmpeg.Keyframes(ctx, files[index]); err != nil {
+					return clips, err
 				}
-			}()
+			}
+		}
+		if localStart > 0 && !reencode {
+			localStart = ffmpeg.SnapToKeyframe(keyframes, localStart)
+		}
+
+		clipPath := strings.TrimSuffix(files[index], ".mp4") + ".clip.mp4"
+		if skip, err := policy.Resolve(clipPath); err != nil {
+			return clips, err
+		} else if skip {
+			clips = append(clips, clipPath)
+			continue
+		}
+		clip := ffmpeg.ClipMp4
+		if reencode {
+			clip = func(ctx context.Context, input string, output string, start float64, end float64) error {
+				return ffmpeg.ClipMp4Reencoded(ctx, input, output, start, end, keyframes)
+			}
+		}
+		if err := clip(ctx, files[index], clipPath, localStart, localEnd); err != nil {
+			return clips, err
 		}
+		clips = append(clips, clipPath)
 	}
 
-	wg.Wait()
+	return clips, nil
 }
 
-func ReadManifestFromFile(manifestPath string, sourceUrl string) (*Manifest, error) {
+// MergeMp4s joins files, the MP4s of consecutive discontinuities, into a single MP4 at output, see ffmpeg.ConcatMp4s.
+// An existing output is resolved against policy. The files are removed once merged.
+func MergeMp4s(ctx context.Context, files []string, output string, policy utils.OutputPolicy) error {
+	if skip, err := policy.Resolve(output); err != nil || skip {
+		return err
+	}
+
+	slog.Info("merging discontinuities", slog.String("file", output), slog.Int("discontinuities", len(files)))
+	if err := ffmpeg.ConcatMp4s(ctx, files, output); err != nil {
+		return err
+	}
+	if ffmpeg.DryRun(ctx) {
+		return nil
+	}
+
+	var errs []error
+	for _, file := range files {
+		errs = append(errs, os.Remove(file))
+	}
+	return errors.Join(errs...)
+}
+
+// DownloadAllFragments downloads every fragment into options.Dir, see DownloadPlan.Download. Zero Retries and RetryBackoff
+// default to DefaultRetries and DefaultRetryBackoff, a negative Retries disables retrying. The results list every planned
+// file in playlist order, whether or not it failed.
+func (manifest Manifest) DownloadAllFragments(ctx context.Context, options PlanOptions) ([]FragmentResult, error) {
+	if options.Retries == 0 {
+		options.Retries = DefaultRetries
+	}
+	if options.RetryBackoff == 0 {
+		options.RetryBackoff = DefaultRetryBackoff
+	}
+	plan := Plan(&manifest, options)
+	err := plan.Download(ctx)
+	return plan.Results, err
+}
+
+// ReadOptions controls how ReadManifestWithOptions interprets a playlist.
+type ReadOptions struct {
+	// Strict makes parsing fail with a *ParseError listing every unrecognized or malformed tag instead of silently ignoring them.
+	Strict bool
+	// Lenient repairs common real-world violations instead of misreading them, recording each fix in Manifest.Repairs.
+	Lenient bool
+	// Imports are the variables of the parent playlist available to #EXT-X-DEFINE:IMPORT.
+	Imports map[string]string
+	// Namer and MediaQuery are set on the manifest read, so the playlists of a run, its renditions and reloads name and
+	// fetch their files alike, see Manifest.Namer and Manifest.MediaQuery.
+	Namer      Namer
+	MediaQuery url.Values
+}
+
+func ReadManifestFromFile(manifestPath string, sourceUrl string, options ReadOptions) (*Manifest, error) {
 	manifestFile, err := os.Open(manifestPath)
 	if err != nil {
 		return nil, err
 	}
 	defer manifestFile.Close()
 
-	return ReadManifest(manifestFile, sourceUrl)
+	return ReadManifestWithOptions(manifestFile, sourceUrl, options)
 }
 
 func ReadManifest(r io.Reader, sourceUrl string) (*Manifest, error) {
-	manifest := new(Manifest)
+	return ReadManifestWithOptions(r, sourceUrl, ReadOptions{})
+}
+
+func ReadManifestWithOptions(r io.Reader, sourceUrl string, options ReadOptions) (*Manifest, error) {
+	manifest := &Manifest{Namer: options.Namer, MediaQuery: options.MediaQuery}
 
-	manifest.BaseUrl, _ = url.Parse(sourceUrl)
+	var err error
+	if manifest.BaseUrl, err = url.Parse(sourceUrl); err != nil {
+		return nil, fmt.Errorf("invalid source url: %w", err)
+	}
 	manifest.BaseUrl.Path = strings.TrimSuffix(manifest.BaseUrl.Path, path.Base(manifest.BaseUrl.Path))
 
-	scanner := bufio.NewScanner(r)
+	r, err = decodePlaylist(r)
+	if err != nil {
+		return nil, err
+	}
+	scanner := newPlaylistScanner(r)
+
+	lineNumber := 0
+	// unscanned is set when a line was read ahead and is to be parsed again by the next scan
+	unscanned := false
+	scan := func() bool {
+		if unscanned {
+			unscanned = false
+			return true
+		}
+		lineNumber++
+		return scanner.Scan()
+	}
+
+	tagErrors := make([]TagError, 0)
+	invalid := func(line string, err error) {
+		if err != nil {
+			tagErrors = append(tagErrors, TagError{Line: lineNumber, Tag: tagName(line), Err: err})
+		}
+	}
+	repaired := func(line string, err error) {
+		manifest.Repairs = append(manifest.Repairs, TagError{Line: lineNumber, Tag: tagName(line), Err: err})
+	}
 
+	vars := make(variables)
+	manifest.Variables = vars
+	// text returns the current line with the defined variables substituted
+	text := func() string {
+		line, err := vars.substitute(scanner.Text())
+		invalid(line, err)
+		return line
+	}
+
+	var key *Key
+	// byteRange is the pending #EXT-X-BYTERANGE of the next segment and previousRange the range of the segment before it
+	var byteRange string
+	var previousRange *ManifestEntry
+	// programDateTime is the pending #EXT-X-PROGRAM-DATE-TIME of the next segment
+	var programDateTime time.Time
+	segments := 0
+	// variants are only collected to explain the error when a master playlist is read by mistake
+	var variants []Variant
+
+	manifest.TagLines = make(map[string]int)
 	manifest.Discontinuities = make([]Discontinuity, 1)
-	for scanner.Scan() {
+	for scan() {
 		line := scanner.Text()
+		var err error
+
+		if strings.HasPrefix(line, TagDefine) {
+			manifest.TagLines[tagName(line)] = lineNumber
+			invalid(line, vars.define(strings.TrimPrefix(line, TagDefine), manifest.BaseUrl, options.Imports))
+			continue
+		}
+		line = text()
+
+		if strings.HasPrefix(line, "#EXT") && !strings.HasPrefix(line, TagFragmentDuration) {
+			manifest.TagLines[tagName(line)] = lineNumber
+		}
+
+		if strings.HasPrefix(line, "#EXT") {
+			if err := checkAttributeCount(line); err != nil {
+				invalid(line, err)
+				continue
+			}
+		}
+
+		if lineNumber == 1 && line != TagOpener {
+			if options.Lenient {
+				repaired(TagOpener, ErrMissingHeader)
+			} else {
+				invalid(TagOpener, ErrMissingHeader)
+			}
+		}
 
 		if strings.HasPrefix(line, TagBandwidth) {
-			manifest.Bandwidth, _ = strconv.Atoi(strings.TrimPrefix(line, TagBandwidth))
+			manifest.Bandwidth, err = strconv.Atoi(strings.TrimPrefix(line, TagBandwidth))
+			invalid(line, err)
 			continue
 		}
 
@@ -191,71 +772,296 @@ func ReadManifest(r io.Reader, sourceUrl string) (*Manifest, error) {
 
 		if strings.HasPrefix(line, TagResolution) {
 			resolution := strings.Split(strings.TrimPrefix(line, TagResolution), "x")
-			manifest.ResolutionWidth, _ = strconv.Atoi(resolution[0])
+			manifest.ResolutionWidth, err = strconv.Atoi(resolution[0])
+			invalid(line, err)
 			if len(resolution) > 1 {
-				manifest.ResolutionHeight, _ = strconv.Atoi(resolution[1])
+				manifest.ResolutionHeight, err = strconv.Atoi(resolution[1])
+				invalid(line, err)
 			}
 			continue
 		}
 
 		if strings.HasPrefix(line, TagVersion) {
-			manifest.Version, _ = strconv.Atoi(strings.TrimPrefix(line, TagVersion))
+			manifest.Version, err = strconv.Atoi(strings.TrimPrefix(line, TagVersion))
+			invalid(line, err)
+			continue
+		}
+
+		if line == TagIndependentSegs {
+			manifest.IndependentSegments = true
+			continue
+		}
+
+		if strings.HasPrefix(line, TagPlaylistType) {
+			manifest.PlaylistType = strings.TrimPrefix(line, TagPlaylistType)
 			continue
 		}
 
 		if strings.HasPrefix(line, TagMediaSequence) {
-			manifest.MediaSequence, _ = strconv.Atoi(strings.TrimPrefix(line, TagMediaSequence))
+			manifest.MediaSequence, err = strconv.Atoi(strings.TrimPrefix(line, TagMediaSequence))
+			manifest.SourceSequence = manifest.MediaSequence
+			invalid(line, err)
+			continue
+		}
+
+		if strings.HasPrefix(line, TagDiscontinuitySequence) {
+			manifest.DiscontinuitySequence, err = strconv.Atoi(strings.TrimPrefix(line, TagDiscontinuitySequence))
+			invalid(line, err)
 			continue
 		}
 
 		if strings.HasPrefix(line, TagAllowCache) {
 			manifest.AllowCache = strings.TrimPrefix(line, TagAllowCache) == "YES"
+			manifest.HasAllowCache = true
 			continue
 		}
 
 		if strings.HasPrefix(line, TagTargetDuration) {
-			manifest.TargetDuration, _ = strconv.ParseFloat(strings.TrimPrefix(line, TagTargetDuration), 64)
+			manifest.TargetDuration, err = parseDuration(strings.TrimPrefix(line, TagTargetDuration))
+			invalid(line, err)
+			continue
+		}
+
+		if line == TagEndList {
+			manifest.EndList = true
 			continue
 		}
 
 		if line == TagDiscontinuity {
-			manifest.Discontinuities = append(manifest.Discontinuities, Discontinuity{})
+			manifest.Discontinuities = append(manifest.Discontinuities, Discontinuity{Line: lineNumber})
 			continue
 		}
 
 		lastIndex := len(manifest.Discontinuities) - 1
 		if strings.HasPrefix(line, TagProgramDateTime) {
-			var err error
-			manifest.Discontinuities[lastIndex].ProgramDateTime, err = time.Parse(TimeFormat, strings.TrimPrefix(line, TagProgramDateTime))
+			programDateTime, err = ParseTime(strings.TrimPrefix(line, TagProgramDateTime))
 			if err != nil {
-				slog.Error("failed to parse program date time", slog.String("error", err.Error()), slog.Time("time", manifest.Discontinuities[lastIndex].ProgramDateTime))
+				slog.Error("failed to parse program date time", slog.String("error", err.Error()), slog.String("line", line))
 			}
+			invalid(line, err)
 			continue
 		}
 
-		if strings.HasPrefix(line, TagInitFile) {
-			initFileName := strings.TrimPrefix(line, TagInitFile)
-			initFileName = strings.TrimPrefix(initFileName, `"`)
-			initFileName = strings.TrimSuffix(initFileName, `"`)
-			manifest.Discontinuities[lastIndex].InitFile = initFileName
+		if strings.HasPrefix(line, TagMap) {
+			attributes := ParseAttributes(strings.TrimPrefix(line, TagMap))
+			manifest.Discontinuities[lastIndex].InitFile = attributes["URI"]
+			manifest.Discontinuities[lastIndex].InitFileLine = lineNumber
+			manifest.Discontinuities[lastIndex].InitByteRange = nil
+			if value, ok := attributes["BYTERANGE"]; ok {
+				// the offset of an init segment defaults to the start of the resource
+				manifest.Discontinuities[lastIndex].InitByteRange, err = parseByteRange(value, &ByteRange{})
+				invalid(line, err)
+			}
 			continue
 		}
 
-		if strings.HasPrefix(line, TagFragmentDuration) {
-			manifestEntry := new(ManifestEntry)
-			manifestEntry.Duration, _ = strconv.ParseFloat(strings.TrimSuffix(strings.TrimPrefix(line, TagFragmentDuration), ","), 64)
+		if strings.HasPrefix(line, TagByteRange) {
+			byteRange = strings.TrimPrefix(line, TagByteRange)
+			continue
+		}
+
+		if strings.HasPrefix(line, TagKey) {
+			key, err = parseKey(strings.TrimPrefix(line, TagKey), lineNumber)
+			invalid(line, err)
+			continue
+		}
+
+		if strings.HasPrefix(line, TagStreamInf) {
+			variant, err := parseVariant(strings.TrimPrefix(line, TagStreamInf), lineNumber)
+			invalid(line, err)
+			if scan() {
+				variant.Uri = strings.TrimSpace(text())
+			}
+			variants = append(variants, variant)
+			continue
+		}
+
+		// CUE-OUT-CONT shares the prefix of CUE-OUT, so it is matched first
+		if strings.HasPrefix(line, TagCueOutCont) {
+			// a continuation only opens a break when the cue out is no longer in the playlist
+			if len(manifest.AdBreaks) == 0 || manifest.AdBreaks[len(manifest.AdBreaks)-1].LastSequence >= 0 {
+				adBreak, err := parseCueOutCont(strings.TrimPrefix(strings.TrimPrefix(line, TagCueOutCont), ":"), manifest.MediaSequence+segments, lineNumber)
+				invalid(line, err)
+				manifest.AdBreaks = append(manifest.AdBreaks, adBreak)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, TagCueOut) {
+			adBreak, err := parseCueOut(strings.TrimPrefix(strings.TrimPrefix(line, TagCueOut), ":"), manifest.MediaSequence+segments, lineNumber)
+			invalid(line, err)
+			manifest.AdBreaks = append(manifest.AdBreaks, adBreak)
+			continue
+		}
+
+		if strings.HasPrefix(line, TagCueIn) {
+			if last := len(manifest.AdBreaks) - 1; last >= 0 && manifest.AdBreaks[last].LastSequence < 0 {
+				manifest.AdBreaks[last].LastSequence = manifest.MediaSequence + segments - 1
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, TagPart) {
+			attributes := ParseAttributes(strings.TrimPrefix(line, TagPart))
+			part := &ManifestEntry{Line: lineNumber}
+			part.Duration, err = parseDuration(attributes["DURATION"])
+			invalid(line, err)
+			part.Url = attributes["URI"]
+			if value, ok := attributes["BYTERANGE"]; ok {
+				// without an offset the part continues where the previous part of the same resource ended
+				var previous *ByteRange
+				if parts := manifest.Discontinuities[lastIndex].Parts; len(parts) > 0 && parts[len(parts)-1].Url == part.Url {
+					previous = parts[len(parts)-1].ByteRange
+				}
+				part.ByteRange, err = parseByteRange(value, previous)
+				invalid(line, err)
+			}
+			manifest.Discontinuities[lastIndex].Parts = append(manifest.Discontinuities[lastIndex].Parts, part)
+			continue
+		}
+
+		if strings.HasPrefix(line, TagServerControl) {
+			attributes := ParseAttributes(strings.TrimPrefix(line, TagServerControl))
+			if canSkipUntil, ok := attributes["CAN-SKIP-UNTIL"]; ok {
+				manifest.CanSkipUntil, err = strconv.ParseFloat(canSkipUntil, 64)
+				invalid(line, err)
+			}
+			manifest.CanBlockReload = attributes["CAN-BLOCK-RELOAD"] == "YES"
+			continue
+		}
+
+		if strings.HasPrefix(line, TagSkip) {
+			attributes := ParseAttributes(strings.TrimPrefix(line, TagSkip))
+			manifest.SkippedSegments, err = strconv.Atoi(attributes["SKIPPED-SEGMENTS"])
+			invalid(line, err)
+			continue
+		}
 
-			if !scanner.Scan() {
+		if strings.HasPrefix(line, TagPreloadHint) {
+			attributes := ParseAttributes(strings.TrimPrefix(line, TagPreloadHint))
+			manifest.PreloadHint = &PreloadHint{Type: attributes["TYPE"], Uri: attributes["URI"], Line: lineNumber}
+			if start, ok := attributes["BYTERANGE-START"]; ok {
+				hint := manifest.PreloadHint
+				hint.OpenEnded = true
+				hint.ByteRange = &ByteRange{}
+				hint.ByteRange.Offset, err = strconv.ParseInt(start, 10, 64)
+				invalid(line, err)
+				if length, ok := attributes["BYTERANGE-LENGTH"]; ok {
+					hint.OpenEnded = false
+					hint.ByteRange.Length, err = strconv.ParseInt(length, 10, 64)
+					invalid(line, err)
+				}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, TagDateRange) {
+			dateRange, err := parseDateRange(strings.TrimPrefix(line, TagDateRange), lineNumber)
+			invalid(line, err)
+			if err == nil {
+				manifest.DateRanges = append(manifest.DateRanges, dateRange)
+			}
+			manifest.Assets = append(manifest.Assets, parseAssets(line, lineNumber)...)
+			continue
+		}
+
+		if assets := parseAssets(line, lineNumber); assets != nil {
+			manifest.Assets = append(manifest.Assets, assets...)
+			continue
+		}
+
+		if strings.HasPrefix(line, TagFragmentDuration) {
+			// parts listed so far belong to this now completed segment
+			manifest.Discontinuities[lastIndex].Parts = nil
+
+			manifestEntry := &ManifestEntry{Line: lineNumber}
+			duration, title, _ := strings.Cut(strings.TrimPrefix(line, TagFragmentDuration), ",")
+			manifestEntry.Title = strings.TrimSpace(title)
+			if options.Lenient {
+				if fields := strings.Fields(duration + " " + title); len(fields) > 1 && looksLikeUri(fields[len(fields)-1]) {
+					duration = strings.TrimSuffix(fields[0], ",")
+					manifestEntry.Url = fields[len(fields)-1]
+					manifestEntry.Title = ""
+					repaired(line, ErrInlineUri)
+				}
+			}
+			manifestEntry.Duration, err = parseDuration(duration)
+			invalid(line, err)
+
+			for manifestEntry.Url == "" && scan() {
+				uri := scanner.Text()
+				switch {
+				case strings.HasPrefix(uri, TagByteRange):
+					// the byte range of a segment may also sit between its #EXTINF and its uri
+					manifest.TagLines[tagName(uri)] = lineNumber
+					byteRange = strings.TrimPrefix(text(), TagByteRange)
+					continue
+				case options.Lenient && strings.TrimSpace(uri) == "":
+					repaired(line, ErrBlankLine)
+					continue
+				case strings.HasPrefix(uri, "#EXT"):
+					// another tag means the uri is missing, the tag is parsed as usual by the next scan
+					unscanned = true
+				case strings.HasPrefix(uri, "#"):
+					continue
+				default:
+					manifestEntry.Url = strings.TrimSpace(text())
+				}
 				break
 			}
-			manifestEntry.Url = scanner.Text()
+			if manifestEntry.Url == "" {
+				tagErrors = append(tagErrors, TagError{Line: manifestEntry.Line, Tag: tagName(line), Err: ErrMissingUri})
+				continue
+			}
+
+			if byteRange != "" {
+				var previous *ByteRange
+				if previousRange != nil && previousRange.Url == manifestEntry.Url {
+					previous = previousRange.ByteRange
+				}
+				manifestEntry.ByteRange, err = parseByteRange(byteRange, previous)
+				invalid(TagByteRange+byteRange, err)
+				byteRange = ""
+			}
+			previousRange = manifestEntry
+
+			// the segments an #EXT-X-SKIP replaced come first, see ApplyDelta
+			manifestEntry.Sequence = manifest.MediaSequence + manifest.SkippedSegments + segments
+			segments++
+			if key != nil {
+				manifestEntry.Key = key
+				manifestEntry.IV = key.fragmentIv(manifestEntry.Sequence)
+			}
 
-			manifest.Discontinuities[lastIndex].Entries = append(manifest.Discontinuities[lastIndex].Entries, manifestEntry)
+			// the tag dates the segment following it, the discontinuity starts at the first one dated, counted back to its
+			// first segment
+			discontinuity := &manifest.Discontinuities[lastIndex]
+			manifestEntry.ProgramDateTime, programDateTime = programDateTime, time.Time{}
+			if discontinuity.ProgramDateTime.IsZero() && !manifestEntry.ProgramDateTime.IsZero() {
+				discontinuity.ProgramDateTime = manifestEntry.ProgramDateTime.Add(-discontinuityRuntime(*discontinuity))
+			}
+			discontinuity.Entries = append(discontinuity.Entries, manifestEntry)
 			continue
 		}
+
+		if strings.HasPrefix(line, "#EXT") && !knownTags[tagName(line)] {
+			invalid(line, ErrUnrecognizedTag)
+		}
 	}
 
-	return manifest, scanner.Err()
+	if err := scanError(scanner, lineNumber); err != nil {
+		return manifest, err
+	}
+
+	if segments == 0 && len(variants) > 0 {
+		return manifest, &MasterPlaylistError{BaseUrl: manifest.BaseUrl, Variants: variants}
+	}
+
+	if options.Strict && len(tagErrors) > 0 {
+		return manifest, &ParseError{Errors: tagErrors}
+	}
+
+	return manifest, nil
 }
 
 func (manifest *Manifest) WriteLocalManifestToFile(dir string) error {
@@ -267,7 +1073,17 @@ func (manifest *Manifest) WriteLocalManifestToFile(dir string) error {
 	return manifest.WriteLocalManifest(manifestFile)
 }
 
+// WriteLocalManifest writes the manifest pointing at the downloaded fragments in its directory.
 func (manifest *Manifest) WriteLocalManifest(w io.Writer) error {
+	return manifest.writeManifest(w, true)
+}
+
+// WriteManifest writes the manifest with its fragment and init file uris as they were parsed or generated.
+func (manifest *Manifest) WriteManifest(w io.Writer) error {
+	return manifest.writeManifest(w, false)
+}
+
+func (manifest *Manifest) writeManifest(w io.Writer, local bool) error {
 	if _, err := w.Write([]byte(TagOpener + "\n")); err != nil {
 		return err
 	}
@@ -290,44 +1106,122 @@ func (manifest *Manifest) WriteLocalManifest(w io.Writer) error {
 		}
 	}
 
-	if _, err := w.Write([]byte(fmt.Sprintf("%s%d\n", TagVersion, manifest.Version))); err != nil {
+	if _, err := w.Write([]byte(fmt.Sprintf("%s%d\n", TagVersion, manifest.MinimumVersion()))); err != nil {
 		return err
 	}
 
-	if _, err := w.Write([]byte(fmt.Sprintf("%s%d\n", TagMediaSequence, manifest.MediaSequence))); err != nil {
+	// the playlist is written complete with #EXT-X-ENDLIST unless it is still being recorded
+	playlistType := PlaylistTypeVod
+	if manifest.Recording {
+		playlistType = PlaylistTypeEvent
+	}
+	if _, err := w.Write([]byte(TagPlaylistType + playlistType + "\n")); err != nil {
 		return err
 	}
 
-	if _, err := w.Write([]byte(fmt.Sprintf("%s%s\n", TagAllowCache, manifest.AllowCacheString()))); err != nil {
+	if manifest.IndependentSegments {
+		if _, err := w.Write([]byte(TagIndependentSegs + "\n")); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.Write([]byte(fmt.Sprintf("%s%d\n", TagMediaSequence, manifest.MediaSequence))); err != nil {
 		return err
 	}
 
-	if _, err := w.Write([]byte(fmt.Sprintf("%s%f\n", TagTargetDuration, manifest.TargetDuration))); err != nil {
+	if manifest.HasAllowCache {
+		if _, err := w.Write([]byte(fmt.Sprintf("%s%s\n", TagAllowCache, manifest.AllowCacheString()))); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.Write([]byte(fmt.Sprintf("%s%d\n", TagTargetDuration, manifest.IntegerTargetDuration()))); err != nil {
 		return err
 	}
 
+	// every date range is written before the segment it starts in, or at the end when it starts after the last one
+	written := make([]bool, len(manifest.DateRanges))
+	writeDateRanges := func(before time.Time) error {
+		for index, dateRange := range manifest.DateRanges {
+			if written[index] || (!before.IsZero() && !dateRange.StartDate.Before(before)) {
+				continue
+			}
+			written[index] = true
+			if _, err := w.Write([]byte(dateRange.Tag() + "\n")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	isFmp4 := manifest.IsFmp4()
+	var key *Key
+	var iv []byte
 	for index, discontinuity := range manifest.Discontinuities {
 		if index > 0 {
 			if _, err := w.Write([]byte(TagDiscontinuity + "\n")); err != nil {
 				return err
 			}
 		}
-		if _, err := w.Write([]byte(fmt.Sprintf("%s%s\n", TagProgramDateTime, discontinuity.ProgramDateTime.Format(TimeFormat)))); err != nil {
-			return err
+		if !discontinuity.ProgramDateTime.IsZero() && !discontinuity.ProgramDateTimeInferred {
+			if _, err := w.Write([]byte(fmt.Sprintf("%s%s\n", TagProgramDateTime, discontinuity.ProgramDateTime.Format(TimeFormat)))); err != nil {
+				return err
+			}
 		}
-		if _, err := w.Write([]byte(fmt.Sprintf("%s\"%s\"\n", TagInitFile, discontinuity.InitFileName()))); err != nil {
-			return err
+		if discontinuity.InitFile != "" {
+			initTag := fmt.Sprintf("%s\"%s\"", TagInitFile, discontinuity.InitFile)
+			if local {
+				// ranges are downloaded to files of their own, so the local manifest references them whole
+				initTag = fmt.Sprintf("%s\"%s\"", TagInitFile, url.PathEscape(manifest.InitFileName(discontinuity)))
+			} else if discontinuity.InitByteRange != nil {
+				initTag += fmt.Sprintf(",BYTERANGE=\"%s\"", discontinuity.InitByteRange)
+			}
+			if _, err := w.Write([]byte(initTag + "\n")); err != nil {
+				return err
+			}
 		}
 
-		for _, entry := range discontinuity.Entries {
+		start := discontinuity.ProgramDateTime
+		for index, entry := range discontinuity.Entries {
+			if !entry.ProgramDateTime.IsZero() {
+				start = entry.ProgramDateTime
+				// the first fragment is dated by the tag of the discontinuity written above
+				if index > 0 {
+					if _, err := w.Write([]byte(fmt.Sprintf("%s%s\n", TagProgramDateTime, start.Format(TimeFormat)))); err != nil {
+						return err
+					}
+				}
+			}
+			var end time.Time
+			if !start.IsZero() {
+				end = start.Add(time.Duration(entry.Duration * float64(time.Second)))
+			}
+			if err := writeDateRanges(end); err != nil {
+				return err
+			}
+			start = end
+
+			if !key.equal(entry.Key) || !bytes.Equal(entry.IV, iv) {
+				key, iv = entry.Key, entry.IV
+				if _, err := w.Write([]byte(manifest.keyTag(key, iv, local) + "\n")); err != nil {
+					return err
+				}
+			}
+
 			if _, err := w.Write([]byte(fmt.Sprintf("%s%f,\n", TagFragmentDuration, entry.Duration))); err != nil {
 				return err
 			}
 
-			fileName := entry.MpegTsFilename()
-			if isFmp4 {
-				fileName = entry.Fmp4Filename()
+			if !local && entry.ByteRange != nil {
+				if _, err := w.Write([]byte(fmt.Sprintf("%s%s\n", TagByteRange, entry.ByteRange))); err != nil {
+					return err
+				}
+			}
+
+			fileName := entry.Url
+			if local {
+				// local names are decoded, so they are escaped again to be valid uris
+				fileName = url.PathEscape(manifest.LocalFilename(*entry, isFmp4))
 			}
 			if _, err := w.Write([]byte(fmt.Sprintf("%s\n", fileName))); err != nil {
 				return err
@@ -335,6 +1229,13 @@ func (manifest *Manifest) WriteLocalManifest(w io.Writer) error {
 		}
 	}
 
+	if err := writeDateRanges(time.Time{}); err != nil {
+		return err
+	}
+
+	if manifest.Recording {
+		return nil
+	}
 	if _, err := w.Write([]byte(TagEndList + "\n")); err != nil {
 		return err
 	}
@@ -342,41 +1243,160 @@ func (manifest *Manifest) WriteLocalManifest(w io.Writer) error {
 	return nil
 }
 
+// keyTag writes the #EXT-X-KEY switching to key, or METHOD=NONE when key is nil. The IV is always explicit so that the
+// playlist stays decryptable after fragments are filtered out. Local manifests reference the downloaded key file.
+func (manifest *Manifest) keyTag(key *Key, iv []byte, local bool) string {
+	switch {
+	case key == nil:
+		return TagKey + "METHOD=" + KeyMethodNone
+	case !local:
+		return key.tag(key.Uri, iv)
+	case key.IsIdentity():
+		return key.tag(url.PathEscape(key.FileName()), iv)
+	}
+
+	// keys negotiated with a DRM system are not downloaded, so the local manifest keeps pointing at the origin
+	if resolved, err := ResolveUri(manifest.BaseUrl, key.Uri); err == nil {
+		return key.tag(resolved.String(), iv)
+	}
+	return key.tag(key.Uri, iv)
+}
+
+// parseDuration parses a duration in seconds, which must be a finite number that is not negative.
+func parseDuration(value string) (float64, error) {
+	duration, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(duration) || math.IsInf(duration, 0) || duration < 0 {
+		return 0, fmt.Errorf("%w %q", ErrInvalidDuration, value)
+	}
+	return duration, nil
+}
+
+// looksLikeUri reports whether a token squeezed onto an #EXTINF line is a fragment uri rather than part of its title.
+func looksLikeUri(token string) bool {
+	// a line starting with # is a comment or tag, so the uri could not be written back on a line of its own
+	if strings.HasPrefix(token, "#") {
+		return false
+	}
+	if strings.Contains(token, "://") {
+		return true
+	}
+
+	switch strings.ToLower(path.Ext(token)) {
+	case ".ts", ".m4s", ".mp4", ".m4a", ".m4v", ".aac", ".mp3", ".vtt", ".webvtt", ".cmfv", ".cmfa":
+		return true
+	}
+	return false
+}
+
 type ManifestEntry struct {
 	Duration float64
-	Url      string
+	// Title is the optional human-readable title following the duration of the #EXTINF.
+	Title string
+	Url   string
+	// Line is where the #EXTINF (or #EXT-X-PART) of the fragment was found in the source playlist, or 0 when it was not parsed.
+	Line int
+	// Key is the #EXT-X-KEY the fragment is encrypted with, or nil when it is not encrypted.
+	Key *Key
+	// IV is the initialization vector the fragment is encrypted with, resolved from its media sequence number when the key has none.
+	IV []byte
+	// ByteRange is the sub-range of the resource at Url holding the fragment, or nil when it is the whole resource.
+	ByteRange *ByteRange
+	// Resets is the number of restarts of the origin before the fragment was published, see Manifest.Extend.
+	Resets int
+	// Sequence is the media sequence number of the fragment, which SequenceNamer names it after.
+	Sequence int
+	// ProgramDateTime is the #EXT-X-PROGRAM-DATE-TIME the fragment was tagged with, or zero when it was not. Untagged
+	// fragments follow on from the last tagged one, see Discontinuity.ProgramDateTime.
+	ProgramDateTime time.Time
+}
+
+func (manifest Manifest) MpegTsFilename(entry ManifestEntry) string {
+	return fmt.Sprintf("%s.ts", manifest.FragmentName(entry))
 }
 
-func (entry ManifestEntry) MpegTsFilename() string {
-	return fmt.Sprintf("%s.ts", entry.FilenameWithoutExtension())
+func (manifest Manifest) Fmp4Filename(entry ManifestEntry) string {
+	return fmt.Sprintf("%s.m4s", manifest.FragmentName(entry))
 }
 
-func (entry ManifestEntry) Fmp4Filename() string {
-	return fmt.Sprintf("%s.m4s", entry.FilenameWithoutExtension())
+// LocalFilename is the name the fragment entry is downloaded to, which depends on whether the manifest is fragmented
+// MP4. Packed audio and WebVTT segments, usually found in renditions, are neither and keep their own extension.
+func (manifest Manifest) LocalFilename(entry ManifestEntry, isFmp4 bool) string {
+	return manifest.FragmentName(entry) + fragmentExtension(entry.Url, isFmp4)
 }
 
-func (entry ManifestEntry) FilenameWithoutExtension() string {
-	return strings.TrimSuffix(path.Base(entry.Url), path.Ext(entry.Url))
+func fragmentExtension(uri string, isFmp4 bool) string {
+	switch extension := strings.ToLower(path.Ext(uriPath(uri))); extension {
+	case ".aac", ".ac3", ".ec3", ".mp3", ".vtt", ".webvtt":
+		return extension
+	}
+	if isFmp4 {
+		return ".m4s"
+	}
+	return ".ts"
 }
 
-func (entry ManifestEntry) DynamicUrl(baseUrl *url.URL) *url.URL {
-	u, _ := baseUrl.Parse(entry.Url)
+// FragmentName is the local name of the fragment entry without its extension given by Namer, made safe for the
+// filesystem.
+func (manifest Manifest) FragmentName(entry ManifestEntry) string {
+	return resetName(sanitizeFilename(manifest.namer().Fragment(entry)), entry.Resets)
+}
+
+// namer is the Namer of the manifest, OriginalNamer unless Namer is set.
+func (manifest Manifest) namer() Namer {
+	if manifest.Namer == nil {
+		return OriginalNamer{}
+	}
+	return manifest.Namer
+}
+
+// DynamicUrl resolves the uri of the fragment entry against BaseUrl, adding MediaQuery.
+func (manifest Manifest) DynamicUrl(entry ManifestEntry) *url.URL {
+	u, _ := manifest.resolveMediaUri(manifest.BaseUrl, entry.Url)
 	return u
 }
 
 type Discontinuity struct {
+	// Line is where the #EXT-X-DISCONTINUITY was found in the source playlist, or 0 for the implicit first discontinuity.
+	Line int
+	// ProgramDateTime is the wall-clock time the first fragment starts at, counted back from the first fragment tagged
+	// with an #EXT-X-PROGRAM-DATE-TIME when it is not tagged itself, or zero when none is.
 	ProgramDateTime time.Time
-	InitFile        string
-	Entries         ManifestEntries
+	// ProgramDateTimeInferred is set when ProgramDateTime was not reported by the playlist but inferred, see
+	// InferProgramDateTimes. Inferred values are not written back to playlists.
+	ProgramDateTimeInferred bool
+	InitFile                string
+	InitFileLine            int
+	// InitByteRange is the sub-range of the resource at InitFile holding the init segment, or nil when it is the whole resource.
+	InitByteRange *ByteRange
+	// Resets is the number of restarts of the origin before the discontinuity was published, see Manifest.Extend.
+	Resets  int
+	Entries ManifestEntries
+	// Parts holds the partial segments (#EXT-X-PART) of the segment still being produced at the live edge.
+	Parts ManifestEntries
 }
 
-func (discontinuity Discontinuity) DynamicInitFile(baseUrl *url.URL) *url.URL {
-	u, _ := baseUrl.Parse(discontinuity.InitFile)
+// PreloadHint represents an #EXT-X-PRELOAD-HINT announcing the next resource the origin will publish.
+type PreloadHint struct {
+	Type string
+	Uri  string
+	// ByteRange is the sub-range of the resource at Uri given by BYTERANGE-START and BYTERANGE-LENGTH, or nil for the
+	// whole resource.
+	ByteRange *ByteRange
+	// OpenEnded is set when the hint has a BYTERANGE-START but no BYTERANGE-LENGTH, running to the end of the resource.
+	OpenEnded bool
+	Line      int
+}
+
+// DynamicInitFile resolves the uri of the init file of discontinuity against BaseUrl, adding MediaQuery.
+func (manifest Manifest) DynamicInitFile(discontinuity Discontinuity) *url.URL {
+	u, _ := manifest.resolveMediaUri(manifest.BaseUrl, discontinuity.InitFile)
 	return u
 }
 
-func (discontinuity Discontinuity) InitFileName() string {
-	return fmt.Sprintf("%s.mp4", strings.TrimSuffix(discontinuity.InitFile, path.Ext(discontinuity.InitFile)))
+// InitFileName is the local name of the init file of discontinuity, saved alongside the fragments whatever directory
+// its uri points into.
+func (manifest Manifest) InitFileName(discontinuity Discontinuity) string {
+	return fmt.Sprintf("%s.mp4", resetName(sanitizeFilename(manifest.namer().Init(discontinuity)), discontinuity.Resets))
 }
 
 type ManifestEntries []*ManifestEntry
@@ -388,3 +1408,14 @@ func (entires ManifestEntries) Runtime() (runtime float64) {
 	}
 	return
 }
+
+// Boundaries returns the offset, in seconds, at which each fragment starts relative to the first fragment.
+func (entires ManifestEntries) Boundaries() []float64 {
+	boundaries := make([]float64, 0, len(entires))
+	offset := 0.0
+	for _, entry := range entires {
+		boundaries = append(boundaries, offset)
+		offset += entry.Duration
+	}
+	return boundaries
+}
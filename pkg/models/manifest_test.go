@@ -0,0 +1,82 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveEdgeTime(t *testing.T) {
+	if got, want := readTestManifest(t, datedPlaylist).LiveEdgeTime(), at(t, "2024-01-01T10:00:30Z"); !got.Equal(want) {
+		t.Errorf("live edge at %s, want %s", got, want)
+	}
+	undated := readTestManifest(t, "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10.0,\ns0.ts\n")
+	if edge := undated.LiveEdgeTime(); !edge.IsZero() {
+		t.Errorf("undated playlist has live edge %s", edge)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "2024-01-01T10:00:00Z", want: "2024-01-01T10:00:00Z"},
+		{value: "2024-01-01T10:00:00.250Z", want: "2024-01-01T10:00:00.25Z"},
+		{value: "2024-01-01T12:00:00.000+02:00", want: "2024-01-01T10:00:00Z"},
+		{value: "2024-01-01T10:00:00.000+00:00", want: "2024-01-01T10:00:00Z"},
+		{value: "2024-01-01T10:00:00.000+0000", want: "2024-01-01T10:00:00Z"},
+		{value: "2024-01-01T05:00:00-0500", want: "2024-01-01T10:00:00Z"},
+		{value: "2024-01-01T10:00:00.123456Z", want: "2024-01-01T10:00:00.123456Z"},
+	}
+	for _, test := range tests {
+		parsed, err := ParseTime(test.value)
+		if err != nil {
+			t.Errorf("parsing %s: %v", test.value, err)
+			continue
+		}
+		if !parsed.Equal(at(t, test.want)) {
+			t.Errorf("parsed %s as %s, want %s", test.value, parsed.UTC(), test.want)
+		}
+	}
+
+	for _, value := range []string{"", "2024-01-01", "2024-01-01 10:00:00Z", "yesterday"} {
+		if _, err := ParseTime(value); err == nil {
+			t.Errorf("parsed invalid %q", value)
+		}
+	}
+}
+
+func TestProgramDateTimeOffsets(t *testing.T) {
+	manifest := readTestManifest(t, `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T12:00:00.000+02:00
+#EXTINF:10.0,
+s0.ts
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T10:00:10.000+0000
+#EXTINF:10.0,
+s1.ts
+#EXT-X-ENDLIST
+`)
+	if got := manifest.Discontinuities[0].ProgramDateTime; !got.Equal(at(t, "2024-01-01T10:00:00Z")) {
+		t.Errorf("discontinuity starts at %s", got)
+	}
+
+	var b strings.Builder
+	if err := manifest.WriteManifest(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, written := range []string{TagProgramDateTime + "2024-01-01T12:00:00+02:00\n", TagProgramDateTime + "2024-01-01T10:00:10Z\n"} {
+		if !strings.Contains(b.String(), written) {
+			t.Errorf("written playlist lacks %q:\n%s", written, b.String())
+		}
+	}
+
+	start, err := ParseTimeBound("2024-01-01T12:00:15+02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clipStart, _, err := manifest.CutWindow(start, TimeBound{}); err != nil || clipStart != 5*time.Second {
+		t.Errorf("clipped to %s (%v), want 5s", clipStart, err)
+	}
+}
@@ -0,0 +1,384 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	TagStreamInf       string = "#EXT-X-STREAM-INF:"
+	TagIFrameStreamInf string = "#EXT-X-I-FRAME-STREAM-INF:"
+	TagMedia           string = "#EXT-X-MEDIA:"
+)
+
+// MasterPlaylist is a multivariant playlist listing the variant streams and renditions of a presentation.
+type MasterPlaylist struct {
+	BaseUrl *url.URL
+	// Version is the #EXT-X-VERSION, or 0 when the playlist does not declare one.
+	Version             int
+	IndependentSegments bool
+	Variants            []Variant
+	// IFrameVariants are the I-frame only playlists (#EXT-X-I-FRAME-STREAM-INF) used for trick play.
+	IFrameVariants []Variant
+	Media          []Media
+	Variables      map[string]string
+}
+
+// Variant is an #EXT-X-STREAM-INF or #EXT-X-I-FRAME-STREAM-INF and the media playlist it points to.
+type Variant struct {
+	Bandwidth        int
+	AverageBandwidth int
+	Codecs           string
+	ResolutionWidth  int
+	ResolutionHeight int
+	FrameRate        float64
+	Audio            string
+	Subtitles        string
+	Uri              string
+	Line             int
+	// Attributes holds every attribute of the tag.
+	Attributes map[string]string
+}
+
+// EstimatedSize is the size in bytes of runtime seconds of the variant at its AVERAGE-BANDWIDTH, or at its peak
+// BANDWIDTH when it does not report one.
+func (variant Variant) EstimatedSize(runtime float64) int64 {
+	bandwidth := variant.AverageBandwidth
+	if bandwidth == 0 {
+		bandwidth = variant.Bandwidth
+	}
+	return int64(float64(bandwidth) * runtime / 8)
+}
+
+// DirName is the subfolder of the download directory the variant is saved to when every variant is downloaded, e.g.
+// 720p-2500000, or its bandwidth alone when it reports no resolution.
+func (variant Variant) DirName() string {
+	if variant.ResolutionHeight > 0 {
+		return fmt.Sprintf("%dp-%d", variant.ResolutionHeight, variant.Bandwidth)
+	}
+	return strconv.Itoa(variant.Bandwidth)
+}
+
+// Media is an #EXT-X-MEDIA alternative rendition, such as an audio track or subtitles.
+type Media struct {
+	Type       string
+	GroupId    string
+	Name       string
+	Language   string
+	Default    bool
+	Autoselect bool
+	Forced     bool
+	// Channels is the CHANNELS of an audio rendition, e.g. 2 or 16/JOC.
+	Channels string
+	// Uri is empty when the rendition is muxed into the variant streams of its group.
+	Uri  string
+	Line int
+	// Attributes holds every attribute of the tag.
+	Attributes map[string]string
+}
+
+// ResolvedUri returns the uri of the variant playlist resolved against the master playlist url.
+func (master MasterPlaylist) ResolvedUri(uri string) string {
+	if resolved, err := ResolveUri(master.BaseUrl, uri); err == nil {
+		return resolved.String()
+	}
+	return uri
+}
+
+// MasterPlaylistError is returned when a master playlist is read as a media playlist. It lists the variant playlists that
+// can be passed instead, the hls command selects one of them by itself, see SelectVariant.
+type MasterPlaylistError struct {
+	BaseUrl  *url.URL
+	Variants []Variant
+}
+
+func (masterError *MasterPlaylistError) Error() string {
+	lines := make([]string, 0, len(masterError.Variants)+1)
+	lines = append(lines, fmt.Sprintf("%s, pass one of its %d variant playlists instead:", ErrMasterPlaylist, len(masterError.Variants)))
+	for _, variant := range masterError.Variants {
+		if resolved, err := ResolveUri(masterError.BaseUrl, variant.Uri); err == nil {
+			variant.Uri = resolved.String()
+		}
+		lines = append(lines, "  "+variant.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (masterError *MasterPlaylistError) Unwrap() error {
+	return ErrMasterPlaylist
+}
+
+// WriteLocalMaster rewrites the master playlist read from r onto w, pointing the variant streams and renditions that
+// were downloaded at their local manifests and resolving every other uri against sourceUrl, so the local master still
+// plays those from the origin. local maps the line of the #EXT-X-STREAM-INF or #EXT-X-MEDIA tag, see Variant.Line and
+// Media.Line, to the path of its local manifest. Like Normalize it works line by line, keeping the tags the parser does
+// not model.
+func WriteLocalMaster(r io.Reader, w io.Writer, sourceUrl string, local map[int]string) error {
+	baseUrl, err := url.Parse(sourceUrl)
+	if err != nil {
+		return err
+	}
+	baseUrl.Path = strings.TrimSuffix(baseUrl.Path, path.Base(baseUrl.Path))
+	rewrite := func(line int, uri string) string {
+		if localPath, ok := local[line]; ok {
+			return localPath
+		}
+		return normalizeUrl(baseUrl, uri, UrlStyleAbsolute)
+	}
+
+	r, err = decodePlaylist(r)
+	if err != nil {
+		return err
+	}
+	scanner := newPlaylistScanner(r)
+	lineNumber, streamInf := 0, 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+		case !strings.HasPrefix(line, "#"):
+			// the uri of a variant stream is on the line after its tag
+			line = rewrite(streamInf, line)
+			streamInf = 0
+		case strings.HasPrefix(line, TagStreamInf):
+			streamInf = lineNumber
+		case strings.HasPrefix(line, "#EXT") && strings.Contains(line, "URI="):
+			name, value, _ := strings.Cut(line, ":")
+			attributes := ParseAttributeList(value)
+			for index, attribute := range attributes {
+				if attribute.Key == "URI" {
+					attributes[index].Value = rewrite(lineNumber, attribute.Value)
+				}
+			}
+			line = fmt.Sprintf("%s:%s", name, attributes)
+		}
+
+		if _, err := w.Write([]byte(line + "\n")); err != nil {
+			return err
+		}
+	}
+
+	return scanError(scanner, lineNumber+1)
+}
+
+// IsMasterPlaylist reports whether the playlist in r lists variant streams rather than media segments.
+func IsMasterPlaylist(r io.Reader) bool {
+	scanner := newPlaylistScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, TagStreamInf) || strings.HasPrefix(line, TagIFrameStreamInf) {
+			return true
+		}
+		if strings.HasPrefix(line, TagFragmentDuration) {
+			return false
+		}
+	}
+	return false
+}
+
+// ReadMasterPlaylist parses a multivariant playlist, substituting #EXT-X-DEFINE variables like ReadManifestWithOptions does.
+func ReadMasterPlaylist(r io.Reader, sourceUrl string) (*MasterPlaylist, error) {
+	master := &MasterPlaylist{}
+	var err error
+	if master.BaseUrl, err = url.Parse(sourceUrl); err != nil {
+		return nil, fmt.Errorf("invalid source url: %w", err)
+	}
+	playlistUrl := *master.BaseUrl
+	master.BaseUrl.Path = strings.TrimSuffix(master.BaseUrl.Path, path.Base(master.BaseUrl.Path))
+
+	r, err = decodePlaylist(r)
+	if err != nil {
+		return nil, err
+	}
+	scanner := newPlaylistScanner(r)
+
+	vars := make(variables)
+	master.Variables = vars
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, TagDefine) {
+			if err := vars.define(strings.TrimPrefix(line, TagDefine), &playlistUrl, nil); err != nil {
+				return nil, TagError{Line: lineNumber, Tag: tagName(line), Err: err}
+			}
+			continue
+		}
+		if line, err = vars.substitute(line); err != nil {
+			return nil, TagError{Line: lineNumber, Tag: tagName(line), Err: err}
+		}
+		if strings.HasPrefix(line, "#EXT") {
+			if err := checkAttributeCount(line); err != nil {
+				return nil, TagError{Line: lineNumber, Tag: tagName(line), Err: err}
+			}
+		}
+
+		switch {
+		case line == TagIndependentSegs:
+			master.IndependentSegments = true
+		case strings.HasPrefix(line, TagVersion):
+			if master.Version, err = strconv.Atoi(strings.TrimPrefix(line, TagVersion)); err != nil {
+				return nil, TagError{Line: lineNumber, Tag: tagName(line), Err: err}
+			}
+		case strings.HasPrefix(line, TagMedia):
+			attributes := ParseAttributes(strings.TrimPrefix(line, TagMedia))
+			master.Media = append(master.Media, Media{
+				Type:       attributes["TYPE"],
+				GroupId:    attributes["GROUP-ID"],
+				Name:       attributes["NAME"],
+				Language:   attributes["LANGUAGE"],
+				Default:    attributes["DEFAULT"] == "YES",
+				Autoselect: attributes["AUTOSELECT"] == "YES",
+				Forced:     attributes["FORCED"] == "YES",
+				Channels:   attributes["CHANNELS"],
+				Uri:        attributes["URI"],
+				Line:       lineNumber,
+				Attributes: attributes,
+			})
+		case strings.HasPrefix(line, TagIFrameStreamInf):
+			variant, err := parseVariant(strings.TrimPrefix(line, TagIFrameStreamInf), lineNumber)
+			if err != nil {
+				return nil, TagError{Line: lineNumber, Tag: tagName(line), Err: err}
+			}
+			variant.Uri = variant.Attributes["URI"]
+			master.IFrameVariants = append(master.IFrameVariants, variant)
+		case strings.HasPrefix(line, TagStreamInf):
+			variant, err := parseVariant(strings.TrimPrefix(line, TagStreamInf), lineNumber)
+			if err != nil {
+				return nil, TagError{Line: lineNumber, Tag: tagName(line), Err: err}
+			}
+			// the uri is the next line that is neither blank nor a comment
+			for scanner.Scan() {
+				lineNumber++
+				uri := strings.TrimSpace(scanner.Text())
+				if uri == "" || strings.HasPrefix(uri, "#") {
+					continue
+				}
+				if variant.Uri, err = vars.substitute(uri); err != nil {
+					return nil, TagError{Line: lineNumber, Tag: tagName(uri), Err: err}
+				}
+				break
+			}
+			if variant.Uri == "" {
+				return nil, TagError{Line: variant.Line, Tag: tagName(line), Err: ErrMissingUri}
+			}
+			master.Variants = append(master.Variants, variant)
+		}
+	}
+
+	if err := scanError(scanner, lineNumber+1); err != nil {
+		return nil, err
+	}
+	return master, nil
+}
+
+// parseVariant parses the attributes of an #EXT-X-STREAM-INF or #EXT-X-I-FRAME-STREAM-INF, returning the malformed
+// numeric ones as an error along with the variant.
+func parseVariant(attributeList string, lineNumber int) (Variant, error) {
+	attributes := ParseAttributes(attributeList)
+	variant := Variant{
+		Codecs:     attributes["CODECS"],
+		Audio:      attributes["AUDIO"],
+		Subtitles:  attributes["SUBTITLES"],
+		Line:       lineNumber,
+		Attributes: attributes,
+	}
+
+	var errs [5]error
+	variant.Bandwidth, errs[0] = optionalInt("BANDWIDTH", attributes["BANDWIDTH"])
+	variant.AverageBandwidth, errs[1] = optionalInt("AVERAGE-BANDWIDTH", attributes["AVERAGE-BANDWIDTH"])
+	variant.FrameRate, errs[2] = optionalFloat("FRAME-RATE", attributes["FRAME-RATE"])
+	if resolution := attributes["RESOLUTION"]; resolution != "" {
+		width, height, found := strings.Cut(resolution, "x")
+		variant.ResolutionWidth, errs[3] = optionalInt("RESOLUTION width", width)
+		variant.ResolutionHeight, errs[4] = optionalInt("RESOLUTION height", height)
+		if !found || width == "" || height == "" {
+			errs[3], errs[4] = fmt.Errorf("invalid RESOLUTION %q", resolution), nil
+		}
+	}
+
+	return variant, errors.Join(errs[:]...)
+}
+
+const (
+	VariantBest  = "best"
+	VariantWorst = "worst"
+)
+
+// String describes the variant for logs and error messages.
+func (variant Variant) String() string {
+	description := fmt.Sprintf("%d bps", variant.Bandwidth)
+	if variant.ResolutionHeight > 0 {
+		description += fmt.Sprintf(" %dx%d", variant.ResolutionWidth, variant.ResolutionHeight)
+	}
+	if variant.Codecs != "" {
+		description += fmt.Sprintf(" %s", variant.Codecs)
+	}
+	return fmt.Sprintf("%s (%s)", variant.Uri, description)
+}
+
+// SelectVariant picks a variant stream: VariantBest or VariantWorst by bandwidth, a resolution such as 1280x720 or 720p,
+// or a bandwidth in bits per second selecting the best variant that does not exceed it (the worst when all do).
+func (master MasterPlaylist) SelectVariant(selector string) (Variant, error) {
+	if len(master.Variants) == 0 {
+		return Variant{}, errors.New("master playlist has no variant streams")
+	}
+
+	variants := append([]Variant{}, master.Variants...)
+	sort.SliceStable(variants, func(i, j int) bool {
+		return variants[i].Bandwidth < variants[j].Bandwidth
+	})
+
+	var candidates []Variant
+	switch {
+	case selector == "" || selector == VariantBest:
+		return variants[len(variants)-1], nil
+	case selector == VariantWorst:
+		return variants[0], nil
+	case strings.HasSuffix(selector, "p"):
+		height, err := strconv.Atoi(strings.TrimSuffix(selector, "p"))
+		if err != nil {
+			return Variant{}, fmt.Errorf("invalid variant selector %q", selector)
+		}
+		for _, variant := range variants {
+			if variant.ResolutionHeight == height {
+				candidates = append(candidates, variant)
+			}
+		}
+	case strings.Contains(selector, "x"):
+		for _, variant := range variants {
+			if fmt.Sprintf("%dx%d", variant.ResolutionWidth, variant.ResolutionHeight) == selector {
+				candidates = append(candidates, variant)
+			}
+		}
+	default:
+		bandwidth, err := strconv.Atoi(selector)
+		if err != nil {
+			return Variant{}, fmt.Errorf("invalid variant selector %q, expected %s, %s, a resolution or a bandwidth", selector, VariantBest, VariantWorst)
+		}
+		candidates = []Variant{variants[0]}
+		for _, variant := range variants[1:] {
+			if variant.Bandwidth <= bandwidth {
+				candidates = append(candidates, variant)
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		available := make([]string, 0, len(variants))
+		for _, variant := range variants {
+			available = append(available, variant.String())
+		}
+		return Variant{}, fmt.Errorf("no variant matches %q, available:\n%s", selector, strings.Join(available, "\n"))
+	}
+	return candidates[len(candidates)-1], nil
+}
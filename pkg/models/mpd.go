@@ -0,0 +1,692 @@
+package models
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MpdTypeStatic  = "static"
+	MpdTypeDynamic = "dynamic"
+)
+
+var (
+	ErrDynamicMpd    = errors.New("dynamic (live) MPDs are not supported")
+	ErrInvalidMpd    = errors.New("invalid MPD")
+	ErrNoSegmentsMpd = errors.New("representation has neither a SegmentTemplate nor a SegmentList")
+)
+
+// Mpd is a DASH Media Presentation Description. Only the elements needed to list the segments of a static presentation
+// are parsed, and written by WriteLocalMpd.
+type Mpd struct {
+	XMLName                   xml.Name
+	Profiles                  string      `xml:"profiles,attr,omitempty"`
+	MinBufferTime             string      `xml:"minBufferTime,attr,omitempty"`
+	Type                      string      `xml:"type,attr,omitempty"`
+	MediaPresentationDuration string      `xml:"mediaPresentationDuration,attr,omitempty"`
+	BaseUrls                  []string    `xml:"BaseURL"`
+	Periods                   []MpdPeriod `xml:"Period"`
+	// BaseUrl is the url the MPD was fetched from, which the BaseURL elements and segment uris are resolved against.
+	BaseUrl *url.URL `xml:"-"`
+}
+
+type MpdPeriod struct {
+	Id              string              `xml:"id,attr,omitempty"`
+	Start           string              `xml:"start,attr,omitempty"`
+	Duration        string              `xml:"duration,attr,omitempty"`
+	BaseUrls        []string            `xml:"BaseURL"`
+	SegmentTemplate *MpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *MpdSegmentList     `xml:"SegmentList"`
+	AdaptationSets  []MpdAdaptationSet  `xml:"AdaptationSet"`
+}
+
+type MpdAdaptationSet struct {
+	Id              string              `xml:"id,attr,omitempty"`
+	ContentType     string              `xml:"contentType,attr,omitempty"`
+	MimeType        string              `xml:"mimeType,attr,omitempty"`
+	Codecs          string              `xml:"codecs,attr,omitempty"`
+	Lang            string              `xml:"lang,attr,omitempty"`
+	Label           string              `xml:"Label,omitempty"`
+	Roles           []MpdDescriptor     `xml:"Role"`
+	AudioChannels   []MpdDescriptor     `xml:"AudioChannelConfiguration"`
+	BaseUrls        []string            `xml:"BaseURL"`
+	SegmentTemplate *MpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *MpdSegmentList     `xml:"SegmentList"`
+	Representations []MpdRepresentation `xml:"Representation"`
+}
+
+type MpdRepresentation struct {
+	Id              string              `xml:"id,attr,omitempty"`
+	Bandwidth       int                 `xml:"bandwidth,attr,omitempty"`
+	Width           int                 `xml:"width,attr,omitempty"`
+	Height          int                 `xml:"height,attr,omitempty"`
+	Codecs          string              `xml:"codecs,attr,omitempty"`
+	MimeType        string              `xml:"mimeType,attr,omitempty"`
+	AudioChannels   []MpdDescriptor     `xml:"AudioChannelConfiguration"`
+	BaseUrls        []string            `xml:"BaseURL"`
+	SegmentTemplate *MpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *MpdSegmentList     `xml:"SegmentList"`
+}
+
+// MpdDescriptor is a scheme/value descriptor such as Role or AudioChannelConfiguration.
+type MpdDescriptor struct {
+	SchemeIdUri string `xml:"schemeIdUri,attr,omitempty"`
+	Value       string `xml:"value,attr,omitempty"`
+}
+
+// MpdSegmentTemplate builds the uris of the segments from the identifiers $RepresentationID$, $Number$, $Time$ and
+// $Bandwidth$, either for every segment of SegmentTimeline or for segments of a constant Duration.
+type MpdSegmentTemplate struct {
+	Media                  string              `xml:"media,attr,omitempty"`
+	Initialization         string              `xml:"initialization,attr,omitempty"`
+	StartNumber            *int64              `xml:"startNumber,attr,omitempty"`
+	Timescale              *int64              `xml:"timescale,attr,omitempty"`
+	Duration               *int64              `xml:"duration,attr,omitempty"`
+	PresentationTimeOffset *int64              `xml:"presentationTimeOffset,attr,omitempty"`
+	Timeline               *MpdSegmentTimeline `xml:"SegmentTimeline"`
+}
+
+// MpdSegmentList lists the uri, or byte range of the representation BaseURL, of every segment.
+type MpdSegmentList struct {
+	Timescale      *int64              `xml:"timescale,attr,omitempty"`
+	Duration       *int64              `xml:"duration,attr,omitempty"`
+	Initialization *MpdUrl             `xml:"Initialization"`
+	Timeline       *MpdSegmentTimeline `xml:"SegmentTimeline"`
+	SegmentUrls    []MpdSegmentUrl     `xml:"SegmentURL"`
+}
+
+type MpdUrl struct {
+	SourceUrl string `xml:"sourceURL,attr,omitempty"`
+	Range     string `xml:"range,attr,omitempty"`
+}
+
+type MpdSegmentUrl struct {
+	Media      string `xml:"media,attr,omitempty"`
+	MediaRange string `xml:"mediaRange,attr,omitempty"`
+}
+
+type MpdSegmentTimeline struct {
+	Segments []MpdTimelineSegment `xml:"S"`
+}
+
+// MpdTimelineSegment is an S element: R more segments of duration D follow the one starting at T. A negative R repeats
+// it until the next S or the end of the period.
+type MpdTimelineSegment struct {
+	T *int64 `xml:"t,attr,omitempty"`
+	D int64  `xml:"d,attr,omitempty"`
+	R int64  `xml:"r,attr,omitempty"`
+}
+
+// ReadMpd parses the MPD read from r, resolving its uris against sourceUrl.
+func ReadMpd(r io.Reader, sourceUrl string) (*Mpd, error) {
+	mpd := &Mpd{}
+	if err := xml.NewDecoder(r).Decode(mpd); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMpd, err)
+	}
+	if mpd.Type == MpdTypeDynamic {
+		return nil, ErrDynamicMpd
+	}
+	if len(mpd.Periods) == 0 {
+		return nil, fmt.Errorf("%w: no Period", ErrInvalidMpd)
+	}
+
+	var err error
+	if mpd.BaseUrl, err = url.Parse(sourceUrl); err != nil {
+		return nil, fmt.Errorf("invalid source url: %w", err)
+	}
+	return mpd, nil
+}
+
+var isoDurationPattern = regexp.MustCompile(`^P(?:([\d.]+)Y)?(?:([\d.]+)M)?(?:([\d.]+)D)?(?:T(?:([\d.]+)H)?(?:([\d.]+)M)?(?:([\d.]+)S)?)?$`)
+
+// parseIsoDuration parses an xs:duration such as PT1H2M3.5S into seconds, counting years as 365 and months as 30 days.
+func parseIsoDuration(value string) (float64, error) {
+	match := isoDurationPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("%w: duration %q", ErrInvalidMpd, value)
+	}
+
+	seconds := 0.0
+	for index, unit := range []float64{365 * 86400, 30 * 86400, 86400, 3600, 60, 1} {
+		if match[index+1] == "" {
+			continue
+		}
+		amount, err := strconv.ParseFloat(match[index+1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: duration %q", ErrInvalidMpd, value)
+		}
+		seconds += amount * unit
+	}
+	return seconds, nil
+}
+
+// periodDurations returns the duration in seconds of every period. A period without a duration lasts until the next one
+// starts or the presentation ends, so the duration of the last one can be 0 when the MPD does not say.
+func (mpd Mpd) periodDurations() (durations []float64, err error) {
+	total := 0.0
+	if mpd.MediaPresentationDuration != "" {
+		if total, err = parseIsoDuration(mpd.MediaPresentationDuration); err != nil {
+			return nil, err
+		}
+	}
+
+	starts := make([]float64, len(mpd.Periods))
+	durations = make([]float64, len(mpd.Periods))
+	known := make([]bool, len(mpd.Periods))
+	for index, period := range mpd.Periods {
+		if period.Start != "" {
+			if starts[index], err = parseIsoDuration(period.Start); err != nil {
+				return nil, err
+			}
+		} else if index > 0 {
+			starts[index] = starts[index-1] + durations[index-1]
+		}
+		if period.Duration != "" {
+			if durations[index], err = parseIsoDuration(period.Duration); err != nil {
+				return nil, err
+			}
+			known[index] = true
+		}
+	}
+
+	for index := range mpd.Periods {
+		if known[index] {
+			continue
+		}
+		if index+1 < len(mpd.Periods) {
+			durations[index] = starts[index+1] - starts[index]
+		} else if total > 0 {
+			durations[index] = total - starts[index]
+		}
+	}
+	return durations, nil
+}
+
+// contentType is video, audio or whatever else the adaptation set or its first representation declares.
+func (adaptationSet MpdAdaptationSet) contentType() string {
+	if adaptationSet.ContentType != "" {
+		return adaptationSet.ContentType
+	}
+	mimeType := adaptationSet.MimeType
+	if mimeType == "" && len(adaptationSet.Representations) > 0 {
+		mimeType = adaptationSet.Representations[0].MimeType
+	}
+	contentType, _, _ := strings.Cut(mimeType, "/")
+	return contentType
+}
+
+// MasterPlaylist lists the video representations of the period at index as variants and each audio adaptation set, by
+// its highest bandwidth representation, as an AUDIO rendition of group "audio", so they are selected the same way as the
+// streams of an HLS master playlist. Their uris are the representation ids.
+func (mpd Mpd) MasterPlaylist(index int) MasterPlaylist {
+	master := MasterPlaylist{BaseUrl: mpd.BaseUrl}
+	period := mpd.Periods[index]
+
+	for _, adaptationSet := range period.AdaptationSets {
+		switch adaptationSet.contentType() {
+		case "video":
+			for _, representation := range adaptationSet.Representations {
+				master.Variants = append(master.Variants, Variant{
+					Bandwidth:        representation.Bandwidth,
+					Codecs:           firstNonEmpty(representation.Codecs, adaptationSet.Codecs),
+					ResolutionWidth:  representation.Width,
+					ResolutionHeight: representation.Height,
+					Uri:              representation.Id,
+				})
+			}
+		case "audio":
+			if len(adaptationSet.Representations) == 0 {
+				continue
+			}
+			best := adaptationSet.Representations[0]
+			for _, representation := range adaptationSet.Representations[1:] {
+				if representation.Bandwidth > best.Bandwidth {
+					best = representation
+				}
+			}
+
+			media := Media{
+				Type:       MediaTypeAudio,
+				GroupId:    "audio",
+				Name:       firstNonEmpty(adaptationSet.Label, adaptationSet.Lang, adaptationSet.Id, best.Id),
+				Language:   adaptationSet.Lang,
+				Autoselect: true,
+				Uri:        best.Id,
+				Line:       len(master.Media) + 1,
+			}
+			for _, role := range adaptationSet.Roles {
+				media.Default = media.Default || role.Value == "main"
+			}
+			if channels := append(best.AudioChannels, adaptationSet.AudioChannels...); len(channels) > 0 {
+				media.Channels = channels[0].Value
+			}
+			master.Media = append(master.Media, media)
+		}
+	}
+
+	if len(master.Media) > 0 {
+		for index := range master.Variants {
+			master.Variants[index].Audio = "audio"
+		}
+	}
+	return master
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Select picks the video representation of every period with SelectVariant and its audio adaptation sets with
+// SelectRenditions, returning the manifest of the video, with a discontinuity per period, and a rendition for each audio
+// track. The first audio track takes the place of the video in audio only presentations.
+func (mpd Mpd) Select(variantSelector string, audioSelector string) (*Manifest, []Rendition, error) {
+	durations, err := mpd.periodDurations()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var manifest *Manifest
+	var renditions []Rendition
+	for index, period := range mpd.Periods {
+		master := mpd.MasterPlaylist(index)
+
+		var ids []string
+		var tracks []Media
+		if len(master.Variants) > 0 {
+			variant, err := master.SelectVariant(variantSelector)
+			if err != nil {
+				return nil, nil, fmt.Errorf("period %d: %w", index, err)
+			}
+			ids = append(ids, variant.Uri)
+			if tracks, err = master.SelectRenditions(MediaTypeAudio, variant.Audio, audioSelector); err != nil {
+				return nil, nil, fmt.Errorf("period %d: %w", index, err)
+			}
+		} else {
+			if audioSelector == RenditionsNone {
+				audioSelector = RenditionsDefault
+			}
+			if tracks, err = master.SelectRenditions(MediaTypeAudio, "audio", audioSelector); err != nil {
+				return nil, nil, fmt.Errorf("period %d: %w", index, err)
+			}
+			if len(tracks) == 0 {
+				return nil, nil, fmt.Errorf("%w: period %d has neither video nor audio", ErrInvalidMpd, index)
+			}
+		}
+		for _, track := range tracks {
+			ids = append(ids, track.Uri)
+		}
+
+		if index == 0 {
+			// in audio only presentations the first track is the main one
+			for _, track := range tracks[len(tracks)+1-len(ids):] {
+				renditions = append(renditions, Rendition{Media: track, Dir: track.DirName()})
+			}
+		}
+		if len(ids) != len(renditions)+1 {
+			return nil, nil, fmt.Errorf("%w: period %d has %d selected tracks, the first has %d", ErrInvalidMpd, index, len(ids), len(renditions)+1)
+		}
+
+		for track, id := range ids {
+			adaptationSet, representation := period.representation(id)
+			discontinuity, err := mpd.discontinuity(period, *adaptationSet, *representation, durations[index])
+			if err != nil {
+				return nil, nil, fmt.Errorf("period %d representation %q: %w", index, id, err)
+			}
+
+			target := &manifest
+			if track > 0 {
+				target = &renditions[track-1].Manifest
+			}
+			if *target == nil {
+				*target = mpd.manifest(*adaptationSet, *representation)
+			}
+			(*target).Discontinuities = append((*target).Discontinuities, discontinuity)
+		}
+	}
+
+	for _, selected := range append([]*Manifest{manifest}, renditionManifests(renditions)...) {
+		// segment numbers start over with every period, so the fragments are numbered across them
+		selected.forEachEntry(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) {
+			entry.Sequence = sequence
+			selected.TargetDuration = math.Max(selected.TargetDuration, math.Ceil(entry.Duration))
+		})
+	}
+	return manifest, renditions, nil
+}
+
+func renditionManifests(renditions []Rendition) []*Manifest {
+	manifests := make([]*Manifest, 0, len(renditions))
+	for _, rendition := range renditions {
+		manifests = append(manifests, rendition.Manifest)
+	}
+	return manifests
+}
+
+// representation finds the representation with id in the period.
+func (period MpdPeriod) representation(id string) (*MpdAdaptationSet, *MpdRepresentation) {
+	for setIndex := range period.AdaptationSets {
+		adaptationSet := &period.AdaptationSets[setIndex]
+		for index := range adaptationSet.Representations {
+			if adaptationSet.Representations[index].Id == id {
+				return adaptationSet, &adaptationSet.Representations[index]
+			}
+		}
+	}
+	return nil, nil
+}
+
+// manifest is the empty media playlist the periods of representation are added to.
+func (mpd Mpd) manifest(adaptationSet MpdAdaptationSet, representation MpdRepresentation) *Manifest {
+	baseUrl := *mpd.BaseUrl
+	baseUrl.Path = strings.TrimSuffix(baseUrl.Path, path.Base(baseUrl.Path))
+
+	return &Manifest{
+		Version:          7,
+		PlaylistType:     PlaylistTypeVod,
+		EndList:          true,
+		Bandwidth:        representation.Bandwidth,
+		Codecs:           firstNonEmpty(representation.Codecs, adaptationSet.Codecs),
+		ResolutionWidth:  representation.Width,
+		ResolutionHeight: representation.Height,
+		BaseUrl:          &baseUrl,
+	}
+}
+
+// baseUrl resolves the innermost BaseURL elements down to the representation against the url of the MPD.
+func (mpd Mpd) baseUrl(period MpdPeriod, adaptationSet MpdAdaptationSet, representation MpdRepresentation) (*url.URL, error) {
+	baseUrl := mpd.BaseUrl
+	for _, baseUrls := range [][]string{mpd.BaseUrls, period.BaseUrls, adaptationSet.BaseUrls, representation.BaseUrls} {
+		if len(baseUrls) == 0 {
+			continue
+		}
+		resolved, err := ResolveUri(baseUrl, strings.TrimSpace(baseUrls[0]))
+		if err != nil {
+			return nil, err
+		}
+		baseUrl = resolved
+	}
+	return baseUrl, nil
+}
+
+// discontinuity lists the init segment and media segments of representation in period as a discontinuity, with
+// absolute uris.
+func (mpd Mpd) discontinuity(period MpdPeriod, adaptationSet MpdAdaptationSet, representation MpdRepresentation, duration float64) (Discontinuity, error) {
+	baseUrl, err := mpd.baseUrl(period, adaptationSet, representation)
+	if err != nil {
+		return Discontinuity{}, err
+	}
+	resolve := func(uri string) (string, error) {
+		resolved, err := ResolveUri(baseUrl, uri)
+		if err != nil {
+			return "", err
+		}
+		return resolved.String(), nil
+	}
+
+	template := representation.SegmentTemplate.inherit(adaptationSet.SegmentTemplate).inherit(period.SegmentTemplate)
+	list := representation.SegmentList.inherit(adaptationSet.SegmentList).inherit(period.SegmentList)
+
+	var discontinuity Discontinuity
+	switch {
+	case template != nil && template.Media != "":
+		if template.Initialization != "" {
+			if discontinuity.InitFile, err = resolve(template.expand(template.Initialization, representation, 0, 0)); err != nil {
+				return discontinuity, err
+			}
+		}
+
+		segments, err := template.segments(duration)
+		if err != nil {
+			return discontinuity, err
+		}
+		for _, segment := range segments {
+			uri, err := resolve(template.expand(template.Media, representation, segment.number, segment.time))
+			if err != nil {
+				return discontinuity, err
+			}
+			discontinuity.Entries = append(discontinuity.Entries, &ManifestEntry{Url: uri, Duration: segment.duration})
+		}
+	case list != nil:
+		if list.Initialization != nil {
+			if discontinuity.InitFile, err = resolve(list.Initialization.SourceUrl); err != nil {
+				return discontinuity, err
+			}
+			if discontinuity.InitByteRange, err = parseMpdRange(list.Initialization.Range); err != nil {
+				return discontinuity, err
+			}
+		}
+
+		durations, err := list.durations(duration)
+		if err != nil {
+			return discontinuity, err
+		}
+		for index, segmentUrl := range list.SegmentUrls {
+			entry := &ManifestEntry{Duration: durations[min(index, len(durations)-1)]}
+			if entry.Url, err = resolve(segmentUrl.Media); err != nil {
+				return discontinuity, err
+			}
+			if entry.ByteRange, err = parseMpdRange(segmentUrl.MediaRange); err != nil {
+				return discontinuity, err
+			}
+			discontinuity.Entries = append(discontinuity.Entries, entry)
+		}
+	default:
+		return discontinuity, ErrNoSegmentsMpd
+	}
+
+	if len(discontinuity.Entries) == 0 {
+		return discontinuity, fmt.Errorf("%w: no segments", ErrInvalidMpd)
+	}
+	return discontinuity, nil
+}
+
+// parseMpdRange parses a DASH byte range of the form <first>-<last>, which unlike ByteRange includes its last byte.
+func parseMpdRange(value string) (*ByteRange, error) {
+	if value == "" {
+		return nil, nil
+	}
+	firstValue, lastValue, _ := strings.Cut(value, "-")
+	first, err := strconv.ParseInt(firstValue, 10, 64)
+	if err != nil || first < 0 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidByteRange, value)
+	}
+	last, err := strconv.ParseInt(lastValue, 10, 64)
+	if err != nil || last < first {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidByteRange, value)
+	}
+	return &ByteRange{Offset: first, Length: last - first + 1}, nil
+}
+
+// inherit fills the attributes the template leaves out from the template of the enclosing element.
+func (template *MpdSegmentTemplate) inherit(parent *MpdSegmentTemplate) *MpdSegmentTemplate {
+	if template == nil {
+		return parent
+	}
+	if parent == nil {
+		return template
+	}
+
+	merged := *template
+	merged.Media = firstNonEmpty(merged.Media, parent.Media)
+	merged.Initialization = firstNonEmpty(merged.Initialization, parent.Initialization)
+	for _, field := range []struct{ value, parent **int64 }{
+		{&merged.StartNumber, &parent.StartNumber},
+		{&merged.Timescale, &parent.Timescale},
+		{&merged.Duration, &parent.Duration},
+		{&merged.PresentationTimeOffset, &parent.PresentationTimeOffset},
+	} {
+		if *field.value == nil {
+			*field.value = *field.parent
+		}
+	}
+	if merged.Timeline == nil {
+		merged.Timeline = parent.Timeline
+	}
+	return &merged
+}
+
+// inherit fills the attributes the list leaves out from the list of the enclosing element.
+func (list *MpdSegmentList) inherit(parent *MpdSegmentList) *MpdSegmentList {
+	if list == nil {
+		return parent
+	}
+	if parent == nil {
+		return list
+	}
+
+	merged := *list
+	if merged.Timescale == nil {
+		merged.Timescale = parent.Timescale
+	}
+	if merged.Duration == nil {
+		merged.Duration = parent.Duration
+	}
+	if merged.Initialization == nil {
+		merged.Initialization = parent.Initialization
+	}
+	if merged.Timeline == nil {
+		merged.Timeline = parent.Timeline
+	}
+	return &merged
+}
+
+func valueOr(value *int64, fallback int64) int64 {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
+
+var templateIdentifierPattern = regexp.MustCompile(`\$(RepresentationID|Number|Time|Bandwidth|)(%0\d+d)?\$`)
+
+// expand substitutes the identifiers of a media or initialization template, formatting numbers with their optional
+// printf width such as $Number%05d$.
+func (template MpdSegmentTemplate) expand(value string, representation MpdRepresentation, number int64, time int64) string {
+	return templateIdentifierPattern.ReplaceAllStringFunc(value, func(identifier string) string {
+		match := templateIdentifierPattern.FindStringSubmatch(identifier)
+		format := firstNonEmpty(match[2], "%d")
+		switch match[1] {
+		case "RepresentationID":
+			return representation.Id
+		case "Number":
+			return fmt.Sprintf(format, number)
+		case "Time":
+			return fmt.Sprintf(format, time)
+		case "Bandwidth":
+			return fmt.Sprintf(format, representation.Bandwidth)
+		}
+		return "$"
+	})
+}
+
+type mpdSegment struct {
+	number   int64
+	time     int64
+	duration float64
+}
+
+// segments lists the number, time and duration in seconds of every segment the template addresses in a period lasting
+// periodDuration seconds.
+func (template MpdSegmentTemplate) segments(periodDuration float64) ([]mpdSegment, error) {
+	timescale := valueOr(template.Timescale, 1)
+	if timescale <= 0 {
+		return nil, fmt.Errorf("%w: timescale %d", ErrInvalidMpd, timescale)
+	}
+	number := valueOr(template.StartNumber, 1)
+	offset := valueOr(template.PresentationTimeOffset, 0)
+
+	var segments []mpdSegment
+	if template.Timeline != nil {
+		for _, timed := range template.Timeline.expand(offset, periodDuration, timescale) {
+			segments = append(segments, mpdSegment{number: number, time: timed.time, duration: timed.duration})
+			number++
+		}
+		return segments, nil
+	}
+
+	duration := valueOr(template.Duration, 0)
+	if duration <= 0 {
+		return nil, fmt.Errorf("%w: SegmentTemplate has neither a duration nor a SegmentTimeline", ErrInvalidMpd)
+	}
+	if periodDuration <= 0 {
+		return nil, fmt.Errorf("%w: the duration of the period is unknown", ErrInvalidMpd)
+	}
+	count := int64(math.Ceil(periodDuration * float64(timescale) / float64(duration)))
+	for index := int64(0); index < count; index++ {
+		segmentDuration := math.Min(float64(duration), periodDuration*float64(timescale)-float64(index*duration)) / float64(timescale)
+		segments = append(segments, mpdSegment{number: number + index, time: offset + index*duration, duration: segmentDuration})
+	}
+	return segments, nil
+}
+
+// durations lists the duration in seconds of every segment of the list, from its timeline or constant duration.
+func (list MpdSegmentList) durations(periodDuration float64) ([]float64, error) {
+	timescale := valueOr(list.Timescale, 1)
+	if timescale <= 0 {
+		return nil, fmt.Errorf("%w: timescale %d", ErrInvalidMpd, timescale)
+	}
+
+	var durations []float64
+	if list.Timeline != nil {
+		for _, timed := range list.Timeline.expand(0, periodDuration, timescale) {
+			durations = append(durations, timed.duration)
+		}
+	} else if duration := valueOr(list.Duration, 0); duration > 0 {
+		durations = append(durations, float64(duration)/float64(timescale))
+	} else if len(list.SegmentUrls) == 1 && periodDuration > 0 {
+		durations = append(durations, periodDuration)
+	}
+
+	if len(durations) == 0 {
+		return nil, fmt.Errorf("%w: SegmentList has neither a duration nor a SegmentTimeline", ErrInvalidMpd)
+	}
+	return durations, nil
+}
+
+type mpdTimedSegment struct {
+	time     int64
+	duration float64
+}
+
+// expand lists the start time in timescale units and duration in seconds of every segment of the timeline, which starts
+// at offset unless its first S says otherwise.
+func (timeline MpdSegmentTimeline) expand(offset int64, periodDuration float64, timescale int64) []mpdTimedSegment {
+	var timed []mpdTimedSegment
+	time := offset
+	for index, segment := range timeline.Segments {
+		if segment.T != nil {
+			time = *segment.T
+		}
+		if segment.D <= 0 {
+			continue
+		}
+
+		repeat := segment.R
+		if repeat < 0 {
+			end := offset + int64(periodDuration*float64(timescale))
+			if index+1 < len(timeline.Segments) && timeline.Segments[index+1].T != nil {
+				end = *timeline.Segments[index+1].T
+			}
+			repeat = int64(math.Ceil(float64(end-time)/float64(segment.D))) - 1
+		}
+		for ; repeat >= 0; repeat-- {
+			timed = append(timed, mpdTimedSegment{time: time, duration: float64(segment.D) / float64(timescale)})
+			time += segment.D
+		}
+	}
+	return timed
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+)
+
+// MuxJournalFileName is the journal of the outputs Process finished, kept in the download directory until it completes.
+const MuxJournalFileName = "mux.journal.json"
+
+// MuxJournal records the outputs of the discontinuities already converted by Process, so that a run failing midway, on a
+// full disk or an ffmpeg crash, resumes from the discontinuity that failed rather than converting the finished ones again.
+type MuxJournal struct {
+	path string
+
+	mu      sync.Mutex
+	outputs map[string]MuxRecord
+}
+
+// MuxRecord is an output of the journal, named relative to the download directory.
+type MuxRecord struct {
+	// Source fingerprints the files the output was made of, so an output of fragments downloaded again is converted again.
+	Source string `json:"source"`
+	// Size is the size in bytes of the finished output. An output changed since, by a later step or by hand, is converted
+	// again.
+	Size int64 `json:"size"`
+	// Muxed is set once the renditions are muxed into the output, see MuxRenditions.
+	Muxed bool `json:"muxed,omitempty"`
+}
+
+// LoadMuxJournal reads the journal at filePath, returning an empty journal if it does not exist yet.
+func LoadMuxJournal(filePath string) (*MuxJournal, error) {
+	journal := &MuxJournal{path: filePath, outputs: make(map[string]MuxRecord)}
+
+	b, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return journal, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &journal.outputs); err != nil {
+		return nil, fmt.Errorf("invalid mux journal %s: %w", filePath, err)
+	}
+	return journal, nil
+}
+
+// converted reports whether the output name in dir was finished from source and is unchanged since. A nil journal has
+// no outputs.
+func (journal *MuxJournal) converted(dir string, name string, source string) bool {
+	if journal == nil {
+		return false
+	}
+	journal.mu.Lock()
+	record, ok := journal.outputs[name]
+	journal.mu.Unlock()
+	return ok && record.Source == source && outputSize(dir, name) == record.Size
+}
+
+// muxed reports whether the renditions were muxed into the output name in dir, which is unchanged since.
+func (journal *MuxJournal) muxed(dir string, name string) bool {
+	if journal == nil {
+		return false
+	}
+	journal.mu.Lock()
+	record, ok := journal.outputs[name]
+	journal.mu.Unlock()
+	return ok && record.Muxed && outputSize(dir, name) == record.Size
+}
+
+// record saves the output name in dir as finished from source. Outputs never written, as with ffmpeg.DryRun, are not.
+func (journal *MuxJournal) record(dir string, name string, source string) error {
+	return journal.update(dir, name, func(record *MuxRecord) {
+		*record = MuxRecord{Source: source}
+	})
+}
+
+// recordMuxed saves the renditions as muxed into the output name in dir, which was recorded before.
+func (journal *MuxJournal) recordMuxed(dir string, name string) error {
+	return journal.update(dir, name, func(record *MuxRecord) {
+		record.Muxed = true
+	})
+}
+
+func (journal *MuxJournal) update(dir string, name string, update func(record *MuxRecord)) error {
+	if journal == nil {
+		return nil
+	}
+	size := outputSize(dir, name)
+	if size < 0 {
+		return nil
+	}
+
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	record := journal.outputs[name]
+	update(&record)
+	record.Size = size
+	journal.outputs[name] = record
+
+	b, err := json.MarshalIndent(journal.outputs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(journal.path, b, 0644)
+}
+
+// Remove deletes the journal once every output is finished, so a later run produces them again as utils.OutputPolicy says.
+func (journal *MuxJournal) Remove() error {
+	if journal == nil {
+		return nil
+	}
+	if err := os.Remove(journal.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// outputSize is the size of the output name in dir, or -1 when it does not exist.
+func outputSize(dir string, name string) int64 {
+	info, err := os.Stat(path.Join(dir, name))
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+// muxSource fingerprints the init file and fragments of discontinuity in dir by their names and sizes.
+func (manifest Manifest) muxSource(dir string, discontinuity Discontinuity) string {
+	hash := sha256.New()
+	if discontinuity.InitFile != "" {
+		name := manifest.InitFileName(discontinuity)
+		fmt.Fprintf(hash, "%s %d\n", name, outputSize(dir, name))
+	}
+	isFmp4 := manifest.IsFmp4()
+	for _, entry := range discontinuity.Entries {
+		name := manifest.LocalFilename(*entry, isFmp4)
+		fmt.Fprintf(hash, "%s %d\n", name, outputSize(dir, name))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+)
+
+const (
+	NamingOriginal = "original"
+	NamingSequence = "sequence"
+	NamingHash     = "hash"
+)
+
+// Namer names the files of a download, without their extension: the fragments, which keep the extension of their
+// kind, the init segments and the outputs of the discontinuities. Embedders with their own asset naming conventions set
+// Manifest.Namer, or ReadOptions.Namer, instead of renaming the files afterwards. Names must be unique within the
+// download, and are made safe for the filesystem whatever the Namer returns.
+type Namer interface {
+	// Fragment names a fragment of the playlist.
+	Fragment(entry ManifestEntry) string
+	// Init names the init segment of discontinuity.
+	Init(discontinuity Discontinuity) string
+	// Output names the output of the discontinuity at index, by expanding template, see OutputNames.
+	Output(template string, index int, discontinuity Discontinuity) string
+}
+
+// NewNamer returns the Namer of naming: NamingOriginal, NamingSequence or NamingHash.
+func NewNamer(naming string) (Namer, error) {
+	switch naming {
+	case NamingOriginal:
+		return OriginalNamer{}, nil
+	case NamingSequence:
+		return SequenceNamer{}, nil
+	case NamingHash:
+		return HashNamer{}, nil
+	}
+	return nil, fmt.Errorf("unknown naming %q", naming)
+}
+
+// OriginalNamer names fragments and init segments after the last path segment of their uri, see localName, and outputs
+// by their template.
+type OriginalNamer struct{}
+
+func (OriginalNamer) Fragment(entry ManifestEntry) string {
+	return entry.ByteRange.localName(entry.Url)
+}
+
+func (OriginalNamer) Init(discontinuity Discontinuity) string {
+	return discontinuity.InitByteRange.localName(discontinuity.InitFile)
+}
+
+func (OriginalNamer) Output(template string, index int, discontinuity Discontinuity) string {
+	return expandOutputName(template, index, discontinuity)
+}
+
+// SequenceNamer names fragments after their media sequence number, e.g. 00000042, and init segments after that of the
+// first fragment using them, for origins whose uris are opaque or change with every request.
+type SequenceNamer struct {
+	OriginalNamer
+}
+
+func (SequenceNamer) Fragment(entry ManifestEntry) string {
+	return fmt.Sprintf("%08d", entry.Sequence)
+}
+
+func (namer SequenceNamer) Init(discontinuity Discontinuity) string {
+	if len(discontinuity.Entries) == 0 {
+		return namer.OriginalNamer.Init(discontinuity)
+	}
+	return fmt.Sprintf("init%08d", discontinuity.Entries[0].Sequence)
+}
+
+// HashNamer names fragments and init segments after a hash of their uri and byte range, leaving out the query string,
+// which often carries tokens changing with every request, so a file is named the same whichever playlist it is
+// downloaded from.
+type HashNamer struct {
+	OriginalNamer
+}
+
+func (HashNamer) Fragment(entry ManifestEntry) string {
+	return uriHash(entry.ByteRange.cacheKey(withoutQuery(entry.Url)))
+}
+
+func (HashNamer) Init(discontinuity Discontinuity) string {
+	return uriHash(discontinuity.InitByteRange.cacheKey(withoutQuery(discontinuity.InitFile)))
+}
+
+func uriHash(uri string) string {
+	sum := sha256.Sum256([]byte(uri))
+	return hex.EncodeToString(sum[:16])
+}
+
+func withoutQuery(uri string) string {
+	parsed, err := url.Parse(EscapeUri(uri))
+	if err != nil {
+		return uri
+	}
+	parsed.RawQuery, parsed.Fragment = "", ""
+	return parsed.String()
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	UrlStyleKeep     = ""
+	UrlStyleAbsolute = "absolute"
+	UrlStyleRelative = "relative"
+)
+
+// NormalizeOptions controls how Normalize rewrites a playlist.
+type NormalizeOptions struct {
+	// Precision is the number of decimal places written for #EXTINF durations.
+	Precision int
+	// UrlStyle is one of UrlStyleKeep, UrlStyleAbsolute or UrlStyleRelative.
+	UrlStyle string
+	// DropTags lists tag names (e.g. EXT-X-PROGRAM-DATE-TIME) to remove from the output.
+	DropTags []string
+}
+
+// Normalize rewrites the playlist read from r into a canonical form on w so that playlists from different packagers can be diffed line by line.
+// Unlike ReadManifest it works line by line, so tags the parser does not model are preserved.
+func Normalize(r io.Reader, w io.Writer, sourceUrl string, options NormalizeOptions) error {
+	baseUrl, err := url.Parse(sourceUrl)
+	if err != nil {
+		return err
+	}
+	baseUrl.Path = strings.TrimSuffix(baseUrl.Path, path.Base(baseUrl.Path))
+
+	r, err = decodePlaylist(r)
+	if err != nil {
+		return err
+	}
+	scanner := newPlaylistScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "#") {
+			line = normalizeUrl(baseUrl, line, options.UrlStyle)
+		} else if strings.HasPrefix(line, "#EXT") {
+			name, value, hasValue := strings.Cut(strings.TrimPrefix(line, "#"), ":")
+			if slices.Contains(options.DropTags, name) {
+				continue
+			}
+
+			switch {
+			case !hasValue:
+			case "#"+name+":" == TagFragmentDuration:
+				duration, title, _ := strings.Cut(value, ",")
+				if seconds, err := strconv.ParseFloat(duration, 64); err == nil {
+					duration = strconv.FormatFloat(seconds, 'f', options.Precision, 64)
+				}
+				line = fmt.Sprintf("%s%s,%s", TagFragmentDuration, duration, title)
+			case strings.Contains(value, "="):
+				attributes := ParseAttributeList(value).Sorted()
+				for index, attribute := range attributes {
+					if attribute.Key == "URI" {
+						attributes[index].Value = normalizeUrl(baseUrl, attribute.Value, options.UrlStyle)
+					}
+				}
+				line = fmt.Sprintf("#%s:%s", name, attributes)
+			}
+		}
+
+		if _, err := w.Write([]byte(line + "\n")); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func normalizeUrl(baseUrl *url.URL, rawUrl string, style string) string {
+	resolved, err := ResolveUri(baseUrl, rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+
+	switch style {
+	case UrlStyleAbsolute:
+		return resolved.String()
+	case UrlStyleRelative:
+		if resolved.Scheme == baseUrl.Scheme && resolved.Host == baseUrl.Host && strings.HasPrefix(resolved.Path, baseUrl.Path) {
+			relative := *resolved
+			relative.Scheme, relative.Host, relative.User = "", "", nil
+			relative.Path = strings.TrimPrefix(resolved.Path, baseUrl.Path)
+			return relative.String()
+		}
+		return resolved.String()
+	}
+
+	return rawUrl
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path"
+)
+
+// PartsDir is the subfolder of the download directory the LL-HLS partial segments of a live recording are saved to
+// until the segment they belong to is published, see DownloadLiveParts.
+const PartsDir = "parts"
+
+// LiveParts returns the partial segments (#EXT-X-PART) of the segment still being produced at the live edge, followed
+// by the part announced by the #EXT-X-PRELOAD-HINT. A hint of a byte range without a length is left out, as it runs to
+// the end of a resource that is still growing.
+func (manifest Manifest) LiveParts() ManifestEntries {
+	parts := make(ManifestEntries, 0)
+	if len(manifest.Discontinuities) > 0 {
+		parts = append(parts, manifest.Discontinuities[len(manifest.Discontinuities)-1].Parts...)
+	}
+	if hint := manifest.PreloadHint; hint != nil && hint.Type == "PART" && !hint.OpenEnded {
+		parts = append(parts, &ManifestEntry{Url: hint.Uri, ByteRange: hint.ByteRange, Line: hint.Line})
+	}
+	return parts
+}
+
+// partFilename is the name the part is saved to inside PartsDir, after its uri and byte range whatever the Namer, as
+// parts are not numbered.
+func (part ManifestEntry) partFilename(isFmp4 bool) string {
+	return resetName(OriginalNamer{}.Fragment(part), part.Resets) + fragmentExtension(part.Url, isFmp4)
+}
+
+// DownloadLiveParts downloads the LiveParts of the variant into PartsDir, so a recording holds the live edge before its
+// segment is published. The origin holds the request for the preload hint open until the part exists, so this returns
+// as soon as the next part is published. Parts downloaded by an earlier call are skipped. It returns the files of the
+// parts, which are meant to be removed once the segment they belong to is downloaded whole.
+func (manifest Manifest) DownloadLiveParts(ctx context.Context, options PlanOptions) ([]string, error) {
+	parts := manifest.LiveParts()
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(path.Join(options.Dir, PartsDir), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// the parts are fetched between the reloads of a recording, which the progress of its segments does not count
+	options.Progress = nil
+	plan := &DownloadPlan{Manifest: &manifest, Options: options}
+	isFmp4 := manifest.IsFmp4()
+	files := make([]string, 0, len(parts))
+	for _, part := range parts {
+		file := path.Join(PartsDir, part.partFilename(isFmp4))
+		plan.Downloads = append(plan.Downloads, PlannedDownload{File: file, Url: part.Url, ByteRange: part.ByteRange, Line: part.Line})
+		files = append(files, file)
+	}
+	err := plan.Download(ctx)
+	options.logger().Debug("downloaded live parts", slog.Int("parts", len(files)), slog.Int("segment", manifest.LastSequence()+1))
+	return files, err
+}
@@ -0,0 +1,725 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+	"github.com/alehechka/manifestr/pkg/telemetry"
+	"github.com/alehechka/manifestr/pkg/utils"
+)
+
+const (
+	ContainerMp4 = "mp4"
+	ContainerTs  = "ts"
+)
+
+const (
+	StepConcatMp4     = "concat-mp4"
+	StepConcatTs      = "concat-ts"
+	StepMuxRenditions = "mux-renditions"
+	StepTrimJoin      = "trim-join"
+	StepAvSync        = "av-sync"
+	StepClip          = "clip"
+	StepMergeMp4      = "merge-mp4"
+	StepSplit         = "split"
+)
+
+const (
+	ConcatNone             = "none"
+	ConcatSingle           = "single"
+	ConcatPerDiscontinuity = "per-discontinuity"
+)
+
+const (
+	DefaultConcurrency  = 8
+	DefaultRetries      = 3
+	DefaultRetryBackoff = 500 * time.Millisecond
+)
+
+// PlanOptions describes what a DownloadPlan should fetch and produce. Its JSON, kept by a StageHandoff, leaves out the
+// hooks.
+type PlanOptions struct {
+	Dir           string
+	ForceDownload bool
+	// Concurrency is how many files are downloaded at once, DefaultConcurrency when zero.
+	Concurrency int
+	// Retries is how many more times a download failing with a transient error is attempted, waiting RetryBackoff
+	// before the first retry and twice as long before each next one, see utils.IsTransient and utils.Backoff.
+	Retries      int
+	RetryBackoff time.Duration
+	// RefetchVariant downloads the files of the variant again even when they exist, keeping those of its renditions, for
+	// an archive switched to another variant whose files are named the same, see PlannedDownload.Force.
+	RefetchVariant bool
+	// PreloadParts also fetches the LL-HLS parts and preload hint at the live edge of a recording, see
+	// DownloadLiveParts.
+	PreloadParts bool
+	// Assets also fetches the external assets referenced by the playlist into AssetsDir, see Asset.
+	Assets bool
+	// Container is ContainerMp4 or ContainerTs. ContainerTs is concatenated into a single file unless ConcatMode is
+	// ConcatNone.
+	Container string
+	// ConcatMode is ConcatPerDiscontinuity for an MP4 of every discontinuity, ConcatSingle to merge those into one MP4 or
+	// ConcatNone, the default when empty, to leave the fragments as they are.
+	ConcatMode string
+	// AvSync is AvSyncOff, AvSyncReport or AvSyncCorrect, deciding how the audio/video offset of the MP4 outputs is checked, see CheckAvSync.
+	AvSync string
+	// AvSyncTolerance is the offset in seconds below which outputs are in sync, DefaultAvSyncTolerance when zero.
+	AvSyncTolerance float64
+	// OutputName names the MP4 of every discontinuity, DefaultOutputName when empty, see OutputNames. The merged MP4 of
+	// ConcatSingle is named as the first discontinuity.
+	OutputName string
+	// LiveJoin is LiveJoinKeep, LiveJoinDrop or LiveJoinGop, deciding how the start of a live recording is handled. Only
+	// LiveJoinGop adds a step, see TrimToFirstKeyframe.
+	LiveJoin string
+	// KeyframeTolerance is how far in seconds the first key frame of LiveJoinGop may be from the start of the output,
+	// DefaultKeyframeTolerance when zero.
+	KeyframeTolerance float64
+	// Start and End clip the MP4 output when either is non-zero, see ClipMp4s.
+	Start time.Duration
+	End   time.Duration
+	// ClipReencode cuts the clips exactly at Start and End by re-encoding the GOPs they fall in, rather than snapping
+	// Start back to a key frame.
+	ClipReencode bool
+	// SplitSize and SplitDuration, when either is non-zero, split the final MP4 outputs into parts of at most that many
+	// bytes or about that long, see SplitMp4s.
+	SplitSize     int64
+	SplitDuration time.Duration
+	// OutputPolicy decides what happens to outputs that already exist, replacing them when zero.
+	OutputPolicy utils.OutputPolicy
+	// Transfer tunes how every file is transferred and written, and when an existing one is downloaded again.
+	Transfer utils.TransferOptions
+	// Quota, when set, stops scheduling downloads once the directory reached it, see ErrQuotaExceeded. Plans writing
+	// outputs reserve as much again as every download for them, see quotaShare. The downloads in flight are completed,
+	// so the directory may end up past it by up to Concurrency files.
+	Quota *utils.Quota `json:"-"`
+	// Progress, when set, counts finished downloads.
+	Progress *utils.Progress `json:"-"`
+	// Scan, when set, inspects every downloaded file and can veto it from the outputs, see ExcludeVetoed.
+	Scan ScanFunc `json:"-"`
+	// Downloader sends the requests of the downloads. When nil, they are sent through Client without any headers, or
+	// through http.DefaultClient when Client is nil too.
+	Downloader *utils.Downloader `json:"-"`
+	Client     *http.Client      `json:"-"`
+	// Logger receives the messages about the downloads, slog.Default() when nil.
+	Logger *slog.Logger `json:"-"`
+}
+
+func (options PlanOptions) logger() *slog.Logger {
+	if options.Logger == nil {
+		return slog.Default()
+	}
+	return options.Logger
+}
+
+// downloadOptions configures a single download of the plan, forced when force is set.
+func (options PlanOptions) downloadOptions(force bool) utils.DownloadOptions {
+	downloader := options.Downloader
+	if downloader == nil {
+		downloader = &utils.Downloader{Client: options.Client}
+	}
+	return utils.DownloadOptions{TransferOptions: options.Transfer, Force: force, Downloader: downloader, Logger: options.Logger}
+}
+
+func (options PlanOptions) avSyncTolerance() float64 {
+	if options.AvSyncTolerance <= 0 {
+		return DefaultAvSyncTolerance
+	}
+	return options.AvSyncTolerance
+}
+
+func (options PlanOptions) keyframeTolerance() float64 {
+	if options.KeyframeTolerance <= 0 {
+		return DefaultKeyframeTolerance
+	}
+	return options.KeyframeTolerance
+}
+
+func (options PlanOptions) concurrency() int {
+	if options.Concurrency <= 0 {
+		return DefaultConcurrency
+	}
+	return options.Concurrency
+}
+
+// merges reports whether the MP4s of the discontinuities of manifest are merged into one. The MP4 of a single
+// discontinuity needs no merging, it is the output.
+func (options PlanOptions) merges(manifest *Manifest) bool {
+	return options.ConcatMode == ConcatSingle && len(manifest.Discontinuities) > 1
+}
+
+// mp4OutputName is the template the MP4 of every discontinuity of manifest is named by, see OutputNames.
+func (options PlanOptions) mp4OutputName(manifest *Manifest) string {
+	if options.merges(manifest) {
+		return partOutputName(options.OutputName)
+	}
+	return options.OutputName
+}
+
+// PlannedDownload is a single file a DownloadPlan will fetch into its directory.
+type PlannedDownload struct {
+	File string
+	// Url is the fragment uri as written in the manifest, resolved against the primary and failover origins at download time.
+	Url string
+	// Line is where the file is referenced in the source playlist, or 0 when unknown.
+	Line int
+	// Shared is set for init segments and keys, which the variants of a presentation often have in common, see Manifest.Store.
+	Shared bool
+	// Encrypted is set for fragments encrypted with an #EXT-X-KEY, which are kept as served and decrypted when concatenated.
+	Encrypted bool
+	// ByteRange is the sub-range of the resource at Url to fetch, or nil for all of it.
+	ByteRange *ByteRange
+	// EstimatedSize in bytes, derived from the advertised bandwidth and the fragment duration. Zero when the manifest does not report a bandwidth.
+	EstimatedSize int64
+	// Start is the offset in seconds of the fragment from the start of the playlist, which Download schedules by so the
+	// variant and its renditions complete in presentation order across discontinuities.
+	Start float64
+	// Ad is set for fragments played within one of the AdBreaks of the variant, which Download schedules after the program.
+	Ad bool
+	// Resets is the number of restarts of the origin before the file was published, which tells it apart from a file
+	// published under the same uri before, see Manifest.Extend.
+	Resets int
+	// Force downloads the file again even when it exists, see PlanOptions.RefetchVariant.
+	Force bool
+}
+
+// FragmentResult is the outcome of a single PlannedDownload.
+type FragmentResult struct {
+	PlannedDownload
+	// Path is where the file was written, empty when it was never attempted.
+	Path string
+	// Size in bytes of the file saved, zero when the download failed.
+	Size int64
+	// Duration is how long downloading took, including the waits between retries.
+	Duration time.Duration
+	// Attempts counts every try including retries and the fallback origins.
+	Attempts int
+	// Skipped is set when the file was kept from an earlier run rather than transferred.
+	Skipped bool
+	// ExpectedSize is the size in bytes the origin announced for the file, or -1 when it announced none or the file was
+	// Skipped. See SizeMismatch.
+	ExpectedSize int64
+	Err          error
+}
+
+// SizeMismatch reports whether the file was saved with a different size than the origin announced, as happens when a
+// transfer is silently truncated.
+func (result FragmentResult) SizeMismatch() bool {
+	return result.Err == nil && result.ExpectedSize >= 0 && result.Size != result.ExpectedSize
+}
+
+// PlannedStep is a post-processing step a DownloadPlan will run once every file is downloaded.
+type PlannedStep struct {
+	Kind string
+	// Outputs are the files the step writes. The parts of StepSplit are printf patterns numbered from 1.
+	Outputs []string
+}
+
+// DownloadPlan separates deciding what to fetch and produce from doing it, so the plan can be inspected or modified before Execute.
+type DownloadPlan struct {
+	Manifest  *Manifest
+	Options   PlanOptions
+	Downloads []PlannedDownload
+	Steps     []PlannedStep
+	// Vetoed lists the downloads rejected by PlanOptions.Scan.
+	Vetoed []PlannedDownload
+	// Results holds the outcome of each of Downloads, in the same order, once Download returns. Downloads never
+	// attempted because ctx was cancelled have zero Attempts.
+	Results []FragmentResult
+	// Outputs are the final files Process produced, empty when it has no Steps.
+	Outputs []string
+
+	mu sync.Mutex
+}
+
+// Plan lists every file to download and every output to produce for manifest without touching the network or disk.
+func Plan(manifest *Manifest, options PlanOptions) *DownloadPlan {
+	plan := &DownloadPlan{Manifest: manifest, Options: options}
+
+	planned := make(map[string]bool)
+	add := func(fileName string, url string, byteRange *ByteRange, duration float64, start float64, ad bool, line int, shared bool, encrypted bool, resets int) {
+		if planned[fileName] {
+			return
+		}
+		planned[fileName] = true
+		estimatedSize := int64(float64(manifest.Bandwidth) * duration / 8)
+		if byteRange != nil {
+			estimatedSize = byteRange.Length
+		}
+		plan.Downloads = append(plan.Downloads, PlannedDownload{
+			File:          fileName,
+			Url:           url,
+			Line:          line,
+			Shared:        shared,
+			Encrypted:     encrypted,
+			ByteRange:     byteRange,
+			EstimatedSize: estimatedSize,
+			Start:         start,
+			Ad:            ad,
+			Resets:        resets,
+		})
+	}
+
+	// the ad breaks are marked by sequence in the variant only, so renditions are matched to them by time
+	type window struct{ from, to float64 }
+	var adWindows []window
+	inAdWindow := func(start float64) bool {
+		for _, ad := range adWindows {
+			if start >= ad.from && start < ad.to {
+				return true
+			}
+		}
+		return false
+	}
+
+	// the segments of a rendition are saved into its own subfolder, with their uris resolved against its playlist
+	addSegments := func(source *Manifest, dir string) {
+		resolve := func(uri string) string {
+			if dir == "" {
+				return uri
+			}
+			if resolved, err := ResolveUri(source.BaseUrl, uri); err == nil {
+				return resolved.String()
+			}
+			return uri
+		}
+
+		isFmp4 := source.IsFmp4()
+		start, sequence := 0.0, source.MediaSequence
+		for _, discontinuity := range source.Discontinuities {
+			if isFmp4 {
+				add(path.Join(dir, source.InitFileName(discontinuity)), resolve(discontinuity.InitFile), discontinuity.InitByteRange, 0, start, false, discontinuity.InitFileLine, true, false, discontinuity.Resets)
+			}
+
+			for _, entry := range discontinuity.Entries {
+				ad := inAdWindow(start)
+				if source == manifest {
+					if ad = manifest.InAdBreak(sequence); ad {
+						adWindows = append(adWindows, window{from: start, to: start + entry.Duration})
+					}
+				}
+				if entry.Key != nil && entry.Key.IsIdentity() {
+					add(path.Join(dir, entry.Key.FileName()), resolve(entry.Key.Uri), nil, 0, start, false, entry.Key.Line, true, false, 0)
+				}
+				add(path.Join(dir, source.LocalFilename(*entry, isFmp4)), resolve(entry.Url), entry.ByteRange, entry.Duration, start, ad, entry.Line, false, entry.Key != nil, entry.Resets)
+				start += entry.Duration
+				sequence++
+			}
+		}
+	}
+
+	addSegments(manifest, "")
+	for index := range plan.Downloads {
+		plan.Downloads[index].Force = options.RefetchVariant
+	}
+	for _, rendition := range manifest.Renditions {
+		addSegments(rendition.Manifest, rendition.Dir)
+	}
+
+	if options.Assets {
+		for _, asset := range manifest.Assets {
+			add(path.Join(AssetsDir, asset.FileName()), asset.Uri, nil, 0, 0, false, asset.Line, false, false, 0)
+		}
+	}
+
+	switch {
+	case options.Container == ContainerTs:
+		if options.ConcatMode != ConcatNone {
+			plan.Steps = append(plan.Steps, PlannedStep{Kind: StepConcatTs, Outputs: []string{path.Join(options.Dir, "output.ts")}})
+		}
+	case options.ConcatMode == ConcatPerDiscontinuity || options.ConcatMode == ConcatSingle:
+		outputs := make([]string, 0, len(manifest.Discontinuities))
+		clips := make([]string, 0, len(manifest.Discontinuities))
+		for _, name := range manifest.OutputNames(options.mp4OutputName(manifest)) {
+			outputs = append(outputs, path.Join(options.Dir, name+".mp4"))
+			clips = append(clips, path.Join(options.Dir, name+".clip.mp4"))
+		}
+		plan.Steps = append(plan.Steps, PlannedStep{Kind: StepConcatMp4, Outputs: outputs})
+		if len(manifest.Renditions) > 0 {
+			plan.Steps = append(plan.Steps, PlannedStep{Kind: StepMuxRenditions, Outputs: outputs})
+		}
+		if options.LiveJoin == LiveJoinGop && len(outputs) > 0 {
+			plan.Steps = append(plan.Steps, PlannedStep{Kind: StepTrimJoin, Outputs: outputs[:1]})
+		}
+		switch options.AvSync {
+		case AvSyncReport:
+			plan.Steps = append(plan.Steps, PlannedStep{Kind: StepAvSync})
+		case AvSyncCorrect:
+			plan.Steps = append(plan.Steps, PlannedStep{Kind: StepAvSync, Outputs: outputs})
+		}
+		if options.Start > 0 || options.End > 0 {
+			plan.Steps = append(plan.Steps, PlannedStep{Kind: StepClip, Outputs: clips})
+		}
+		final := outputs
+		if options.Start > 0 || options.End > 0 {
+			final = clips
+		}
+		if options.merges(manifest) {
+			merged := path.Join(options.Dir, manifest.OutputNames(options.OutputName)[0]+".mp4")
+			plan.Steps = append(plan.Steps, PlannedStep{Kind: StepMergeMp4, Outputs: []string{merged}})
+			final = []string{merged}
+		}
+		if options.SplitSize > 0 || options.SplitDuration > 0 {
+			parts := make([]string, 0, len(final))
+			for _, output := range final {
+				parts = append(parts, splitOutputPattern(output))
+			}
+			plan.Steps = append(plan.Steps, PlannedStep{Kind: StepSplit, Outputs: parts})
+		}
+	}
+
+	return plan
+}
+
+// FfmpegRequirements is what the planned steps need from the installed ffmpeg, see ffmpeg.Preflight.
+func (plan *DownloadPlan) FfmpegRequirements() ffmpeg.Requirements {
+	var requirements ffmpeg.Requirements
+	for _, step := range plan.Steps {
+		switch step.Kind {
+		case StepConcatMp4:
+			requirements = requirements.Merge(ffmpeg.TransmuxRequirements)
+		case StepMuxRenditions:
+			requirements = requirements.Merge(ffmpeg.Requirements{Muxers: []string{"mp4"}})
+			for _, rendition := range plan.Manifest.Renditions {
+				if rendition.Type == MediaTypeSubtitles {
+					requirements = requirements.Merge(ffmpeg.Requirements{Encoders: []string{"mov_text"}})
+				}
+			}
+		case StepTrimJoin, StepAvSync:
+			requirements = requirements.Merge(ffmpeg.Requirements{Muxers: []string{"mp4"}, Ffprobe: true})
+		case StepClip:
+			requirements = requirements.Merge(ffmpeg.Requirements{Muxers: []string{"mp4"}, Ffprobe: !plan.Manifest.CanClipWithoutKeyframeScan()})
+			if plan.Options.ClipReencode {
+				requirements = requirements.Merge(ffmpeg.TransmuxRequirements)
+			}
+		case StepMergeMp4:
+			requirements = requirements.Merge(ffmpeg.Requirements{Muxers: []string{"mp4"}})
+		case StepSplit:
+			requirements = requirements.Merge(ffmpeg.Requirements{Muxers: []string{"mp4", "segment"}, Ffprobe: plan.Options.SplitSize > 0})
+		}
+	}
+	return requirements
+}
+
+// CheckOutput reports the steps that cannot run against Manifest.Output: only the concatenations of the fragments read
+// and write objects, every other step runs ffmpeg on files in the download directory.
+func (plan *DownloadPlan) CheckOutput() error {
+	if plan.Manifest.Output == nil {
+		return nil
+	}
+	for _, step := range plan.Steps {
+		if step.Kind == StepConcatTs || (step.Kind == StepConcatMp4 && plan.Manifest.IsFmp4()) {
+			continue
+		}
+		return fmt.Errorf("the %s step needs a local download directory, it cannot write to %s", step.Kind, plan.Manifest.Output.Location(""))
+	}
+	return nil
+}
+
+// EstimatedSize is the total estimated size in bytes of every planned download.
+func (plan *DownloadPlan) EstimatedSize() (size int64) {
+	for _, download := range plan.Downloads {
+		size += download.EstimatedSize
+	}
+	return
+}
+
+// quotaShare is how many times its size a download takes up of Options.Quota: twice for a plan concatenating the
+// fragments into outputs, which are about as large as the fragments they are written from, and once otherwise. The
+// clips, merges and splits written from the outputs afterwards are not reserved for.
+func (plan *DownloadPlan) quotaShare() int64 {
+	for _, step := range plan.Steps {
+		if step.Kind == StepConcatTs || step.Kind == StepConcatMp4 {
+			return 2
+		}
+	}
+	return 1
+}
+
+// Download fetches the planned downloads in schedule order, Options.Concurrency at a time, retrying transient failures. Downloads that
+// still fail are logged and recorded in Manifest.Statuses rather than aborting the rest, and returned as a *DownloadError.
+// Cancelling ctx stops scheduling downloads and aborts those in flight, removing their partially written files. Reaching
+// Options.Quota stops scheduling downloads too, returning ErrQuotaExceeded once those in flight completed.
+func (plan *DownloadPlan) Download(ctx context.Context) error {
+	plan.Options.Progress.Start("downloading", len(plan.Downloads))
+	defer plan.Options.Progress.Finish()
+
+	return plan.download(ctx, make(chan struct{}, plan.Options.concurrency()))
+}
+
+// DownloadAll fetches plans at once like Download, sharing the Concurrency and Progress of the first plan between them,
+// so the variants of a presentation planned into their own directories are downloaded as one. The errors of the plans
+// are joined.
+func DownloadAll(ctx context.Context, plans []*DownloadPlan) error {
+	if len(plans) == 0 {
+		return nil
+	}
+	options := plans[0].Options
+	total := 0
+	for _, plan := range plans {
+		plan.Options.Progress = options.Progress
+		total += len(plan.Downloads)
+	}
+	options.Progress.Start("downloading", total)
+	defer options.Progress.Finish()
+
+	slots := make(chan struct{}, options.concurrency())
+	errs := make([]error, len(plans))
+	var wg sync.WaitGroup
+	for index, plan := range plans {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[index] = plan.download(ctx, slots)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// download fetches the planned downloads, taking one of slots for each download in flight.
+func (plan *DownloadPlan) download(ctx context.Context, slots chan struct{}) error {
+	var wg sync.WaitGroup
+
+	if plan.Options.Assets && len(plan.Manifest.Assets) > 0 {
+		if err := os.MkdirAll(path.Join(plan.Options.Dir, AssetsDir), os.ModePerm); err != nil {
+			plan.Options.logger().Error("failed to create assets directory", slog.String("error", err.Error()))
+		}
+	}
+	for _, rendition := range plan.Manifest.Renditions {
+		if err := os.MkdirAll(path.Join(plan.Options.Dir, rendition.Dir), os.ModePerm); err != nil {
+			plan.Options.logger().Error("failed to create rendition directory", slog.String("dir", rendition.Dir), slog.String("error", err.Error()))
+		}
+	}
+
+	plan.Results = make([]FragmentResult, len(plan.Downloads))
+	for index, download := range plan.Downloads {
+		plan.Results[index].PlannedDownload = download
+	}
+
+	stopped := false
+schedule:
+	for _, index := range plan.schedule() {
+		download := plan.Downloads[index]
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			break schedule
+		}
+		// a slot frees up once a download finished, which by then is counted against the quota
+		if plan.Options.Quota.Exceeded() {
+			<-slots
+			stopped = true
+			break schedule
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result := plan.downloadWithRetries(ctx, download)
+			plan.Results[index] = result
+			plan.Manifest.DeadLetters.record(plan.Manifest.BaseUrl, result)
+			if result.Err == nil && !result.Skipped {
+				plan.Options.Quota.Add(result.Size * plan.quotaShare())
+			}
+			defer plan.Options.Progress.Done(result.Size, result.Err)
+			if result.Err != nil {
+				plan.Options.logger().Error("failed to download fragment", slog.String("url", download.Url), slog.String("file", download.File), slog.Int("line", download.Line), slog.String("error", result.Err.Error()))
+			} else {
+				plan.Options.logger().Debug("downloaded fragment", slog.String("file", download.File), slog.Int64("size", result.Size), slog.Duration("duration", result.Duration), slog.Int("attempts", result.Attempts))
+				if plan.Options.Scan != nil {
+					plan.scan(ctx, download)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("downloads interrupted: %w", context.Cause(ctx))
+	}
+	if stopped {
+		quota := plan.Options.Quota
+		plan.Options.logger().Warn("stopped downloading at the quota", slog.String("used", utils.FormatBytes(quota.Used())), slog.String("quota", utils.FormatBytes(quota.Limit)))
+		return ErrQuotaExceeded
+	}
+
+	var failed []FailedDownload
+	for _, result := range plan.Results {
+		if result.Err != nil {
+			failed = append(failed, FailedDownload{PlannedDownload: result.PlannedDownload, Err: result.Err})
+		}
+	}
+	if len(failed) > 0 {
+		return &DownloadError{Failed: failed}
+	}
+	return nil
+}
+
+// schedule returns the indices of the Downloads in the order to start them: the files that have not failed before ahead
+// of the Manifest.DeadLetters, which are likely to fail again, then the program before the ad breaks, and within each by
+// Start, interleaving the variant and its renditions so the earliest material completes first rather than a whole stream
+// at a time.
+func (plan *DownloadPlan) schedule() []int {
+	order := make([]int, len(plan.Downloads))
+	failed := make([]bool, len(plan.Downloads))
+	for index, download := range plan.Downloads {
+		order[index] = index
+		failed[index] = plan.Manifest.DeadLetters.contains(download.File)
+	}
+	sort.SliceStable(order, func(i int, j int) bool {
+		if failed[order[i]] != failed[order[j]] {
+			return !failed[order[i]]
+		}
+		a, b := plan.Downloads[order[i]], plan.Downloads[order[j]]
+		if a.Ad != b.Ad {
+			return !a.Ad
+		}
+		return a.Start < b.Start
+	})
+	return order
+}
+
+// downloadWithRetries downloads a single file, attempting it again after a backoff while it fails with a transient error,
+// and finally from the backup origins of Manifest.FallbackRules.
+func (plan *DownloadPlan) downloadWithRetries(ctx context.Context, download PlannedDownload) (result FragmentResult) {
+	result.PlannedDownload = download
+	started := time.Now()
+	defer func() {
+		if result.Err != nil && len(plan.Manifest.FallbackRules) > 0 {
+			plan.Options.logger().Warn("trying fallback origins", slog.String("file", download.File), slog.String("error", result.Err.Error()))
+			result.Attempts++
+			result.record(plan.Manifest.downloadWithFailover(ctx, plan.Options, download, true))
+		}
+		result.Duration = time.Since(started)
+		if result.Err == nil && plan.Manifest.Output == nil {
+			if info, err := os.Stat(result.Path); err == nil {
+				result.Size = info.Size()
+			}
+		}
+	}()
+
+	for {
+		result.Attempts++
+		result.record(plan.Manifest.downloadWithFailover(ctx, plan.Options, download, false))
+		if result.Err == nil || result.Attempts > plan.Options.Retries || !utils.IsTransient(result.Err) {
+			return result
+		}
+
+		// a Retry-After of the server outlasts the backoff, even beyond utils.MaxBackoff
+		wait := max(utils.Backoff(plan.Options.RetryBackoff, result.Attempts), utils.RetryAfter(result.Err))
+		plan.Options.logger().Warn("retrying download", slog.String("file", download.File), slog.Int("attempt", result.Attempts), slog.Duration("wait", wait), slog.String("error", result.Err.Error()))
+		select {
+		case <-ctx.Done():
+			return result
+		case <-time.After(wait):
+		}
+	}
+}
+
+// record keeps the outcome of a download attempt.
+func (result *FragmentResult) record(download utils.DownloadResult, err error) {
+	result.Path, result.Skipped, result.Err = download.Path, download.Skipped, err
+	if err == nil {
+		result.Size = download.Written
+	}
+	result.ExpectedSize = -1
+	if !download.Skipped {
+		result.ExpectedSize = download.Expected
+	}
+}
+
+// ArchiveTo places every downloaded file, along with extraFiles of the download directory such as the manifests, in dir
+// without doubling disk usage where the filesystem allows, see utils.LinkOrClone. Files that were not downloaded are skipped.
+func (plan *DownloadPlan) ArchiveTo(dir string, extraFiles ...string) error {
+	files := make([]string, 0, len(plan.Downloads)+len(extraFiles))
+	for _, download := range plan.Downloads {
+		files = append(files, download.File)
+	}
+	files = append(files, extraFiles...)
+
+	for _, file := range files {
+		src := path.Join(plan.Options.Dir, file)
+		if _, err := os.Stat(src); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		dst := path.Join(dir, file)
+		if err := os.MkdirAll(path.Dir(dst), os.ModePerm); err != nil {
+			return err
+		}
+		if err := utils.LinkOrClone(src, dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Process runs the planned post-processing steps in order, leaving out any vetoed fragments.
+func (plan *DownloadPlan) Process(ctx context.Context) (err error) {
+	ctx, span := telemetry.Start(ctx, "process")
+	defer func() { telemetry.End(span, err) }()
+
+	plan.ExcludeVetoed()
+
+	if plan.Manifest.MuxJournal == nil && plan.Manifest.Output == nil && len(plan.Steps) > 0 {
+		if plan.Manifest.MuxJournal, err = LoadMuxJournal(path.Join(plan.Options.Dir, MuxJournalFileName)); err != nil {
+			return err
+		}
+	}
+
+	var files []string
+	for _, step := range plan.Steps {
+		switch step.Kind {
+		case StepConcatTs:
+			var output string
+			if output, err = plan.Manifest.ConcatToTs(ctx, plan.Options.Dir, plan.Options.OutputPolicy); err == nil {
+				files = []string{output}
+			}
+		case StepConcatMp4:
+			files, err = plan.Manifest.ConcatToMp4s(ctx, plan.Options.Dir, plan.Options.mp4OutputName(plan.Manifest), plan.Options.OutputPolicy)
+		case StepMuxRenditions:
+			err = plan.Manifest.MuxRenditions(ctx, plan.Options.Dir, files)
+		case StepTrimJoin:
+			err = TrimToFirstKeyframe(ctx, files, plan.Options.keyframeTolerance())
+		case StepAvSync:
+			err = CheckAvSync(ctx, files, plan.Options.AvSync == AvSyncCorrect, plan.Options.avSyncTolerance())
+		case StepClip:
+			files, err = plan.Manifest.ClipMp4s(ctx, files, plan.Options.Start, plan.Options.End, plan.Options.ClipReencode, plan.Options.OutputPolicy)
+		case StepMergeMp4:
+			if err = MergeMp4s(ctx, files, step.Outputs[0], plan.Options.OutputPolicy); err == nil {
+				files = step.Outputs
+			}
+		case StepSplit:
+			files, err = SplitMp4s(ctx, files, plan.Options.SplitSize, plan.Options.SplitDuration, plan.Options.OutputPolicy)
+		default:
+			err = fmt.Errorf("unknown step %q", step.Kind)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	plan.Outputs = files
+	return plan.Manifest.MuxJournal.Remove()
+}
+
+// Execute downloads everything in plan and then runs its post-processing steps.
+func Execute(ctx context.Context, plan *DownloadPlan) error {
+	if err := plan.Download(ctx); err != nil {
+		return err
+	}
+	return plan.Process(ctx)
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"io"
+	"net/url"
+	"strings"
+)
+
+// WritePreview copies the local manifest read from r to w up to the first segment, init segment or key that is not
+// available yet, so a player can start on the leading segments of a download in progress. The preview is an EVENT
+// playlist, which players reload, without #EXT-X-ENDLIST until every file is available. available is given the path of a
+// file relative to the local manifest. WritePreview returns the number of segments written and whether that is all of them.
+func WritePreview(r io.Reader, w io.Writer, available func(file string) bool) (segments int, complete bool, err error) {
+	var preview strings.Builder
+	// pending holds the tags of the next segment, written along with it once it is available
+	var pending []string
+
+	scanner := newPlaylistScanner(r)
+	complete = true
+scan:
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, TagPlaylistType):
+			line = TagPlaylistType + PlaylistTypeEvent
+		case strings.HasPrefix(line, TagMap) || strings.HasPrefix(line, TagKey):
+			_, attributes, _ := strings.Cut(line, ":")
+			if uri := ParseAttributes(attributes)["URI"]; uri != "" && !isPreviewAvailable(uri, available) {
+				complete = false
+				break scan
+			}
+		case !strings.HasPrefix(line, "#"):
+			if !isPreviewAvailable(line, available) {
+				complete = false
+				break scan
+			}
+			for _, tag := range pending {
+				preview.WriteString(tag + "\n")
+			}
+			preview.WriteString(line + "\n")
+			pending = pending[:0]
+			segments++
+			continue
+		}
+		pending = append(pending, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return segments, false, err
+	}
+
+	// the tags after the last segment, #EXT-X-ENDLIST among them, end the preview once everything is available
+	if complete {
+		for _, tag := range pending {
+			preview.WriteString(tag + "\n")
+		}
+	}
+	_, err = io.WriteString(w, preview.String())
+	return segments, complete, err
+}
+
+// isPreviewAvailable reports whether the file uri of the local manifest is available. Keys of a DRM system and other
+// absolute uris are left for the player to fetch.
+func isPreviewAvailable(uri string, available func(file string) bool) bool {
+	if strings.Contains(uri, "://") || strings.HasPrefix(uri, "data:") {
+		return true
+	}
+	if unescaped, err := url.PathUnescape(uri); err == nil {
+		uri = unescaped
+	}
+	return available(uri)
+}
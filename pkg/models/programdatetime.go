@@ -0,0 +1,61 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// InferProgramDateTimes fills in the program date time of the discontinuities that lack an #EXT-X-PROGRAM-DATE-TIME, so
+// wall-clock windows and timelines still work. A discontinuity after one with a program date time follows on from its
+// end, one before the first follows on to its start, summing the #EXTINF durations in between. A playlist without any is
+// anchored at lastModified, the time its last fragment ended, and left as is when that is zero too. The values are marked
+// as ProgramDateTimeInferred. InferProgramDateTimes returns the number of discontinuities inferred.
+func (manifest *Manifest) InferProgramDateTimes(lastModified time.Time) int {
+	discontinuities := manifest.Discontinuities
+	if len(discontinuities) == 0 {
+		return 0
+	}
+
+	inferred := 0
+	infer := func(index int, at time.Time) {
+		discontinuities[index].ProgramDateTime = at
+		discontinuities[index].ProgramDateTimeInferred = true
+		inferred++
+	}
+
+	first := slices.IndexFunc(discontinuities, func(discontinuity Discontinuity) bool {
+		return !discontinuity.ProgramDateTime.IsZero()
+	})
+	if first < 0 {
+		if lastModified.IsZero() {
+			return 0
+		}
+		first = len(discontinuities) - 1
+		infer(first, lastModified.Add(-discontinuityRuntime(discontinuities[first])))
+	}
+
+	for index := first - 1; index >= 0; index-- {
+		infer(index, discontinuities[index+1].ProgramDateTime.Add(-discontinuityRuntime(discontinuities[index])))
+	}
+	for index := first + 1; index < len(discontinuities); index++ {
+		if discontinuities[index].ProgramDateTime.IsZero() {
+			previous := discontinuities[index-1]
+			infer(index, previous.ProgramDateTime.Add(discontinuityRuntime(previous)))
+		}
+	}
+	return inferred
+}
+
+// HasProgramDateTime reports whether any discontinuity has a program date time, reported or inferred.
+func (manifest Manifest) HasProgramDateTime() bool {
+	for _, discontinuity := range manifest.Discontinuities {
+		if !discontinuity.ProgramDateTime.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+func discontinuityRuntime(discontinuity Discontinuity) time.Duration {
+	return time.Duration(discontinuity.Entries.Runtime() * float64(time.Second))
+}
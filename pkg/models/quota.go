@@ -0,0 +1,40 @@
+package models
+
+import (
+	"errors"
+	"os"
+	"path"
+	"time"
+)
+
+// ErrQuotaExceeded is returned by DownloadPlan.Download when it stopped downloading because the directory reached
+// PlanOptions.Quota, leaving the rest of the downloads unattempted.
+var ErrQuotaExceeded = errors.New("download directory quota exceeded")
+
+// ExcludeMissing removes the fragments missing from the download directory from the manifest, such as those left out
+// when the downloads stopped at the quota, so the outputs are made of what was downloaded. A missing init file excludes
+// every fragment of its discontinuity.
+func (plan *DownloadPlan) ExcludeMissing() {
+	exists := func(file string) bool {
+		_, err := os.Stat(path.Join(plan.Options.Dir, file))
+		return err == nil
+	}
+
+	isFmp4 := plan.Manifest.IsFmp4()
+	plan.Manifest.filterEntries(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) bool {
+		if isFmp4 && !exists(plan.Manifest.InitFileName(plan.Manifest.Discontinuities[discontinuityIndex])) {
+			return false
+		}
+		return exists(plan.Manifest.LocalFilename(*entry, isFmp4))
+	})
+}
+
+// HasEntries reports whether the manifest has any fragment left, which ExcludeMissing and ExcludeVetoed may remove all of.
+func (manifest *Manifest) HasEntries() bool {
+	for _, discontinuity := range manifest.Discontinuities {
+		if len(discontinuity.Entries) > 0 {
+			return true
+		}
+	}
+	return false
+}
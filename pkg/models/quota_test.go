@@ -0,0 +1,62 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alehechka/manifestr/pkg/utils"
+)
+
+func TestQuotaReservesOutputs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Repeat("x", 100))
+	}))
+	defer server.Close()
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n#EXT-X-TARGETDURATION:1\n")
+	for index := 0; index < 10; index++ {
+		fmt.Fprintf(&playlist, "#EXTINF:1.0,\ns%d.ts\n", index)
+	}
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+
+	tests := []struct {
+		concatMode string
+		downloaded int
+	}{
+		{concatMode: ConcatNone, downloaded: 5},
+		{concatMode: ConcatSingle, downloaded: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.concatMode, func(t *testing.T) {
+			manifest, err := ReadManifest(strings.NewReader(playlist.String()), server.URL+"/playlist.m3u8")
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			quota, err := utils.OpenQuota(dir, 500)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			plan := Plan(manifest, PlanOptions{Dir: dir, Concurrency: 1, ConcatMode: test.concatMode, Quota: quota})
+			if err := plan.Download(context.Background()); !errors.Is(err, ErrQuotaExceeded) {
+				t.Fatalf("downloads ended with %v, want ErrQuotaExceeded", err)
+			}
+			downloaded := 0
+			for _, result := range plan.Results {
+				if result.Err == nil && result.Attempts > 0 {
+					downloaded++
+				}
+			}
+			if downloaded != test.downloaded {
+				t.Errorf("downloaded %d fragments, want %d", downloaded, test.downloaded)
+			}
+		})
+	}
+}
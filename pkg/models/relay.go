@@ -0,0 +1,140 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultRelayWindow is how many segments a relay playlist lists, see WriteRelay.
+const DefaultRelayWindow = 6
+
+// relayHeaderTags are the playlist tags WriteRelay keeps from the local manifest. The media and discontinuity sequences
+// and the playlist type are replaced, as they change with the window.
+var relayHeaderTags = []string{TagOpener, TagVersion, TagTargetDuration, TagIndependentSegs}
+
+// relaySegment is a segment of the local manifest with the tags before it.
+type relaySegment struct {
+	tags []string
+	uri  string
+	// key and initFile are the #EXT-X-KEY and #EXT-X-MAP the segment is under, which the window repeats when it drops the
+	// segment they were written before
+	key      string
+	initFile string
+}
+
+// WriteRelay writes a live playlist of the last window segments of the local manifest read from r that are available,
+// stopping at the first that is not, like WritePreview, so players follow a recording in progress close to its live
+// edge. The window slides with the recording, numbered by #EXT-X-MEDIA-SEQUENCE and #EXT-X-DISCONTINUITY-SEQUENCE, and
+// is ended by #EXT-X-ENDLIST once the recording ended and every segment is available.
+func WriteRelay(r io.Reader, w io.Writer, window int, available func(file string) bool) (err error) {
+	if window <= 0 {
+		window = DefaultRelayWindow
+	}
+
+	var header []string
+	var segments []relaySegment
+	mediaSequence, ended, complete := 0, false, true
+	var pending []string
+	var key, initFile string
+
+	scanner := newPlaylistScanner(r)
+scan:
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, TagMediaSequence):
+			if mediaSequence, err = strconv.Atoi(strings.TrimPrefix(line, TagMediaSequence)); err != nil {
+				return fmt.Errorf("invalid %s: %w", strings.TrimSuffix(TagMediaSequence, ":"), err)
+			}
+		case strings.HasPrefix(line, TagEndList):
+			ended = true
+		case isRelayHeaderTag(line):
+			if len(segments) == 0 && len(pending) == 0 {
+				header = append(header, line)
+			}
+		case strings.HasPrefix(line, TagPlaylistType), strings.HasPrefix(line, TagAllowCache):
+		case strings.HasPrefix(line, "#"):
+			if strings.HasPrefix(line, TagKey) {
+				key = line
+			} else if strings.HasPrefix(line, TagMap) {
+				initFile = line
+			}
+			if strings.HasPrefix(line, TagKey) || strings.HasPrefix(line, TagMap) {
+				_, attributes, _ := strings.Cut(line, ":")
+				if uri := ParseAttributes(attributes)["URI"]; uri != "" && !isPreviewAvailable(uri, available) {
+					complete = false
+					break scan
+				}
+			}
+			pending = append(pending, line)
+		default:
+			if !isPreviewAvailable(line, available) {
+				complete = false
+				break scan
+			}
+			segments = append(segments, relaySegment{tags: pending, uri: line, key: key, initFile: initFile})
+			pending = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	first := max(len(segments)-window, 0)
+	discontinuities := 0
+	for _, segment := range segments[:first] {
+		for _, tag := range segment.tags {
+			if tag == TagDiscontinuity {
+				discontinuities++
+			}
+		}
+	}
+
+	var relay strings.Builder
+	for _, tag := range header {
+		relay.WriteString(tag + "\n")
+	}
+	fmt.Fprintf(&relay, "%s%d\n", TagMediaSequence, mediaSequence+first)
+	fmt.Fprintf(&relay, "%s%d\n", TagDiscontinuitySequence, discontinuities)
+	for index, segment := range segments[first:] {
+		tags := segment.tags
+		if index == 0 {
+			tags = repeatRelayState(tags, segment)
+		}
+		for _, tag := range tags {
+			relay.WriteString(tag + "\n")
+		}
+		relay.WriteString(segment.uri + "\n")
+	}
+	if ended && complete {
+		relay.WriteString(TagEndList + "\n")
+	}
+
+	_, err = io.WriteString(w, relay.String())
+	return err
+}
+
+func isRelayHeaderTag(line string) bool {
+	for _, tag := range relayHeaderTags {
+		if strings.HasPrefix(line, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// repeatRelayState prepends the #EXT-X-KEY and #EXT-X-MAP segment is under to its tags when they were written before an
+// earlier segment, which the window dropped.
+func repeatRelayState(tags []string, segment relaySegment) []string {
+	repeated := make([]string, 0, len(tags)+2)
+	for _, state := range []string{segment.key, segment.initFile} {
+		if state != "" && !slices.Contains(tags, state) {
+			repeated = append(repeated, state)
+		}
+	}
+	return append(repeated, tags...)
+}
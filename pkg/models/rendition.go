@@ -0,0 +1,171 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+)
+
+const (
+	MediaTypeAudio          = "AUDIO"
+	MediaTypeVideo          = "VIDEO"
+	MediaTypeSubtitles      = "SUBTITLES"
+	MediaTypeClosedCaptions = "CLOSED-CAPTIONS"
+)
+
+const (
+	// RenditionsDefault selects the DEFAULT rendition of the group, falling back to the first AUTOSELECT one.
+	RenditionsDefault = "default"
+	RenditionsAll     = "all"
+	RenditionsNone    = "none"
+)
+
+// Rendition is an alternative rendition downloaded alongside the variant stream, see MasterPlaylist.SelectRenditions.
+type Rendition struct {
+	Media
+	// Dir is the subfolder of the download directory the rendition playlist and its segments are saved to.
+	Dir      string
+	Manifest *Manifest
+}
+
+// DirName is the subfolder of the download directory the rendition is saved to, e.g. audio-English.
+func (media Media) DirName() string {
+	name := media.Name
+	if name == "" {
+		name = media.Language
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, name)
+	return strings.ToLower(media.Type) + "-" + name
+}
+
+// String describes the rendition for logs and error messages.
+func (media Media) String() string {
+	description := fmt.Sprintf("%s %q", media.Type, media.Name)
+	if media.Language != "" {
+		description += " " + media.Language
+	}
+	if media.Channels != "" {
+		description += " " + media.Channels + "ch"
+	}
+	if media.Default {
+		description += " default"
+	}
+	return description
+}
+
+func (media Media) equal(other Media) bool {
+	return media.Line == other.Line
+}
+
+// SelectRenditions picks the renditions of mediaType in groupId, the AUDIO or SUBTITLES group of the selected variant:
+// RenditionsDefault, RenditionsAll, RenditionsNone or a comma separated list of languages, where en also matches en-US.
+// Renditions without a uri are muxed into the variant streams, so they are never selected.
+func (master MasterPlaylist) SelectRenditions(mediaType string, groupId string, selector string) ([]Media, error) {
+	if groupId == "" || selector == "" || selector == RenditionsNone {
+		return nil, nil
+	}
+
+	var group []Media
+	for _, media := range master.Media {
+		if media.Type == mediaType && media.GroupId == groupId && media.Uri != "" {
+			group = append(group, media)
+		}
+	}
+	if len(group) == 0 {
+		return nil, nil
+	}
+
+	switch selector {
+	case RenditionsAll:
+		return group, nil
+	case RenditionsDefault:
+		for _, media := range group {
+			if media.Default {
+				return []Media{media}, nil
+			}
+		}
+		for _, media := range group {
+			if media.Autoselect {
+				return []Media{media}, nil
+			}
+		}
+		// subtitles are only shown when asked for, while audio has to come from somewhere
+		if mediaType == MediaTypeAudio {
+			return group[:1], nil
+		}
+		return nil, nil
+	}
+
+	var selected []Media
+	for _, language := range strings.Split(selector, ",") {
+		language = strings.ToLower(strings.TrimSpace(language))
+		for _, media := range group {
+			tag := strings.ToLower(media.Language)
+			if (tag == language || strings.HasPrefix(tag, language+"-")) && !slices.ContainsFunc(selected, media.equal) {
+				selected = append(selected, media)
+			}
+		}
+	}
+	if len(selected) == 0 {
+		available := make([]string, 0, len(group))
+		for _, media := range group {
+			available = append(available, "  "+media.String())
+		}
+		return nil, fmt.Errorf("no %s rendition of group %q matches %q, available:\n%s", strings.ToLower(mediaType), groupId, selector, strings.Join(available, "\n"))
+	}
+	return selected, nil
+}
+
+// MuxRenditions muxes the audio and subtitle renditions into each of files, the MP4 of every discontinuity, cutting
+// each rendition to the window of the discontinuity. The files are rewritten in place.
+func (manifest Manifest) MuxRenditions(ctx context.Context, dir string, files []string) error {
+	tracks := make([]ffmpeg.Track, 0, len(manifest.Renditions))
+	for _, rendition := range manifest.Renditions {
+		tracks = append(tracks, ffmpeg.Track{
+			Input:     path.Join(dir, rendition.Dir, "local.manifest.m3u8"),
+			Subtitles: rendition.Type == MediaTypeSubtitles,
+			Language:  rendition.Language,
+		})
+	}
+
+	start := 0.0
+	for index, file := range files {
+		duration := manifest.Discontinuities[index].Entries.Runtime()
+
+		if manifest.MuxJournal.muxed(dir, path.Base(file)) {
+			slog.Info("resuming after muxed output", slog.String("file", file))
+			start += duration
+			continue
+		}
+
+		muxed := strings.TrimSuffix(file, ".mp4") + ".muxed.mp4"
+		slog.Info("muxing renditions", slog.String("file", file), slog.Int("renditions", len(tracks)))
+		if err := ffmpeg.MuxTracks(ctx, file, muxed, start, duration, tracks); err != nil {
+			return err
+		}
+		if !ffmpeg.DryRun(ctx) {
+			if err := os.Rename(muxed, file); err != nil {
+				return err
+			}
+			if err := manifest.MuxJournal.recordMuxed(dir, path.Base(file)); err != nil {
+				return err
+			}
+		}
+
+		start += duration
+	}
+
+	return nil
+}
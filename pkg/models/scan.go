@@ -0,0 +1,66 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// ScanFunc inspects a downloaded file before it is included in any output. Returning an error vetoes the file.
+type ScanFunc func(ctx context.Context, filePath string) error
+
+// ScanCommand returns a ScanFunc running command with the file path appended as its last argument, vetoing the file when it exits non-zero.
+// The command is split on whitespace and run without a shell, e.g. "clamscan --no-summary".
+func ScanCommand(command string) ScanFunc {
+	fields := strings.Fields(command)
+	return func(ctx context.Context, filePath string) error {
+		output, err := exec.CommandContext(ctx, fields[0], append(fields[1:], filePath)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %w: %s", fields[0], err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+}
+
+// scan runs the scan hook of the plan on a downloaded file, recording and removing it when vetoed.
+func (plan *DownloadPlan) scan(ctx context.Context, download PlannedDownload) {
+	filePath := path.Join(plan.Options.Dir, download.File)
+	if err := plan.Options.Scan(ctx, filePath); err != nil {
+		slog.Warn("fragment vetoed by scan", slog.String("file", download.File), slog.String("url", download.Url), slog.String("error", err.Error()))
+
+		plan.mu.Lock()
+		plan.Vetoed = append(plan.Vetoed, download)
+		plan.mu.Unlock()
+
+		// removing it makes the next run download and scan it again rather than trust it as existing
+		if err := os.Remove(filePath); err != nil {
+			slog.Error("failed to remove vetoed fragment", slog.String("file", filePath), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// ExcludeVetoed removes the fragments vetoed by the scan hook from the manifest so they are left out of every output.
+// A vetoed init file excludes every fragment of its discontinuity.
+func (plan *DownloadPlan) ExcludeVetoed() {
+	if len(plan.Vetoed) == 0 {
+		return
+	}
+
+	vetoed := make(map[string]bool)
+	for _, download := range plan.Vetoed {
+		vetoed[download.File] = true
+	}
+
+	isFmp4 := plan.Manifest.IsFmp4()
+	plan.Manifest.filterEntries(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) bool {
+		if isFmp4 && vetoed[plan.Manifest.InitFileName(plan.Manifest.Discontinuities[discontinuityIndex])] {
+			return false
+		}
+		return !vetoed[plan.Manifest.LocalFilename(*entry, isFmp4)]
+	})
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// SessionFileName is the record of what a download directory was made from, kept in it, see Session.
+const SessionFileName = "session.json"
+
+// Session records what a download directory was made from, so that after a reboot, or on another machine the directory
+// was moved to, it can be verified, repaired and resumed by running the download into it again. It holds no path into
+// the directory: like the index, the checksums and the list of failed downloads it names every file relative to it.
+type Session struct {
+	// Urls are the playlist url and its failovers, with their credentials and sensitive query parameters hidden.
+	Urls []string `json:"urls"`
+	// Redacted is set when credentials were hidden from the Urls, which then have to be given again to resume.
+	Redacted bool `json:"redacted,omitempty"`
+	// Flags are the values of the flags the download was given, by name, repeated flags holding several.
+	Flags   map[string][]string `json:"flags,omitempty"`
+	Started time.Time           `json:"started"`
+	Updated time.Time           `json:"updated"`
+}
+
+// LoadSession reads the session recorded in dir, returning an error matching fs.ErrNotExist when there is none.
+func LoadSession(dir string) (*Session, error) {
+	b, err := os.ReadFile(path.Join(dir, SessionFileName))
+	if err != nil {
+		return nil, err
+	}
+	session := new(Session)
+	if err := json.Unmarshal(b, session); err != nil {
+		return nil, fmt.Errorf("invalid session %s: %w", SessionFileName, err)
+	}
+	return session, nil
+}
+
+// Write saves the session as updated now into dir.
+func (session *Session) Write(dir string) error {
+	session.Updated = time.Now().UTC()
+	if session.Started.IsZero() {
+		session.Started = session.Updated
+	}
+
+	b, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(dir, SessionFileName), b, 0644)
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+	"github.com/alehechka/manifestr/pkg/utils"
+)
+
+// SplitPartSuffix is appended to the name of a split MP4 to number its parts, see SplitMp4s.
+const SplitPartSuffix = "-%03d"
+
+// splitSizeMargin is the share of the split size the media of a part is cut at, leaving room for the index of the MP4
+// that ffprobe does not list.
+const splitSizeMargin = 0.98
+
+// ParseSplitLimit parses a split limit: a duration such as 1h or 45m, or a size such as 4G or 4GB, see utils.ParseSize.
+func ParseSplitLimit(value string) (size int64, duration time.Duration, err error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		if duration <= 0 {
+			return 0, 0, fmt.Errorf("split duration %q is not positive", value)
+		}
+		return 0, duration, nil
+	}
+	size, err = utils.ParseSize(value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%q is neither a duration such as 1h nor a size such as 4GB", value)
+	}
+	if size <= 0 {
+		return 0, 0, fmt.Errorf("split size %q is not positive", value)
+	}
+	return size, 0, nil
+}
+
+// splitOutputPattern is the printf pattern of the parts of the MP4 file.
+func splitOutputPattern(file string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(file, ".mp4"), "%", "%%") + SplitPartSuffix + ".mp4"
+}
+
+// SplitMp4s splits each of files into consecutive parts of at most size bytes, or about duration long, cut at key
+// frames, for file systems such as FAT32 limited to 4GB files. The parts are named after the file with SplitPartSuffix,
+// and the files are removed once split. The parts of an earlier split are resolved against policy.
+func SplitMp4s(ctx context.Context, files []string, size int64, duration time.Duration, policy utils.OutputPolicy) ([]string, error) {
+	parts := make([]string, 0, len(files))
+	for _, file := range files {
+		pattern := splitOutputPattern(file)
+		if skip, err := resolveParts(ctx, pattern, policy); err != nil {
+			return parts, err
+		} else if skip {
+			slog.Info("skipping existing output", slog.String("file", fmt.Sprintf(pattern, 1)))
+			split, err := splitParts(pattern, 0)
+			parts = append(parts, split...)
+			if err != nil {
+				return parts, err
+			}
+			continue
+		}
+
+		times, err := splitTimes(ctx, file, size)
+		if err != nil {
+			return parts, err
+		}
+
+		slog.Info("splitting output", slog.String("file", file), slog.Int("parts", len(times)+1))
+		if err := ffmpeg.SplitMp4(ctx, file, pattern, duration.Seconds(), times); err != nil {
+			return parts, err
+		}
+		if ffmpeg.DryRun(ctx) {
+			parts = append(parts, fmt.Sprintf(pattern, 1))
+			continue
+		}
+
+		split, err := splitParts(pattern, size)
+		if err != nil {
+			return parts, err
+		}
+		parts = append(parts, split...)
+		if err := os.Remove(file); err != nil {
+			return parts, err
+		}
+	}
+	return parts, nil
+}
+
+// splitTimes returns the timestamps, in seconds, of the key frames to cut file at so that no part exceeds size bytes,
+// or nil when size is 0.
+func splitTimes(ctx context.Context, file string, size int64) ([]float64, error) {
+	if size <= 0 {
+		return nil, nil
+	}
+	packets, err := ffmpeg.Packets(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+
+	// the segment muxer cuts at key frames of the video, or anywhere in a file without video
+	hasVideo := false
+	for _, packet := range packets {
+		hasVideo = hasVideo || packet.Video
+	}
+
+	budget := int64(float64(size) * splitSizeMargin)
+	var times []float64
+	// written counts the bytes before the current packet, partStart those before the current part and cut those before
+	// the key frame it was last possible to cut at
+	var written, partStart, cut int64
+	cutTime := 0.0
+	gop := func() error {
+		if written-cut > budget {
+			return fmt.Errorf("%s has a key frame interval of over %d bytes at %.3fs, it cannot be split at that size", file, size, cutTime)
+		}
+		if written-partStart > budget {
+			times = append(times, cutTime)
+			partStart = cut
+		}
+		return nil
+	}
+	for _, packet := range packets {
+		if packet.Keyframe && (packet.Video || !hasVideo) {
+			if err := gop(); err != nil {
+				return nil, err
+			}
+			cut, cutTime = written, packet.Time
+		}
+		written += packet.Size
+	}
+	if err := gop(); err != nil {
+		return nil, err
+	}
+	return times, nil
+}
+
+// resolveParts applies policy to the parts of an earlier split with the printf pattern, see utils.OutputPolicy.Resolve.
+// Overwritten parts are removed, so none is left over from a split into more parts.
+func resolveParts(ctx context.Context, pattern string, policy utils.OutputPolicy) (skip bool, err error) {
+	for number := 1; ; number++ {
+		part := fmt.Sprintf(pattern, number)
+		if _, err := os.Stat(part); errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		if skip, err := policy.Resolve(part); err != nil || skip {
+			return skip, err
+		}
+		if policy.Overwrites() && !ffmpeg.DryRun(ctx) {
+			if err := os.Remove(part); err != nil {
+				return false, err
+			}
+		}
+	}
+}
+
+// splitParts lists the parts written for the printf pattern, checking that none exceeds size bytes when it is set.
+func splitParts(pattern string, size int64) ([]string, error) {
+	var parts []string
+	for number := 1; ; number++ {
+		part := fmt.Sprintf(pattern, number)
+		info, err := os.Stat(part)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return parts, err
+		}
+		if size > 0 && info.Size() > size {
+			return parts, fmt.Errorf("split part %s is %d bytes, over the split size of %d", part, info.Size(), size)
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no parts were written to %s", pattern)
+	}
+	return parts, nil
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	StageDownload = "download"
+	StageMux      = "mux"
+	StageUpload   = "upload"
+)
+
+// Stages are the stages a run is made of, in order.
+var Stages = []string{StageDownload, StageMux, StageUpload}
+
+// StageHandoff is what a stage of a run split into separate jobs leaves in the download directory for the next one, such
+// as containers sharing a volume: the download stage the parsed manifests and the options to process them with, the mux
+// stage the outputs to upload and the upload stage where they went. Paths are relative to the download directory, which
+// may be mounted elsewhere by the next job.
+type StageHandoff struct {
+	Stage    string    `json:"stage"`
+	Finished time.Time `json:"finished"`
+	// BaseUrl is what the uris of Manifest are resolved against.
+	BaseUrl    string            `json:"baseUrl,omitempty"`
+	Manifest   *Manifest         `json:"manifest,omitempty"`
+	Renditions []StageRendition  `json:"renditions,omitempty"`
+	Options    PlanOptions       `json:"options"`
+	Outputs    []string          `json:"outputs,omitempty"`
+	Uploaded   map[string]string `json:"uploaded,omitempty"`
+}
+
+// StageRendition is a rendition of the manifest, which the Manifest of a StageHandoff does not keep itself.
+type StageRendition struct {
+	Media
+	Dir      string    `json:"dir"`
+	BaseUrl  string    `json:"baseUrl,omitempty"`
+	Manifest *Manifest `json:"manifest"`
+}
+
+// StageFileName names the handoff stage leaves in the download directory, e.g. stage.download.json.
+func StageFileName(stage string) string {
+	return "stage." + stage + ".json"
+}
+
+// NewStageHandoff returns the handoff of stage for manifest, planned with options.
+func NewStageHandoff(stage string, manifest *Manifest, options PlanOptions) *StageHandoff {
+	handoff := &StageHandoff{Stage: stage, Manifest: manifest, Options: options}
+	if manifest.BaseUrl != nil {
+		handoff.BaseUrl = manifest.BaseUrl.String()
+	}
+	for _, rendition := range manifest.Renditions {
+		stageRendition := StageRendition{Media: rendition.Media, Dir: rendition.Dir, Manifest: rendition.Manifest}
+		if rendition.Manifest.BaseUrl != nil {
+			stageRendition.BaseUrl = rendition.Manifest.BaseUrl.String()
+		}
+		handoff.Renditions = append(handoff.Renditions, stageRendition)
+	}
+	return handoff
+}
+
+// LoadStageHandoff reads the handoff the stage before stage left in dir, restoring its manifest and options for dir.
+func LoadStageHandoff(dir string, stage string) (*StageHandoff, error) {
+	index := -1
+	for i, known := range Stages {
+		if known == stage {
+			index = i
+		}
+	}
+	if index <= 0 {
+		return nil, fmt.Errorf("stage %q follows no other stage", stage)
+	}
+	previous := Stages[index-1]
+
+	b, err := os.ReadFile(path.Join(dir, StageFileName(previous)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s holds no handoff of the %s stage, run it first: %w", dir, previous, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	handoff := new(StageHandoff)
+	if err := json.Unmarshal(b, handoff); err != nil {
+		return nil, fmt.Errorf("invalid handoff %s: %w", StageFileName(previous), err)
+	}
+	if handoff.Manifest == nil {
+		return nil, fmt.Errorf("handoff %s holds no manifest", StageFileName(previous))
+	}
+
+	if handoff.Manifest.BaseUrl, err = url.Parse(handoff.BaseUrl); err != nil {
+		return nil, fmt.Errorf("invalid url in handoff %s: %w", StageFileName(previous), err)
+	}
+	for _, rendition := range handoff.Renditions {
+		if rendition.Manifest == nil {
+			return nil, fmt.Errorf("handoff %s holds no manifest for rendition %s", StageFileName(previous), rendition.Dir)
+		}
+		if rendition.Manifest.BaseUrl, err = url.Parse(rendition.BaseUrl); err != nil {
+			return nil, fmt.Errorf("invalid url in handoff %s: %w", StageFileName(previous), err)
+		}
+		handoff.Manifest.Renditions = append(handoff.Manifest.Renditions, Rendition{Media: rendition.Media, Dir: rendition.Dir, Manifest: rendition.Manifest})
+	}
+	handoff.Options.Dir = dir
+	return handoff, nil
+}
+
+// Write saves the handoff as finished now into dir.
+func (handoff *StageHandoff) Write(dir string) error {
+	handoff.Finished = time.Now().UTC()
+	handoff.Options.Dir = ""
+
+	b, err := json.MarshalIndent(handoff, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(dir, StageFileName(handoff.Stage)), b, 0644)
+}
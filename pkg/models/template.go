@@ -0,0 +1,85 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var sequencePlaceholder = regexp.MustCompile(`\{seq(?::(0?)(\d+)d)?\}`)
+
+// ExpandSegmentTemplate builds a segment url from template for the given media sequence number and segment start time.
+// Numeric placeholders take the form {seq} or {seq:05d}, and strftime directives (%Y, %m, %d, %H, %M, %S, %j, %s) are replaced using t.
+func ExpandSegmentTemplate(template string, sequence int, t time.Time) string {
+	expanded := sequencePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		match := sequencePlaceholder.FindStringSubmatch(placeholder)
+		if match[2] == "" {
+			return strconv.Itoa(sequence)
+		}
+		return fmt.Sprintf("%"+match[1]+match[2]+"d", sequence)
+	})
+
+	return strftime(expanded, t)
+}
+
+func strftime(format string, t time.Time) string {
+	var b strings.Builder
+
+	for index := 0; index < len(format); index++ {
+		if format[index] != '%' || index == len(format)-1 {
+			b.WriteByte(format[index])
+			continue
+		}
+
+		index++
+		switch format[index] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 's':
+			fmt.Fprintf(&b, "%d", t.Unix())
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(format[index])
+		}
+	}
+
+	return b.String()
+}
+
+// GenerateManifest reconstructs a VOD manifest for an origin with predictable segment naming, expanding template (see ExpandSegmentTemplate) for count segments of equal duration.
+func GenerateManifest(template string, firstSequence int, count int, duration time.Duration, start time.Time) *Manifest {
+	manifest := &Manifest{
+		Version:        3,
+		MediaSequence:  firstSequence,
+		TargetDuration: duration.Seconds(),
+	}
+
+	discontinuity := Discontinuity{ProgramDateTime: start}
+	for index := 0; index < count; index++ {
+		segmentStart := start.Add(time.Duration(index) * duration)
+		discontinuity.Entries = append(discontinuity.Entries, &ManifestEntry{
+			Duration: duration.Seconds(),
+			Url:      ExpandSegmentTemplate(template, firstSequence+index, segmentStart),
+			Sequence: firstSequence + index,
+		})
+	}
+	manifest.Discontinuities = []Discontinuity{discontinuity}
+
+	return manifest
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	EventSegment       = "segment"
+	EventDiscontinuity = "discontinuity"
+	EventDateRange     = "daterange"
+)
+
+// TimelineEvent is a single point or span on the presentation timeline of a manifest.
+type TimelineEvent struct {
+	Kind string
+	// Name identifies the event: the fragment url, the DATERANGE ID or the index of the discontinuity or ad break.
+	Name string
+	// Sequence is the media sequence number of the fragment the event starts at, or -1 for date ranges.
+	Sequence int
+	// MediaTime is the offset from the start of the playlist. Only valid when HasMediaTime is set,
+	// which date ranges lack when the playlist has no #EXT-X-PROGRAM-DATE-TIME to place them by.
+	MediaTime    time.Duration
+	HasMediaTime bool
+	// WallClock is the program date time of the event, or zero when the playlist does not report one.
+	WallClock time.Time
+	// WallClockInferred is set when WallClock was inferred rather than reported, see InferProgramDateTimes.
+	WallClockInferred bool
+	Duration          time.Duration
+	Line              int
+}
+
+// Timeline lists every fragment, discontinuity, ad break and date range of the manifest ordered by media time.
+func (manifest Manifest) Timeline() []TimelineEvent {
+	events := make([]TimelineEvent, 0)
+
+	// anchors map wall clock to media time for placing date ranges
+	type anchor struct {
+		mediaTime time.Duration
+		wallClock time.Time
+	}
+	anchors := make([]anchor, 0)
+
+	var mediaTime time.Duration
+	previousDiscontinuity := -1
+	manifest.forEachEntry(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) {
+		inferred := manifest.Discontinuities[discontinuityIndex].ProgramDateTimeInferred
+		if discontinuityIndex != previousDiscontinuity {
+			previousDiscontinuity = discontinuityIndex
+			if !start.IsZero() {
+				anchors = append(anchors, anchor{mediaTime: mediaTime, wallClock: start})
+			}
+			if discontinuityIndex > 0 {
+				events = append(events, TimelineEvent{
+					Kind:              EventDiscontinuity,
+					Name:              strconv.Itoa(discontinuityIndex),
+					Sequence:          sequence,
+					MediaTime:         mediaTime,
+					HasMediaTime:      true,
+					WallClock:         start,
+					WallClockInferred: inferred,
+					Line:              manifest.Discontinuities[discontinuityIndex].Line,
+				})
+			}
+		}
+
+		duration := time.Duration(entry.Duration * float64(time.Second))
+		events = append(events, TimelineEvent{
+			Kind:              EventSegment,
+			Name:              entry.Url,
+			Sequence:          sequence,
+			MediaTime:         mediaTime,
+			HasMediaTime:      true,
+			WallClock:         start,
+			WallClockInferred: inferred,
+			Duration:          duration,
+			Line:              entry.Line,
+		})
+		mediaTime += duration
+	})
+
+	// ad breaks span the fragments between their markers, so they are placed by those
+	segments := events
+	for index, adBreak := range manifest.AdBreaks {
+		event := TimelineEvent{Kind: EventAdBreak, Name: strconv.Itoa(index), Sequence: adBreak.FirstSequence, Line: adBreak.Line}
+		for _, segment := range segments {
+			if segment.Kind != EventSegment || !adBreak.Contains(segment.Sequence) {
+				continue
+			}
+			if !event.HasMediaTime {
+				event.MediaTime, event.HasMediaTime = segment.MediaTime, true
+				event.WallClock, event.WallClockInferred = segment.WallClock, segment.WallClockInferred
+			}
+			event.Duration += segment.Duration
+		}
+		if event.HasMediaTime {
+			events = append(events, event)
+		}
+	}
+
+	for _, dateRange := range manifest.DateRanges {
+		event := TimelineEvent{
+			Kind:      EventDateRange,
+			Name:      dateRange.ID,
+			Sequence:  -1,
+			WallClock: dateRange.StartDate,
+			Duration:  time.Duration(dateRange.Duration * float64(time.Second)),
+			Line:      dateRange.Line,
+		}
+		for index, anchor := range anchors {
+			if index == 0 || !anchor.wallClock.After(dateRange.StartDate) {
+				event.MediaTime = anchor.mediaTime + dateRange.StartDate.Sub(anchor.wallClock)
+				event.HasMediaTime = true
+			}
+		}
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].HasMediaTime != events[j].HasMediaTime {
+			return events[i].HasMediaTime
+		}
+		return events[i].MediaTime < events[j].MediaTime
+	})
+
+	return events
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// EscapeUri percent-encodes the bytes of a playlist uri that may not appear in a URI reference, such as spaces,
+// non-ASCII or invalid UTF-8 bytes and '%' signs that do not start an escape. Existing escapes and reserved characters
+// are left intact, so escaping an already valid uri does not change it.
+func EscapeUri(uri string) string {
+	var builder strings.Builder
+	for i := 0; i < len(uri); i++ {
+		c := uri[i]
+		switch {
+		case c == '%' && i+2 < len(uri) && isHex(uri[i+1]) && isHex(uri[i+2]):
+			builder.WriteByte(c)
+		case c <= ' ' || c >= 0x7F || c == '%' || strings.IndexByte("\"<>\\^`{|}", c) >= 0:
+			fmt.Fprintf(&builder, "%%%02X", c)
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	return builder.String()
+}
+
+// ResolveUri resolves a uri as written in a playlist against baseUrl, escaping it first with EscapeUri.
+func ResolveUri(baseUrl *url.URL, uri string) (*url.URL, error) {
+	return baseUrl.Parse(EscapeUri(uri))
+}
+
+// MediaUrl returns a copy of u with MediaQuery added to its query, replacing the parameters of the same names.
+func (manifest Manifest) MediaUrl(u *url.URL) *url.URL {
+	if len(manifest.MediaQuery) == 0 || u == nil {
+		return u
+	}
+	query := u.Query()
+	for name, values := range manifest.MediaQuery {
+		query[name] = values
+	}
+	withQuery := *u
+	withQuery.RawQuery = query.Encode()
+	return &withQuery
+}
+
+// resolveMediaUri resolves the uri of a segment, init segment or key like ResolveUri, adding MediaQuery.
+func (manifest Manifest) resolveMediaUri(baseUrl *url.URL, uri string) (*url.URL, error) {
+	resolved, err := ResolveUri(baseUrl, uri)
+	if err != nil {
+		return nil, err
+	}
+	return manifest.MediaUrl(resolved), nil
+}
+
+// mediaUrl adds MediaQuery to the absolute rawUrl of a segment, init segment or key, see MediaUrl.
+func (manifest Manifest) mediaUrl(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || len(manifest.MediaQuery) == 0 {
+		return rawUrl
+	}
+	return manifest.MediaUrl(parsed).String()
+}
+
+// uriPath returns the decoded path of a uri as written in a playlist, or the uri itself when it has no path.
+func uriPath(uri string) string {
+	if parsed, err := url.Parse(EscapeUri(uri)); err == nil && parsed.Path != "" {
+		return parsed.Path
+	}
+	return uri
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
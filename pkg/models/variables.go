@@ -0,0 +1,61 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const TagDefine string = "#EXT-X-DEFINE:"
+
+var variableReference = regexp.MustCompile(`\{\$([A-Za-z0-9_-]+)\}`)
+
+// variables holds the #EXT-X-DEFINE variables of a playlist for substitution into the lines that follow.
+type variables map[string]string
+
+// define adds the variable declared by the attribute list of an #EXT-X-DEFINE. Its value is given by VALUE, imported
+// from the parent playlist with IMPORT or taken from the query string of the playlist url with QUERYPARAM.
+func (vars variables) define(attributeList string, playlistUrl *url.URL, imports map[string]string) error {
+	attributes := ParseAttributes(attributeList)
+
+	switch {
+	case attributes["NAME"] != "":
+		vars[attributes["NAME"]] = attributes["VALUE"]
+	case attributes["IMPORT"] != "":
+		value, ok := imports[attributes["IMPORT"]]
+		if !ok {
+			return fmt.Errorf("%w: %s is not defined by the parent playlist", ErrUndefinedVariable, attributes["IMPORT"])
+		}
+		vars[attributes["IMPORT"]] = value
+	case attributes["QUERYPARAM"] != "":
+		name := attributes["QUERYPARAM"]
+		if playlistUrl == nil || !playlistUrl.Query().Has(name) {
+			return fmt.Errorf("%w: %s is not a query parameter of the playlist url", ErrUndefinedVariable, name)
+		}
+		vars[name] = playlistUrl.Query().Get(name)
+	default:
+		return fmt.Errorf("%w: missing NAME, IMPORT or QUERYPARAM", ErrInvalidDefine)
+	}
+
+	return nil
+}
+
+// substitute replaces every {$name} reference in line with the value of the variable, failing on references to undefined variables.
+func (vars variables) substitute(line string) (string, error) {
+	if !strings.Contains(line, "{$") {
+		return line, nil
+	}
+
+	var err error
+	substituted := variableReference.ReplaceAllStringFunc(line, func(reference string) string {
+		name := variableReference.FindStringSubmatch(reference)[1]
+		value, ok := vars[name]
+		if !ok {
+			err = fmt.Errorf("%w: %s", ErrUndefinedVariable, name)
+			return reference
+		}
+		return value
+	})
+	return substituted, err
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var (
+	ErrNoProgramDateTime = errors.New("playlist has no #EXT-X-PROGRAM-DATE-TIME to match a wall-clock time against")
+	ErrEmptyWindow       = errors.New("no fragments within the time window")
+)
+
+// TimeBound is one side of a time window: an offset from the start of the playlist, or a wall-clock time matched against
+// #EXT-X-PROGRAM-DATE-TIME when At is set. The zero TimeBound leaves its side of the window open.
+type TimeBound struct {
+	Offset time.Duration
+	At     time.Time
+}
+
+// ParseTimeBound parses an offset such as 1h20m or an RFC 3339 timestamp such as 2024-01-01T10:00:00Z. An empty value
+// is the zero TimeBound.
+func ParseTimeBound(value string) (TimeBound, error) {
+	if value == "" {
+		return TimeBound{}, nil
+	}
+	if offset, err := time.ParseDuration(value); err == nil {
+		if offset < 0 {
+			return TimeBound{}, fmt.Errorf("negative offset %q", value)
+		}
+		return TimeBound{Offset: offset}, nil
+	}
+	at, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return TimeBound{}, fmt.Errorf("%q is neither an offset such as 1h20m nor an RFC 3339 timestamp", value)
+	}
+	return TimeBound{At: at}, nil
+}
+
+func (bound TimeBound) IsZero() bool {
+	return bound.Offset == 0 && bound.At.IsZero()
+}
+
+// CheckWindow rejects a window from start to end that does not end after it starts, as far as can be told before the
+// manifest is read: when both bounds are offsets or both are wall-clock times. CutWindow checks the others once it
+// resolved them against the manifest.
+func CheckWindow(start TimeBound, end TimeBound) error {
+	switch {
+	case end.IsZero():
+		return nil
+	case start.At.IsZero() && end.At.IsZero() && end.Offset <= start.Offset:
+		return fmt.Errorf("window ends at %s, not after it starts at %s", end.Offset, start.Offset)
+	case !start.At.IsZero() && !end.At.IsZero() && !end.At.After(start.At):
+		return fmt.Errorf("window ends at %s, not after it starts at %s", end.At.Format(time.RFC3339Nano), start.At.Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// seconds resolves bound to an offset in seconds from the start of the manifest. A wall-clock time before the first
+// fragment resolves to 0, one after the last to the runtime of the manifest.
+func (manifest Manifest) seconds(bound TimeBound) (float64, error) {
+	if bound.At.IsZero() {
+		return bound.Offset.Seconds(), nil
+	}
+
+	offset, found, dated := 0.0, false, false
+	manifest.forEachEntry(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) {
+		if !found && !start.IsZero() {
+			dated = true
+			if since := bound.At.Sub(start).Seconds(); since < entry.Duration {
+				offset += max(since, 0)
+				found = true
+				return
+			}
+		}
+		if !found {
+			offset += entry.Duration
+		}
+	})
+	if !dated {
+		return 0, ErrNoProgramDateTime
+	}
+	return offset, nil
+}
+
+// CutWindow keeps only the fragments of the manifest and its renditions that overlap the window from start to end, so
+// nothing outside of it is downloaded. It returns the bounds as offsets from the start of the first fragment kept, which
+// the output can be clipped to precisely, see ClipMp4s; clipEnd is 0 when end is.
+func (manifest *Manifest) CutWindow(start TimeBound, end TimeBound) (clipStart time.Duration, clipEnd time.Duration, err error) {
+	from, err := manifest.seconds(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	to := math.Inf(1)
+	if !end.IsZero() {
+		if to, err = manifest.seconds(end); err != nil {
+			return 0, 0, err
+		}
+	}
+	if to <= from {
+		return 0, 0, fmt.Errorf("window ends at %.3fs before it starts at %.3fs", to, from)
+	}
+
+	// renditions are cut at the same offsets as the variant so they stay aligned with it
+	first := manifest.cutOffsets(from, to)
+	if first < 0 {
+		return 0, 0, ErrEmptyWindow
+	}
+	for _, rendition := range manifest.Renditions {
+		rendition.Manifest.cutOffsets(from, to)
+	}
+
+	clipStart = time.Duration((from - first) * float64(time.Second))
+	if !math.IsInf(to, 1) {
+		clipEnd = time.Duration((to - first) * float64(time.Second))
+	}
+	return clipStart, clipEnd, nil
+}
+
+// cutOffsets keeps the fragments overlapping from to to seconds, returning the offset the first of them started at, or -1
+// when none was kept.
+func (manifest *Manifest) cutOffsets(from float64, to float64) float64 {
+	offset, first := 0.0, -1.0
+	manifest.filterEntries(func(discontinuityIndex int, sequence int, start time.Time, entry *ManifestEntry) bool {
+		keep := offset+entry.Duration > from && offset < to
+		if keep && first < 0 {
+			first = offset
+		}
+		offset += entry.Duration
+		return keep
+	})
+	return first
+}
@@ -0,0 +1,190 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// datedPlaylist tags every segment with its program date time, as most live origins do.
+const datedPlaylist = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T10:00:00.000Z
+#EXTINF:10.0,
+s0.ts
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T10:00:10.000Z
+#EXTINF:10.0,
+s1.ts
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T10:00:20.000Z
+#EXTINF:10.0,
+s2.ts
+#EXT-X-ENDLIST
+`
+
+func readTestManifest(t *testing.T, playlist string) *Manifest {
+	t.Helper()
+	manifest, err := ReadManifestWithOptions(strings.NewReader(playlist), "http://localhost/stream/playlist.m3u8", ReadOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("reading playlist: %v", err)
+	}
+	return manifest
+}
+
+func at(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestProgramDateTimePerSegment(t *testing.T) {
+	manifest := readTestManifest(t, datedPlaylist)
+
+	if got, want := manifest.Discontinuities[0].ProgramDateTime, at(t, "2024-01-01T10:00:00Z"); !got.Equal(want) {
+		t.Errorf("discontinuity starts at %s, want %s", got, want)
+	}
+	for index, want := range []string{"2024-01-01T10:00:00Z", "2024-01-01T10:00:10Z", "2024-01-01T10:00:20Z"} {
+		if got := manifest.Discontinuities[0].Entries[index].ProgramDateTime; !got.Equal(at(t, want)) {
+			t.Errorf("segment %d is dated %s, want %s", index, got, want)
+		}
+	}
+}
+
+func TestProgramDateTimeCountedBack(t *testing.T) {
+	// only the second segment is tagged, the discontinuity starts one segment before it
+	manifest := readTestManifest(t, `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXTINF:6.0,
+s0.ts
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T10:00:06.000Z
+#EXTINF:6.0,
+s1.ts
+#EXT-X-ENDLIST
+`)
+	if got, want := manifest.Discontinuities[0].ProgramDateTime, at(t, "2024-01-01T10:00:00Z"); !got.Equal(want) {
+		t.Errorf("discontinuity starts at %s, want %s", got, want)
+	}
+}
+
+func TestProgramDateTimeBeforeDiscontinuity(t *testing.T) {
+	// the tag dates the segment following it, which opens the next discontinuity
+	manifest := readTestManifest(t, `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T10:00:00.000Z
+#EXTINF:6.0,
+s0.ts
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T11:00:00.000Z
+#EXT-X-DISCONTINUITY
+#EXTINF:6.0,
+s1.ts
+#EXT-X-ENDLIST
+`)
+	for index, want := range []string{"2024-01-01T10:00:00Z", "2024-01-01T11:00:00Z"} {
+		if got := manifest.Discontinuities[index].ProgramDateTime; !got.Equal(at(t, want)) {
+			t.Errorf("discontinuity %d starts at %s, want %s", index, got, want)
+		}
+	}
+}
+
+func TestCutWindowPerSegmentProgramDateTime(t *testing.T) {
+	tests := []struct {
+		name      string
+		start     string
+		end       string
+		segments  []string
+		clipStart time.Duration
+		clipEnd   time.Duration
+	}{
+		{name: "within first segment", start: "2024-01-01T10:00:05Z", segments: []string{"s0.ts", "s1.ts", "s2.ts"}, clipStart: 5 * time.Second},
+		{name: "within second segment", start: "2024-01-01T10:00:15Z", segments: []string{"s1.ts", "s2.ts"}, clipStart: 5 * time.Second},
+		{name: "window", start: "2024-01-01T10:00:12Z", end: "2024-01-01T10:00:18Z", segments: []string{"s1.ts"}, clipStart: 2 * time.Second, clipEnd: 8 * time.Second},
+		{name: "offset", start: "25s", segments: []string{"s2.ts"}, clipStart: 5 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manifest := readTestManifest(t, datedPlaylist)
+			start, err := ParseTimeBound(test.start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			end, err := ParseTimeBound(test.end)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clipStart, clipEnd, err := manifest.CutWindow(start, end)
+			if err != nil {
+				t.Fatalf("cutting window: %v", err)
+			}
+			if clipStart != test.clipStart || clipEnd != test.clipEnd {
+				t.Errorf("clipped to %s-%s, want %s-%s", clipStart, clipEnd, test.clipStart, test.clipEnd)
+			}
+			var segments []string
+			for _, entry := range manifest.Discontinuities[0].Entries {
+				segments = append(segments, entry.Url)
+			}
+			if strings.Join(segments, " ") != strings.Join(test.segments, " ") {
+				t.Errorf("kept %v, want %v", segments, test.segments)
+			}
+		})
+	}
+}
+
+func TestWriteManifestKeepsProgramDateTimes(t *testing.T) {
+	manifest := readTestManifest(t, datedPlaylist)
+	start, _ := ParseTimeBound("2024-01-01T10:00:05Z")
+	if _, _, err := manifest.CutWindow(start, TimeBound{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := manifest.WriteManifest(&b); err != nil {
+		t.Fatal(err)
+	}
+	var dates []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if strings.HasPrefix(line, TagProgramDateTime) {
+			dates = append(dates, strings.TrimPrefix(line, TagProgramDateTime))
+		}
+	}
+	want := []string{"2024-01-01T10:00:00Z", "2024-01-01T10:00:10Z", "2024-01-01T10:00:20Z"}
+	if strings.Join(dates, " ") != strings.Join(want, " ") {
+		t.Errorf("wrote program date times %v, want %v", dates, want)
+	}
+}
+
+func TestCheckWindow(t *testing.T) {
+	tests := []struct {
+		start string
+		end   string
+		valid bool
+	}{
+		{start: "10s", end: "20s", valid: true},
+		{start: "20s", end: "10s"},
+		{start: "10s", end: "10s"},
+		{end: "0s", valid: true},
+		{start: "10s", valid: true},
+		{start: "2024-01-01T10:00:00Z", end: "2024-01-01T10:00:10Z", valid: true},
+		{start: "2024-01-01T10:00:10Z", end: "2024-01-01T10:00:00Z"},
+		{start: "2024-01-01T10:00:00Z", end: "2024-01-01T11:00:00+01:00"},
+		// resolved against the manifest by CutWindow
+		{start: "1h", end: "2024-01-01T10:00:00Z", valid: true},
+	}
+	for _, test := range tests {
+		start, err := ParseTimeBound(test.start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end, err := ParseTimeBound(test.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckWindow(start, end); (err == nil) != test.valid {
+			t.Errorf("CheckWindow(%q, %q) = %v, want valid %v", test.start, test.end, err, test.valid)
+		}
+	}
+}
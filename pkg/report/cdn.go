@@ -0,0 +1,160 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alehechka/manifestr/pkg/utils"
+)
+
+const (
+	CacheHit     = "HIT"
+	CacheMiss    = "MISS"
+	CacheUnknown = "UNKNOWN"
+)
+
+// CdnReport summarizes the cache behavior of a CDN from the response headers recorded in an archive index.
+type CdnReport struct {
+	Total   int
+	Results map[string]int
+	Pops    map[string]int
+	// Elapsed holds the download durations, in milliseconds, of each cache result.
+	Elapsed map[string][]int64
+	Slowest []CdnRecord
+}
+
+// CdnRecord is an index record classified by its cache result.
+type CdnRecord struct {
+	utils.IndexRecord
+	Result string
+	Pop    string
+}
+
+// CacheResult classifies a response as a cache hit or miss from the headers used by common CDNs.
+func CacheResult(headers map[string]string) string {
+	for _, name := range []string{"X-Cache", "Cf-Cache-Status"} {
+		value := strings.ToUpper(headers[name])
+		switch {
+		case strings.Contains(value, "MISS"), strings.Contains(value, "EXPIRED"):
+			return CacheMiss
+		case strings.Contains(value, "HIT"):
+			return CacheHit
+		}
+	}
+
+	if age, err := strconv.Atoi(headers["Age"]); err == nil {
+		if age > 0 {
+			return CacheHit
+		}
+		return CacheMiss
+	}
+
+	return CacheUnknown
+}
+
+// EdgePop identifies the edge location that served a response, if the CDN reports one.
+func EdgePop(headers map[string]string) string {
+	if pop := headers["X-Amz-Cf-Pop"]; pop != "" {
+		return pop
+	}
+	if ray := headers["Cf-Ray"]; ray != "" {
+		if _, pop, found := strings.Cut(ray, "-"); found {
+			return pop
+		}
+	}
+	if servedBy := headers["X-Served-By"]; servedBy != "" {
+		nodes := strings.Split(servedBy, ",")
+		return strings.TrimSpace(nodes[len(nodes)-1])
+	}
+	return ""
+}
+
+// AnalyzeCdn classifies every record and keeps the slowest count downloads for correlation with cache misses.
+func AnalyzeCdn(records []utils.IndexRecord, slowest int) CdnReport {
+	report := CdnReport{
+		Results: make(map[string]int),
+		Pops:    make(map[string]int),
+		Elapsed: make(map[string][]int64),
+	}
+
+	classified := make([]CdnRecord, 0, len(records))
+	for _, record := range records {
+		if record.Headers == nil {
+			continue
+		}
+
+		cdnRecord := CdnRecord{IndexRecord: record, Result: CacheResult(record.Headers), Pop: EdgePop(record.Headers)}
+		classified = append(classified, cdnRecord)
+
+		report.Total++
+		report.Results[cdnRecord.Result]++
+		report.Elapsed[cdnRecord.Result] = append(report.Elapsed[cdnRecord.Result], record.ElapsedMs)
+		if cdnRecord.Pop != "" {
+			report.Pops[cdnRecord.Pop]++
+		}
+	}
+
+	sort.Slice(classified, func(i, j int) bool {
+		return classified[i].ElapsedMs > classified[j].ElapsedMs
+	})
+	report.Slowest = classified[:min(slowest, len(classified))]
+
+	return report
+}
+
+// HitRatio is the share of classified responses served from cache.
+func (report CdnReport) HitRatio() float64 {
+	classified := report.Results[CacheHit] + report.Results[CacheMiss]
+	if classified == 0 {
+		return 0
+	}
+	return float64(report.Results[CacheHit]) / float64(classified)
+}
+
+func percentile(values []int64, p float64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int64{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+func (report CdnReport) Write(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "fragments with headers: %d\n", report.Total)
+	fmt.Fprintf(&b, "hit ratio:              %.1f%% (%d hit, %d miss, %d unknown)\n\n", report.HitRatio()*100, report.Results[CacheHit], report.Results[CacheMiss], report.Results[CacheUnknown])
+
+	fmt.Fprintf(&b, "%-8s %8s %8s %8s\n", "result", "count", "p50 ms", "p95 ms")
+	for _, result := range []string{CacheHit, CacheMiss, CacheUnknown} {
+		elapsed := report.Elapsed[result]
+		fmt.Fprintf(&b, "%-8s %8d %8d %8d\n", result, len(elapsed), percentile(elapsed, 0.5), percentile(elapsed, 0.95))
+	}
+
+	if len(report.Pops) > 0 {
+		pops := make([]string, 0, len(report.Pops))
+		for pop := range report.Pops {
+			pops = append(pops, pop)
+		}
+		sort.Slice(pops, func(i, j int) bool { return report.Pops[pops[i]] > report.Pops[pops[j]] })
+
+		fmt.Fprintf(&b, "\nedge pops:\n")
+		for _, pop := range pops {
+			fmt.Fprintf(&b, "  %-12s %d\n", pop, report.Pops[pop])
+		}
+	}
+
+	if len(report.Slowest) > 0 {
+		fmt.Fprintf(&b, "\nslowest fragments:\n")
+		for _, record := range report.Slowest {
+			fmt.Fprintf(&b, "  %8d ms  %-7s %-10s %s\n", record.ElapsedMs, record.Result, record.Pop, record.File)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,220 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/utils"
+)
+
+const (
+	SeverityMust   = "MUST"
+	SeverityShould = "SHOULD"
+)
+
+// MaxKeyframeInterval is the longest gap, in seconds, the HLS Authoring Specification allows between key frames.
+const MaxKeyframeInterval = 2.0
+
+// ComplianceCheck is the outcome of one HLS Authoring Specification rule applied to one playlist or variant.
+type ComplianceCheck struct {
+	Rule     string
+	Severity string
+	Subject  string
+	Passed   bool
+	Detail   string
+}
+
+// ComplianceReport grades a multivariant playlist and its variants against a subset of Apple's HLS Authoring Specification.
+type ComplianceReport struct {
+	Checks []ComplianceCheck
+}
+
+func (report *ComplianceReport) check(rule string, severity string, subject string, passed bool, detail string, args ...any) {
+	report.Checks = append(report.Checks, ComplianceCheck{Rule: rule, Severity: severity, Subject: subject, Passed: passed, Detail: fmt.Sprintf(detail, args...)})
+}
+
+// Score starts at 100 and loses 15 points for every failed MUST and 5 for every failed SHOULD.
+func (report ComplianceReport) Score() int {
+	score := 100
+	for _, check := range report.Checks {
+		switch {
+		case check.Passed:
+		case check.Severity == SeverityMust:
+			score -= 15
+		default:
+			score -= 5
+		}
+	}
+	return max(score, 0)
+}
+
+// Grade maps the Score to a letter from A to F.
+func (report ComplianceReport) Grade() string {
+	switch score := report.Score(); {
+	case score >= 90:
+		return "A"
+	case score >= 80:
+		return "B"
+	case score >= 70:
+		return "C"
+	case score >= 60:
+		return "D"
+	}
+	return "F"
+}
+
+// CheckCompliance applies the authoring rules to master and to each of its variant playlists, fetched through
+// downloader. When probeDir is set the first segment of every variant is downloaded there and probed with ffprobe to
+// check key frame intervals.
+func CheckCompliance(ctx context.Context, master *models.MasterPlaylist, probeDir string, downloader *utils.Downloader) ComplianceReport {
+	report := ComplianceReport{}
+
+	report.check("independent-segments", SeverityShould, "master", master.IndependentSegments, "#EXT-X-INDEPENDENT-SEGMENTS declared")
+	report.check("i-frame-playlists", SeverityMust, "master", len(master.IFrameVariants) > 0, "%d I-frame playlists for trick play", len(master.IFrameVariants))
+
+	for _, variant := range master.Variants {
+		subject := variant.Uri
+		isVideo := variant.Codecs == "" || hasCodec(variant.Codecs, "avc1", "avc3", "hvc1", "hev1", "dvh1", "dvhe")
+
+		report.check("codecs", SeverityMust, subject, variant.Codecs != "", "CODECS %q", variant.Codecs)
+		report.check("average-bandwidth", SeverityShould, subject, variant.AverageBandwidth > 0, "AVERAGE-BANDWIDTH %d", variant.AverageBandwidth)
+		if isVideo {
+			report.check("resolution", SeverityShould, subject, variant.ResolutionHeight > 0, "RESOLUTION %dx%d", variant.ResolutionWidth, variant.ResolutionHeight)
+			report.check("frame-rate", SeverityShould, subject, variant.FrameRate > 0, "FRAME-RATE %.3f", variant.FrameRate)
+			report.check("max-frame-rate", SeverityMust, subject, variant.FrameRate <= 60, "FRAME-RATE %.3f of at most 60", variant.FrameRate)
+		}
+		if hasCodec(variant.Codecs, "avc1", "avc3") {
+			report.check("h264-resolution", SeverityMust, subject, variant.ResolutionWidth <= 1920 && variant.ResolutionHeight <= 1080,
+				"H.264 at %dx%d, HEVC is required above 1920x1080", variant.ResolutionWidth, variant.ResolutionHeight)
+		}
+
+		checkVariantPlaylist(ctx, &report, master.ResolvedUri(variant.Uri), subject, isVideo, probeDir, downloader)
+	}
+
+	return report
+}
+
+func checkVariantPlaylist(ctx context.Context, report *ComplianceReport, variantUrl string, subject string, isVideo bool, probeDir string, downloader *utils.Downloader) {
+	in, err := downloader.OpenUrl(variantUrl)
+	if err != nil {
+		report.check("variant-playlist", SeverityMust, subject, false, "failed to fetch: %s", err)
+		return
+	}
+	defer in.Close()
+
+	manifest, err := models.ReadManifest(in, variantUrl)
+	if err != nil {
+		report.check("variant-playlist", SeverityMust, subject, false, "failed to parse: %s", err)
+		return
+	}
+
+	report.check("target-duration", SeverityShould, subject, manifest.TargetDuration == 6, "EXT-X-TARGETDURATION %.0f, 6 recommended", manifest.TargetDuration)
+
+	longest := 0.0
+	for _, discontinuity := range manifest.Discontinuities {
+		for _, entry := range discontinuity.Entries {
+			longest = math.Max(longest, entry.Duration)
+		}
+	}
+	report.check("segment-duration", SeverityMust, subject, math.Round(longest) <= manifest.TargetDuration,
+		"longest segment %.3fs within the target duration", longest)
+
+	if probeDir == "" || !isVideo {
+		return
+	}
+
+	interval, err := firstSegmentKeyframeInterval(ctx, manifest, probeDir, downloader)
+	if err != nil {
+		report.check("keyframe-interval", SeverityMust, subject, false, "failed to probe: %s", err)
+		return
+	}
+	report.check("keyframe-interval", SeverityMust, subject, interval <= MaxKeyframeInterval, "key frames every %.3fs at most, %.0fs allowed", interval, MaxKeyframeInterval)
+}
+
+// firstSegmentKeyframeInterval downloads the first segment of manifest, preceded by its init segment if any, and returns the longest gap between its key frames.
+func firstSegmentKeyframeInterval(ctx context.Context, manifest *models.Manifest, probeDir string, downloader *utils.Downloader) (float64, error) {
+	if len(manifest.Discontinuities) == 0 || len(manifest.Discontinuities[0].Entries) == 0 {
+		return 0, fmt.Errorf("no segments")
+	}
+	discontinuity := manifest.Discontinuities[0]
+	entry := discontinuity.Entries[0]
+
+	probeFile, err := os.CreateTemp(probeDir, "probe-*"+path.Ext(manifest.LocalFilename(*entry, manifest.IsFmp4())))
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(probeFile.Name())
+	defer probeFile.Close()
+
+	uris := []string{manifest.DynamicUrl(*entry).String()}
+	if discontinuity.InitFile != "" {
+		uris = append([]string{manifest.DynamicInitFile(discontinuity).String()}, uris...)
+	}
+	for _, uri := range uris {
+		in, err := downloader.OpenUrl(uri)
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.Copy(probeFile, in)
+		in.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := probeFile.Close(); err != nil {
+		return 0, err
+	}
+
+	keyframes, err := ffmpeg.Keyframes(ctx, probeFile.Name())
+	if err != nil {
+		return 0, err
+	}
+	if len(keyframes) == 0 {
+		return 0, fmt.Errorf("no key frames found")
+	}
+
+	// the segment end bounds the last gap
+	keyframes = append(keyframes, keyframes[0]+entry.Duration)
+	longest := 0.0
+	for index := 1; index < len(keyframes); index++ {
+		longest = math.Max(longest, keyframes[index]-keyframes[index-1])
+	}
+	return longest, nil
+}
+
+func hasCodec(codecs string, prefixes ...string) bool {
+	for _, codec := range strings.Split(codecs, ",") {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(strings.TrimSpace(codec), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (report ComplianceReport) Write(w io.Writer) error {
+	var b strings.Builder
+
+	failed := 0
+	for _, check := range report.Checks {
+		result := "PASS"
+		if !check.Passed {
+			result = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(&b, "%-4s %-6s %-20s %-30s %s\n", result, check.Severity, check.Rule, check.Subject, check.Detail)
+	}
+
+	fmt.Fprintf(&b, "\n%d of %d checks passed, score %d, grade %s\n", len(report.Checks)-failed, len(report.Checks), report.Score(), report.Grade())
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
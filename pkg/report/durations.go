@@ -0,0 +1,129 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/alehechka/manifestr/pkg/ffmpeg"
+	"github.com/alehechka/manifestr/pkg/models"
+)
+
+// DurationRecord compares the #EXTINF duration of a downloaded segment with the duration of its media.
+type DurationRecord struct {
+	File     string
+	Sequence int
+	Declared float64
+	Actual   float64
+	// Err is set when the segment could not be probed, in which case Actual is zero.
+	Err error
+}
+
+func (record DurationRecord) Delta() float64 {
+	return record.Actual - record.Declared
+}
+
+// DurationReport surfaces packagers whose #EXTINF durations differ from the media, which breaks seek accuracy.
+type DurationReport struct {
+	Records []DurationRecord
+	// Tolerance is the absolute delta in seconds above which a segment is flagged.
+	Tolerance float64
+}
+
+// CompareDurations probes every segment of the local manifest in dir. Fragmented MP4 segments are probed together with their init segment.
+func CompareDurations(ctx context.Context, manifest *models.Manifest, dir string, tolerance float64) DurationReport {
+	report := DurationReport{Tolerance: tolerance}
+	isFmp4 := manifest.IsFmp4()
+
+	sequence := manifest.MediaSequence
+	for _, discontinuity := range manifest.Discontinuities {
+		for _, entry := range discontinuity.Entries {
+			record := DurationRecord{File: manifest.LocalFilename(*entry, isFmp4), Sequence: sequence, Declared: entry.Duration}
+			sequence++
+
+			input := path.Join(dir, record.File)
+			cleanup := func() {}
+			if isFmp4 {
+				input, cleanup, record.Err = withInitSegment(path.Join(dir, manifest.InitFileName(discontinuity)), input)
+			}
+			if record.Err == nil {
+				record.Actual, record.Err = ffmpeg.Duration(ctx, input)
+				cleanup()
+			}
+			report.Records = append(report.Records, record)
+		}
+	}
+
+	return report
+}
+
+// withInitSegment writes init followed by segment to a temporary file so the segment can be probed on its own.
+func withInitSegment(init string, segment string) (string, func(), error) {
+	out, err := os.CreateTemp("", "manifestr-duration-*.mp4")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(out.Name()) }
+
+	for _, file := range []string{init, segment} {
+		in, err := os.Open(file)
+		if err != nil {
+			out.Close()
+			cleanup()
+			return "", nil, err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			out.Close()
+			cleanup()
+			return "", nil, err
+		}
+	}
+
+	return out.Name(), cleanup, out.Close()
+}
+
+// Flagged lists the records whose delta exceeds the tolerance or that could not be probed.
+func (report DurationReport) Flagged() []DurationRecord {
+	flagged := make([]DurationRecord, 0)
+	for _, record := range report.Records {
+		if record.Err != nil || math.Abs(record.Delta()) > report.Tolerance {
+			flagged = append(flagged, record)
+		}
+	}
+	return flagged
+}
+
+func (report DurationReport) Write(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%-8s %-40s %10s %10s %10s\n", "sequence", "file", "extinf", "actual", "delta")
+	declared, actual, largest := 0.0, 0.0, 0.0
+	for _, record := range report.Records {
+		if record.Err != nil {
+			fmt.Fprintf(&b, "%-8d %-40s %10.3f %10s %10s  %s\n", record.Sequence, record.File, record.Declared, "-", "-", record.Err)
+			continue
+		}
+
+		flag := ""
+		if math.Abs(record.Delta()) > report.Tolerance {
+			flag = "  !"
+		}
+		fmt.Fprintf(&b, "%-8d %-40s %10.3f %10.3f %+10.3f%s\n", record.Sequence, record.File, record.Declared, record.Actual, record.Delta(), flag)
+
+		declared += record.Declared
+		actual += record.Actual
+		largest = math.Max(largest, math.Abs(record.Delta()))
+	}
+
+	fmt.Fprintf(&b, "\n%d segments, %d flagged beyond %.3fs\n", len(report.Records), len(report.Flagged()), report.Tolerance)
+	fmt.Fprintf(&b, "declared %.3fs, actual %.3fs, drift %+.3fs, largest delta %.3fs\n", declared, actual, actual-declared, largest)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,266 @@
+package report
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"unicode/utf8"
+
+	"github.com/alehechka/manifestr/pkg/models"
+)
+
+// EventMessagesFileName is the JSON sidecar ExtractEventMessages results are written to in the download directory.
+const EventMessagesFileName = "event-messages.json"
+
+var errTruncatedEmsg = errors.New("truncated emsg box")
+
+// unknownEventDuration is the event_duration of an emsg whose duration is not known yet.
+const unknownEventDuration = 0xFFFFFFFF
+
+// EventMessage is a DASH/CMAF event carried in an emsg box of an fMP4 segment, such as a SCTE-35 splice or an ID3 tag.
+type EventMessage struct {
+	File     string `json:"file"`
+	Sequence int    `json:"sequence"`
+	// Version is 0 when the presentation time is relative to the segment, 1 when it is absolute.
+	Version     int    `json:"version"`
+	SchemeIdUri string `json:"schemeIdUri"`
+	Value       string `json:"value,omitempty"`
+	Id          uint32 `json:"id"`
+	Timescale   uint32 `json:"timescale"`
+	// PresentationTime is the time of the event in seconds on the media timeline of the track.
+	PresentationTime float64 `json:"presentationTime"`
+	// MediaTime is the offset of the event in seconds from the start of the playlist, placed within its segment by PresentationTime.
+	MediaTime float64 `json:"mediaTime"`
+	// Duration is the duration of the event in seconds, omitted when the packager did not know it yet.
+	Duration *float64 `json:"duration,omitempty"`
+	Payload  []byte   `json:"payload,omitempty"`
+	// Text is the payload when it is valid UTF-8, such as the XML of a SCTE-35 event.
+	Text string `json:"text,omitempty"`
+}
+
+// ExtractEventMessages collects the emsg events of every fMP4 segment of the local manifest in dir, in playlist order.
+// Segments that cannot be read are skipped, their errors joined into the returned error.
+func ExtractEventMessages(manifest *models.Manifest, dir string) ([]EventMessage, error) {
+	events := make([]EventMessage, 0)
+	if !manifest.IsFmp4() {
+		return events, errors.New("event messages are only extracted from fMP4 segments")
+	}
+
+	var errs []error
+	sequence := manifest.MediaSequence
+	mediaTime := 0.0
+	for _, discontinuity := range manifest.Discontinuities {
+		// the earliest presentation time of each segment is in the timescale of the track, which only the init segment has
+		var trackTimescale uint32
+		if discontinuity.InitFile != "" {
+			init, err := os.ReadFile(path.Join(dir, manifest.InitFileName(discontinuity)))
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				trackTimescale = readTrackTimescale(init)
+			}
+		}
+
+		for _, entry := range discontinuity.Entries {
+			file := manifest.LocalFilename(*entry, true)
+			segment, err := os.ReadFile(path.Join(dir, file))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", file, err))
+			}
+
+			segmentStart := -1.0
+			if decodeTime, ok := readBaseMediaDecodeTime(segment); ok && trackTimescale > 0 {
+				segmentStart = float64(decodeTime) / float64(trackTimescale)
+			}
+
+			for _, box := range topLevelBoxes(segment, "emsg") {
+				event, err := parseEmsg(box)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", file, err))
+					continue
+				}
+
+				event.File = file
+				event.Sequence = sequence
+				switch {
+				case event.Version == 0:
+					// a version 0 presentation time is the delta from the start of the segment
+					event.MediaTime = mediaTime + event.PresentationTime
+					if segmentStart >= 0 {
+						event.PresentationTime += segmentStart
+					}
+				case segmentStart >= 0:
+					event.MediaTime = mediaTime + event.PresentationTime - segmentStart
+				default:
+					event.MediaTime = mediaTime
+				}
+				events = append(events, event)
+			}
+
+			sequence++
+			mediaTime += entry.Duration
+		}
+	}
+
+	return events, errors.Join(errs...)
+}
+
+// WriteEventMessages writes the events as indented JSON, leaving the markup of XML payloads unescaped.
+func WriteEventMessages(w io.Writer, events []EventMessage) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(events)
+}
+
+// topLevelBoxes returns the content, without the header, of the top-level boxes of boxType in data, or of the boxes
+// nested in a container box when data is its content.
+func topLevelBoxes(data []byte, boxType string) [][]byte {
+	var boxes [][]byte
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return boxes
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return boxes
+		}
+
+		if string(data[4:8]) == boxType {
+			boxes = append(boxes, data[headerSize:size])
+		}
+		data = data[size:]
+	}
+	return boxes
+}
+
+// nestedBox follows boxTypes down from data, returning the content of the first box found at the end of the path.
+func nestedBox(data []byte, boxTypes ...string) ([]byte, bool) {
+	for _, boxType := range boxTypes {
+		boxes := topLevelBoxes(data, boxType)
+		if len(boxes) == 0 {
+			return nil, false
+		}
+		data = boxes[0]
+	}
+	return data, true
+}
+
+// readTrackTimescale returns the timescale of the first track of an init segment from its mdhd box, or 0.
+func readTrackTimescale(init []byte) uint32 {
+	mdhd, ok := nestedBox(init, "moov", "trak", "mdia", "mdhd")
+	if !ok || len(mdhd) < 4 {
+		return 0
+	}
+	// version 1 has 64-bit creation and modification times
+	offset := 12
+	if mdhd[0] == 1 {
+		offset = 20
+	}
+	if len(mdhd) < offset+4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(mdhd[offset : offset+4])
+}
+
+// readBaseMediaDecodeTime returns the tfdt decode time of the first track fragment of a media segment.
+func readBaseMediaDecodeTime(segment []byte) (uint64, bool) {
+	tfdt, ok := nestedBox(segment, "moof", "traf", "tfdt")
+	if !ok || len(tfdt) < 8 {
+		return 0, false
+	}
+	if tfdt[0] == 1 {
+		if len(tfdt) < 12 {
+			return 0, false
+		}
+		return binary.BigEndian.Uint64(tfdt[4:12]), true
+	}
+	return uint64(binary.BigEndian.Uint32(tfdt[4:8])), true
+}
+
+// parseEmsg parses the content of an emsg box of version 0 or 1, whose fields come in a different order.
+func parseEmsg(box []byte) (EventMessage, error) {
+	var event EventMessage
+	if len(box) < 4 {
+		return event, errTruncatedEmsg
+	}
+	event.Version = int(box[0])
+	data := box[4:]
+
+	readString := func() (string, bool) {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			return "", false
+		}
+		value := string(data[:end])
+		data = data[end+1:]
+		return value, true
+	}
+
+	var presentationTime uint64
+	var duration uint32
+	switch event.Version {
+	case 0:
+		var ok bool
+		if event.SchemeIdUri, ok = readString(); !ok {
+			return event, errTruncatedEmsg
+		}
+		if event.Value, ok = readString(); !ok || len(data) < 16 {
+			return event, errTruncatedEmsg
+		}
+		event.Timescale = binary.BigEndian.Uint32(data[0:4])
+		presentationTime = uint64(binary.BigEndian.Uint32(data[4:8]))
+		duration = binary.BigEndian.Uint32(data[8:12])
+		event.Id = binary.BigEndian.Uint32(data[12:16])
+		data = data[16:]
+	case 1:
+		if len(data) < 20 {
+			return event, errTruncatedEmsg
+		}
+		event.Timescale = binary.BigEndian.Uint32(data[0:4])
+		presentationTime = binary.BigEndian.Uint64(data[4:12])
+		duration = binary.BigEndian.Uint32(data[12:16])
+		event.Id = binary.BigEndian.Uint32(data[16:20])
+		data = data[20:]
+
+		var ok bool
+		if event.SchemeIdUri, ok = readString(); !ok {
+			return event, errTruncatedEmsg
+		}
+		if event.Value, ok = readString(); !ok {
+			return event, errTruncatedEmsg
+		}
+	default:
+		return event, fmt.Errorf("unsupported emsg version %d", event.Version)
+	}
+
+	if event.Timescale == 0 {
+		return event, errors.New("emsg box has no timescale")
+	}
+	event.PresentationTime = float64(presentationTime) / float64(event.Timescale)
+	if duration != unknownEventDuration {
+		seconds := float64(duration) / float64(event.Timescale)
+		event.Duration = &seconds
+	}
+
+	if len(data) > 0 {
+		event.Payload = bytes.Clone(data)
+		if utf8.Valid(data) {
+			event.Text = string(data)
+		}
+	}
+	return event, nil
+}
@@ -0,0 +1,273 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	InspectJson  = "json"
+	InspectYaml  = "yaml"
+	InspectTable = "table"
+)
+
+const (
+	PlaylistMaster = "master"
+	PlaylistMedia  = "media"
+)
+
+// ManifestSummary is the structure of a playlist as reported by the inspect command, without any of its segments downloaded.
+// Which fields are set depends on whether Type is PlaylistMaster or PlaylistMedia.
+type ManifestSummary struct {
+	Url     string `json:"url" yaml:"url"`
+	Type    string `json:"type" yaml:"type"`
+	Version int    `json:"version,omitempty" yaml:"version,omitempty"`
+
+	PlaylistType    string  `json:"playlistType,omitempty" yaml:"playlistType,omitempty"`
+	TargetDuration  float64 `json:"targetDuration,omitempty" yaml:"targetDuration,omitempty"`
+	MediaSequence   int     `json:"mediaSequence,omitempty" yaml:"mediaSequence,omitempty"`
+	Segments        int     `json:"segments,omitempty" yaml:"segments,omitempty"`
+	Runtime         float64 `json:"runtime,omitempty" yaml:"runtime,omitempty"`
+	Discontinuities int     `json:"discontinuities,omitempty" yaml:"discontinuities,omitempty"`
+	// Container is fmp4 or ts.
+	Container string `json:"container,omitempty" yaml:"container,omitempty"`
+	// Encryption lists the distinct #EXT-X-KEY methods of the segments, NONE for clear ones.
+	Encryption []string `json:"encryption,omitempty" yaml:"encryption,omitempty"`
+	EndList    bool     `json:"endList,omitempty" yaml:"endList,omitempty"`
+	Bandwidth  int      `json:"bandwidth,omitempty" yaml:"bandwidth,omitempty"`
+	Resolution string   `json:"resolution,omitempty" yaml:"resolution,omitempty"`
+	Codecs     string   `json:"codecs,omitempty" yaml:"codecs,omitempty"`
+
+	// DateRanges are the #EXT-X-DATERANGE tags of a media playlist, such as the ad breaks signalled with SCTE-35.
+	DateRanges []DateRangeSummary `json:"dateRanges,omitempty" yaml:"dateRanges,omitempty"`
+
+	IndependentSegments bool               `json:"independentSegments,omitempty" yaml:"independentSegments,omitempty"`
+	Variants            []VariantSummary   `json:"variants,omitempty" yaml:"variants,omitempty"`
+	IFrameVariants      []VariantSummary   `json:"iFrameVariants,omitempty" yaml:"iFrameVariants,omitempty"`
+	Renditions          []RenditionSummary `json:"renditions,omitempty" yaml:"renditions,omitempty"`
+}
+
+// VariantSummary is an #EXT-X-STREAM-INF or #EXT-X-I-FRAME-STREAM-INF of a master playlist.
+type VariantSummary struct {
+	Bandwidth        int     `json:"bandwidth" yaml:"bandwidth"`
+	AverageBandwidth int     `json:"averageBandwidth,omitempty" yaml:"averageBandwidth,omitempty"`
+	Resolution       string  `json:"resolution,omitempty" yaml:"resolution,omitempty"`
+	FrameRate        float64 `json:"frameRate,omitempty" yaml:"frameRate,omitempty"`
+	Codecs           string  `json:"codecs,omitempty" yaml:"codecs,omitempty"`
+	Audio            string  `json:"audio,omitempty" yaml:"audio,omitempty"`
+	Subtitles        string  `json:"subtitles,omitempty" yaml:"subtitles,omitempty"`
+	Uri              string  `json:"uri" yaml:"uri"`
+}
+
+// RenditionSummary is an #EXT-X-MEDIA of a master playlist.
+type RenditionSummary struct {
+	Type     string `json:"type" yaml:"type"`
+	GroupId  string `json:"groupId" yaml:"groupId"`
+	Name     string `json:"name" yaml:"name"`
+	Language string `json:"language,omitempty" yaml:"language,omitempty"`
+	Default  bool   `json:"default,omitempty" yaml:"default,omitempty"`
+	Uri      string `json:"uri,omitempty" yaml:"uri,omitempty"`
+}
+
+// DateRangeSummary is an #EXT-X-DATERANGE of a media playlist, with the hex encoded SCTE-35 payloads it carries.
+type DateRangeSummary struct {
+	Id        string     `json:"id" yaml:"id"`
+	Class     string     `json:"class,omitempty" yaml:"class,omitempty"`
+	StartDate time.Time  `json:"startDate" yaml:"startDate"`
+	EndDate   *time.Time `json:"endDate,omitempty" yaml:"endDate,omitempty"`
+	Duration  float64    `json:"duration,omitempty" yaml:"duration,omitempty"`
+	Scte35Cmd string     `json:"scte35Cmd,omitempty" yaml:"scte35Cmd,omitempty"`
+	Scte35Out string     `json:"scte35Out,omitempty" yaml:"scte35Out,omitempty"`
+	Scte35In  string     `json:"scte35In,omitempty" yaml:"scte35In,omitempty"`
+	Line      int        `json:"line,omitempty" yaml:"line,omitempty"`
+}
+
+// SummarizeManifest describes a media playlist.
+func SummarizeManifest(manifestUrl string, manifest *models.Manifest) ManifestSummary {
+	summary := ManifestSummary{
+		Url:             manifestUrl,
+		Type:            PlaylistMedia,
+		Version:         manifest.Version,
+		PlaylistType:    manifest.PlaylistType,
+		TargetDuration:  manifest.TargetDuration,
+		MediaSequence:   manifest.MediaSequence,
+		Segments:        manifest.LastSequence() - manifest.MediaSequence + 1,
+		Discontinuities: len(manifest.Discontinuities),
+		Container:       "ts",
+		EndList:         manifest.EndList,
+		Bandwidth:       manifest.Bandwidth,
+		Resolution:      resolution(manifest.ResolutionWidth, manifest.ResolutionHeight),
+		Codecs:          manifest.Codecs,
+	}
+	if manifest.IsFmp4() {
+		summary.Container = "fmp4"
+	}
+
+	for _, dateRange := range manifest.DateRanges {
+		dateRangeSummary := DateRangeSummary{
+			Id:        dateRange.ID,
+			Class:     dateRange.Class,
+			StartDate: dateRange.StartDate,
+			Duration:  dateRange.Duration,
+			Scte35Cmd: dateRange.Scte35Cmd,
+			Scte35Out: dateRange.Scte35Out,
+			Scte35In:  dateRange.Scte35In,
+			Line:      dateRange.Line,
+		}
+		if !dateRange.EndDate.IsZero() {
+			dateRangeSummary.EndDate = &dateRange.EndDate
+		}
+		summary.DateRanges = append(summary.DateRanges, dateRangeSummary)
+	}
+
+	methods := make(map[string]bool)
+	for _, discontinuity := range manifest.Discontinuities {
+		summary.Runtime += discontinuity.Entries.Runtime()
+		for _, entry := range discontinuity.Entries {
+			method := models.KeyMethodNone
+			if entry.Key != nil {
+				method = entry.Key.Method
+			}
+			if !methods[method] {
+				methods[method] = true
+				summary.Encryption = append(summary.Encryption, method)
+			}
+		}
+	}
+
+	return summary
+}
+
+// SummarizeMaster describes a master playlist by its variants and renditions.
+func SummarizeMaster(masterUrl string, master *models.MasterPlaylist) ManifestSummary {
+	summary := ManifestSummary{
+		Url:                 masterUrl,
+		Type:                PlaylistMaster,
+		Version:             master.Version,
+		IndependentSegments: master.IndependentSegments,
+	}
+	for _, variant := range master.Variants {
+		summary.Variants = append(summary.Variants, summarizeVariant(variant))
+	}
+	for _, variant := range master.IFrameVariants {
+		summary.IFrameVariants = append(summary.IFrameVariants, summarizeVariant(variant))
+	}
+	for _, media := range master.Media {
+		summary.Renditions = append(summary.Renditions, RenditionSummary{Type: media.Type, GroupId: media.GroupId, Name: media.Name, Language: media.Language, Default: media.Default, Uri: media.Uri})
+	}
+	return summary
+}
+
+func summarizeVariant(variant models.Variant) VariantSummary {
+	return VariantSummary{
+		Bandwidth:        variant.Bandwidth,
+		AverageBandwidth: variant.AverageBandwidth,
+		Resolution:       resolution(variant.ResolutionWidth, variant.ResolutionHeight),
+		FrameRate:        variant.FrameRate,
+		Codecs:           variant.Codecs,
+		Audio:            variant.Audio,
+		Subtitles:        variant.Subtitles,
+		Uri:              variant.Uri,
+	}
+}
+
+func resolution(width int, height int) string {
+	if width == 0 || height == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", width, height)
+}
+
+// Write writes the summary in format, one of InspectJson, InspectYaml or InspectTable.
+func (summary ManifestSummary) Write(w io.Writer, format string) error {
+	switch format {
+	case InspectJson:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(summary)
+	case InspectYaml:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(summary); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case InspectTable:
+		return summary.writeTable(w)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+func (summary ManifestSummary) writeTable(w io.Writer) error {
+	var b strings.Builder
+	row := func(label string, value any) {
+		fmt.Fprintf(&b, "%-22s %v\n", label, value)
+	}
+
+	row("url", summary.Url)
+	row("type", summary.Type)
+	if summary.Version > 0 {
+		row("version", summary.Version)
+	}
+
+	if summary.Type == PlaylistMedia {
+		if summary.PlaylistType != "" {
+			row("playlist type", summary.PlaylistType)
+		}
+		row("target duration", fmt.Sprintf("%gs", summary.TargetDuration))
+		row("media sequence", summary.MediaSequence)
+		row("segments", summary.Segments)
+		row("runtime", fmt.Sprintf("%.3fs", summary.Runtime))
+		row("discontinuities", summary.Discontinuities)
+		row("container", summary.Container)
+		row("encryption", strings.Join(summary.Encryption, ", "))
+		row("end list", summary.EndList)
+		if summary.Bandwidth > 0 {
+			row("bandwidth", summary.Bandwidth)
+		}
+		if summary.Resolution != "" {
+			row("resolution", summary.Resolution)
+		}
+		if summary.Codecs != "" {
+			row("codecs", summary.Codecs)
+		}
+
+		if len(summary.DateRanges) > 0 {
+			fmt.Fprintf(&b, "\n%-24s %-24s %-30s %-9s %s\n", "ID", "CLASS", "START", "DURATION", "SCTE-35")
+			for _, dateRange := range summary.DateRanges {
+				scte35 := make([]string, 0, 3)
+				for _, payload := range []struct{ name, value string }{{"CMD", dateRange.Scte35Cmd}, {"OUT", dateRange.Scte35Out}, {"IN", dateRange.Scte35In}} {
+					if payload.value != "" {
+						scte35 = append(scte35, payload.name+" "+payload.value)
+					}
+				}
+				fmt.Fprintf(&b, "%-24s %-24s %-30s %-9s %s\n", dateRange.Id, dateRange.Class, dateRange.StartDate.Format(time.RFC3339Nano), fmt.Sprintf("%gs", dateRange.Duration), strings.Join(scte35, ", "))
+			}
+		}
+	} else {
+		row("independent segments", summary.IndependentSegments)
+
+		fmt.Fprintf(&b, "\n%-10s %-10s %-10s %-7s %-32s %s\n", "BANDWIDTH", "AVERAGE", "RESOLUTION", "FPS", "CODECS", "URI")
+		for _, variants := range [][]VariantSummary{summary.Variants, summary.IFrameVariants} {
+			for _, variant := range variants {
+				fmt.Fprintf(&b, "%-10d %-10d %-10s %-7g %-32s %s\n", variant.Bandwidth, variant.AverageBandwidth, variant.Resolution, variant.FrameRate, variant.Codecs, variant.Uri)
+			}
+		}
+
+		if len(summary.Renditions) > 0 {
+			fmt.Fprintf(&b, "\n%-15s %-15s %-20s %-8s %-7s %s\n", "TYPE", "GROUP", "NAME", "LANG", "DEFAULT", "URI")
+			for _, rendition := range summary.Renditions {
+				fmt.Fprintf(&b, "%-15s %-15s %-20s %-8s %-7t %s\n", rendition.Type, rendition.GroupId, rendition.Name, rendition.Language, rendition.Default, rendition.Uri)
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,410 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/alehechka/manifestr/pkg/models"
+)
+
+// TimedMetadataFileName is the JSON sidecar ExtractTimedMetadata results are written to in the download directory.
+const TimedMetadataFileName = "timed-metadata.json"
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	// streamTypeMetadata is the PMT stream type of ID3 timed metadata carried in PES packets
+	streamTypeMetadata = 0x15
+	// streamTypePrivate is the PMT stream type some packagers use for ID3 along with an "ID3 " registration descriptor
+	streamTypePrivate = 0x06
+	ptsClock          = 90000
+)
+
+// TimedMetadata is an ID3 tag carried in the metadata stream of an MPEG-TS segment, such as an ad beacon or lyrics.
+type TimedMetadata struct {
+	File     string `json:"file"`
+	Sequence int    `json:"sequence"`
+	// Pts is the presentation timestamp of the tag in seconds.
+	Pts float64 `json:"pts"`
+	// MediaTime is the offset of the tag in seconds from the start of the playlist, placed within its segment by Pts.
+	MediaTime float64 `json:"mediaTime"`
+	// WallClock is the time of the tag according to #EXT-X-PROGRAM-DATE-TIME, omitted when the playlist has none.
+	WallClock *time.Time `json:"wallClock,omitempty"`
+	Frames    []Id3Frame `json:"frames"`
+}
+
+// Id3Frame is a frame of an ID3v2 tag. Text and url frames are decoded into Value, PRIV frames carry Owner and Data,
+// and the raw content of any other frame is kept in Data.
+type Id3Frame struct {
+	Id          string `json:"id"`
+	Description string `json:"description,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	Value       string `json:"value,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+}
+
+// ExtractTimedMetadata collects the ID3 timed metadata of every MPEG-TS segment of the local manifest in dir, in
+// playlist order. Segments that cannot be read are skipped, their errors joined into the returned error.
+func ExtractTimedMetadata(manifest *models.Manifest, dir string) ([]TimedMetadata, error) {
+	metadata := make([]TimedMetadata, 0)
+	if manifest.IsFmp4() {
+		return metadata, errors.New("timed metadata is only extracted from MPEG-TS segments")
+	}
+
+	var errs []error
+	sequence := manifest.MediaSequence
+	mediaTime := 0.0
+	for _, discontinuity := range manifest.Discontinuities {
+		discontinuityStart := mediaTime
+		for _, entry := range discontinuity.Entries {
+			file := manifest.LocalFilename(*entry, false)
+			tags, firstPts, err := readId3Tags(path.Join(dir, file))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", file, err))
+			}
+
+			for _, tag := range tags {
+				record := TimedMetadata{
+					File:      file,
+					Sequence:  sequence,
+					Pts:       float64(tag.pts) / ptsClock,
+					MediaTime: mediaTime + float64(tag.pts-firstPts)/ptsClock,
+					Frames:    tag.frames,
+				}
+				if !discontinuity.ProgramDateTime.IsZero() {
+					wallClock := discontinuity.ProgramDateTime.Add(time.Duration((record.MediaTime - discontinuityStart) * float64(time.Second)))
+					record.WallClock = &wallClock
+				}
+				metadata = append(metadata, record)
+			}
+
+			sequence++
+			mediaTime += entry.Duration
+		}
+	}
+
+	return metadata, errors.Join(errs...)
+}
+
+// WriteTimedMetadata writes the records as indented JSON.
+func WriteTimedMetadata(w io.Writer, metadata []TimedMetadata) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(metadata)
+}
+
+type id3Tag struct {
+	pts    int64
+	frames []Id3Frame
+}
+
+// readId3Tags demuxes the metadata streams of the transport stream at filePath, returning their ID3 tags along with the
+// earliest PTS of any stream, which the segment starts at.
+func readId3Tags(filePath string) ([]id3Tag, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	pmtPids := make(map[int]bool)
+	metadataPids := make(map[int]bool)
+	pending := make(map[int][]byte)
+	firstPts := int64(-1)
+	var tags []id3Tag
+
+	flush := func(pid int) {
+		pes := pending[pid]
+		delete(pending, pid)
+		pts, payload, ok := parsePes(pes)
+		if !ok {
+			return
+		}
+		frames, err := parseId3(payload)
+		if err != nil {
+			return
+		}
+		tags = append(tags, id3Tag{pts: pts, frames: frames})
+	}
+
+	packet := make([]byte, tsPacketSize)
+	for {
+		if _, err := io.ReadFull(r, packet); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, 0, err
+		}
+		if packet[0] != tsSyncByte {
+			return nil, 0, errors.New("missing sync byte")
+		}
+
+		pid := int(packet[1]&0x1F)<<8 | int(packet[2])
+		unitStart := packet[1]&0x40 != 0
+		adaptationFieldControl := packet[3] >> 4 & 0x3
+		if adaptationFieldControl&0x1 == 0 {
+			continue
+		}
+		payload := packet[4:]
+		if adaptationFieldControl&0x2 != 0 {
+			if int(packet[4])+1 > len(payload) {
+				continue
+			}
+			payload = payload[packet[4]+1:]
+		}
+
+		switch {
+		case pid == 0:
+			for _, program := range parsePsi(payload, unitStart, 0x00, 4) {
+				// program number 0 points at the network information table
+				if binary.BigEndian.Uint16(program[:2]) != 0 {
+					pmtPids[int(binary.BigEndian.Uint16(program[2:4])&0x1FFF)] = true
+				}
+			}
+		case pmtPids[pid]:
+			for metadataPid := range parsePmt(payload, unitStart) {
+				metadataPids[metadataPid] = true
+			}
+		default:
+			if unitStart {
+				if pts, _, ok := parsePes(payload); ok && (firstPts < 0 || pts < firstPts) {
+					firstPts = pts
+				}
+			}
+			if !metadataPids[pid] {
+				continue
+			}
+			if unitStart {
+				flush(pid)
+			}
+			if unitStart || pending[pid] != nil {
+				pending[pid] = append(pending[pid], payload...)
+			}
+		}
+	}
+	for pid := range pending {
+		flush(pid)
+	}
+
+	if firstPts < 0 {
+		firstPts = 0
+	}
+	// the tags of several metadata streams are interleaved by time
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].pts < tags[j].pts
+	})
+	return tags, firstPts, nil
+}
+
+// parsePsi returns the fixed size entries of the PSI section with tableId starting in payload, ignoring sections split
+// across packets, which PAT and PMT sections of HLS segments never are.
+func parsePsi(payload []byte, unitStart bool, tableId byte, entrySize int) [][]byte {
+	section := psiSection(payload, unitStart, tableId)
+	if len(section) < 5 {
+		return nil
+	}
+	entries := make([][]byte, 0)
+	for data := section[5:]; len(data) >= entrySize; data = data[entrySize:] {
+		entries = append(entries, data[:entrySize])
+	}
+	return entries
+}
+
+// psiSection returns the body of the PSI section starting in payload after its 3 byte header and without its CRC.
+func psiSection(payload []byte, unitStart bool, tableId byte) []byte {
+	if !unitStart || len(payload) < 1 {
+		return nil
+	}
+	pointer := int(payload[0])
+	if 1+pointer+3 > len(payload) {
+		return nil
+	}
+	section := payload[1+pointer:]
+	if section[0] != tableId {
+		return nil
+	}
+	length := int(binary.BigEndian.Uint16(section[1:3]) & 0x0FFF)
+	if 3+length > len(section) || length < 4 {
+		return nil
+	}
+	return section[3 : 3+length-4]
+}
+
+// parsePmt returns the elementary stream pids of the PMT section in payload that carry ID3 timed metadata.
+func parsePmt(payload []byte, unitStart bool) map[int]bool {
+	section := psiSection(payload, unitStart, 0x02)
+	if len(section) < 9 {
+		return nil
+	}
+	programInfoLength := int(binary.BigEndian.Uint16(section[7:9]) & 0x0FFF)
+	if 9+programInfoLength > len(section) {
+		return nil
+	}
+
+	pids := make(map[int]bool)
+	for data := section[9+programInfoLength:]; len(data) >= 5; {
+		streamType := data[0]
+		pid := int(binary.BigEndian.Uint16(data[1:3]) & 0x1FFF)
+		infoLength := int(binary.BigEndian.Uint16(data[3:5]) & 0x0FFF)
+		if 5+infoLength > len(data) {
+			break
+		}
+		descriptors := data[5 : 5+infoLength]
+		if streamType == streamTypeMetadata || (streamType == streamTypePrivate && bytes.Contains(descriptors, []byte("ID3 "))) {
+			pids[pid] = true
+		}
+		data = data[5+infoLength:]
+	}
+	return pids
+}
+
+// parsePes returns the PTS and payload of the PES packet in data.
+func parsePes(data []byte) (int64, []byte, bool) {
+	if len(data) < 9 || data[0] != 0 || data[1] != 0 || data[2] != 1 {
+		return 0, nil, false
+	}
+	headerLength := int(data[8])
+	if 9+headerLength > len(data) {
+		return 0, nil, false
+	}
+	payload := data[9+headerLength:]
+	if length := int(binary.BigEndian.Uint16(data[4:6])); length > 0 && 6+length <= len(data) {
+		payload = data[9+headerLength : 6+length]
+	}
+
+	// the PTS flag is the high bit of the PTS_DTS_flags
+	if data[7]&0x80 == 0 || headerLength < 5 {
+		return 0, payload, false
+	}
+	pts := int64(data[9]>>1&0x07)<<30 | int64(data[10])<<22 | int64(data[11]>>1)<<15 | int64(data[12])<<7 | int64(data[13]>>1)
+	return pts, payload, true
+}
+
+// parseId3 decodes the frames of the ID3v2.3 or ID3v2.4 tag at the start of data.
+func parseId3(data []byte) ([]Id3Frame, error) {
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return nil, errors.New("not an ID3 tag")
+	}
+	version := data[3]
+	size := syncsafe(data[6:10])
+	if 10+size > len(data) {
+		size = len(data) - 10
+	}
+	body := data[10 : 10+size]
+
+	// skip the extended header
+	if data[5]&0x40 != 0 && len(body) >= 4 {
+		extendedSize := int(binary.BigEndian.Uint32(body[:4]))
+		if version >= 4 {
+			extendedSize = syncsafe(body[:4])
+		} else {
+			extendedSize += 4
+		}
+		if extendedSize > len(body) {
+			return nil, errors.New("truncated extended header")
+		}
+		body = body[extendedSize:]
+	}
+
+	frames := make([]Id3Frame, 0)
+	for len(body) >= 10 && body[0] != 0 {
+		id := string(body[:4])
+		frameSize := int(binary.BigEndian.Uint32(body[4:8]))
+		if version >= 4 {
+			frameSize = syncsafe(body[4:8])
+		}
+		if 10+frameSize > len(body) {
+			return frames, errors.New("truncated frame")
+		}
+		frames = append(frames, decodeFrame(id, body[10:10+frameSize]))
+		body = body[10+frameSize:]
+	}
+	return frames, nil
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+func decodeFrame(id string, content []byte) Id3Frame {
+	frame := Id3Frame{Id: id}
+	switch {
+	case id == "PRIV":
+		owner, data, _ := bytes.Cut(content, []byte{0})
+		frame.Owner, frame.Data = string(owner), data
+	case id == "TXXX" || id == "WXXX":
+		if len(content) == 0 {
+			break
+		}
+		encoding := content[0]
+		description, value := splitEncoded(encoding, content[1:])
+		frame.Description = decodeText(encoding, description)
+		if id == "WXXX" {
+			// the url of WXXX is always ISO-8859-1
+			encoding = 0
+		}
+		frame.Value = decodeText(encoding, value)
+	case strings.HasPrefix(id, "T") && len(content) > 0:
+		frame.Value = decodeText(content[0], content[1:])
+	case strings.HasPrefix(id, "W"):
+		frame.Value = decodeText(0, content)
+	default:
+		frame.Data = content
+	}
+	return frame
+}
+
+// splitEncoded splits content at the terminator of its first string, which is two zero bytes in the UTF-16 encodings.
+func splitEncoded(encoding byte, content []byte) ([]byte, []byte) {
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(content); i += 2 {
+			if content[i] == 0 && content[i+1] == 0 {
+				return content[:i], content[i+2:]
+			}
+		}
+		return content, nil
+	}
+	first, rest, _ := bytes.Cut(content, []byte{0})
+	return first, rest
+}
+
+// decodeText decodes an ID3 string: 0 is ISO-8859-1, 1 UTF-16 with a byte order mark, 2 UTF-16BE and 3 UTF-8.
+func decodeText(encoding byte, text []byte) string {
+	switch encoding {
+	case 1, 2:
+		bigEndian := encoding == 2
+		if len(text) >= 2 && text[0] == 0xFF && text[1] == 0xFE {
+			bigEndian, text = false, text[2:]
+		} else if len(text) >= 2 && text[0] == 0xFE && text[1] == 0xFF {
+			bigEndian, text = true, text[2:]
+		}
+		units := make([]uint16, 0, len(text)/2)
+		for i := 0; i+1 < len(text); i += 2 {
+			if bigEndian {
+				units = append(units, binary.BigEndian.Uint16(text[i:]))
+			} else {
+				units = append(units, binary.LittleEndian.Uint16(text[i:]))
+			}
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	case 3:
+		return strings.TrimRight(string(text), "\x00")
+	}
+	runes := make([]rune, 0, len(text))
+	for _, b := range text {
+		runes = append(runes, rune(b))
+	}
+	return strings.TrimRight(string(runes), "\x00")
+}
@@ -0,0 +1,233 @@
+package report
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alehechka/manifestr/pkg/models"
+)
+
+// GoldenSuffix is appended to the name of a corpus playlist for the golden file holding its expected round-trip output.
+const GoldenSuffix = ".golden"
+
+// RoundTripResult is how a playlist of the corpus survived being parsed and written again.
+type RoundTripResult struct {
+	File string
+	// Skipped explains why the playlist was not round-tripped, such as master playlists, which have no writer.
+	Skipped string
+	// Err is set when the playlist failed to parse or write.
+	Err error
+	// Missing lists the lines of the normalized playlist the written one lacks, and Added the lines it gained, such as
+	// tags the parser does not model or the #EXT-X-ENDLIST every written playlist ends with.
+	Missing []string
+	Added   []string
+	// Unstable is set when the written playlist is written differently once parsed again, which loses or corrupts
+	// information on every download that rewrites it.
+	Unstable bool
+	// Golden is set when the written playlist differs from the golden file of the playlist, see GoldenSuffix.
+	Golden bool
+	// Written is the playlist as written after parsing.
+	Written []byte
+}
+
+// Failed reports whether the round trip broke: parsing or writing failed, was unstable or differs from its golden file.
+// Missing and Added lines are expected of tags the parser does not model, so they are reported without failing.
+func (result RoundTripResult) Failed() bool {
+	return result.Err != nil || result.Unstable || result.Golden
+}
+
+// RoundTrip parses playlist, writes it again and compares the two, both normalized with models.Normalize so that only
+// their content differs. golden is the expected written playlist, or nil when there is none.
+func RoundTrip(file string, playlist []byte, golden []byte) RoundTripResult {
+	result := RoundTripResult{File: file}
+	if models.IsMasterPlaylist(bytes.NewReader(playlist)) {
+		result.Skipped = "master playlist"
+		return result
+	}
+
+	written, err := writeParsed(playlist)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Written = written
+
+	rewritten, err := writeParsed(written)
+	if err != nil {
+		result.Err = fmt.Errorf("parsing the written playlist: %w", err)
+		return result
+	}
+	result.Unstable = !bytes.Equal(written, rewritten)
+	result.Golden = golden != nil && !bytes.Equal(written, golden)
+
+	original, err := normalizedLines(playlist)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	normalized, err := normalizedLines(written)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Missing, result.Added = diffLines(original, normalized)
+	return result
+}
+
+func writeParsed(playlist []byte) ([]byte, error) {
+	manifest, err := models.ReadManifest(bytes.NewReader(playlist), "")
+	if err != nil {
+		return nil, err
+	}
+	var written bytes.Buffer
+	if err := manifest.WriteManifest(&written); err != nil {
+		return nil, err
+	}
+	return written.Bytes(), nil
+}
+
+func normalizedLines(playlist []byte) ([]string, error) {
+	var normalized strings.Builder
+	if err := models.Normalize(bytes.NewReader(playlist), &normalized, "", models.NormalizeOptions{Precision: 3}); err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(normalized.String(), "\n"), "\n"), nil
+}
+
+// diffLines returns the lines of a missing from b and those of b added to a, counting repeated lines, in their order.
+func diffLines(a []string, b []string) (missing []string, added []string) {
+	counts := make(map[string]int)
+	for _, line := range b {
+		counts[line]++
+	}
+	for _, line := range a {
+		if counts[line] > 0 {
+			counts[line]--
+		} else {
+			missing = append(missing, line)
+		}
+	}
+	for _, line := range b {
+		if counts[line] > 0 {
+			counts[line]--
+			added = append(added, line)
+		}
+	}
+	return missing, added
+}
+
+// RoundTripReport is the outcome of round-tripping a corpus of playlists, see RoundTripCorpus.
+type RoundTripReport struct {
+	Results []RoundTripResult
+}
+
+// RoundTripCorpus round-trips every .m3u8 and .m3u playlist below dir, in the order of their paths, against their
+// golden files when they have one. With updateGolden the golden files are written from the output instead.
+func RoundTripCorpus(dir string, updateGolden bool) (RoundTripReport, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if extension := strings.ToLower(path.Ext(file)); !entry.IsDir() && (extension == ".m3u8" || extension == ".m3u") {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return RoundTripReport{}, err
+	}
+	sort.Strings(files)
+
+	var report RoundTripReport
+	for _, file := range files {
+		result, err := roundTripFile(file, updateGolden)
+		if err != nil {
+			return report, err
+		}
+		if relative, err := filepath.Rel(dir, file); err == nil && relative != "." {
+			result.File = relative
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+func roundTripFile(file string, updateGolden bool) (RoundTripResult, error) {
+	playlist, err := os.ReadFile(file)
+	if err != nil {
+		return RoundTripResult{}, err
+	}
+	goldenPath := file + GoldenSuffix
+	golden, err := os.ReadFile(goldenPath)
+	if errors.Is(err, fs.ErrNotExist) || updateGolden {
+		golden = nil
+	} else if err != nil {
+		return RoundTripResult{}, err
+	}
+
+	result := RoundTrip(file, playlist, golden)
+	if updateGolden && result.Written != nil {
+		if err := os.WriteFile(goldenPath, result.Written, 0644); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Failed counts the playlists whose round trip broke, see RoundTripResult.Failed.
+func (report RoundTripReport) Failed() int {
+	failed := 0
+	for _, result := range report.Results {
+		if result.Failed() {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Write prints a line for every playlist, followed with verbose by the lines it lost and gained.
+func (report RoundTripReport) Write(w io.Writer, verbose bool) error {
+	var b strings.Builder
+
+	skipped := 0
+	for _, result := range report.Results {
+		status := "ok"
+		switch {
+		case result.Skipped != "":
+			status = "skipped: " + result.Skipped
+			skipped++
+		case result.Err != nil:
+			status = "error: " + result.Err.Error()
+		case result.Unstable:
+			status = "unstable: written differently once parsed again"
+		case result.Golden:
+			status = "differs from golden file"
+		}
+		if result.Skipped == "" && result.Err == nil && (len(result.Missing) > 0 || len(result.Added) > 0) {
+			status += fmt.Sprintf(" (%d lines lost, %d gained)", len(result.Missing), len(result.Added))
+		}
+		fmt.Fprintf(&b, "%-50s %s\n", result.File, status)
+
+		if verbose {
+			for _, line := range result.Missing {
+				fmt.Fprintf(&b, "  - %s\n", line)
+			}
+			for _, line := range result.Added {
+				fmt.Fprintf(&b, "  + %s\n", line)
+			}
+		}
+	}
+	fmt.Fprintf(&b, "\n%d playlists, %d failed, %d skipped\n", len(report.Results), report.Failed(), skipped)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,61 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/alehechka/manifestr/pkg/models"
+)
+
+// SizeReport compares the sizes origins announced for the files transferred by a download with the bytes written to disk,
+// catching transfers that were silently truncated.
+type SizeReport struct {
+	// Transferred counts the files downloaded by this run, of which Unknown were announced without a size.
+	Transferred int
+	Unknown     int
+	// Expected and Written total the announced and written bytes of the files with a known size.
+	Expected   int64
+	Written    int64
+	Mismatches []models.FragmentResult
+}
+
+// CompareSizes accounts the results of one or more DownloadPlans. Failed downloads and files kept from earlier runs are left out.
+func CompareSizes(results []models.FragmentResult) SizeReport {
+	report := SizeReport{}
+	for _, result := range results {
+		if result.Err != nil || result.Attempts == 0 || result.Skipped {
+			continue
+		}
+		report.Transferred++
+		if result.ExpectedSize < 0 {
+			report.Unknown++
+			continue
+		}
+
+		report.Expected += result.ExpectedSize
+		report.Written += result.Size
+		if result.SizeMismatch() {
+			report.Mismatches = append(report.Mismatches, result)
+		}
+	}
+	return report
+}
+
+func (report SizeReport) Write(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "transferred files:  %d (%d without announced size)\n", report.Transferred, report.Unknown)
+	fmt.Fprintf(&b, "expected bytes:     %d\n", report.Expected)
+	fmt.Fprintf(&b, "written bytes:      %d\n", report.Written)
+
+	if len(report.Mismatches) > 0 {
+		fmt.Fprintf(&b, "\nsize mismatches:\n")
+		for _, result := range report.Mismatches {
+			fmt.Fprintf(&b, "  %10d expected %10d written  %s\n", result.ExpectedSize, result.Size, result.File)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
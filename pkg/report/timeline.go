@@ -0,0 +1,102 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/models"
+)
+
+const (
+	TimelineCsv = "csv"
+	TimelineEdl = "edl"
+)
+
+// WriteTimelineCsv writes one row per timeline event with its media time and wall clock position.
+func WriteTimelineCsv(w io.Writer, events []models.TimelineEvent) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"kind", "name", "sequence", "media_time", "wall_clock", "duration", "line", "wall_clock_inferred"}); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		record := []string{event.Kind, event.Name, "", "", "", strconv.FormatFloat(event.Duration.Seconds(), 'f', 3, 64), strconv.Itoa(event.Line), ""}
+		if event.Sequence >= 0 {
+			record[2] = strconv.Itoa(event.Sequence)
+		}
+		if event.HasMediaTime {
+			record[3] = strconv.FormatFloat(event.MediaTime.Seconds(), 'f', 3, 64)
+		}
+		if !event.WallClock.IsZero() {
+			record[4] = event.WallClock.Format(models.TimeFormat)
+			record[7] = strconv.FormatBool(event.WallClockInferred)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteTimelineEdl writes the timeline as a CMX 3600 edit decision list with one event per segment,
+// marking discontinuities and date ranges as locators. Timecodes are non-drop frame at fps.
+func WriteTimelineEdl(w io.Writer, title string, events []models.TimelineEvent, fps int) error {
+	if fps <= 0 {
+		return fmt.Errorf("invalid frame rate %d", fps)
+	}
+
+	if _, err := fmt.Fprintf(w, "TITLE: %s\nFCM: NON-DROP FRAME\n\n", title); err != nil {
+		return err
+	}
+
+	edit := 0
+	var recordEnd time.Duration
+	for _, event := range events {
+		if !event.HasMediaTime {
+			continue
+		}
+
+		if event.Kind != models.EventSegment {
+			// locators attach to the edit they fall in
+			if _, err := fmt.Fprintf(w, "* LOC: %s %s %s\n", timecode(event.MediaTime, fps), event.Kind, event.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		edit++
+		sourceIn := event.MediaTime
+		sourceOut := event.MediaTime + event.Duration
+		recordIn := recordEnd
+		recordEnd = recordIn + event.Duration
+		if _, err := fmt.Fprintf(w, "%03d  AX       V     C        %s %s %s %s\n* FROM CLIP NAME: %s\n",
+			edit, timecode(sourceIn, fps), timecode(sourceOut, fps), timecode(recordIn, fps), timecode(recordEnd, fps), event.Name); err != nil {
+			return err
+		}
+		if !event.WallClock.IsZero() {
+			comment := event.WallClock.Format(models.TimeFormat)
+			if event.WallClockInferred {
+				comment += " (inferred)"
+			}
+			if _, err := fmt.Fprintf(w, "* COMMENT: %s\n", comment); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// timecode formats an offset as HH:MM:SS:FF.
+func timecode(offset time.Duration, fps int) string {
+	if offset < 0 {
+		offset = 0
+	}
+	frames := int64(offset.Seconds()*float64(fps) + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d:%02d", frames/int64(fps)/3600, frames/int64(fps)/60%60, frames/int64(fps)%60, frames%int64(fps))
+}
@@ -0,0 +1,108 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/telemetry"
+	"github.com/alehechka/manifestr/pkg/utils"
+)
+
+// RunReportFileName is the timing report of the last run, kept in the download directory, see RunReport.
+const RunReportFileName = "report.json"
+
+// downloadStage is the span the fragments of a run are downloaded in, whose wall time the bandwidth is measured over.
+const downloadStage = "download fragments"
+
+// RunReport breaks a run down stage by stage, from fetching and parsing the manifest through downloading to muxing, with
+// the aggregate bandwidth of the downloads, so that performance regressions of an origin, or of manifestr, show when
+// the reports of runs are compared.
+type RunReport struct {
+	Url       string        `json:"url"`
+	Started   time.Time     `json:"started"`
+	ElapsedMs int64         `json:"elapsedMs"`
+	Stages    []StageTiming `json:"stages"`
+	// Files and Bytes count the files downloaded by the run, leaving out those kept from earlier runs.
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// BytesPerSecond is the bandwidth of the downloads together over the wall time of the download stage.
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	// QuotaTruncated marks a run that stopped downloading at the quota of its directory, whose outputs leave out the rest.
+	QuotaTruncated bool `json:"quotaTruncated,omitempty"`
+}
+
+// StageTiming is a stage of a RunReport, see telemetry.Stage.
+type StageTiming struct {
+	Name    string `json:"name"`
+	Count   int    `json:"count"`
+	WallMs  int64  `json:"wallMs"`
+	TotalMs int64  `json:"totalMs"`
+	MaxMs   int64  `json:"maxMs"`
+}
+
+// NewRunReport accounts a run of url started at started, which went through stages and downloaded results.
+func NewRunReport(url string, started time.Time, stages []telemetry.Stage, results []models.FragmentResult) RunReport {
+	report := RunReport{Url: utils.RedactUrl(url), Started: started.UTC(), ElapsedMs: time.Since(started).Milliseconds()}
+
+	var downloadWall time.Duration
+	for _, stage := range stages {
+		report.Stages = append(report.Stages, StageTiming{
+			Name:    stage.Name,
+			Count:   stage.Count,
+			WallMs:  stage.Wall.Milliseconds(),
+			TotalMs: stage.Total.Milliseconds(),
+			MaxMs:   stage.Max.Milliseconds(),
+		})
+		if stage.Name == downloadStage {
+			downloadWall = stage.Wall
+		}
+	}
+
+	for _, result := range results {
+		if result.Err != nil || result.Attempts == 0 || result.Skipped {
+			continue
+		}
+		report.Files++
+		report.Bytes += result.Size
+	}
+	if downloadWall > 0 {
+		report.BytesPerSecond = float64(report.Bytes) / downloadWall.Seconds()
+	}
+	return report
+}
+
+// Write prints the stages with the time each took, and the bandwidth of the downloads.
+func (report RunReport) Write(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%-20s %6s %12s %12s %12s\n", "stage", "count", "wall", "total", "max")
+	for _, stage := range report.Stages {
+		fmt.Fprintf(&b, "%-20s %6d %12s %12s %12s\n", stage.Name, stage.Count, milliseconds(stage.WallMs), milliseconds(stage.TotalMs), milliseconds(stage.MaxMs))
+	}
+	fmt.Fprintf(&b, "%-20s %6s %12s\n", "run", "", milliseconds(report.ElapsedMs))
+	fmt.Fprintf(&b, "downloaded %d files, %s at %s/s\n", report.Files, utils.FormatBytes(report.Bytes), utils.FormatBytes(int64(report.BytesPerSecond)))
+	if report.QuotaTruncated {
+		fmt.Fprintln(&b, "truncated at the quota of the directory")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteFile saves the report as JSON to filePath.
+func (report RunReport) WriteFile(filePath string) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, b, 0644)
+}
+
+func milliseconds(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,228 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/alehechka/manifestr/pkg/models"
+	"github.com/alehechka/manifestr/pkg/utils"
+)
+
+const (
+	ValidationText = "text"
+	ValidationJson = "json"
+)
+
+const (
+	containerTs   = "ts"
+	containerFmp4 = "fmp4"
+)
+
+// ValidationIssue is a violation of RFC 8216 or of a playability rule found in a media playlist. Line is 0 when the
+// issue is about the playlist as a whole.
+type ValidationIssue struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Url      string `json:"url"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message"`
+}
+
+// ValidationReport lists the issues found in a playlist, and in the variant playlists of a master playlist.
+type ValidationReport struct {
+	Url       string            `json:"url"`
+	Playlists int               `json:"playlists"`
+	Issues    []ValidationIssue `json:"issues"`
+}
+
+func (report *ValidationReport) issue(rule string, severity string, playlistUrl string, line int, message string, args ...any) {
+	report.Issues = append(report.Issues, ValidationIssue{Rule: rule, Severity: severity, Url: playlistUrl, Line: line, Message: fmt.Sprintf(message, args...)})
+}
+
+// Failed counts the issues with SeverityMust, which make the playlist invalid rather than merely unusual.
+func (report ValidationReport) Failed() int {
+	failed := 0
+	for _, issue := range report.Issues {
+		if issue.Severity == SeverityMust {
+			failed++
+		}
+	}
+	return failed
+}
+
+// ValidateManifest checks manifest, read from playlistUrl with ReadOptions.Strict so that parseErr lists its malformed
+// tags, for segments longer than the target duration, fMP4 segments without an #EXT-X-MAP, segments of different
+// containers and unparsable tags such as #EXT-X-PROGRAM-DATE-TIME.
+func (report *ValidationReport) ValidateManifest(playlistUrl string, manifest *models.Manifest, parseErr error) {
+	report.Playlists++
+
+	var parseError *models.ParseError
+	if errors.As(parseErr, &parseError) {
+		for _, tagError := range parseError.Errors {
+			switch {
+			case errors.Is(tagError, models.ErrUnrecognizedTag):
+				// clients ignore the tags they do not recognize
+			case tagError.Tag == "EXT-X-PROGRAM-DATE-TIME":
+				report.issue("program-date-time", SeverityMust, playlistUrl, tagError.Line, "unparsable #EXT-X-PROGRAM-DATE-TIME: %s", tagError.Err)
+			default:
+				report.issue("malformed-tag", SeverityMust, playlistUrl, tagError.Line, "#%s: %s", tagError.Tag, tagError.Err)
+			}
+		}
+	}
+
+	if manifest.TargetDuration <= 0 {
+		report.issue("target-duration", SeverityMust, playlistUrl, 0, "missing #EXT-X-TARGETDURATION")
+	}
+
+	containers := make(map[string]int)
+	initFile := ""
+	for _, discontinuity := range manifest.Discontinuities {
+		// an #EXT-X-MAP applies to the segments after it until the next one
+		if discontinuity.InitFile != "" {
+			initFile = discontinuity.InitFile
+		}
+		discontinuityContainers := make(map[string]bool)
+		for _, entry := range discontinuity.Entries {
+			if manifest.TargetDuration > 0 && math.Round(entry.Duration) > manifest.TargetDuration {
+				report.issue("target-duration", SeverityMust, playlistUrl, entry.Line, "#EXTINF of %.3fs exceeds the #EXT-X-TARGETDURATION of %.0fs", entry.Duration, manifest.TargetDuration)
+			}
+
+			container := segmentContainer(entry.Url)
+			if container == "" {
+				continue
+			}
+			if container == containerFmp4 && initFile == "" {
+				report.issue("init-map", SeverityMust, playlistUrl, entry.Line, "fMP4 segment %s has no #EXT-X-MAP init segment", entry.Url)
+			}
+			if len(discontinuityContainers) > 0 && !discontinuityContainers[container] {
+				report.issue("mixed-container", SeverityMust, playlistUrl, entry.Line, "%s segment %s between segments of another container without an #EXT-X-DISCONTINUITY", container, entry.Url)
+			}
+			discontinuityContainers[container] = true
+			containers[container]++
+		}
+	}
+	if containers[containerTs] > 0 && containers[containerFmp4] > 0 {
+		report.issue("mixed-container", SeverityShould, playlistUrl, 0, "%d MPEG-TS and %d fMP4 segments in one playlist", containers[containerTs], containers[containerFmp4])
+	}
+}
+
+// ValidateReload checks that current, a reload of the live playlist previous, continues its media sequence: the sequence
+// must not go back, must not skip past segments the previous playlist had not published yet, and a sequence number must
+// keep its segment uri.
+func (report *ValidationReport) ValidateReload(playlistUrl string, previous *models.Manifest, current *models.Manifest) {
+	if current.MediaSequence < previous.MediaSequence {
+		report.issue("media-sequence", SeverityMust, playlistUrl, 0, "#EXT-X-MEDIA-SEQUENCE went back from %d to %d on reload", previous.MediaSequence, current.MediaSequence)
+		return
+	}
+	if current.MediaSequence > previous.LastSequence()+1 {
+		report.issue("media-sequence", SeverityMust, playlistUrl, 0, "#EXT-X-MEDIA-SEQUENCE jumped from %d to %d on reload, skipping segments %d-%d", previous.MediaSequence, current.MediaSequence, previous.LastSequence()+1, current.MediaSequence-1)
+		return
+	}
+	if previous.EndList && !current.EndList {
+		report.issue("endlist", SeverityMust, playlistUrl, 0, "#EXT-X-ENDLIST removed on reload")
+	}
+
+	previousUris := sequenceUris(previous)
+	for sequence, uri := range sequenceUris(current) {
+		if previousUri, ok := previousUris[sequence]; ok && previousUri.Url != uri.Url {
+			report.issue("media-sequence", SeverityMust, playlistUrl, uri.Line, "segment %d changed from %s to %s on reload", sequence, previousUri.Url, uri.Url)
+		}
+	}
+}
+
+func sequenceUris(manifest *models.Manifest) map[int]*models.ManifestEntry {
+	entries := make(map[int]*models.ManifestEntry)
+	sequence := manifest.MediaSequence
+	for _, discontinuity := range manifest.Discontinuities {
+		for _, entry := range discontinuity.Entries {
+			entries[sequence] = entry
+			sequence++
+		}
+	}
+	return entries
+}
+
+// ProbeSegments sends a HEAD request through downloader for every segment and init segment of manifest, concurrency at a
+// time, reporting those that cannot be fetched.
+func (report *ValidationReport) ProbeSegments(playlistUrl string, manifest *models.Manifest, concurrency int, downloader *utils.Downloader) {
+	type probe struct {
+		line int
+		uri  string
+	}
+	var probes []probe
+	for _, discontinuity := range manifest.Discontinuities {
+		if discontinuity.InitFile != "" {
+			probes = append(probes, probe{line: discontinuity.InitFileLine, uri: manifest.DynamicInitFile(discontinuity).String()})
+		}
+		for _, entry := range discontinuity.Entries {
+			probes = append(probes, probe{line: entry.Line, uri: manifest.DynamicUrl(*entry).String()})
+		}
+	}
+
+	errs := make([]error, len(probes))
+	slots := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for index, probe := range probes {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			errs[index] = downloader.CheckUrl(probe.uri)
+		}()
+	}
+	wg.Wait()
+
+	for index, err := range errs {
+		if err != nil {
+			report.issue("unreachable", SeverityMust, playlistUrl, probes[index].line, "%s: %s", probes[index].uri, err)
+		}
+	}
+}
+
+// segmentContainer tells MPEG-TS from fMP4 segments by the extension of uri, returning "" for other or no extensions.
+func segmentContainer(uri string) string {
+	if parsed, err := url.Parse(uri); err == nil {
+		uri = parsed.Path
+	}
+	switch strings.ToLower(path.Ext(uri)) {
+	case ".ts":
+		return containerTs
+	case ".m4s", ".mp4", ".m4v", ".m4a", ".cmfv", ".cmfa":
+		return containerFmp4
+	}
+	return ""
+}
+
+// Write writes the issues in format, ValidationText or ValidationJson.
+func (report ValidationReport) Write(w io.Writer, format string) error {
+	switch format {
+	case ValidationJson:
+		if report.Issues == nil {
+			report.Issues = []ValidationIssue{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case ValidationText:
+		var b strings.Builder
+		for _, issue := range report.Issues {
+			location := issue.Url
+			if issue.Line > 0 {
+				location = fmt.Sprintf("%s:%d", issue.Url, issue.Line)
+			}
+			fmt.Fprintf(&b, "%-6s %-18s %s %s\n", issue.Severity, issue.Rule, location, issue.Message)
+		}
+		fmt.Fprintf(&b, "\n%d playlists, %d issues, %d %s\n", report.Playlists, len(report.Issues), report.Failed(), SeverityMust)
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
@@ -0,0 +1,403 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcsChunkSize is the granularity the chunks of a resumable upload must be a multiple of, but for the last one.
+const gcsChunkSize = 256 * 1024
+
+// metadataTokenUrl hands out access tokens for the service account of a Google Cloud VM.
+const metadataTokenUrl = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// gcsScope is the OAuth scope access tokens are requested for.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// Gcs stores objects below Prefix in a Google Cloud Storage bucket. It authenticates with GOOGLE_OAUTH_ACCESS_TOKEN, the
+// service account key or user credentials at GOOGLE_APPLICATION_CREDENTIALS or written by "gcloud auth
+// application-default login", or the service account of the VM it runs on, and talks to the emulator at
+// STORAGE_EMULATOR_HOST instead when it is set.
+type Gcs struct {
+	Bucket   string
+	Prefix   string
+	Endpoint string
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
+	// PartSize is the size of the chunks of a resumable upload, rounded down to a multiple of 256 KiB, DefaultPartSize
+	// when zero.
+	PartSize int
+
+	credentials *googleCredentials
+	mu          sync.Mutex
+	token       string
+	expires     time.Time
+}
+
+// NewGcs returns the GCS storage below prefix in bucket, configured by the environment, sending its requests with
+// client.
+func NewGcs(bucket string, prefix string, client *http.Client) (*Gcs, error) {
+	gcs := &Gcs{Bucket: bucket, Prefix: prefix, Endpoint: "https://storage.googleapis.com", Client: client, token: os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")}
+	if emulator := os.Getenv("STORAGE_EMULATOR_HOST"); emulator != "" {
+		gcs.Endpoint = strings.TrimSuffix(emulator, "/")
+		if !strings.Contains(gcs.Endpoint, "://") {
+			gcs.Endpoint = "http://" + gcs.Endpoint
+		}
+	}
+	if gcs.token == "" {
+		credentials, err := readGoogleCredentials()
+		if err != nil {
+			return nil, err
+		}
+		gcs.credentials = credentials
+	}
+	return gcs, nil
+}
+
+func (gcs *Gcs) Location(name string) string {
+	return fmt.Sprintf("gs://%s/%s", gcs.Bucket, path.Join(gcs.Prefix, name))
+}
+
+func (gcs *Gcs) objectUrl(name string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", gcs.Endpoint, url.PathEscape(gcs.Bucket), url.PathEscape(path.Join(gcs.Prefix, name)))
+}
+
+func (gcs *Gcs) uploadUrl(name string, uploadType string) string {
+	return fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=%s&name=%s", gcs.Endpoint, url.PathEscape(gcs.Bucket), uploadType, url.QueryEscape(path.Join(gcs.Prefix, name)))
+}
+
+func (gcs *Gcs) Size(ctx context.Context, name string) (int64, error) {
+	resp, err := gcs.do(ctx, http.MethodGet, gcs.objectUrl(name), name, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var object struct {
+		Size int64 `json:"size,string"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&object); err != nil {
+		return 0, err
+	}
+	return object.Size, nil
+}
+
+func (gcs *Gcs) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := gcs.do(ctx, http.MethodGet, gcs.objectUrl(name)+"?alt=media", name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (gcs *Gcs) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return &gcsWriter{ctx: ctx, gcs: gcs, name: name}, nil
+}
+
+// do sends an authenticated request for the object name to requestUrl, returning an error for any status but a success
+// or a 308, with which a resumable upload acknowledges a chunk. A missing object is reported as fs.ErrNotExist.
+func (gcs *Gcs) do(ctx context.Context, method string, requestUrl string, name string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if !strings.HasPrefix(gcs.Endpoint, "http://") {
+		token, err := gcs.accessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := gcs.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusMultipleChoices || resp.StatusCode == http.StatusPermanentRedirect {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		return nil, fmt.Errorf("%s: %w", gcs.Location(name), fs.ErrNotExist)
+	}
+	var gcsError struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&gcsError)
+	return nil, fmt.Errorf("%s %s: %s %s", method, gcs.Location(name), resp.Status, gcsError.Error.Message)
+}
+
+func (gcs *Gcs) client() *http.Client {
+	if gcs.Client == nil {
+		return http.DefaultClient
+	}
+	return gcs.Client
+}
+
+// accessToken returns GOOGLE_OAUTH_ACCESS_TOKEN, or a token of the application default credentials or the VM service
+// account that is renewed before it expires.
+func (gcs *Gcs) accessToken(ctx context.Context) (string, error) {
+	gcs.mu.Lock()
+	defer gcs.mu.Unlock()
+
+	if gcs.token != "" && (gcs.expires.IsZero() || time.Until(gcs.expires) > time.Minute) {
+		return gcs.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	client := gcs.client()
+	if gcs.credentials != nil {
+		req, err = gcs.credentials.tokenRequest(ctx, time.Now())
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenUrl, nil)
+		if err == nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+		// the metadata server only answers the VM itself, never through a proxy
+		client = http.DefaultClient
+	}
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil && gcs.credentials == nil {
+		return "", fmt.Errorf("no GCS credentials, set GOOGLE_OAUTH_ACCESS_TOKEN or GOOGLE_APPLICATION_CREDENTIALS or run on a VM with a service account: %w", err)
+	} else if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching a GCS access token: %s", resp.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	gcs.token, gcs.expires = token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn)*time.Second)
+	return gcs.token, nil
+}
+
+// googleCredentials are application default credentials: the key of a service account, or the refresh token of a user
+// who logged in with gcloud.
+type googleCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenUri     string `json:"token_uri"`
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+
+	key *rsa.PrivateKey
+}
+
+// readGoogleCredentials reads the application default credentials at GOOGLE_APPLICATION_CREDENTIALS, or where gcloud
+// writes them. They are nil when neither is there.
+func readGoogleCredentials() (*googleCredentials, error) {
+	credentialsPath := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if credentialsPath == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, nil
+		}
+		credentialsPath = filepath.Join(configDir, "gcloud", "application_default_credentials.json")
+		if _, err := os.Stat(credentialsPath); err != nil {
+			return nil, nil
+		}
+	}
+
+	b, err := os.ReadFile(credentialsPath)
+	if err != nil {
+		return nil, err
+	}
+	credentials := &googleCredentials{}
+	if err := json.Unmarshal(b, credentials); err != nil {
+		return nil, fmt.Errorf("reading GCS credentials %s: %w", credentialsPath, err)
+	}
+
+	switch credentials.Type {
+	case "service_account":
+		block, _ := pem.Decode([]byte(credentials.PrivateKey))
+		if block == nil {
+			return nil, fmt.Errorf("reading GCS credentials %s: no private key", credentialsPath)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("reading GCS credentials %s: %w", credentialsPath, err)
+		}
+		var ok bool
+		if credentials.key, ok = key.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("reading GCS credentials %s: private key is not an RSA key", credentialsPath)
+		}
+	case "authorized_user":
+	default:
+		return nil, fmt.Errorf("reading GCS credentials %s: unsupported type %q", credentialsPath, credentials.Type)
+	}
+	if credentials.TokenUri == "" {
+		credentials.TokenUri = "https://oauth2.googleapis.com/token"
+	}
+	return credentials, nil
+}
+
+// tokenRequest requests an access token at now: with a JWT signed by the service account key, or the refresh token
+// of the user.
+func (credentials *googleCredentials) tokenRequest(ctx context.Context, now time.Time) (*http.Request, error) {
+	form := url.Values{}
+	if credentials.key != nil {
+		assertion, err := credentials.assertion(now)
+		if err != nil {
+			return nil, err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	} else {
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", credentials.ClientId)
+		form.Set("client_secret", credentials.ClientSecret)
+		form.Set("refresh_token", credentials.RefreshToken)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, credentials.TokenUri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// assertion returns the JWT of the service account asking for an access token valid for an hour from now, signed
+// with RS256 as https://developers.google.com/identity/protocols/oauth2/service-account describes.
+func (credentials *googleCredentials) assertion(now time.Time) (string, error) {
+	claims, err := json.Marshal(map[string]any{
+		"iss":   credentials.ClientEmail,
+		"scope": gcsScope,
+		"aud":   credentials.TokenUri,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, credentials.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// gcsWriter buffers an object up to the part size, uploading it at once when it is closed before then and in chunks of a
+// resumable upload otherwise.
+type gcsWriter struct {
+	ctx     context.Context
+	gcs     *Gcs
+	name    string
+	buffer  bytes.Buffer
+	session string
+	offset  int64
+	err     error
+}
+
+func (writer *gcsWriter) Write(p []byte) (int, error) {
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	writer.buffer.Write(p)
+	chunkSize := max(partSize(writer.gcs.PartSize)/gcsChunkSize, 1) * gcsChunkSize
+	for writer.buffer.Len() >= chunkSize && writer.err == nil {
+		writer.err = writer.uploadChunk(writer.buffer.Next(chunkSize), false)
+	}
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	return len(p), nil
+}
+
+func (writer *gcsWriter) uploadChunk(chunk []byte, last bool) error {
+	if writer.session == "" {
+		resp, err := writer.gcs.do(writer.ctx, http.MethodPost, writer.gcs.uploadUrl(writer.name, "resumable"), writer.name, nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if writer.session = resp.Header.Get("Location"); writer.session == "" {
+			return fmt.Errorf("no resumable upload session for %s", writer.gcs.Location(writer.name))
+		}
+	}
+
+	total := "*"
+	if last {
+		total = fmt.Sprint(writer.offset + int64(len(chunk)))
+	}
+	contentRange := fmt.Sprintf("bytes %d-%d/%s", writer.offset, writer.offset+int64(len(chunk))-1, total)
+	if len(chunk) == 0 {
+		contentRange = "bytes */" + total
+	}
+
+	resp, err := writer.gcs.do(writer.ctx, http.MethodPut, writer.session, writer.name, http.Header{"Content-Range": {contentRange}}, chunk)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if !last && resp.StatusCode != http.StatusPermanentRedirect {
+		return fmt.Errorf("uploading %s: unexpected %s for a chunk", writer.gcs.Location(writer.name), resp.Status)
+	}
+	writer.offset += int64(len(chunk))
+	return nil
+}
+
+func (writer *gcsWriter) Close() error {
+	err := writer.err
+	if err == nil {
+		err = writer.ctx.Err()
+	}
+	if err == nil {
+		if writer.session == "" {
+			var resp *http.Response
+			if resp, err = writer.gcs.do(writer.ctx, http.MethodPost, writer.gcs.uploadUrl(writer.name, "media"), writer.name, nil, writer.buffer.Bytes()); err == nil {
+				resp.Body.Close()
+			}
+		} else {
+			err = writer.uploadChunk(writer.buffer.Bytes(), true)
+		}
+	}
+	if err != nil && writer.session != "" {
+		// cancelling the session discards the chunks uploaded so far, answering with 499
+		if resp, cancelErr := writer.gcs.do(context.WithoutCancel(writer.ctx), http.MethodDelete, writer.session, writer.name, nil, nil); cancelErr == nil {
+			resp.Body.Close()
+		}
+	}
+	return err
+}
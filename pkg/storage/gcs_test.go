@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGcsServiceAccountToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tokenRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			tokenRequests++
+			if grantType := r.FormValue("grant_type"); grantType != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+				t.Errorf("token requested with grant type %q", grantType)
+			}
+			parts := strings.Split(r.FormValue("assertion"), ".")
+			if len(parts) != 3 {
+				t.Fatalf("assertion %q is not a JWT", r.FormValue("assertion"))
+			}
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+				t.Errorf("assertion signature: %v", err)
+			}
+			claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			var decoded struct {
+				Iss   string `json:"iss"`
+				Scope string `json:"scope"`
+			}
+			json.Unmarshal(claims, &decoded)
+			if decoded.Iss != "recorder@project.iam.gserviceaccount.com" || decoded.Scope != gcsScope {
+				t.Errorf("assertion claims %s", claims)
+			}
+			w.Write([]byte(`{"access_token":"token-1","expires_in":3600}`))
+		default:
+			if authorization := r.Header.Get("Authorization"); authorization != "Bearer token-1" {
+				t.Errorf("object requested with authorization %q", authorization)
+			}
+			w.Write([]byte(`{"size":"7"}`))
+		}
+	}))
+	defer server.Close()
+
+	credentials, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "recorder@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    server.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialsPath := filepath.Join(t.TempDir(), "credentials.json")
+	os.WriteFile(credentialsPath, credentials, 0600)
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+	t.Setenv("STORAGE_EMULATOR_HOST", "")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credentialsPath)
+
+	transport := &countingTransport{}
+	gcs, err := NewGcs("bucket", "prefix", &http.Client{Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the test server stands in for the storage api, which has to be reached over https otherwise
+	gcs.Endpoint = strings.Replace(server.URL, "http://", "https://", 1)
+	gcs.Client.Transport = rewriteScheme{transport}
+
+	for range 2 {
+		size, err := gcs.Size(context.Background(), "0001.ts")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != 7 {
+			t.Errorf("size %d, want 7", size)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Errorf("requested %d tokens, want one reused until it expires", tokenRequests)
+	}
+	if transport.requests != 3 {
+		t.Errorf("%d requests were sent with the client, want 3", transport.requests)
+	}
+}
+
+// rewriteScheme sends https requests to the plain http test server.
+type rewriteScheme struct {
+	next http.RoundTripper
+}
+
+func (rewrite rewriteScheme) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	return rewrite.next.RoundTrip(req)
+}
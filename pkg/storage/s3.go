@@ -0,0 +1,340 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultPartSize is the size of the parts objects are uploaded in, buffered in memory one at a time, unless the storage
+// sets its own. Smaller objects are uploaded at once when they are closed.
+const DefaultPartSize = 8 * 1024 * 1024
+
+func partSize(size int) int {
+	if size <= 0 {
+		return DefaultPartSize
+	}
+	return size
+}
+
+// S3 stores objects below Prefix in an S3 bucket, or one of an S3 compatible service. The credentials and region are
+// taken from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION, or the AWS_PROFILE profile of
+// the shared credentials and config files, and an S3 compatible service is addressed by AWS_ENDPOINT_URL.
+type S3 struct {
+	Bucket       string
+	Prefix       string
+	Region       string
+	Endpoint     string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
+	// PartSize is the size of the parts of a multipart upload, DefaultPartSize when zero.
+	PartSize int
+}
+
+// NewS3 returns the S3 storage below prefix in bucket, configured by the environment, sending its requests with client.
+func NewS3(bucket string, prefix string, client *http.Client) (*S3, error) {
+	s3 := &S3{
+		Bucket:       bucket,
+		Prefix:       prefix,
+		Region:       firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		Endpoint:     strings.TrimSuffix(firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"), "/"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		Client:       client,
+	}
+
+	profile := firstEnv("AWS_PROFILE", "AWS_DEFAULT_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	if s3.AccessKey == "" || s3.SecretKey == "" {
+		credentials, err := readAwsProfile(awsConfigPath("AWS_SHARED_CREDENTIALS_FILE", "credentials"), profile)
+		if err != nil {
+			return nil, err
+		}
+		s3.AccessKey, s3.SecretKey, s3.SessionToken = credentials["aws_access_key_id"], credentials["aws_secret_access_key"], credentials["aws_session_token"]
+	}
+	if s3.AccessKey == "" || s3.SecretKey == "" {
+		return nil, fmt.Errorf("no S3 credentials, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or add the %q profile to the shared credentials file", profile)
+	}
+	if s3.Region == "" {
+		// the config file names every profile but the default one "profile name"
+		section := "profile " + profile
+		if profile == "default" {
+			section = profile
+		}
+		config, err := readAwsProfile(awsConfigPath("AWS_CONFIG_FILE", "config"), section)
+		if err != nil {
+			return nil, err
+		}
+		s3.Region = config["region"]
+	}
+	if s3.Region == "" {
+		s3.Region = "us-east-1"
+	}
+	return s3, nil
+}
+
+func firstEnv(keys ...string) string {
+	for _, key := range keys {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// awsConfigPath returns the path of the shared AWS file name, ~/.aws/name unless the environment variable key is set.
+func awsConfigPath(key string, name string) string {
+	if configPath := os.Getenv(key); configPath != "" {
+		return configPath
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".aws", name)
+}
+
+// readAwsProfile returns the settings of section in the shared AWS credentials or config file at configPath, which are
+// none when either does not exist.
+func readAwsProfile(configPath string, section string) (map[string]string, error) {
+	settings := make(map[string]string)
+	if configPath == "" {
+		return settings, nil
+	}
+	file, err := os.Open(configPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return settings, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	current := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			current = strings.TrimSpace(line[1 : len(line)-1])
+		case current == section:
+			if key, value, ok := strings.Cut(line, "="); ok {
+				settings[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+	return settings, scanner.Err()
+}
+
+func (s3 *S3) Location(name string) string {
+	return fmt.Sprintf("s3://%s/%s", s3.Bucket, path.Join(s3.Prefix, name))
+}
+
+// objectUrl addresses the object name with query, path-style on a custom endpoint and virtual-hosted on AWS.
+func (s3 *S3) objectUrl(name string, query url.Values) string {
+	key := awsEscape(path.Join(s3.Prefix, name), false)
+	objectUrl := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s3.Bucket, s3.Region, key)
+	if s3.Endpoint != "" {
+		objectUrl = fmt.Sprintf("%s/%s/%s", s3.Endpoint, awsEscape(s3.Bucket, true), key)
+	}
+	if len(query) > 0 {
+		objectUrl += "?" + canonicalQuery(query)
+	}
+	return objectUrl
+}
+
+func (s3 *S3) Size(ctx context.Context, name string) (int64, error) {
+	resp, err := s3.do(ctx, http.MethodHead, name, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+func (s3 *S3) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s3.do(ctx, http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s3 *S3) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return &s3Writer{ctx: ctx, s3: s3, name: name}, nil
+}
+
+// do sends a signed request for the object name, returning an error for any status but a success. A missing object is
+// reported as fs.ErrNotExist.
+func (s3 *S3) do(ctx context.Context, method string, name string, query url.Values, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s3.objectUrl(name, query), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s3.sign(req, body, time.Now())
+
+	client := s3.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusMultipleChoices {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && method != http.MethodPost {
+		return nil, fmt.Errorf("%s: %w", s3.Location(name), fs.ErrNotExist)
+	}
+	var s3Error struct {
+		Code    string
+		Message string
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&s3Error)
+	return nil, fmt.Errorf("%s %s: %s %s %s", method, s3.Location(name), resp.Status, s3Error.Code, s3Error.Message)
+}
+
+// sign adds the AWS Signature Version 4 of req with body to its headers.
+func (s3 *S3) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	signV4(req, hex.EncodeToString(payloadHash[:]), awsCredentials{AccessKey: s3.AccessKey, SecretKey: s3.SecretKey, SessionToken: s3.SessionToken}, s3.Region, "s3", now)
+}
+
+// s3Writer buffers an object up to the part size, uploading it at once when it is closed before then and in parts of a
+// multipart upload otherwise.
+type s3Writer struct {
+	ctx      context.Context
+	s3       *S3
+	name     string
+	buffer   bytes.Buffer
+	uploadId string
+	parts    []s3Part
+	err      error
+}
+
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+func (writer *s3Writer) Write(p []byte) (int, error) {
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	writer.buffer.Write(p)
+	size := partSize(writer.s3.PartSize)
+	for writer.buffer.Len() >= size && writer.err == nil {
+		writer.err = writer.uploadPart(writer.buffer.Next(size))
+	}
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	return len(p), nil
+}
+
+func (writer *s3Writer) uploadPart(part []byte) error {
+	if writer.uploadId == "" {
+		resp, err := writer.s3.do(writer.ctx, http.MethodPost, writer.name, url.Values{"uploads": {""}}, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var initiated struct{ UploadId string }
+		if err := xml.NewDecoder(resp.Body).Decode(&initiated); err != nil {
+			return err
+		}
+		writer.uploadId = initiated.UploadId
+	}
+
+	number := len(writer.parts) + 1
+	resp, err := writer.s3.do(writer.ctx, http.MethodPut, writer.name, url.Values{"partNumber": {fmt.Sprint(number)}, "uploadId": {writer.uploadId}}, part)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	writer.parts = append(writer.parts, s3Part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	return nil
+}
+
+func (writer *s3Writer) Close() error {
+	err := writer.err
+	if err == nil {
+		err = writer.ctx.Err()
+	}
+	if err == nil {
+		err = writer.complete()
+	}
+	if err != nil && writer.uploadId != "" {
+		// the parts of an abandoned upload are billed until it is aborted, which must happen even after ctx is cancelled
+		if resp, abortErr := writer.s3.do(context.WithoutCancel(writer.ctx), http.MethodDelete, writer.name, url.Values{"uploadId": {writer.uploadId}}, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+	}
+	return err
+}
+
+func (writer *s3Writer) complete() error {
+	if writer.uploadId == "" {
+		resp, err := writer.s3.do(writer.ctx, http.MethodPut, writer.name, nil, writer.buffer.Bytes())
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if writer.buffer.Len() > 0 {
+		if err := writer.uploadPart(writer.buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: writer.parts})
+	if err != nil {
+		return err
+	}
+	resp, err := writer.s3.do(writer.ctx, http.MethodPost, writer.name, url.Values{"uploadId": {writer.uploadId}}, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// a completion failing after the response started is reported with a 200 status and an error document
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("completing upload of %s: %s %s", writer.s3.Location(writer.name), result.Code, result.Message)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// countingTransport counts the requests sent through it.
+type countingTransport struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (transport *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport.mu.Lock()
+	transport.requests++
+	transport.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestS3SharedProfileAndClient(t *testing.T) {
+	objects := make(map[string][]byte)
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+		case http.MethodGet:
+			object, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(object)
+		}
+	}))
+	defer server.Close()
+
+	home := t.TempDir()
+	credentials := filepath.Join(home, "credentials")
+	config := filepath.Join(home, "config")
+	os.WriteFile(credentials, []byte("[default]\naws_access_key_id = AKIDDEFAULT\naws_secret_access_key = default\n\n[recordings]\naws_access_key_id = AKIDRECORDINGS\naws_secret_access_key = secret\n"), 0600)
+	os.WriteFile(config, []byte("[default]\nregion = us-west-2\n\n[profile recordings]\nregion = eu-west-1\n"), 0600)
+	for key, value := range map[string]string{"AWS_ACCESS_KEY_ID": "", "AWS_SECRET_ACCESS_KEY": "", "AWS_REGION": "", "AWS_DEFAULT_REGION": "", "AWS_ENDPOINT_URL_S3": "", "AWS_ENDPOINT_URL": server.URL, "AWS_PROFILE": "recordings", "AWS_SHARED_CREDENTIALS_FILE": credentials, "AWS_CONFIG_FILE": config} {
+		t.Setenv(key, value)
+	}
+
+	transport := &countingTransport{}
+	storage, err := Open("s3://bucket/prefix", &http.Client{Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(context.Background(), storage, "0001.ts", func(w io.Writer) error {
+		_, err := io.WriteString(w, "segment")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ReadFile(context.Background(), storage, "0001.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "segment" {
+		t.Errorf("read %q, want segment", b)
+	}
+
+	if transport.requests != len(authorizations) || transport.requests == 0 {
+		t.Errorf("%d of %d requests were sent with the client", transport.requests, len(authorizations))
+	}
+	for _, authorization := range authorizations {
+		if !strings.Contains(authorization, "Credential=AKIDRECORDINGS/") || !strings.Contains(authorization, "/eu-west-1/s3/aws4_request") {
+			t.Errorf("signed with %q, want the recordings profile in eu-west-1", authorization)
+		}
+	}
+}
+
+func TestS3NoCredentials(t *testing.T) {
+	for key, value := range map[string]string{"AWS_ACCESS_KEY_ID": "", "AWS_SECRET_ACCESS_KEY": "", "AWS_PROFILE": "", "AWS_DEFAULT_PROFILE": "", "AWS_SHARED_CREDENTIALS_FILE": filepath.Join(t.TempDir(), "credentials")} {
+		t.Setenv(key, value)
+	}
+	if _, err := NewS3("bucket", "", nil); err == nil {
+		t.Error("opened S3 without credentials")
+	}
+}
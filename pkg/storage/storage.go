@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	SchemeS3  = "s3"
+	SchemeGcs = "gs"
+)
+
+// Storage is where the downloaded files and the outputs are written to, addressed by names relative to its root such as
+// "audio-en/0001.ts".
+type Storage interface {
+	// Create returns a writer saving the object name, which only becomes visible once the writer is closed. Cancelling
+	// ctx before Close discards everything written instead.
+	Create(ctx context.Context, name string) (io.WriteCloser, error)
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// Size returns the size in bytes of the object name, or an error wrapping fs.ErrNotExist when there is none.
+	Size(ctx context.Context, name string) (int64, error)
+	// Location returns the object name as a path or URI for log messages.
+	Location(name string) string
+}
+
+// IsRemote reports whether uri addresses object storage, such as s3://bucket/prefix or gs://bucket/prefix, rather than a
+// local directory.
+func IsRemote(uri string) bool {
+	return strings.HasPrefix(uri, SchemeS3+"://") || strings.HasPrefix(uri, SchemeGcs+"://")
+}
+
+// Open returns the Storage at uri: an S3 bucket for s3://bucket/prefix, a GCS bucket for gs://bucket/prefix and the
+// local directory otherwise. Requests to buckets are sent with client.
+func Open(uri string, client *http.Client) (Storage, error) {
+	if !IsRemote(uri) {
+		return Local{Dir: strings.TrimPrefix(uri, "file://")}, nil
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("no bucket in %q", uri)
+	}
+	prefix := strings.Trim(parsed.Path, "/")
+
+	if parsed.Scheme == SchemeS3 {
+		return NewS3(parsed.Host, prefix, client)
+	}
+	return NewGcs(parsed.Host, prefix, client)
+}
+
+// WriteFile saves what write writes as the object name of storage, discarding it when write fails.
+func WriteFile(ctx context.Context, storage Storage, name string, write func(w io.Writer) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := storage.Create(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := write(w); err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// ReadFile returns the content of the object name of storage.
+func ReadFile(ctx context.Context, storage Storage, name string) ([]byte, error) {
+	r, err := storage.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// Local stores objects as files below Dir.
+type Local struct {
+	Dir string
+}
+
+func (local Local) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	filePath := local.Location(name)
+	if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+		return nil, err
+	}
+	// the file is written aside and renamed into place, which also leaves alone any link to an earlier version
+	file, err := os.CreateTemp(path.Dir(filePath), ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	// temporary files are private, unlike the outputs they become
+	if err := file.Chmod(0644); err != nil {
+		return nil, errors.Join(err, file.Close(), os.Remove(file.Name()))
+	}
+	return &localWriter{ctx: ctx, File: file, filePath: filePath}, nil
+}
+
+func (local Local) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(local.Location(name))
+}
+
+func (local Local) Size(ctx context.Context, name string) (int64, error) {
+	info, err := os.Stat(local.Location(name))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (local Local) Location(name string) string {
+	return path.Join(local.Dir, name)
+}
+
+type localWriter struct {
+	ctx context.Context
+	*os.File
+	filePath string
+}
+
+func (writer *localWriter) Close() error {
+	err := writer.File.Close()
+	if err == nil {
+		err = writer.ctx.Err()
+	}
+	if err != nil {
+		return errors.Join(err, os.Remove(writer.Name()))
+	}
+	return os.Rename(writer.Name(), writer.filePath)
+}
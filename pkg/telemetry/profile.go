@@ -0,0 +1,145 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// StageTimings is a span processor aggregating the wall time of spans by name, giving a local breakdown of where a run spends its time
+// (parse, download, mux...) without an OTLP collector. Concurrent spans of the same stage add up, so a stage can exceed the run time.
+type StageTimings struct {
+	mu     sync.Mutex
+	stages map[string]*stageTiming
+}
+
+type stageTiming struct {
+	count int
+	total time.Duration
+	max   time.Duration
+	first time.Time
+	last  time.Time
+}
+
+func NewStageTimings() *StageTimings {
+	return &StageTimings{stages: make(map[string]*stageTiming)}
+}
+
+func (timings *StageTimings) OnStart(ctx context.Context, span sdktrace.ReadWriteSpan) {}
+
+func (timings *StageTimings) OnEnd(span sdktrace.ReadOnlySpan) {
+	elapsed := span.EndTime().Sub(span.StartTime())
+
+	timings.mu.Lock()
+	defer timings.mu.Unlock()
+
+	stage, ok := timings.stages[span.Name()]
+	if !ok {
+		stage = &stageTiming{first: span.StartTime()}
+		timings.stages[span.Name()] = stage
+	}
+	stage.count++
+	stage.total += elapsed
+	stage.max = max(stage.max, elapsed)
+	if span.StartTime().Before(stage.first) {
+		stage.first = span.StartTime()
+	}
+	if span.EndTime().After(stage.last) {
+		stage.last = span.EndTime()
+	}
+}
+
+func (timings *StageTimings) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (timings *StageTimings) ForceFlush(ctx context.Context) error {
+	return nil
+}
+
+// Stage is the timing of the spans of a pipeline stage, see StageTimings.
+type Stage struct {
+	Name  string
+	Count int
+	// Wall is the time from the first span of the stage starting to the last one ending.
+	Wall time.Duration
+	// Total sums the time of the spans, which exceeds Wall when they ran concurrently.
+	Total time.Duration
+	// Max is the time of the slowest span.
+	Max time.Duration
+}
+
+// Stages returns every stage finished so far in the order it first started.
+func (timings *StageTimings) Stages() []Stage {
+	timings.mu.Lock()
+	defer timings.mu.Unlock()
+
+	names := make([]string, 0, len(timings.stages))
+	for name := range timings.stages {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return timings.stages[names[i]].first.Before(timings.stages[names[j]].first)
+	})
+
+	stages := make([]Stage, 0, len(names))
+	for _, name := range names {
+		stage := timings.stages[name]
+		stages = append(stages, Stage{Name: name, Count: stage.count, Wall: stage.last.Sub(stage.first), Total: stage.total, Max: stage.max})
+	}
+	return stages
+}
+
+// Write prints every stage in the order it first started with its span count, wall time from first start to last end,
+// summed time across concurrent spans and slowest single span.
+func (timings *StageTimings) Write(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-20s %6s %12s %12s %12s\n", "stage", "count", "wall", "total", "max")
+	for _, stage := range timings.Stages() {
+		fmt.Fprintf(&b, "%-20s %6d %12s %12s %12s\n", stage.Name, stage.Count, stage.Wall.Round(time.Millisecond), stage.Total.Round(time.Millisecond), stage.Max.Round(time.Millisecond))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// StartProfile writes a pprof CPU profile to cpu.pprof in dir until the returned stop function is called, which also writes a heap profile to heap.pprof.
+func StartProfile(dir string) (stop func() error, err error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	cpuFile, err := os.Create(path.Join(dir, "cpu.pprof"))
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(cpuFile); err != nil {
+		return nil, errors.Join(err, cpuFile.Close())
+	}
+
+	return func() error {
+		pprof.StopCPUProfile()
+		if err := cpuFile.Close(); err != nil {
+			return err
+		}
+
+		heapFile, err := os.Create(path.Join(dir, "heap.pprof"))
+		if err != nil {
+			return err
+		}
+		// collect garbage first so the profile reflects live memory
+		runtime.GC()
+		return errors.Join(pprof.WriteHeapProfile(heapFile), heapFile.Close())
+	}, nil
+}
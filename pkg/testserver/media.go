@@ -0,0 +1,68 @@
+package testserver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+)
+
+// segment generates the payload of the segment with the given media sequence number, which is deterministic, so tests
+// can compare downloads with Payload, and encrypted with Key when the stream is Encrypted.
+func (stream *liveStream) segment(sequence int) []byte {
+	data := Payload(sequence, stream.SegmentSize, stream.Fmp4)
+	if !stream.Encrypted {
+		return data
+	}
+	return Encrypt(data, sequence)
+}
+
+// Payload is the clear payload of the segment with the given media sequence number: size bytes of MPEG-TS packets, or of
+// a moof and mdat box when fmp4 is set, carrying the sequence number so every segment differs.
+func Payload(sequence int, size int, fmp4 bool) []byte {
+	data := make([]byte, size)
+	for index := range data {
+		data[index] = byte(sequence + index)
+	}
+	if fmp4 {
+		// a moof box holding the sequence number, then an mdat box over the rest
+		moof := box("moof", binary.BigEndian.AppendUint32(nil, uint32(sequence)))
+		if len(moof)+8 <= size {
+			copy(data, moof)
+			binary.BigEndian.PutUint32(data[len(moof):], uint32(size-len(moof)))
+			copy(data[len(moof)+4:], "mdat")
+		}
+		return data
+	}
+	for offset := 0; offset < size; offset += 188 {
+		data[offset] = 0x47
+	}
+	return data
+}
+
+// Encrypt encrypts data, the payload of the segment with the given media sequence number, with Key as AES-128 with PKCS#7
+// padding, using the sequence number as the IV like players do for a playlist that declares none.
+func Encrypt(data []byte, sequence int) []byte {
+	block, err := aes.NewCipher(Key)
+	if err != nil {
+		panic(err)
+	}
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	encrypted := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+	return encrypted
+}
+
+// initSegment is the init segment of the fMP4 streams, an ftyp and an empty moov box.
+func initSegment() []byte {
+	return append(box("ftyp", []byte("isom\x00\x00\x02\x00isomiso6")), box("moov", nil)...)
+}
+
+func box(boxType string, payload []byte) []byte {
+	data := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	data = append(data, boxType...)
+	return append(data, payload...)
+}
@@ -0,0 +1,287 @@
+// Package testserver is a programmable in-memory HLS origin for integration tests. It serves VOD and live streams with a
+// rotating window, in MPEG-TS or fMP4 segments, optionally encrypted with AES-128, and injects failures such as error
+// statuses, Retry-After headers and slow responses on any path.
+//
+//	server := testserver.New()
+//	defer server.Close()
+//	playlistUrl := server.AddStream("vod", testserver.Stream{Segments: 5})
+//	server.Fail("/vod/seg2.ts", testserver.Failure{Status: http.StatusServiceUnavailable, Times: 2})
+package testserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSegmentDuration is the duration of the segments of a Stream that does not set one.
+const DefaultSegmentDuration = 2 * time.Second
+
+// Stream describes a media playlist and the segments the server generates for it.
+type Stream struct {
+	// Segments is the number of segments of a VOD playlist, or the size of the window of a Live one.
+	Segments int
+	// SegmentDuration is the duration of every segment, DefaultSegmentDuration when zero.
+	SegmentDuration time.Duration
+	// Live publishes a new segment every SegmentDuration, or on Advance, sliding the window of the playlist along
+	// without an #EXT-X-ENDLIST.
+	Live bool
+	// EndAfter ends a Live stream with an #EXT-X-ENDLIST once that many segments were published. Zero never ends it.
+	EndAfter int
+	// Fmp4 serves fragmented MP4 segments with an #EXT-X-MAP init segment rather than MPEG-TS.
+	Fmp4 bool
+	// Encrypted encrypts the segments with AES-128 using Key, served as key.bin.
+	Encrypted bool
+	// SegmentSize is the size in bytes of the clear segment payloads, 4 KiB when zero.
+	SegmentSize int
+	// ProgramDateTime is the wall-clock time segment 0 starts at, which the first segment of the playlist is tagged
+	// with as #EXT-X-PROGRAM-DATE-TIME. Zero leaves the tag out.
+	ProgramDateTime time.Time
+}
+
+// Failure is injected into the responses to a path, see Server.Fail.
+type Failure struct {
+	// Status is answered in place of the file. Zero serves the file, after Delay.
+	Status int
+	// Times is how many requests fail before the path is served again. Zero fails them all.
+	Times int
+	// RetryAfter is sent as the Retry-After header in seconds when non-zero.
+	RetryAfter time.Duration
+	// Delay holds the response back, to test timeouts and stalls.
+	Delay time.Duration
+}
+
+// Key is the AES-128 key of the Encrypted streams, which must stay 16 bytes long.
+var Key = []byte("manifestr-tests!")
+
+// liveStream is a Stream as the server publishes it.
+type liveStream struct {
+	Stream
+	added time.Time
+	// advanced counts the segments published by Advance on top of those published over time.
+	advanced int
+}
+
+// Server is an httptest.Server serving the streams added to it, each under /<name>/ as index.m3u8, its segments
+// seg<sequence>.ts or seg<sequence>.m4s, init.mp4 and key.bin, and the master playlists under /<name>/master.m3u8.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	streams  map[string]*liveStream
+	masters  map[string][]string
+	failures map[string]*Failure
+	requests map[string]int
+}
+
+// New starts a Server, which is shut down with Close.
+func New() *Server {
+	server := &Server{
+		streams:  make(map[string]*liveStream),
+		masters:  make(map[string][]string),
+		failures: make(map[string]*Failure),
+		requests: make(map[string]int),
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serve))
+	return server
+}
+
+// AddStream serves stream under /<name>/, returning the url of its playlist. A Live stream starts publishing now.
+func (server *Server) AddStream(name string, stream Stream) string {
+	if stream.SegmentDuration <= 0 {
+		stream.SegmentDuration = DefaultSegmentDuration
+	}
+	if stream.SegmentSize <= 0 {
+		stream.SegmentSize = 4096
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.streams[name] = &liveStream{Stream: stream, added: time.Now()}
+	return server.URL + "/" + name + "/index.m3u8"
+}
+
+// AddMaster serves a master playlist under /<name>/master.m3u8 listing the variants, names of streams added with
+// AddStream, at a bandwidth growing with their position. It returns the url of the master playlist.
+func (server *Server) AddMaster(name string, variants ...string) string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.masters[name] = variants
+	return server.URL + "/" + name + "/master.m3u8"
+}
+
+// Advance publishes segments more segments of the Live stream name at once.
+func (server *Server) Advance(name string, segments int) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if stream, ok := server.streams[name]; ok {
+		stream.advanced += segments
+	}
+}
+
+// Fail injects failure into the responses to path, such as /vod/seg2.ts, replacing any failure injected before.
+func (server *Server) Fail(path string, failure Failure) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.failures[path] = &failure
+}
+
+// Requests is how many times path was requested, failed requests included.
+func (server *Server) Requests(path string) int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.requests[path]
+}
+
+func (server *Server) serve(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	server.requests[r.URL.Path]++
+	failure := server.failures[r.URL.Path]
+	var delay time.Duration
+	status := 0
+	if failure != nil {
+		delay, status = failure.Delay, failure.Status
+		if status != 0 && failure.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(failure.RetryAfter.Seconds())))
+		}
+		if failure.Times > 0 {
+			if failure.Times--; failure.Times == 0 {
+				delete(server.failures, r.URL.Path)
+			}
+		}
+	}
+	server.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	name, file, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	body, contentType, err := server.file(name, file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
+
+// file generates the file of the stream or master name.
+func (server *Server) file(name string, file string) ([]byte, string, error) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if variants, ok := server.masters[name]; ok && file == "master.m3u8" {
+		return server.masterPlaylist(variants), "application/vnd.apple.mpegurl", nil
+	}
+	stream, ok := server.streams[name]
+	if !ok {
+		return nil, "", fmt.Errorf("no stream %q", name)
+	}
+
+	switch {
+	case file == "index.m3u8":
+		return stream.playlist(time.Now()), "application/vnd.apple.mpegurl", nil
+	case file == "key.bin" && stream.Encrypted:
+		return Key, "application/octet-stream", nil
+	case file == "init.mp4" && stream.Fmp4:
+		return initSegment(), "video/mp4", nil
+	case strings.HasPrefix(file, "seg"):
+		sequence, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(file, "seg"), ".ts"), ".m4s"))
+		if err != nil || file != stream.segmentName(sequence) {
+			return nil, "", fmt.Errorf("no segment %q", file)
+		}
+		// like most origins, segments are still served for a while after sliding out of the window
+		if _, last := stream.window(time.Now()); sequence < 0 || sequence > last {
+			return nil, "", fmt.Errorf("segment %d is not published", sequence)
+		}
+		return stream.segment(sequence), stream.contentType(), nil
+	}
+	return nil, "", fmt.Errorf("no file %q in stream %q", file, name)
+}
+
+func (server *Server) masterPlaylist(variants []string) []byte {
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for index, variant := range variants {
+		height := 360 * (index + 1)
+		fmt.Fprintf(&playlist, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"avc1.64001f,mp4a.40.2\"\n../%s/index.m3u8\n", 1000000*(index+1), height*16/9, height, variant)
+	}
+	return []byte(playlist.String())
+}
+
+// window returns the media sequence numbers of the first and last segment of the playlist at now.
+func (stream *liveStream) window(now time.Time) (int, int) {
+	if !stream.Live {
+		return 0, stream.Segments - 1
+	}
+	published := stream.Segments + int(now.Sub(stream.added)/stream.SegmentDuration) + stream.advanced
+	if stream.EndAfter > 0 {
+		published = min(published, stream.EndAfter)
+	}
+	return max(published-stream.Segments, 0), published - 1
+}
+
+func (stream *liveStream) ended(now time.Time) bool {
+	_, last := stream.window(now)
+	return !stream.Live || (stream.EndAfter > 0 && last+1 >= stream.EndAfter)
+}
+
+func (stream *liveStream) segmentName(sequence int) string {
+	if stream.Fmp4 {
+		return fmt.Sprintf("seg%d.m4s", sequence)
+	}
+	return fmt.Sprintf("seg%d.ts", sequence)
+}
+
+func (stream *liveStream) contentType() string {
+	if stream.Fmp4 {
+		return "video/mp4"
+	}
+	return "video/mp2t"
+}
+
+func (stream *liveStream) playlist(now time.Time) []byte {
+	first, last := stream.window(now)
+	seconds := stream.SegmentDuration.Seconds()
+
+	var playlist strings.Builder
+	version := 3
+	if stream.Fmp4 {
+		version = 7
+	}
+	fmt.Fprintf(&playlist, "#EXTM3U\n#EXT-X-VERSION:%d\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n", version, max(int(stream.SegmentDuration.Round(time.Second).Seconds()), 1), first)
+	if !stream.Live {
+		playlist.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	}
+	if stream.Fmp4 {
+		playlist.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
+	}
+	if stream.Encrypted {
+		playlist.WriteString("#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n")
+	}
+	if !stream.ProgramDateTime.IsZero() {
+		start := stream.ProgramDateTime.Add(time.Duration(first) * stream.SegmentDuration)
+		fmt.Fprintf(&playlist, "#EXT-X-PROGRAM-DATE-TIME:%s\n", start.UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+	}
+	for sequence := first; sequence <= last; sequence++ {
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n%s\n", seconds, stream.segmentName(sequence))
+	}
+	if stream.ended(now) {
+		playlist.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return []byte(playlist.String())
+}
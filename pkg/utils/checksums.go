@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const ChecksumsFileName = "checksums.sha256"
+
+// ChecksumIndex is a concurrency safe record of file checksums, persisted in the format understood by `sha256sum -c`.
+type ChecksumIndex struct {
+	mu   sync.Mutex
+	sums map[string]string
+}
+
+// LoadChecksumIndex reads the index at filePath, returning an empty index if it does not exist yet.
+func LoadChecksumIndex(filePath string) (*ChecksumIndex, error) {
+	index := &ChecksumIndex{sums: make(map[string]string)}
+
+	file, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		sum, name, found := strings.Cut(scanner.Text(), "  ")
+		if found {
+			index.sums[name] = sum
+		}
+	}
+
+	return index, scanner.Err()
+}
+
+func (index *ChecksumIndex) Set(name string, sum string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.sums[name] = sum
+}
+
+func (index *ChecksumIndex) Get(name string) string {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	return index.sums[name]
+}
+
+// Write persists the index to filePath sorted by file name.
+func (index *ChecksumIndex) Write(filePath string) error {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	names := make([]string, 0, len(index.sums))
+	for name := range index.sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s  %s\n", index.sums[name], name)
+	}
+
+	return os.WriteFile(filePath, []byte(b.String()), 0644)
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// confirmMu keeps concurrent confirmations from interleaving their prompts and answers.
+var confirmMu sync.Mutex
+
+// Confirm asks question on stderr and reads a yes or no answer from stdin, declining on anything but y or yes. It only
+// asks when both are terminals: when stdin is a pipe or a file such as a playlist read from stdin, nobody could answer,
+// so it goes ahead without asking, as a run without confirmations would.
+func Confirm(question string) bool {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		return true
+	}
+	confirmMu.Lock()
+	defer confirmMu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr)
+		slog.Debug("no answer to confirmation", slog.String("question", question), slog.String("error", err.Error()))
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
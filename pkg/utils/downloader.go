@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Downloader issues the requests for playlists, fragments, init segments and keys through Client, adding the
+// configured headers to each of them. Other requests of a run, like health webhooks, use Client directly and never see
+// them. The zero value sends the requests through http.DefaultClient without any headers.
+type Downloader struct {
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
+	// Header is added to every request, e.g. an Authorization or Cookie header required by the origin.
+	Header http.Header
+	// SensitiveQuery names the query parameters, such as tokens added to media urls, whose values are hidden wherever
+	// the downloader logs or reports a url, see RedactUrl.
+	SensitiveQuery []string
+}
+
+// DownloaderOptions configures the Downloader built by NewDownloader.
+type DownloaderOptions struct {
+	// Headers are raw "Name: value" or "Name=value" headers.
+	Headers   []string
+	Cookies   []string
+	UserAgent string
+	// BearerToken is sent as an "Authorization: Bearer" header.
+	BearerToken string
+	// Proxy is the url of an HTTP(S) or SOCKS5 proxy. When empty, the HTTP_PROXY/HTTPS_PROXY environment variables apply.
+	Proxy string
+	// Timeout limits each request including reading its body. Zero means no limit.
+	Timeout time.Duration
+	// ConnectTimeout limits establishing a connection. Zero keeps the default.
+	ConnectTimeout time.Duration
+	// MaxRate limits the bytes per second of all downloads together. Zero means no limit.
+	MaxRate int64
+	// MaxRequestsPerSecond limits the requests sent to every host. Zero means no limit.
+	MaxRequestsPerSecond float64
+	// SensitiveQuery names the query parameters whose values are redacted, see Downloader.SensitiveQuery.
+	SensitiveQuery []string
+}
+
+// NewDownloader returns a Downloader with a client of its own configured by options.
+func NewDownloader(options DownloaderOptions) (*Downloader, error) {
+	header, err := ParseHeaders(options.Headers)
+	if err != nil {
+		return nil, err
+	}
+	if len(options.Cookies) > 0 {
+		header.Set("Cookie", strings.Join(append(header.Values("Cookie"), options.Cookies...), "; "))
+	}
+	if options.BearerToken != "" {
+		header.Set("Authorization", "Bearer "+options.BearerToken)
+	}
+	if options.UserAgent != "" {
+		header.Set("User-Agent", options.UserAgent)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.Proxy != "" {
+		proxyUrl, err := url.Parse(options.Proxy)
+		if err != nil || proxyUrl.Host == "" {
+			return nil, fmt.Errorf("invalid proxy url %q", options.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+	if options.ConnectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: options.ConnectTimeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = options.ConnectTimeout
+	}
+
+	// requests to a host that asked to retry after a while are held back even without limits
+	client := &http.Client{
+		Transport: newPoliteTransport(transport, options.MaxRate, options.MaxRequestsPerSecond),
+		Timeout:   options.Timeout,
+	}
+	return &Downloader{Client: client, Header: header, SensitiveQuery: options.SensitiveQuery}, nil
+}
+
+// ParseHeaders parses raw "Name: value" or "Name=value" headers. Repeated names add values rather than replacing them.
+func ParseHeaders(raw []string) (http.Header, error) {
+	header := http.Header{}
+	for _, line := range raw {
+		separator := strings.IndexAny(line, ":=")
+		if separator <= 0 {
+			return nil, fmt.Errorf("invalid header %q, expected Name=value", line)
+		}
+		header.Add(strings.TrimSpace(line[:separator]), strings.TrimSpace(line[separator+1:]))
+	}
+	return header, nil
+}
+
+// ParseQuery parses raw name=value query parameters. Repeated names add values rather than replacing them.
+func ParseQuery(raw []string) (url.Values, error) {
+	query := url.Values{}
+	for _, pair := range raw {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid query parameter %q, expected name=value", pair)
+		}
+		query.Add(strings.TrimSpace(name), value)
+	}
+	return query, nil
+}
+
+// withHeader adds the headers of the downloader to req.
+func (downloader *Downloader) withHeader(req *http.Request) *http.Request {
+	for name, values := range downloader.Header {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	return req
+}
+
+// Do sends req with the headers of the downloader added.
+func (downloader *Downloader) Do(req *http.Request) (*http.Response, error) {
+	client := downloader.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(downloader.withHeader(req))
+	return resp, redactError(err, downloader.SensitiveQuery)
+}
+
+// Get fetches url with the headers of the downloader.
+func (downloader *Downloader) Get(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return downloader.Do(req)
+}
+
+// Head checks url with the headers of the downloader.
+func (downloader *Downloader) Head(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return downloader.Do(req)
+}
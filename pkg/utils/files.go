@@ -1,60 +1,576 @@
 package utils
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
 	"path"
 	"strings"
+	"time"
+
+	"github.com/alehechka/manifestr/pkg/storage"
 )
 
-func CreateDirectoryOrTemp(directory string) (string, error) {
-	if directory == "" {
-		err := os.MkdirAll("./tmp", os.ModePerm)
-		if err != nil {
-			return "", err
+// DownloadFile saves the resource at url as filename in dir unless it already exists there. Cancelling ctx aborts the
+// download, keeping what was written aside with the PartialSuffix so a later run resumes it.
+func DownloadFile(ctx context.Context, dir string, filename string, url string, options DownloadOptions) (string, error) {
+	result, err := DownloadFileWithResult(ctx, dir, filename, url, options)
+	return result.Path, err
+}
+
+// DownloadResult describes a file fetched by DownloadFileWithResult.
+type DownloadResult struct {
+	Path string
+	// Checksum is the hex SHA-256 of the content, computed while it streamed to disk. It is empty when an existing file was kept.
+	Checksum string
+	// Headers holds the response headers of a remote download.
+	Headers http.Header
+	Elapsed time.Duration
+	Skipped bool
+	// Expected is the size in bytes the origin announced, from the Content-Length of the response or a HEAD request when
+	// it had none, or -1 when it did not announce one. Written is the number of bytes saved, which falls short of Expected
+	// when the transfer was silently truncated.
+	Expected int64
+	Written  int64
+}
+
+// TransferOptions tune how the files of a run are transferred and written, the same way for all of them. The zero value
+// never aborts a stalled transfer, copies local sources, writes through a buffer of DefaultWriteBufferSize, leaves
+// syncing to the OS and keeps any existing file of the recorded size.
+type TransferOptions struct {
+	// IdleTimeout aborts a transfer once no bytes have arrived for this long, detecting half-dead connections. Zero
+	// disables it.
+	IdleTimeout time.Duration
+	// IdleRetries is the number of times a stalled transfer is restarted before giving up, see DefaultIdleRetries.
+	IdleRetries int
+	// LocalFileMode decides how a local source file is brought into the download directory: LocalFileCopy, the default
+	// when empty, LocalFileHardlink or LocalFileSymlink.
+	LocalFileMode string
+	// WriteBufferSize is the size of the buffer downloads are written through, trading memory for fewer small writes.
+	// DefaultWriteBufferSize when zero.
+	WriteBufferSize int
+	// Fsync decides when downloaded files are flushed to stable storage: FsyncNever, the default when empty, leaves it to
+	// the OS, FsyncEach syncs every file as it completes and FsyncBatch defers syncing to the Sync of Batch, or syncs
+	// every file like FsyncEach when Batch is nil.
+	Fsync string
+	// Batch collects the files written with FsyncBatch, shared by all the downloads of a run.
+	Batch *SyncBatch `json:"-"`
+	// Verify decides when a file that already exists is kept instead of downloaded again, VerifyExists when empty, see
+	// IsStale.
+	Verify string
+}
+
+const (
+	DefaultIdleRetries     = 2
+	DefaultWriteBufferSize = 256 * 1024
+)
+
+// DownloadOptions configures DownloadFile and its variants. The zero value keeps a file that already exists, saves the
+// whole resource, sends the requests through http.DefaultClient and logs through the default slog logger.
+type DownloadOptions struct {
+	TransferOptions
+	// Force downloads the file again even when it already exists.
+	Force bool
+	// Offset and Length select the bytes of the resource to save, requested with an HTTP Range header. A zero Length
+	// saves the whole resource.
+	Offset int64
+	Length int64
+	// Downloader sends the requests, a zero Downloader when nil.
+	Downloader *Downloader
+	// Logger receives the messages about the download, slog.Default() when nil.
+	Logger *slog.Logger
+}
+
+func (options DownloadOptions) downloader() *Downloader {
+	if options.Downloader == nil {
+		return &Downloader{}
+	}
+	return options.Downloader
+}
+
+func (options DownloadOptions) logger() *slog.Logger {
+	if options.Logger == nil {
+		return slog.Default()
+	}
+	return options.Logger
+}
+
+// DownloadFileWithResult behaves like DownloadFile but also reports the checksum, response headers and timing of the download.
+func DownloadFileWithResult(ctx context.Context, dir string, filename string, url string, options DownloadOptions) (DownloadResult, error) {
+	result := DownloadResult{Path: path.Join(dir, filename), Expected: -1}
+	started := time.Now()
+	offset, length := options.Offset, options.Length
+
+	if _, err := os.Stat(result.Path); err == nil && !options.Force {
+		options.logger().Debug("skipping download", slog.String("file", result.Path), slog.String("url", options.downloader().RedactUrl(url)))
+		result.Skipped = true
+		return result, nil
+	}
+
+	if url == StdinUrl {
+		if length > 0 {
+			return result, ErrStdinRange
 		}
+		var err error
+		result.Checksum, err = copyStdin(result.Path, options.TransferOptions)
+		result.Elapsed = time.Since(started)
+		return result, err
+	}
+
+	if strings.HasPrefix(url, "/") {
+		var err error
+		if length > 0 {
+			result.Checksum, err = copyLocalRange(url, result.Path, offset, length, options.TransferOptions)
+		} else {
+			result.Checksum, err = copyLocalFile(url, result.Path, options.TransferOptions)
+		}
+		result.Elapsed = time.Since(started)
+		return result, err
+	}
 
-		return os.MkdirTemp("./tmp", "")
+	var err error
+	for attempt := 0; attempt <= options.IdleRetries; attempt++ {
+		err = downloadRemote(ctx, &result, url, options)
+		if !errors.Is(err, ErrStalled) {
+			break
+		}
+		options.logger().Warn("download stalled", slog.String("url", options.downloader().RedactUrl(url)), slog.Int("attempt", attempt+1), slog.Duration("idleTimeout", options.IdleTimeout))
 	}
 
-	return directory, os.MkdirAll(directory, os.ModePerm)
+	result.Elapsed = time.Since(started)
+	return result, err
 }
 
-func DownloadFile(dir string, filename string, url string, forceDownload bool) (string, error) {
-	filePath := path.Join(dir, filename)
+// DownloadToStorage behaves like DownloadFileWithResult but streams the resource straight into output as the object
+// filename, with nothing written to disk. Objects only appear once complete, so an interrupted transfer leaves nothing
+// behind to resume and starts over on the next run.
+func DownloadToStorage(ctx context.Context, output storage.Storage, filename string, url string, options DownloadOptions) (DownloadResult, error) {
+	result := DownloadResult{Path: output.Location(filename), Expected: -1}
+	started := time.Now()
 
-	if _, err := os.Stat(filePath); err == nil && !forceDownload {
-		slog.Debug("skipping download", slog.String("file", filePath), slog.String("url", url))
-		return filePath, nil
+	if !options.Force {
+		size, err := output.Size(ctx, filename)
+		if err == nil {
+			options.logger().Debug("skipping download", slog.String("file", result.Path), slog.String("url", options.downloader().RedactUrl(url)))
+			result.Skipped, result.Written = true, size
+			return result, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return result, err
+		}
+	}
+
+	var err error
+	for attempt := 0; attempt <= options.IdleRetries; attempt++ {
+		err = uploadRemote(ctx, output, filename, &result, url, options)
+		if !errors.Is(err, ErrStalled) {
+			break
+		}
+		options.logger().Warn("download stalled", slog.String("url", options.downloader().RedactUrl(url)), slog.Int("attempt", attempt+1), slog.Duration("idleTimeout", options.IdleTimeout))
 	}
 
-	file, err := os.Create(filePath)
+	result.Elapsed = time.Since(started)
+	return result, err
+}
+
+// uploadRemote copies the resource at url, or the bytes of it selected by options, into the object filename of output.
+func uploadRemote(parent context.Context, output storage.Storage, filename string, result *DownloadResult, url string, options DownloadOptions) error {
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+
+	offset, length := options.Offset, options.Length
+	body, err := openSource(ctx, result, url, options)
 	if err != nil {
-		return filePath, err
+		return stalledOr(ctx, err)
+	}
+	defer body.Close()
+
+	var reader io.Reader = body
+	var idle *time.Timer
+	if options.IdleTimeout > 0 {
+		idle = time.AfterFunc(options.IdleTimeout, func() { cancel(ErrStalled) })
+		defer idle.Stop()
+		reader = &idleReader{r: body, timer: idle, timeout: options.IdleTimeout}
+	}
+
+	object, err := output.Create(ctx, filename)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	result.Written, err = io.Copy(io.MultiWriter(object, hash), reader)
+	if err == nil && length > 0 && result.Written < length {
+		err = fmt.Errorf("byte range %d@%d of %s: %w", length, offset, url, io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		// the object is discarded rather than completed with what arrived so far
+		cancel(err)
+		object.Close()
+		return stalledOr(ctx, err)
+	}
+	if err := object.Close(); err != nil {
+		return err
+	}
+
+	result.Checksum = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// openSource opens the resource at url, a local path, StdinUrl or a remote url, limited to the bytes selected by options,
+// recording its headers and expected size in result.
+func openSource(ctx context.Context, result *DownloadResult, url string, options DownloadOptions) (io.ReadCloser, error) {
+	offset, length := options.Offset, options.Length
+	if url == StdinUrl {
+		if length > 0 {
+			return nil, ErrStdinRange
+		}
+		return io.NopCloser(os.Stdin), nil
 	}
-	defer file.Close()
 
 	if strings.HasPrefix(url, "/") {
-		b, err := os.ReadFile(url)
-		if err != nil {
-			return filePath, err
+		file, err := os.Open(url)
+		if err != nil || length == 0 {
+			return file, err
+		}
+		result.Expected = length
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(file, offset, length), file}, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	resp, err := options.downloader().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	result.Headers = resp.Header
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		return nil, options.downloader().statusError(resp, url)
+	}
+
+	switch {
+	case length > 0:
+		result.Expected = length
+		if resp.StatusCode != http.StatusPartialContent {
+			if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, length), resp.Body}, nil
+	case resp.ContentLength >= 0:
+		result.Expected = resp.ContentLength
+	default:
+		if contentLength, err := options.downloader().ContentLength(url); err == nil {
+			result.Expected = contentLength
+		}
+	}
+	return resp.Body, nil
+}
+
+// PartialSuffix is appended to the name of a remote file while it downloads, so a file cut short by an interrupted run is
+// never mistaken for a complete one. A later download of the same file resumes it with an HTTP Range request.
+const PartialSuffix = ".part"
+
+func downloadRemote(parent context.Context, result *DownloadResult, url string, options DownloadOptions) (err error) {
+	offset, length := options.Offset, options.Length
+	partPath := result.Path + PartialSuffix
+	previous, previousErr := os.Stat(result.Path)
+	unlock, err := lockPartial(parent, partPath, options.logger())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// another process sharing the directory may have completed the file while this one waited for the lock
+	if current, err := os.Stat(result.Path); err == nil && (previousErr != nil || !os.SameFile(previous, current)) {
+		options.logger().Debug("file downloaded by another process", slog.String("file", result.Path))
+		result.Skipped = true
+		return os.Remove(partPath)
+	}
+
+	file, resumed, err := openPartial(partPath, result.Path, options.TransferOptions)
+	if err != nil {
+		return err
+	}
+	closed := false
+	defer func() {
+		if !closed {
+			err = errors.Join(err, file.Close())
+		}
+		// what arrived before the transfer broke off is kept for the next attempt to resume, unless the origin refused it
+		var statusError *StatusError
+		if err != nil && (errors.As(err, &statusError) || !isResumable(partPath)) {
+			os.Remove(partPath)
+		}
+	}()
+
+	if length > 0 && resumed >= length {
+		if err := file.restart(); err != nil {
+			return err
+		}
+		resumed = 0
+	}
+
+	hash := sha256.New()
+	if resumed > 0 {
+		if err := hashPrefix(partPath, resumed, hash); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+
+	var idle *time.Timer
+	if options.IdleTimeout > 0 {
+		idle = time.AfterFunc(options.IdleTimeout, func() { cancel(ErrStalled) })
+		defer idle.Stop()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	switch {
+	case length > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset+resumed, offset+length-1))
+	case resumed > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumed))
+	}
+
+	resp, err := options.downloader().Do(req)
+	if err != nil {
+		return stalledOr(ctx, err)
+	}
+	defer resp.Body.Close()
+	result.Headers = resp.Header
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return options.downloader().statusError(resp, url)
+	}
+
+	if resumed > 0 {
+		if resp.StatusCode == http.StatusPartialContent && contentRangeStart(resp.Header.Get("Content-Range")) == offset+resumed {
+			options.logger().Debug("resuming download", slog.String("file", result.Path), slog.Int64("from", resumed))
+		} else {
+			// origins that ignore the Range header serve the whole resource again
+			if err := file.restart(); err != nil {
+				return err
+			}
+			hash.Reset()
+			resumed = 0
 		}
-		if err := os.WriteFile(filePath, b, 0644); err != nil {
-			return filePath, err
+	}
+
+	var body io.Reader = resp.Body
+	if idle != nil {
+		body = &idleReader{r: resp.Body, timer: idle, timeout: options.IdleTimeout}
+	}
+
+	if length > 0 {
+		if resp.StatusCode != http.StatusPartialContent {
+			if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+				return stalledOr(ctx, err)
+			}
 		}
-	} else {
-		resp, err := http.Get(url)
+		body = io.LimitReader(body, length-resumed)
+	}
+
+	switch {
+	case length > 0:
+		result.Expected = length
+	case resp.ContentLength >= 0:
+		result.Expected = resumed + resp.ContentLength
+	default:
+		// chunked responses announce no size, which the origin may still report for a HEAD request
+		if contentLength, err := options.downloader().ContentLength(url); err == nil {
+			result.Expected = contentLength
+		}
+	}
+
+	written, err := io.Copy(io.MultiWriter(file, hash), body)
+	result.Written = resumed + written
+	if err != nil {
+		return stalledOr(ctx, err)
+	}
+	if length > 0 && result.Written < length {
+		return fmt.Errorf("byte range %d@%d of %s: %w", length, offset, url, io.ErrUnexpectedEOF)
+	}
+
+	closed = true
+	if err := file.Close(); err != nil {
+		return err
+	}
+	// result.Path may be linked into an archive or the shared store, which the rename leaves untouched
+	if err := os.Rename(partPath, result.Path); err != nil {
+		return err
+	}
+
+	result.Checksum = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// isResumable reports whether the partial file at partPath holds anything a later attempt could resume from.
+func isResumable(partPath string) bool {
+	info, err := os.Stat(partPath)
+	return err == nil && info.Size() > 0
+}
+
+// hashPrefix feeds the first size bytes of the file at filePath into hash.
+func hashPrefix(filePath string, size int64, hash io.Writer) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.CopyN(hash, file, size)
+	return err
+}
+
+// contentRangeStart returns the first byte position of a Content-Range such as "bytes 100-199/1000", or -1.
+func contentRangeStart(contentRange string) int64 {
+	var start, end int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d", &start, &end); err != nil {
+		return -1
+	}
+	return start
+}
+
+// ErrStalled is returned when no bytes of a download arrived within TransferOptions.IdleTimeout.
+var ErrStalled = errors.New("download stalled")
+
+// idleReader pushes back the idle timer every time bytes arrive.
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (reader *idleReader) Read(p []byte) (int, error) {
+	n, err := reader.r.Read(p)
+	if n > 0 {
+		reader.timer.Reset(reader.timeout)
+	}
+	return n, err
+}
+
+func stalledOr(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), ErrStalled) {
+		return ErrStalled
+	}
+	return err
+}
+
+// StdinUrl is accepted in place of a url to read the resource from standard input.
+const StdinUrl = "-"
+
+// ErrStdinRange is returned when a byte range of standard input is requested, which can only be read once.
+var ErrStdinRange = errors.New("byte ranges of standard input are not supported")
+
+func copyStdin(dst string, options TransferOptions) (string, error) {
+	destination, err := createBuffered(dst, options)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(destination, hash), os.Stdin)
+	if err := errors.Join(err, destination.Close()); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// copyLocalRange copies the length bytes starting at offset of the local file at src to dst.
+func copyLocalRange(src string, dst string, offset int64, length int64, options TransferOptions) (string, error) {
+	source, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	destination, err := createBuffered(dst, options)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(destination, hash), io.NewSectionReader(source, offset, length))
+	if err == nil && written < length {
+		err = fmt.Errorf("byte range %d@%d of %s: %w", length, offset, src, io.ErrUnexpectedEOF)
+	}
+	if err := errors.Join(err, destination.Close()); err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+const (
+	LocalFileCopy     = "copy"
+	LocalFileHardlink = "hardlink"
+	LocalFileSymlink  = "symlink"
+)
+
+// copyLocalFile places the local file at src at dst according to the LocalFileMode of options, streaming rather than buffering it so huge fixtures don't exhaust memory.
+func copyLocalFile(src string, dst string, options TransferOptions) (string, error) {
+	source, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	// dst may be a link to src left by an earlier run, which must not be truncated through
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	hash := sha256.New()
+
+	switch options.LocalFileMode {
+	case LocalFileHardlink, LocalFileSymlink:
+		link := os.Link
+		if options.LocalFileMode == LocalFileSymlink {
+			link = os.Symlink
+		}
+		if err := link(src, dst); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(hash, source); err != nil {
+			return "", err
+		}
+	default:
+		destination, err := createBuffered(dst, options)
 		if err != nil {
-			return filePath, err
+			return "", err
 		}
-		defer resp.Body.Close()
 
-		if _, err := io.Copy(file, resp.Body); err != nil {
-			return filePath, err
+		_, err = io.Copy(io.MultiWriter(destination, hash), source)
+		if err := errors.Join(err, destination.Close()); err != nil {
+			return "", err
 		}
 	}
 
-	return filePath, nil
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
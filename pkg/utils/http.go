@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// OpenUrl opens the resource at url for reading, which may be a remote HTTP(S) url, an absolute local path or StdinUrl.
+func (downloader *Downloader) OpenUrl(url string) (io.ReadCloser, error) {
+	if url == StdinUrl {
+		return io.NopCloser(os.Stdin), nil
+	}
+
+	if strings.HasPrefix(url, "/") {
+		return os.Open(url)
+	}
+
+	resp, err := downloader.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		resp.Body.Close()
+		return nil, downloader.statusError(resp, url)
+	}
+
+	return resp.Body, nil
+}
+
+// CheckUrl verifies the resource at url exists without downloading it.
+func (downloader *Downloader) CheckUrl(url string) error {
+	if strings.HasPrefix(url, "/") {
+		_, err := os.Stat(url)
+		return err
+	}
+
+	resp, err := downloader.Head(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return downloader.statusError(resp, url)
+	}
+
+	return nil
+}
+
+// ContentLength returns the size in bytes of the resource at url without downloading it, or -1 when the server does not
+// report one.
+func (downloader *Downloader) ContentLength(url string) (int64, error) {
+	if strings.HasPrefix(url, "/") {
+		info, err := os.Stat(url)
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+
+	resp, err := downloader.Head(url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return 0, downloader.statusError(resp, url)
+	}
+
+	return resp.ContentLength, nil
+}
+
+// LastModified returns the Last-Modified time of the resource at url without downloading it, or the zero time when the
+// server does not report one.
+func (downloader *Downloader) LastModified(url string) (time.Time, error) {
+	if strings.HasPrefix(url, "/") {
+		info, err := os.Stat(url)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return info.ModTime(), nil
+	}
+
+	resp, err := downloader.Head(url)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return time.Time{}, downloader.statusError(resp, url)
+	}
+
+	modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return modified, nil
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const IndexFileName = "index.json"
+
+// IndexedHeaders are the response headers kept for each fragment, chosen for CDN cache-behavior analysis.
+var IndexedHeaders = []string{
+	"Age", "X-Cache", "Content-Length", "Last-Modified", "Cache-Control", "Via",
+	"CF-Cache-Status", "CF-Ray", "X-Amz-Cf-Pop", "X-Served-By", "ETag",
+}
+
+// IndexRecord describes how a single file in the archive was downloaded.
+type IndexRecord struct {
+	File       string            `json:"file"`
+	Url        string            `json:"url"`
+	Sha256     string            `json:"sha256,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	ElapsedMs  int64             `json:"elapsedMs"`
+	Downloaded time.Time         `json:"downloaded"`
+}
+
+// ArchiveIndex is a concurrency safe record of every file downloaded into a directory, persisted as JSON.
+type ArchiveIndex struct {
+	mu      sync.Mutex
+	records map[string]IndexRecord
+}
+
+// LoadArchiveIndex reads the index at filePath, returning an empty index if it does not exist yet.
+func LoadArchiveIndex(filePath string) (*ArchiveIndex, error) {
+	index := &ArchiveIndex{records: make(map[string]IndexRecord)}
+
+	b, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]IndexRecord, 0)
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		index.records[record.File] = record
+	}
+
+	return index, nil
+}
+
+// Record stores the outcome of a download, keeping only the IndexedHeaders of its response.
+func (index *ArchiveIndex) Record(file string, url string, result DownloadResult) {
+	record := IndexRecord{
+		File:       file,
+		Url:        url,
+		Sha256:     result.Checksum,
+		ElapsedMs:  result.Elapsed.Milliseconds(),
+		Downloaded: time.Now().UTC(),
+	}
+
+	if result.Headers != nil {
+		record.Headers = make(map[string]string)
+		for _, name := range IndexedHeaders {
+			if value := result.Headers.Get(name); value != "" {
+				record.Headers[http.CanonicalHeaderKey(name)] = value
+			}
+		}
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.records[file] = record
+}
+
+// Get returns the record of file, if it was downloaded.
+func (index *ArchiveIndex) Get(file string) (IndexRecord, bool) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	record, ok := index.records[file]
+	return record, ok
+}
+
+// Records returns every record sorted by file name.
+func (index *ArchiveIndex) Records() []IndexRecord {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	records := make([]IndexRecord, 0, len(index.records))
+	for _, record := range index.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].File < records[j].File
+	})
+	return records
+}
+
+// Write persists the index to filePath.
+func (index *ArchiveIndex) Write(filePath string) error {
+	b, err := json.MarshalIndent(index.Records(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filePath, b, 0644)
+}
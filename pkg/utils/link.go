@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+)
+
+// LinkOrClone places src at dst without duplicating its content where the filesystem allows:
+// a hardlink when both are on the same filesystem, otherwise a copy-on-write reflink, and a plain copy as a last resort.
+func LinkOrClone(src string, dst string) error {
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	linkErr := os.Link(src, dst)
+	if linkErr == nil {
+		return nil
+	}
+
+	cloneErr := reflink(src, dst)
+	if cloneErr == nil {
+		return nil
+	}
+
+	slog.Debug("falling back to copy", slog.String("file", src), slog.String("link", linkErr.Error()), slog.String("reflink", cloneErr.Error()))
+	return copyFile(src, dst)
+}
+
+func copyFile(src string, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(destination, source)
+	return errors.Join(err, destination.Close())
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// lockPollInterval is how often a download waiting for the lock of its partial file tries to take it again.
+const lockPollInterval = 100 * time.Millisecond
+
+// lockPartial takes an exclusive lock on the partial file at partPath, creating it when it does not exist yet, waiting
+// while another process sharing the directory downloads the same file, such as the daemon or a parallel download of
+// other variants, until ctx is done. The lock is held until the returned function is called, which must happen only
+// once the partial file was renamed into place or removed, so no two processes ever append to the same partial file.
+func lockPartial(ctx context.Context, partPath string, logger *slog.Logger) (unlock func(), err error) {
+	waiting := false
+	for {
+		file, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE, 0666)
+		if err != nil {
+			return nil, err
+		}
+
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if locked {
+			// the process holding the lock before may have renamed the file into place or removed it, leaving this lock on a
+			// file no other process opens by name anymore
+			if current, err := os.Stat(partPath); err == nil {
+				if info, err := file.Stat(); err == nil && os.SameFile(current, info) {
+					return func() { file.Close() }, nil
+				}
+			}
+			file.Close()
+			continue
+		}
+		file.Close()
+
+		if !waiting {
+			logger.Info("waiting for another process downloading the same file", slog.String("file", partPath))
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package utils
+
+import "os"
+
+// tryLock is only implemented on Unix, elsewhere processes sharing a download directory are not kept from writing the
+// same file.
+func tryLock(file *os.File) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"context"
+	"log/slog"
+)
+
+// ProgressHandler wraps a slog.Handler so that records are printed above the progress bar rather than corrupting it.
+// It is safe for concurrent use as long as the wrapped handler is.
+type ProgressHandler struct {
+	handler  slog.Handler
+	progress *Progress
+}
+
+// NewProgressHandler routes the records of handler around progress. A nil progress returns handler unchanged.
+func NewProgressHandler(handler slog.Handler, progress *Progress) slog.Handler {
+	if progress == nil {
+		return handler
+	}
+	return &ProgressHandler{handler: handler, progress: progress}
+}
+
+func (handler *ProgressHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return handler.handler.Enabled(ctx, level)
+}
+
+func (handler *ProgressHandler) Handle(ctx context.Context, record slog.Record) error {
+	return handler.progress.above(func() error {
+		return handler.handler.Handle(ctx, record)
+	})
+}
+
+func (handler *ProgressHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ProgressHandler{handler: handler.handler.WithAttrs(attrs), progress: handler.progress}
+}
+
+func (handler *ProgressHandler) WithGroup(name string) slog.Handler {
+	return &ProgressHandler{handler: handler.handler.WithGroup(name), progress: handler.progress}
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	OutputOverwrite = "overwrite"
+	OutputSkip      = "skip-existing"
+	OutputRename    = "rename-existing"
+)
+
+// OutputPolicy decides what happens when a final output already exists. The zero value replaces it without asking.
+type OutputPolicy struct {
+	// Mode is OutputOverwrite, the default when empty, which replaces the output, OutputSkip, which keeps it and skips
+	// producing it again, or OutputRename, which moves it aside to the first free "name.N.ext" before writing the new one.
+	Mode string
+	// Confirm, when set, is asked whether OutputOverwrite may replace an existing output, which is skipped when it
+	// declines, see Confirm.
+	Confirm func(question string) bool `json:"-"`
+}
+
+// Skips reports whether the policy keeps existing outputs.
+func (policy OutputPolicy) Skips() bool {
+	return policy.Mode == OutputSkip
+}
+
+// Overwrites reports whether the policy replaces existing outputs, possibly after asking.
+func (policy OutputPolicy) Overwrites() bool {
+	return policy.Mode == OutputOverwrite || policy.Mode == ""
+}
+
+// Resolve applies the policy to filePath, reporting whether the output should be skipped because it already exists.
+func (policy OutputPolicy) Resolve(filePath string) (skip bool, err error) {
+	if _, err := os.Stat(filePath); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	switch policy.Mode {
+	case OutputSkip:
+		return true, nil
+	case OutputRename:
+		ext := path.Ext(filePath)
+		base := strings.TrimSuffix(filePath, ext)
+		for n := 1; ; n++ {
+			renamed := fmt.Sprintf("%s.%d%s", base, n, ext)
+			if _, err := os.Stat(renamed); errors.Is(err, fs.ErrNotExist) {
+				return false, os.Rename(filePath, renamed)
+			}
+		}
+	case OutputOverwrite, "":
+		if policy.Confirm != nil && !policy.Confirm(fmt.Sprintf("%s already exists, overwrite it?", filePath)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const progressWidth = 30
+
+// throughputWindow is how far back the current throughput is measured, so it follows changes in speed.
+const throughputWindow = 10 * time.Second
+
+// DefaultProgressLogInterval is how often a Progress that is not drawn on a terminal logs how far it got instead, unless
+// its LogInterval is set.
+const DefaultProgressLogInterval = 10 * time.Second
+
+// Progress renders a single line progress bar at the bottom of a terminal. Log lines written through a ProgressHandler
+// are printed above it instead of through it. When the output is not a terminal, the progress is logged through slog
+// every LogInterval instead.
+type Progress struct {
+	// LogInterval is how often the progress is logged when it is not drawn, DefaultProgressLogInterval when zero.
+	LogInterval time.Duration
+
+	mu       sync.Mutex
+	out      io.Writer
+	terminal bool
+	label    string
+	total    int
+	done     int
+	failed   int
+	bytes    int64
+	// samples are the completions within throughputWindow, oldest first
+	samples []progressSample
+	started time.Time
+	logged  time.Time
+	visible bool
+	// update, when set, receives the counts instead of a bar or log lines, see NewProgressFunc
+	update ProgressFunc
+}
+
+// ProgressFunc receives how many of total items are done, how many of those failed and their size in bytes, every time
+// the counts change.
+type ProgressFunc func(done int, failed int, total int, bytes int64)
+
+type progressSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// NewProgress returns a Progress drawing a bar to out when it is a terminal and logging through slog otherwise.
+// A nil Progress ignores every call.
+func NewProgress(out *os.File) *Progress {
+	return &Progress{out: out, terminal: isTerminal(out)}
+}
+
+// NewProgressFunc returns a Progress reporting its counts to update rather than drawing or logging them, for callers
+// embedding the downloads that show their own progress. update is called outside of any lock, from the goroutine that
+// finished the item.
+func NewProgressFunc(update ProgressFunc) *Progress {
+	return &Progress{update: update}
+}
+
+// Terminal reports whether the progress is drawn as a bar, which log lines have to be routed around, see ProgressHandler.
+func (progress *Progress) Terminal() bool {
+	return progress != nil && progress.terminal
+}
+
+// Start resets the progress to count up to total under label.
+func (progress *Progress) Start(label string, total int) {
+	if progress == nil {
+		return
+	}
+	progress.mu.Lock()
+	progress.label, progress.total, progress.done, progress.failed, progress.bytes = label, total, 0, 0, 0
+	progress.samples = nil
+	progress.started = time.Now()
+	progress.logged = progress.started
+	message, attrs := progress.report()
+	done, failed, bytes := progress.done, progress.failed, progress.bytes
+	progress.mu.Unlock()
+
+	progress.log(message, attrs)
+	if progress.update != nil {
+		progress.update(done, failed, total, bytes)
+	}
+}
+
+// Done counts one finished item of size bytes, as failed when err is not nil.
+func (progress *Progress) Done(size int64, err error) {
+	if progress == nil {
+		return
+	}
+	progress.mu.Lock()
+	now := time.Now()
+	progress.done++
+	if err != nil {
+		progress.failed++
+	}
+	progress.bytes += size
+	progress.samples = append(progress.samples, progressSample{at: now, bytes: size})
+	for len(progress.samples) > 1 && now.Sub(progress.samples[0].at) > throughputWindow {
+		progress.samples = progress.samples[1:]
+	}
+	message, attrs := progress.report()
+	done, failed, total, bytes := progress.done, progress.failed, progress.total, progress.bytes
+	progress.mu.Unlock()
+
+	progress.log(message, attrs)
+	if progress.update != nil {
+		progress.update(done, failed, total, bytes)
+	}
+}
+
+// Finish removes the bar, leaving the terminal as it was, and logs the overall stats.
+func (progress *Progress) Finish() {
+	if progress == nil {
+		return
+	}
+	progress.mu.Lock()
+	progress.clear()
+	var message string
+	var attrs []any
+	if progress.total > 0 {
+		elapsed := time.Since(progress.started)
+		throughput := 0.0
+		if elapsed > 0 {
+			throughput = float64(progress.bytes) / elapsed.Seconds()
+		}
+		message = "finished " + progress.label
+		attrs = []any{slog.Int("done", progress.done), slog.Int("total", progress.total), slog.Int("failed", progress.failed), slog.String("size", FormatBytes(progress.bytes)), slog.String("throughput", FormatBytes(int64(throughput))+"/s"), slog.Duration("elapsed", elapsed.Round(time.Second))}
+	}
+	progress.total = 0
+	progress.mu.Unlock()
+
+	progress.log(message, attrs)
+}
+
+// log logs what report or Finish returned once the lock is released, as logging may go through a ProgressHandler
+// taking it again. Nothing is logged for an empty message.
+func (progress *Progress) log(message string, attrs []any) {
+	if message != "" {
+		slog.Info(message, attrs...)
+	}
+}
+
+// above runs write with the bar cleared, redrawing it afterwards, so whatever write prints ends up above it.
+func (progress *Progress) above(write func() error) error {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+
+	progress.clear()
+	err := write()
+	if progress.total > 0 && progress.terminal {
+		progress.draw()
+	}
+	return err
+}
+
+// throughput returns the bytes per second and items per second completed within throughputWindow.
+func (progress *Progress) throughput() (bytesPerSecond float64, itemsPerSecond float64) {
+	if len(progress.samples) == 0 {
+		return 0, 0
+	}
+	// the window starts at the first sample, or at the start when it has not filled up yet
+	since := progress.samples[0].at
+	if len(progress.samples) == progress.done {
+		since = progress.started
+	}
+	elapsed := time.Since(since).Seconds()
+	if elapsed <= 0 {
+		return 0, 0
+	}
+
+	var bytes int64
+	for _, sample := range progress.samples {
+		bytes += sample.bytes
+	}
+	return float64(bytes) / elapsed, float64(len(progress.samples)) / elapsed
+}
+
+// eta estimates how long the remaining items take at the current rate, or 0 when it cannot be estimated yet.
+func (progress *Progress) eta(itemsPerSecond float64) time.Duration {
+	if itemsPerSecond <= 0 || progress.done >= progress.total {
+		return 0
+	}
+	return time.Duration(float64(progress.total-progress.done) / itemsPerSecond * float64(time.Second))
+}
+
+// report draws the bar on a terminal, or leaves the counts to update when it is set. Otherwise it returns the progress to log, see log, when LogInterval passed
+// since it was last logged.
+func (progress *Progress) report() (string, []any) {
+	if progress.update != nil {
+		return "", nil
+	}
+	if progress.terminal {
+		progress.draw()
+		return "", nil
+	}
+
+	now := time.Now()
+	interval := progress.LogInterval
+	if interval <= 0 {
+		interval = DefaultProgressLogInterval
+	}
+	if now.Sub(progress.logged) < interval {
+		return "", nil
+	}
+	progress.logged = now
+	bytesPerSecond, itemsPerSecond := progress.throughput()
+	return progress.label, []any{slog.Int("done", progress.done), slog.Int("total", progress.total), slog.Int("failed", progress.failed), slog.String("size", FormatBytes(progress.bytes)), slog.String("throughput", FormatBytes(int64(bytesPerSecond))+"/s"), slog.Duration("eta", progress.eta(itemsPerSecond).Round(time.Second))}
+}
+
+func (progress *Progress) clear() {
+	if progress.visible {
+		fmt.Fprint(progress.out, "\r\033[2K")
+		progress.visible = false
+	}
+}
+
+func (progress *Progress) draw() {
+	filled := 0
+	if progress.total > 0 {
+		filled = progress.done * progressWidth / progress.total
+	}
+
+	line := fmt.Sprintf("\r\033[2K%s [%s%s] %d/%d", progress.label, strings.Repeat("=", filled), strings.Repeat(" ", progressWidth-filled), progress.done, progress.total)
+	if progress.failed > 0 {
+		line += fmt.Sprintf(" (%d failed)", progress.failed)
+	}
+	bytesPerSecond, itemsPerSecond := progress.throughput()
+	line += fmt.Sprintf(" %s %s/s", FormatBytes(progress.bytes), FormatBytes(int64(bytesPerSecond)))
+	if eta := progress.eta(itemsPerSecond); eta > 0 {
+		line += fmt.Sprintf(" ETA %s", eta.Round(time.Second))
+	}
+	line += fmt.Sprintf(" %s", time.Since(progress.started).Round(time.Second))
+
+	fmt.Fprint(progress.out, line)
+	progress.visible = true
+}
+
+// FormatBytes formats a size with a binary unit, e.g. 1.5 MiB.
+func FormatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value, exponent := float64(bytes)/unit, 0
+	for value >= unit && exponent < 4 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exponent])
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// Quota caps how many bytes a download directory may hold, protecting shared storage from a runaway capture. It counts
+// the files the directory holds when opened and every byte added since: the files downloaded into it, files downloaded
+// again included, and what they reserve for the outputs to be written from them. A nil Quota is unlimited.
+type Quota struct {
+	Limit int64
+	used  atomic.Int64
+}
+
+// OpenQuota opens a quota of limit bytes over dir and everything below it, such as the renditions and variants
+// downloaded into subdirectories.
+func OpenQuota(dir string, limit int64) (*Quota, error) {
+	quota := &Quota{Limit: limit}
+	err := filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		quota.used.Add(info.Size())
+		return nil
+	})
+	return quota, err
+}
+
+// Add counts size more bytes written, or to be written, into the directory.
+func (quota *Quota) Add(size int64) {
+	if quota != nil {
+		quota.used.Add(size)
+	}
+}
+
+// Used is how many bytes the directory is known to hold.
+func (quota *Quota) Used() int64 {
+	if quota == nil {
+		return 0
+	}
+	return quota.used.Load()
+}
+
+// Exceeded reports whether the directory holds Limit bytes or more.
+func (quota *Quota) Exceeded() bool {
+	return quota != nil && quota.used.Load() >= quota.Limit
+}
@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseSize parses a size in bytes with an optional binary K, M, G or T suffix, e.g. 500K or 1.5G, which may be followed
+// by B or iB as in 4GB. An empty size is 0.
+func ParseSize(size string) (int64, error) {
+	number, multiplier := strings.TrimSpace(size), 1.0
+	if number == "" {
+		return 0, nil
+	}
+	if upper := strings.ToUpper(number); strings.HasSuffix(upper, "IB") && len(number) > 2 {
+		number = number[:len(number)-2]
+	} else if strings.HasSuffix(upper, "B") && len(number) > 1 {
+		number = number[:len(number)-1]
+	}
+	if index := strings.IndexByte("KMGT", strings.ToUpper(number)[len(number)-1]); index >= 0 {
+		multiplier = float64(int64(1) << (10 * (index + 1)))
+		number = number[:len(number)-1]
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected bytes such as 500M or 2G", size)
+	}
+	return int64(value * multiplier), nil
+}
+
+// ParseByteRate parses a rate in bytes per second like ParseSize, e.g. 500K or 1.5M. An empty rate is 0, meaning
+// unlimited.
+func ParseByteRate(rate string) (int64, error) {
+	bytesPerSecond, err := ParseSize(rate)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q, expected bytes per second such as 500K or 10M", rate)
+	}
+	return bytesPerSecond, nil
+}
+
+// tokenBucket hands out rate tokens per second on average, in bursts of up to burst tokens.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait takes n tokens, waiting until the bucket has refilled enough or ctx is done. Tokens are taken in advance, so
+// concurrent callers queue up behind each other rather than all waking up at once.
+func (bucket *tokenBucket) wait(ctx context.Context, n float64) error {
+	bucket.mu.Lock()
+	now := time.Now()
+	bucket.tokens = min(bucket.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate)
+	bucket.last = now
+	bucket.tokens -= n
+	delay := time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+	bucket.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		bucket.mu.Lock()
+		bucket.tokens += n
+		bucket.mu.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// politeTransport holds back the requests to a host that answered 429 or 503 with a Retry-After header until then,
+// spaces out the requests to every host by requestRate and throttles all response bodies together to bandwidth.
+type politeTransport struct {
+	next http.RoundTripper
+	// bandwidth is nil when the rate of the response bodies is unlimited.
+	bandwidth *tokenBucket
+	// requestRate is the number of requests per second allowed to every host, 0 meaning unlimited.
+	requestRate float64
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimit
+}
+
+type hostLimit struct {
+	requests   *tokenBucket
+	retryAfter time.Time
+}
+
+func newPoliteTransport(next http.RoundTripper, maxRate int64, maxRequestsPerSecond float64) *politeTransport {
+	transport := &politeTransport{next: next, requestRate: maxRequestsPerSecond, hosts: map[string]*hostLimit{}}
+	if maxRate > 0 {
+		transport.bandwidth = newTokenBucket(float64(maxRate), float64(maxRate))
+	}
+	return transport
+}
+
+func (transport *politeTransport) host(host string) *hostLimit {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	limit, ok := transport.hosts[host]
+	if !ok {
+		limit = &hostLimit{}
+		if transport.requestRate > 0 {
+			limit.requests = newTokenBucket(transport.requestRate, max(transport.requestRate, 1))
+		}
+		transport.hosts[host] = limit
+	}
+	return limit
+}
+
+func (transport *politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	limit := transport.host(req.URL.Host)
+
+	transport.mu.Lock()
+	retryAfter := time.Until(limit.retryAfter)
+	transport.mu.Unlock()
+	if retryAfter > 0 {
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if limit.requests != nil {
+		if err := limit.requests.wait(ctx, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := transport.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if wait := retryAfterHeader(resp, time.Now()); wait > 0 {
+		transport.mu.Lock()
+		if until := time.Now().Add(wait); until.After(limit.retryAfter) {
+			limit.retryAfter = until
+		}
+		transport.mu.Unlock()
+	}
+	if transport.bandwidth != nil {
+		resp.Body = &throttledBody{ctx: ctx, ReadCloser: resp.Body, bandwidth: transport.bandwidth}
+	}
+	return resp, nil
+}
+
+// retryAfterHeader returns how long the Retry-After header of a 429 or 503 response asks to wait, in seconds or until an
+// HTTP date, or 0 when there is none.
+func retryAfterHeader(resp *http.Response, now time.Time) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
+
+// throttledBody takes a token of bandwidth for every byte read, reading at most a burst at a time.
+type throttledBody struct {
+	ctx context.Context
+	io.ReadCloser
+	bandwidth *tokenBucket
+}
+
+func (body *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > int(body.bandwidth.burst) {
+		p = p[:max(int(body.bandwidth.burst), 1)]
+	}
+	n, err := body.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := body.bandwidth.wait(body.ctx, float64(n)); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
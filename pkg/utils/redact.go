@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// RedactUrl hides the password and the values of the sensitive query parameters in rawUrl, so it can be logged.
+func RedactUrl(rawUrl string, sensitive ...string) string {
+	if len(sensitive) == 0 && !strings.Contains(rawUrl, "@") {
+		return rawUrl
+	}
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+	parsed.RawQuery = redactQuery(parsed.RawQuery, sensitive)
+	return parsed.Redacted()
+}
+
+// redactQuery hides the values of the sensitive parameters in rawQuery, keeping the order of the parameters.
+func redactQuery(rawQuery string, sensitive []string) string {
+	if len(sensitive) == 0 || rawQuery == "" {
+		return rawQuery
+	}
+	pairs := strings.Split(rawQuery, "&")
+	for index, pair := range pairs {
+		name, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if slices.Contains(sensitive, name) {
+			pairs[index] = url.QueryEscape(name) + "=REDACTED"
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// redactError hides the sensitive parts of the url of a failed request in err, see RedactUrl.
+func redactError(err error, sensitive []string) error {
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		urlError.URL = RedactUrl(urlError.URL, sensitive...)
+	}
+	return err
+}
+
+// RedactUrl hides the password and the values of the SensitiveQuery parameters of downloader in rawUrl, so it can be
+// logged.
+func (downloader *Downloader) RedactUrl(rawUrl string) string {
+	return RedactUrl(rawUrl, downloader.SensitiveQuery...)
+}
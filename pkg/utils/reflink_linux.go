@@ -0,0 +1,29 @@
+//go:build linux
+
+package utils
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink clones src to dst sharing its extents, which filesystems such as Btrfs and XFS support with FICLONE.
+func reflink(src string, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if err := unix.IoctlFileClone(int(destination.Fd()), int(source.Fd())); err != nil {
+		return errors.Join(err, destination.Close(), os.Remove(dst))
+	}
+	return destination.Close()
+}
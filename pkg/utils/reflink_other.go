@@ -0,0 +1,10 @@
+//go:build !linux
+
+package utils
+
+import "errors"
+
+// reflink is only implemented on Linux, elsewhere LinkOrClone falls back to copying.
+func reflink(src string, dst string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// MaxBackoff caps the wait between retries computed by Backoff.
+const MaxBackoff = 30 * time.Second
+
+// StatusError is returned when a request is answered with an error status.
+type StatusError struct {
+	Status     string
+	StatusCode int
+	Url        string
+	// RetryAfter is how long the Retry-After header of a 429 or 503 response asked to wait, or 0 when there was none.
+	RetryAfter time.Duration
+	// sensitive names the query parameters of Url redacted from the message.
+	sensitive []string
+}
+
+func (statusError *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %s from %s", statusError.Status, RedactUrl(statusError.Url, statusError.sensitive...))
+}
+
+// statusError returns the StatusError of resp, the response of downloader to a request of url.
+func (downloader *Downloader) statusError(resp *http.Response, url string) error {
+	return &StatusError{Status: resp.Status, StatusCode: resp.StatusCode, Url: url, RetryAfter: retryAfterHeader(resp, time.Now()), sensitive: downloader.SensitiveQuery}
+}
+
+// IsTransient reports whether a failed request may succeed when retried: server errors, 429 Too Many Requests,
+// stalled downloads and network timeouts, resets and truncated responses. Client errors such as 404 are permanent.
+func IsTransient(err error) bool {
+	var statusError *StatusError
+	if errors.As(err, &statusError) {
+		return statusError.StatusCode >= http.StatusInternalServerError || statusError.StatusCode == http.StatusTooManyRequests
+	}
+
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, ErrStalled) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// RetryAfter returns how long the server that failed a request with err asked to wait before retrying it, or 0.
+func RetryAfter(err error) time.Duration {
+	var statusError *StatusError
+	if errors.As(err, &statusError) {
+		return statusError.RetryAfter
+	}
+	return 0
+}
+
+// Backoff is how long to wait before the given retry attempt, counted from 1: base doubled for every earlier attempt, up to MaxBackoff.
+func Backoff(base time.Duration, attempt int) time.Duration {
+	wait := base
+	for i := 1; i < attempt && wait < MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, MaxBackoff)
+}
@@ -0,0 +1,42 @@
+package utils
+
+import "strings"
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline renders values as a single line of block characters scaled between their minimum and maximum.
+func Sparkline(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	min, max := values[0], values[0]
+	for _, value := range values {
+		min = minFloat(min, value)
+		max = maxFloat(max, value)
+	}
+
+	var b strings.Builder
+	for _, value := range values {
+		level := 0
+		if max > min {
+			level = int((value - min) / (max - min) * float64(len(sparks)-1))
+		}
+		b.WriteRune(sparks[level])
+	}
+	return b.String()
+}
+
+func minFloat(a float64, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a float64, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
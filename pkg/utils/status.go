@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"net/url"
+	"sync"
+)
+
+type DownloadStatus int
+
+const (
+	DownloadPending DownloadStatus = iota
+	DownloadComplete
+	DownloadFailed
+)
+
+// DownloadStatusCache remembers the final status of every url downloaded during a session so that a retried pass only requests what previously failed.
+// Urls are keyed without their query string, so re-signed urls (e.g. after a token refresh) still match. The fragment is
+// kept, as it tells apart the byte ranges of a single resource.
+type DownloadStatusCache struct {
+	mu       sync.Mutex
+	statuses map[string]DownloadStatus
+}
+
+func NewDownloadStatusCache() *DownloadStatusCache {
+	return &DownloadStatusCache{statuses: make(map[string]DownloadStatus)}
+}
+
+func statusKey(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+	u.RawQuery = ""
+	return u.String()
+}
+
+func (cache *DownloadStatusCache) Get(rawUrl string) DownloadStatus {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.statuses[statusKey(rawUrl)]
+}
+
+func (cache *DownloadStatusCache) Set(rawUrl string, status DownloadStatus) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.statuses[statusKey(rawUrl)] = status
+}
+
+// Failed returns the number of urls whose last attempt failed.
+func (cache *DownloadStatusCache) Failed() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	failed := 0
+	for _, status := range cache.statuses {
+		if status == DownloadFailed {
+			failed++
+		}
+	}
+	return failed
+}
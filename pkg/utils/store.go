@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	StoreAuto     = "auto"
+	StorePlaylist = "playlist"
+	StoreAlways   = "always"
+)
+
+// SharedStore is a content-addressable store shared by every run, so resources that several playlists have in common,
+// such as the init segments and keys of the variants of a presentation, are fetched once. Objects are stored under
+// their SHA-256 and each url records the checksum of what it served, one file per entry so concurrent runs never conflict.
+type SharedStore struct {
+	Dir string
+	// Policy decides which files the store reuses and keeps: StoreAuto, the default when empty, honors both an
+	// #EXT-X-ALLOW-CACHE:NO in the playlist and a Cache-Control: no-store served with a file, StorePlaylist only the
+	// former, for origins sending no-store with everything, and StoreAlways neither.
+	Policy string
+}
+
+// Allows reports whether a file served with header may be added to the store according to its Policy.
+func (store *SharedStore) Allows(header http.Header) bool {
+	if store.Policy != StoreAuto && store.Policy != "" {
+		return true
+	}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// OpenSharedStore opens the store in dir with policy, creating it if needed. An empty dir uses manifestr/store in the
+// user cache directory.
+func OpenSharedStore(dir string, policy string) (*SharedStore, error) {
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = path.Join(cacheDir, "manifestr", "store")
+	}
+
+	store := &SharedStore{Dir: dir, Policy: policy}
+	for _, subDir := range []string{store.objectsDir(), store.urlsDir()} {
+		if err := os.MkdirAll(subDir, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+func (store *SharedStore) objectsDir() string {
+	return path.Join(store.Dir, "objects")
+}
+
+func (store *SharedStore) urlsDir() string {
+	return path.Join(store.Dir, "urls")
+}
+
+func (store *SharedStore) urlPath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return path.Join(store.urlsDir(), hex.EncodeToString(sum[:]))
+}
+
+// Get places what url served in an earlier run at dst, see LinkOrClone, reporting false when the store does not have it.
+func (store *SharedStore) Get(url string, dst string) bool {
+	checksum, err := os.ReadFile(store.urlPath(url))
+	if err != nil {
+		return false
+	}
+	object := path.Join(store.objectsDir(), strings.TrimSpace(string(checksum)))
+	return LinkOrClone(object, dst) == nil
+}
+
+// Put records the file at src as what url served, checksum being its hex SHA-256.
+func (store *SharedStore) Put(url string, src string, checksum string) error {
+	object := path.Join(store.objectsDir(), checksum)
+	if _, err := os.Stat(object); errors.Is(err, fs.ErrNotExist) {
+		if err := writeAtomically(object, func(tmp string) error { return LinkOrClone(src, tmp) }); err != nil {
+			return err
+		}
+	}
+
+	return writeAtomically(store.urlPath(url), func(tmp string) error {
+		return os.WriteFile(tmp, []byte(checksum), 0o644)
+	})
+}
+
+// writeAtomically creates filePath through write on a temporary name, so concurrent readers never see it half written.
+func writeAtomically(filePath string, write func(tmp string) error) error {
+	file, err := os.CreateTemp(path.Dir(filePath), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	file.Close()
+
+	if err := write(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+)
+
+// DefaultTempMaxAge is how long a temporary directory is kept after its last change.
+const DefaultTempMaxAge = 7 * 24 * time.Hour
+
+// TempOptions decides where CreateDirectoryOrTemp creates temporary directories and how long it keeps them.
+type TempOptions struct {
+	// Root is the directory the temporary directories are created in. When empty, manifestr/tmp in the user cache
+	// directory ($XDG_CACHE_HOME or ~/.cache on Linux) is used, or in the system temporary directory when there is none.
+	Root string
+	// MaxAge is how long a temporary directory is kept after its last change before it is removed, see
+	// DefaultTempMaxAge. Zero keeps them all.
+	MaxAge time.Duration
+}
+
+func (options TempOptions) root() string {
+	if options.Root != "" {
+		return options.Root
+	}
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		return path.Join(cacheDir, "manifestr", "tmp")
+	}
+	return path.Join(os.TempDir(), "manifestr")
+}
+
+// CreateDirectoryOrTemp creates directory, or a temporary directory when it is empty. Temporary directories are namespaced
+// by a hash of manifestUrl, so the runs of a manifest are found together, and are unique to every run, so concurrent runs
+// never share one. Temporary directories under the root of options older than its MaxAge are removed on the way.
+func CreateDirectoryOrTemp(directory string, manifestUrl string, options TempOptions) (string, error) {
+	if directory != "" {
+		return directory, os.MkdirAll(directory, os.ModePerm)
+	}
+
+	root := options.root()
+	if err := CleanTempDirs(root, options.MaxAge); err != nil {
+		slog.Warn("failed to clean up temporary directories", slog.String("dir", root), slog.String("error", err.Error()))
+	}
+
+	sum := sha256.Sum256([]byte(manifestUrl))
+	namespace := path.Join(root, hex.EncodeToString(sum[:8]))
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = os.MkdirAll(namespace, os.ModePerm); err != nil {
+			return "", err
+		}
+		// a concurrent clean up removes the namespace when it finds it empty, in which case it is created again
+		if directory, err = os.MkdirTemp(namespace, time.Now().Format("20060102T150405-")); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	slog.Info("using temporary directory", slog.String("dir", directory))
+	return directory, nil
+}
+
+// CleanTempDirs removes the run directories in the namespaces of root whose newest file is older than maxAge, then the
+// namespaces left empty. A zero maxAge removes nothing.
+func CleanTempDirs(root string, maxAge time.Duration) error {
+	if maxAge <= 0 {
+		return nil
+	}
+	namespaces, err := os.ReadDir(root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	var errs []error
+	for _, namespace := range namespaces {
+		if !namespace.IsDir() {
+			continue
+		}
+		namespaceDir := path.Join(root, namespace.Name())
+		runs, err := os.ReadDir(namespaceDir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		kept := 0
+		for _, run := range runs {
+			runDir := path.Join(namespaceDir, run.Name())
+			// a long recording may keep adding to a directory created long ago
+			if newest, err := newestChange(runDir); err != nil || newest.After(cutoff) {
+				kept++
+				continue
+			}
+			slog.Debug("removing aged temporary directory", slog.String("dir", runDir))
+			if err := os.RemoveAll(runDir); err != nil {
+				errs = append(errs, err)
+				kept++
+			}
+		}
+		if kept == 0 {
+			// another run may just be creating its directory here, which then fails to remove harmlessly
+			os.Remove(namespaceDir)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newestChange returns the latest modification time of dir and everything below it.
+func newestChange(dir string) (time.Time, error) {
+	var newest time.Time
+	err := fs.WalkDir(os.DirFS(dir), ".", func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return newest, err
+}
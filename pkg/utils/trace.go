@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+)
+
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// tracingTransport dumps every request and response passing through it to a trace writer.
+type tracingTransport struct {
+	next   http.RoundTripper
+	mu     sync.Mutex
+	out    io.Writer
+	bodies bool
+	// sensitive names the query parameters redacted from the traced urls.
+	sensitive []string
+}
+
+// Trace makes the client of downloader dump sanitized request and response headers to out. When bodies is set, playlist
+// bodies are dumped as well. A downloader without a client of its own is given one, leaving http.DefaultClient as it is.
+func (downloader *Downloader) Trace(out io.Writer, bodies bool) {
+	if downloader.Client == nil {
+		downloader.Client = &http.Client{}
+	}
+	next := downloader.Client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	downloader.Client.Transport = &tracingTransport{next: next, out: out, bodies: bodies, sensitive: downloader.SensitiveQuery}
+}
+
+func (transport *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+
+	traced := req.Clone(req.Context())
+	sanitizeHeaders(traced.Header)
+	traced.URL.RawQuery = redactQuery(traced.URL.RawQuery, transport.sensitive)
+	reqDump, _ := httputil.DumpRequestOut(traced, false)
+
+	resp, err := transport.next.RoundTrip(req)
+
+	var entry bytes.Buffer
+	fmt.Fprintf(&entry, "=== %s %s %s (%s)\n", started.Format(time.RFC3339Nano), req.Method, RedactUrl(req.URL.String(), transport.sensitive...), time.Since(started).Round(time.Millisecond))
+	entry.Write(reqDump)
+
+	if err != nil {
+		fmt.Fprintf(&entry, "error: %s\n\n", err)
+	} else {
+		dumpBody := transport.bodies && isPlaylist(req, resp)
+
+		header := resp.Header.Clone()
+		sanitizeHeaders(resp.Header)
+		respDump, _ := httputil.DumpResponse(resp, dumpBody)
+		resp.Header = header
+
+		entry.Write(respDump)
+		entry.WriteString("\n\n")
+	}
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	transport.out.Write(entry.Bytes())
+
+	return resp, err
+}
+
+func sanitizeHeaders(header http.Header) {
+	for _, name := range sensitiveHeaders {
+		if header.Get(name) != "" {
+			header.Set(name, "REDACTED")
+		}
+	}
+}
+
+func isPlaylist(req *http.Request, resp *http.Response) bool {
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	return strings.Contains(contentType, "mpegurl") || strings.HasSuffix(req.URL.Path, ".m3u8")
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	VerifyExists   = "exists"
+	VerifyChecksum = "checksum"
+	VerifyRemote   = "remote"
+)
+
+// RecordedFile is what was recorded about a file when it was downloaded, see ChecksumIndex and ArchiveIndex.
+type RecordedFile struct {
+	Checksum string
+	ETag     string
+	// Size is the Content-Length the file was downloaded with, or the length of its byte range, or 0 when it is not known.
+	Size int64
+	// Partial marks a file holding a byte range of the resource, whose size cannot be compared with that of the resource.
+	Partial bool
+}
+
+// IsStale reports whether the existing file at filePath should be downloaded again from url according to the Verify
+// policy of options: VerifyExists keeps any existing file of the recorded size, VerifyChecksum keeps it only while it
+// matches its recorded checksum and VerifyRemote only while it matches the Content-Length and ETag the origin currently
+// reports, asked through the Downloader of options. Files that do not exist are never stale, they are simply
+// downloaded. Nothing recorded about a file means it cannot be shown stale.
+func IsStale(filePath string, url string, recorded RecordedFile, options DownloadOptions) (bool, error) {
+	info, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// a file cut short after the download recorded it, such as by a full disk, is stale whatever the policy
+	if recorded.Size > 0 && info.Size() != recorded.Size {
+		return true, nil
+	}
+
+	switch options.Verify {
+	case VerifyChecksum:
+		if recorded.Checksum == "" {
+			return false, nil
+		}
+		checksum, err := fileChecksum(filePath)
+		if err != nil {
+			return false, err
+		}
+		return checksum != recorded.Checksum, nil
+	case VerifyRemote:
+		return isRemoteChanged(info, url, recorded, options.downloader())
+	}
+
+	return false, nil
+}
+
+func fileChecksum(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func isRemoteChanged(info fs.FileInfo, url string, recorded RecordedFile, downloader *Downloader) (bool, error) {
+	if url == StdinUrl {
+		return false, nil
+	}
+
+	if strings.HasPrefix(url, "/") {
+		source, err := os.Stat(url)
+		if err != nil {
+			return false, err
+		}
+		return !recorded.Partial && source.Size() != info.Size(), nil
+	}
+
+	resp, err := downloader.Head(url)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return false, downloader.statusError(resp, url)
+	}
+
+	if contentLength, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil && !recorded.Partial && contentLength != info.Size() {
+		return true, nil
+	}
+
+	etag := resp.Header.Get("ETag")
+	return recorded.ETag != "" && etag != "" && etag != recorded.ETag, nil
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+const (
+	FsyncNever = "never"
+	FsyncBatch = "batch"
+	FsyncEach  = "each"
+)
+
+// SyncBatch collects the files written with FsyncBatch until they are flushed to stable storage together, see Sync.
+type SyncBatch struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+// bufferedFile is a file written through a buffer that honors the Fsync policy it was opened with when closed.
+type bufferedFile struct {
+	*bufio.Writer
+	file *os.File
+	// name is the path synced by the batch, which differs from that of file when it is renamed once complete
+	name  string
+	fsync string
+	batch *SyncBatch
+}
+
+// newBufferedFile writes file through a buffer configured by options, syncing it as name.
+func newBufferedFile(file *os.File, name string, options TransferOptions) *bufferedFile {
+	size := options.WriteBufferSize
+	if size <= 0 {
+		size = DefaultWriteBufferSize
+	}
+	return &bufferedFile{Writer: bufio.NewWriterSize(file, size), file: file, name: name, fsync: options.Fsync, batch: options.Batch}
+}
+
+func createBuffered(filePath string, options TransferOptions) (*bufferedFile, error) {
+	// filePath may be linked into an archive or the shared store, which must not be truncated through
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBufferedFile(file, filePath, options), nil
+}
+
+// openPartial opens the partial file at partPath for appending, creating it when it does not exist yet, and returns how
+// many bytes it already holds. finalPath is where it is renamed to once complete, which is what the batch syncs.
+func openPartial(partPath string, finalPath string, options TransferOptions) (*bufferedFile, int64, error) {
+	file, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return newBufferedFile(file, finalPath, options), info.Size(), nil
+}
+
+// restart discards everything written to the file so far.
+func (buffered *bufferedFile) restart() error {
+	buffered.Reset(buffered.file)
+	return buffered.file.Truncate(0)
+}
+
+func (buffered *bufferedFile) Close() error {
+	err := buffered.Flush()
+
+	switch {
+	case buffered.fsync == FsyncBatch && buffered.batch != nil:
+		buffered.batch.mu.Lock()
+		buffered.batch.paths = append(buffered.batch.paths, buffered.name)
+		buffered.batch.mu.Unlock()
+	case buffered.fsync == FsyncEach, buffered.fsync == FsyncBatch:
+		if err == nil {
+			err = buffered.file.Sync()
+		}
+	}
+
+	return errors.Join(err, buffered.file.Close())
+}
+
+// Sync flushes every file added to the batch since the last call to stable storage. A nil batch holds none.
+func (batch *SyncBatch) Sync() error {
+	if batch == nil {
+		return nil
+	}
+	batch.mu.Lock()
+	paths := batch.paths
+	batch.paths = nil
+	batch.mu.Unlock()
+
+	var errs []error
+	for _, filePath := range paths {
+		file, err := os.Open(filePath)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, file.Sync(), file.Close())
+	}
+
+	return errors.Join(errs...)
+}
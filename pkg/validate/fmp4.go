@@ -0,0 +1,105 @@
+package validate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	ErrMissingBox   = errors.New("missing box")
+	ErrInvalidBox   = errors.New("invalid box")
+	ErrNotFmp4      = errors.New("not fragmented MP4")
+	ErrTruncatedBox = errors.New("truncated box")
+)
+
+// Fmp4Init checks that r is an ISO BMFF initialization segment holding a moov box.
+func Fmp4Init(r *bufio.Reader) error {
+	return requireBoxes(r, "moov")
+}
+
+// Fmp4Fragment checks that r is an ISO BMFF media segment holding moof and mdat boxes.
+func Fmp4Fragment(r *bufio.Reader) error {
+	return requireBoxes(r, "moof", "mdat")
+}
+
+// requireBoxes walks the top-level boxes of r, failing on malformed or truncated boxes and when any of required is absent.
+func requireBoxes(r *bufio.Reader, required ...string) error {
+	if err := sniffContent(r); err != nil {
+		return err
+	}
+
+	found := make(map[string]bool)
+	header := make([]byte, 8)
+	for offset := int64(0); ; {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%w at offset %d", ErrTruncatedBox, offset)
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// the box extends to the end of the file
+			found[boxType] = true
+			return missingBoxes(found, required)
+		case 1:
+			largeSize := make([]byte, 8)
+			if _, err := io.ReadFull(r, largeSize); err != nil {
+				return fmt.Errorf("%w: %q at offset %d", ErrTruncatedBox, boxType, offset)
+			}
+			size = int64(binary.BigEndian.Uint64(largeSize))
+			headerSize = 16
+		}
+		if size < headerSize {
+			return fmt.Errorf("%w: %q at offset %d has size %d", ErrInvalidBox, boxType, offset, size)
+		}
+
+		if discarded, err := io.CopyN(io.Discard, r, size-headerSize); err != nil {
+			return fmt.Errorf("%w: %q at offset %d has %d of %d bytes", ErrTruncatedBox, boxType, offset, discarded+headerSize, size)
+		}
+		found[boxType] = true
+		offset += size
+	}
+
+	return missingBoxes(found, required)
+}
+
+func missingBoxes(found map[string]bool, required []string) error {
+	missing := make([]string, 0)
+	for _, boxType := range required {
+		if !found[boxType] {
+			missing = append(missing, boxType)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingBox, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// sniffContent recognizes content origins commonly serve in place of fMP4, such as error pages and MPEG-TS.
+func sniffContent(r *bufio.Reader) error {
+	peeked, _ := r.Peek(16)
+	switch trimmed := bytes.TrimSpace(peeked); {
+	case len(peeked) == 0:
+		return fmt.Errorf("%w: empty file", ErrNotFmp4)
+	case peeked[0] == tsSyncByte:
+		return fmt.Errorf("%w: looks like MPEG-TS", ErrNotFmp4)
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return fmt.Errorf("%w: looks like HTML or XML", ErrNotFmp4)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return fmt.Errorf("%w: looks like JSON", ErrNotFmp4)
+	case bytes.HasPrefix(trimmed, []byte("#EXTM3U")):
+		return fmt.Errorf("%w: looks like a playlist", ErrNotFmp4)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package validate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	tsNullPid    = 0x1FFF
+)
+
+var (
+	ErrTruncated  = errors.New("truncated packet")
+	ErrSyncLost   = errors.New("missing sync byte")
+	ErrContinuity = errors.New("continuity counter skipped")
+	ErrNoPackets  = errors.New("no packets")
+)
+
+// PacketError locates an invalid MPEG-TS packet.
+type PacketError struct {
+	Packet int
+	// Pid is -1 when the packet is too short to carry one.
+	Pid int
+	Err error
+}
+
+func (packetError PacketError) Error() string {
+	if packetError.Pid < 0 {
+		return fmt.Sprintf("packet %d: %s", packetError.Packet, packetError.Err)
+	}
+	return fmt.Sprintf("packet %d (pid %d): %s", packetError.Packet, packetError.Pid, packetError.Err)
+}
+
+func (packetError PacketError) Unwrap() error {
+	return packetError.Err
+}
+
+// MpegTs checks that r is a whole number of 188 byte transport stream packets, each starting with the sync byte,
+// and that the continuity counter of every PID carrying a payload advances without gaps.
+func MpegTs(r *bufio.Reader) error {
+	counters := make(map[int]byte)
+	packet := make([]byte, tsPacketSize)
+
+	for index := 0; ; index++ {
+		n, err := io.ReadFull(r, packet)
+		if err == io.EOF {
+			if index == 0 {
+				return ErrNoPackets
+			}
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return PacketError{Packet: index, Pid: -1, Err: fmt.Errorf("%w: %d of %d bytes", ErrTruncated, n, tsPacketSize)}
+		}
+		if err != nil {
+			return err
+		}
+
+		pid := int(packet[1]&0x1F)<<8 | int(packet[2])
+		if packet[0] != tsSyncByte {
+			return PacketError{Packet: index, Pid: pid, Err: ErrSyncLost}
+		}
+		if pid == tsNullPid {
+			continue
+		}
+
+		adaptationFieldControl := packet[3] >> 4 & 0x3
+		counter := packet[3] & 0xF
+		hasPayload := adaptationFieldControl&0x1 != 0
+		// the discontinuity indicator allows the counter to jump
+		discontinuity := adaptationFieldControl&0x2 != 0 && packet[4] > 0 && packet[5]&0x80 != 0
+
+		previous, seen := counters[pid]
+		if seen && hasPayload && !discontinuity && counter != previous && counter != (previous+1)&0xF {
+			return PacketError{Packet: index, Pid: pid, Err: fmt.Errorf("%w: %d after %d", ErrContinuity, counter, previous)}
+		}
+		if hasPayload || !seen {
+			counters[pid] = counter
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package validate
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// File checks the structure of the fragment at filePath according to its extension: MPEG-TS for .ts, fMP4 media segments
+// for .m4s and fMP4 initialization segments for .mp4, the name init files are downloaded to. Files of other types are not checked.
+func File(filePath string) error {
+	var validator func(r *bufio.Reader) error
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".ts":
+		validator = MpegTs
+	case ".m4s":
+		validator = Fmp4Fragment
+	case ".mp4":
+		validator = Fmp4Init
+	default:
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := validator(bufio.NewReader(file)); err != nil {
+		return fmt.Errorf("%s: %w", path.Base(filePath), err)
+	}
+	return nil
+}